@@ -87,6 +87,7 @@ Details: https://juicefs.com/docs/community/performance_evaluation_guide#juicefs
 var resultRange = map[string][4]float64{
 	"bigwr":   {100, 200, 10, 50},
 	"bigrd":   {100, 200, 10, 50},
+	"bigcrd":  {200, 1000, 1, 5},
 	"smallwr": {12.5, 20, 50, 80},
 	"smallrd": {50, 100, 10, 20},
 	"stat":    {20, 1000, 1, 5},
@@ -105,6 +106,7 @@ type benchCase struct {
 	fsize, bsize     int        // file/block size in Bytes
 	fcount, bcount   int        // file/block count
 	wbar, rbar, sbar *utils.Bar // progress bar for write/read/stat
+	cbar             *utils.Bar // progress bar for read from cache
 }
 
 type benchmark struct {
@@ -133,7 +135,7 @@ func (bc *benchCase) writeFiles(index int) {
 	}
 }
 
-func (bc *benchCase) readFiles(index int) {
+func (bc *benchCase) readFiles(index int, bar *utils.Bar) {
 	for i := 0; i < bc.fcount; i++ {
 		fname := fmt.Sprintf("%s/%s.%d.%d", bc.bm.tmpdir, bc.name, index, i)
 		fp, err := os.Open(fname)
@@ -145,7 +147,7 @@ func (bc *benchCase) readFiles(index int) {
 			if n, err := fp.Read(buf); err != nil || n != bc.bsize {
 				logger.Fatalf("Failed to read file %s: %d %s", fname, n, err)
 			}
-			bc.rbar.Increment()
+			bar.Increment()
 		}
 		_ = fp.Close()
 	}
@@ -167,7 +169,9 @@ func (bc *benchCase) run(test string) float64 {
 	case "write":
 		fn = bc.writeFiles
 	case "read":
-		fn = bc.readFiles
+		fn = func(index int) { bc.readFiles(index, bc.rbar) }
+	case "reread":
+		fn = func(index int) { bc.readFiles(index, bc.cbar) }
 	case "stat":
 		fn = bc.statFiles
 	} // default: fatal
@@ -363,6 +367,7 @@ func bench(ctx *cli.Context) error {
 		total := int64(bm.threads * b.fcount * b.bcount)
 		b.wbar = progress.AddCountBar("Write big blocks", total)
 		b.rbar = progress.AddCountBar("Read big blocks", total)
+		b.cbar = progress.AddCountBar("Read cached big blocks", total)
 	}
 	if s := bm.small; s != nil {
 		total := int64(bm.threads * s.fcount * s.bcount)
@@ -394,6 +399,16 @@ func bench(ctx *cli.Context) error {
 		line[1] += " MiB/s"
 		line[2] += " s/file"
 		result = append(result, line)
+		dropCaches()
+
+		// read again from the cache of JuiceFS, which could be spliced into kernel
+		cost = b.run("reread")
+		line = make([]string, 3)
+		line[0] = "Read cached big file"
+		line[1], line[2] = bm.colorize("bigcrd", float64((b.fsize>>20)*b.fcount*bm.threads)/cost, cost/float64(b.fcount), 2)
+		line[1] += " MiB/s"
+		line[2] += " s/file"
+		result = append(result, line)
 	}
 	if s := bm.small; s != nil {
 		cost := s.run("write")
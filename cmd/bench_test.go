@@ -17,8 +17,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBench(t *testing.T) {
@@ -37,3 +41,76 @@ func TestBenchForObject(t *testing.T) {
 		t.Fatalf("test bench failed: %s", err)
 	}
 }
+
+func TestObjbenchMixed(t *testing.T) {
+	mix, err := parseMix("get=70,PUT=20,list=10,delete=0")
+	if err != nil || len(mix) != 3 || mix[1].value != 1 {
+		t.Fatalf("parse mix: %+v %s", mix, err)
+	}
+	if _, err = parseMix("get=70,rename=30"); err == nil {
+		t.Fatalf("unknown operation should fail")
+	}
+	sizes, err := parseSizeDist("4K:30, 1MiB:70")
+	if err != nil || len(sizes) != 2 || sizes[0].value != 4<<10 || sizes[1].value != 1<<20 {
+		t.Fatalf("parse sizes: %+v %s", sizes, err)
+	}
+	if _, err = parseSizeDist("4X:30"); err == nil {
+		t.Fatalf("invalid size should fail")
+	}
+
+	lats := []time.Duration{time.Millisecond * 3, time.Millisecond, time.Millisecond * 2, time.Millisecond * 100}
+	st := summarize(lats, 0, 0, time.Second)
+	if st.Latency.P50 != 2 || st.Latency.Max != 100 || st.Latency.P99 != 100 || st.Throughput != 4 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	base := &BenchReport{Ops: map[string]*OpStats{"get": {Throughput: 100, Latency: LatencyStats{P50: 10, P99: 20}}}}
+	cur := &BenchReport{Ops: map[string]*OpStats{"get": {Throughput: 95, Latency: LatencyStats{P50: 10, P99: 30}}}}
+	if regs := compareReports(base, cur, 10); len(regs) != 1 || !strings.HasPrefix(regs[0], "get p99 latency") {
+		t.Fatalf("unexpected regressions: %v", regs)
+	}
+	if regs := compareReports(base, base, 10); len(regs) != 0 {
+		t.Fatalf("no regression expected: %v", regs)
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "result.json")
+	args := []string{"", "objbench", filepath.Join(dir, "bucket") + "/", "--json", "-p", "4", "--ops", "200", "--objects", "10",
+		"--mix", "get=50,put=20,head=10,list=10,delete=10", "--size-dist", "1K:50,64K:50", "--output", out}
+	if err = Main(args); err != nil {
+		t.Fatalf("objbench in JSON mode: %s", err)
+	}
+	report, err := loadReport(out)
+	if err != nil {
+		t.Fatalf("load report: %s", err)
+	}
+	var total int
+	for _, st := range report.Ops {
+		total += st.Count + st.Errors
+	}
+	if total != 200 || report.Ops["get"] == nil || report.Ops["get"].Latency.P99 <= 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	// a baseline that is way faster must be reported as regression
+	for _, st := range report.Ops {
+		st.Throughput *= 100
+	}
+	data, _ := json.Marshal(report)
+	if err = os.WriteFile(out, data, 0644); err != nil {
+		t.Fatalf("write baseline: %s", err)
+	}
+	if err = Main(append(args[:len(args)-2], "--baseline", out)); err == nil {
+		t.Fatalf("regression should be reported")
+	}
+
+	// the mixed workload printed as a table, the result in JSON is still written into --output
+	out2 := filepath.Join(dir, "result2.json")
+	args = []string{"", "objbench", filepath.Join(dir, "bucket") + "/", "-p", "2", "--ops", "50", "--objects", "5",
+		"--mix", "get=50,put=50", "--size-dist", "1K:100", "--output", out2}
+	if err = Main(args); err != nil {
+		t.Fatalf("objbench with mixed workload: %s", err)
+	}
+	if report, err = loadReport(out2); err != nil || report.Ops["put"] == nil {
+		t.Fatalf("load report: %+v %s", report, err)
+	}
+}
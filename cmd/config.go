@@ -79,6 +79,14 @@ $ juicefs config redis://localhost --min-client-version 1.0.0 --max-client-versi
 				Name:  "trash-days",
 				Usage: "number of days after which removed files will be permanently deleted",
 			},
+			&cli.BoolFlag{
+				Name:  "enable-retention",
+				Usage: "enable WORM retention directories, which can not be disabled afterwards",
+			},
+			&cli.BoolFlag{
+				Name:  "require-token",
+				Usage: "require the clients serving the files (mount, gateway, WebDAV and the Java SDK) to use a token",
+			},
 			&cli.StringFlag{
 				Name:  "min-client-version",
 				Usage: "minimum client version allowed to connect",
@@ -180,6 +188,19 @@ func config(ctx *cli.Context) error {
 				format.TrashDays = new
 				trash = true
 			}
+		case "enable-retention":
+			if !ctx.Bool(flag) && format.EnableRetention {
+				return fmt.Errorf("retention can not be disabled once enabled")
+			}
+			if ctx.Bool(flag) && !format.EnableRetention {
+				msg.WriteString(fmt.Sprintf("%10s: false -> true\n", flag))
+				format.EnableRetention = true
+			}
+		case "require-token":
+			if new := ctx.Bool(flag); new != format.RequireToken {
+				msg.WriteString(fmt.Sprintf("%s: %t -> %t\n", flag, format.RequireToken, new))
+				format.RequireToken = new
+			}
 		case "min-client-version":
 			if new := ctx.String(flag); new != format.MinClientVersion {
 				if version.Parse(new) == nil {
@@ -124,6 +124,15 @@ func clientFlags() []cli.Flag {
 			Value: 0,
 			Usage: "bandwidth limit for download in Mbps",
 		},
+		&cli.IntFlag{
+			Name:  "max-downloads",
+			Value: 0,
+			Usage: "number of concurrent downloads, requests from applications win over prefetch and warmup when it's reached (0 means unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "qos",
+			Usage: "a JSON file of QoS rules limiting the bandwidth and request rate for users or process groups",
+		},
 
 		&cli.IntFlag{
 			Name:  "prefetch",
@@ -185,6 +194,10 @@ func clientFlags() []cli.Flag {
 			Name:  "subdir",
 			Usage: "mount a sub-directory as root",
 		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "access token created by \"juicefs token create\", which limits the client to a subtree",
+		},
 	}
 }
 
@@ -114,7 +114,7 @@ Details: https://juicefs.com/docs/community/quick_start_guide`,
 			&cli.StringFlag{
 				Name:  "compress",
 				Value: "none",
-				Usage: "compression algorithm (lz4, zstd, none)",
+				Usage: "compression algorithm (lz4, zstd[:level], snappy, brotli[:level], none), add suffix +adaptive to skip incompressible blocks",
 			},
 			&cli.IntFlag{
 				Name:  "shards",
@@ -143,6 +143,11 @@ Details: https://juicefs.com/docs/community/quick_start_guide`,
 				Name:  "encrypt-rsa-key",
 				Usage: "a path to RSA private key (PEM)",
 			},
+			&cli.StringFlag{
+				Name:  "encrypt-algo",
+				Value: object.AES256GCM_RSA,
+				Usage: "encrypt algorithm (aes256gcm-rsa, chacha20-rsa, aes256gcm-hkdf, chacha20-hkdf)",
+			},
 			&cli.IntFlag{
 				Name:  "trash-days",
 				Value: 1,
@@ -152,6 +157,14 @@ Details: https://juicefs.com/docs/community/quick_start_guide`,
 				Name:  "hash-prefix",
 				Usage: "give each object a hashed prefix",
 			},
+			&cli.BoolFlag{
+				Name:  "enable-retention",
+				Usage: "enable WORM retention directories, which can not be disabled afterwards",
+			},
+			&cli.BoolFlag{
+				Name:  "require-token",
+				Usage: "require the clients serving the files (mount, gateway, WebDAV and the Java SDK) to use a token",
+			},
 			&cli.BoolFlag{
 				Name:  "force",
 				Usage: "overwrite existing format",
@@ -198,11 +211,18 @@ func createStorage(format meta.Format) (object.ObjectStorage, error) {
 		if err != nil {
 			return nil, err
 		}
-		var tlsSkipVerify bool
-		if tlsSkipVerify, err = strconv.ParseBool(values.Get("tls-insecure-skip-verify")); err != nil {
-			return nil, err
+		if values.Has("tls-insecure-skip-verify") {
+			var tlsSkipVerify bool
+			if tlsSkipVerify, err = strconv.ParseBool(values.Get("tls-insecure-skip-verify")); err != nil {
+				return nil, err
+			}
+			object.GetHttpClient().Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsSkipVerify}
+			values.Del("tls-insecure-skip-verify")
+		}
+		if strings.ToLower(format.Storage) == "file" && len(values) > 0 {
+			// options of local storage, e.g. fsync=true&checksum=true
+			format.Bucket += "?" + values.Encode()
 		}
-		object.GetHttpClient().Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsSkipVerify}
 	}
 	if format.Shards > 1 {
 		blob, err = object.NewSharded(strings.ToLower(format.Storage), format.Bucket, format.AccessKey, format.SecretKey, format.Shards)
@@ -215,28 +235,65 @@ func createStorage(format meta.Format) (object.ObjectStorage, error) {
 	blob = object.WithPrefix(blob, format.Name+"/")
 
 	if format.EncryptKey != "" {
-		passphrase := os.Getenv("JFS_RSA_PASSPHRASE")
-		block, _ := pem.Decode([]byte(format.EncryptKey))
-		if block == nil {
-			return nil, errors.New("failed to parse PEM block containing the key")
+		encryptor, err := newEncryptor(&format)
+		if err != nil {
+			return nil, err
 		}
-		// nolint:staticcheck
-		if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") && x509.IsEncryptedPEMBlock(block) {
-			if passphrase == "" {
-				return nil, fmt.Errorf("passphrase is required to private key, please try again after setting the 'JFS_RSA_PASSPHRASE' environment variable")
-			}
-		} else if passphrase != "" {
-			logger.Warningf("passphrase is not used, because private key is not encrypted")
+		blob = object.NewEncrypted(blob, encryptor)
+	}
+	return blob, nil
+}
+
+func newEncryptor(format *meta.Format) (object.Encryptor, error) {
+	if object.IsSymmetricAlgo(format.EncryptAlgo) {
+		passphrase, err := encryptPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		master, err := object.LoadSymmetricKey(passphrase, format.EncryptKey)
+		if err != nil {
+			return nil, fmt.Errorf("load master key: %s", err)
 		}
+		return object.NewSymmetricEncryptor(master, format.EncryptAlgo)
+	}
+
+	passphrase := os.Getenv("JFS_RSA_PASSPHRASE")
+	block, _ := pem.Decode([]byte(format.EncryptKey))
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	// nolint:staticcheck
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") && x509.IsEncryptedPEMBlock(block) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("passphrase is required to private key, please try again after setting the 'JFS_RSA_PASSPHRASE' environment variable")
+		}
+	} else if passphrase != "" {
+		logger.Warningf("passphrase is not used, because private key is not encrypted")
+	}
 
-		privKey, err := object.ParseRsaPrivateKeyFromPem(block, passphrase)
+	privKey, err := object.ParseRsaPrivateKeyFromPem(block, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect passphrase: %s", err)
+	}
+	return object.NewDataEncryptor(object.NewRSAEncryptor(privKey), format.EncryptAlgo)
+}
+
+// encryptPassphrase returns the passphrase to derive the master key of symmetric encryption,
+// which is never stored in the volume.
+func encryptPassphrase() ([]byte, error) {
+	if p := os.Getenv("JFS_ENCRYPT_PASSPHRASE"); p != "" {
+		return []byte(p), nil
+	}
+	if path := os.Getenv("JFS_ENCRYPT_PASSPHRASE_FILE"); path != "" {
+		p, err := os.ReadFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("incorrect passphrase: %s", err)
+			return nil, fmt.Errorf("read passphrase: %s", err)
+		}
+		if p = bytes.TrimRight(p, "\r\n"); len(p) > 0 {
+			return p, nil
 		}
-		encryptor := object.NewAESEncryptor(object.NewRSAEncryptor(privKey))
-		blob = object.NewEncrypted(blob, encryptor)
 	}
-	return blob, nil
+	return nil, fmt.Errorf("passphrase is required by symmetric encryption, please set the 'JFS_ENCRYPT_PASSPHRASE' or 'JFS_ENCRYPT_PASSPHRASE_FILE' environment variable")
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -319,8 +376,30 @@ func format(c *cli.Context) error {
 	if v := c.Int("trash-days"); v < 0 {
 		logger.Fatalf("Invalid trash days: %d", v)
 	}
+	encryptAlgo := c.String("encrypt-algo")
+	var validAlgo bool
+	for _, a := range object.EncryptAlgos {
+		validAlgo = validAlgo || a == encryptAlgo
+	}
+	if !validAlgo {
+		logger.Fatalf("Unsupported encrypt algorithm: %s", encryptAlgo)
+	}
 
 	loadEncrypt := func(keyPath string) string {
+		if object.IsSymmetricAlgo(encryptAlgo) {
+			if keyPath != "" {
+				logger.Fatalf("RSA key is not used by encrypt algorithm %s", encryptAlgo)
+			}
+			passphrase, err := encryptPassphrase()
+			if err != nil {
+				logger.Fatalf("%s", err)
+			}
+			_, stored, err := object.NewSymmetricKey(passphrase)
+			if err != nil {
+				logger.Fatalf("generate master key for encryption: %s", err)
+			}
+			return stored
+		}
 		if keyPath == "" {
 			return ""
 		}
@@ -361,30 +440,39 @@ func format(c *cli.Context) error {
 				format.Shards = c.Int(flag)
 			case "hash-prefix":
 				format.HashPrefix = c.Bool(flag)
+			case "enable-retention":
+				format.EnableRetention = format.EnableRetention || c.Bool(flag)
+			case "require-token":
+				format.RequireToken = c.Bool(flag)
 			case "storage":
 				format.Storage = c.String(flag)
-			case "encrypt-rsa-key":
+			case "encrypt-rsa-key", "encrypt-algo":
 				logger.Warnf("Flag %s is ignored since it cannot be updated", flag)
 			}
 		}
 	} else if err.Error() == "database is not formatted" {
 		create = true
 		format = &meta.Format{
-			Name:        name,
-			UUID:        uuid.New().String(),
-			Storage:     c.String("storage"),
-			Bucket:      c.String("bucket"),
-			AccessKey:   c.String("access-key"),
-			SecretKey:   c.String("secret-key"),
-			EncryptKey:  loadEncrypt(c.String("encrypt-rsa-key")),
-			Shards:      c.Int("shards"),
-			HashPrefix:  c.Bool("hash-prefix"),
-			Capacity:    c.Uint64("capacity") << 30,
-			Inodes:      c.Uint64("inodes"),
-			BlockSize:   fixObjectSize(c.Int("block-size")),
-			Compression: c.String("compress"),
-			TrashDays:   c.Int("trash-days"),
-			MetaVersion: 1,
+			Name:            name,
+			UUID:            uuid.New().String(),
+			Storage:         c.String("storage"),
+			Bucket:          c.String("bucket"),
+			AccessKey:       c.String("access-key"),
+			SecretKey:       c.String("secret-key"),
+			EncryptKey:      loadEncrypt(c.String("encrypt-rsa-key")),
+			Shards:          c.Int("shards"),
+			HashPrefix:      c.Bool("hash-prefix"),
+			Capacity:        c.Uint64("capacity") << 30,
+			Inodes:          c.Uint64("inodes"),
+			BlockSize:       fixObjectSize(c.Int("block-size")),
+			Compression:     c.String("compress"),
+			TrashDays:       c.Int("trash-days"),
+			MetaVersion:     1,
+			EnableRetention: c.Bool("enable-retention"),
+			RequireToken:    c.Bool("require-token"),
+		}
+		if format.EncryptKey != "" {
+			format.EncryptAlgo = encryptAlgo
 		}
 		if format.AccessKey == "" && os.Getenv("ACCESS_KEY") != "" {
 			format.AccessKey = os.Getenv("ACCESS_KEY")
@@ -398,8 +486,12 @@ func format(c *cli.Context) error {
 		logger.Fatalf("Load metadata: %s", err)
 	}
 	if format.Storage == "file" {
+		var query string
+		if p := strings.Index(format.Bucket, "?"); p > 0 {
+			format.Bucket, query = format.Bucket[:p], format.Bucket[p:]
+		}
 		if p, err := filepath.Abs(format.Bucket); err == nil {
-			format.Bucket = p + "/"
+			format.Bucket = p + "/" + query
 		} else {
 			logger.Fatalf("Failed to get absolute path of %s: %s", format.Bucket, err)
 		}
@@ -20,6 +20,7 @@
 package cmd
 
 import (
+	"fmt"
 	_ "net/http/pprof"
 	"os"
 	"strconv"
@@ -35,6 +36,8 @@ import (
 	mcli "github.com/minio/cli"
 	minio "github.com/minio/minio/cmd"
 	"github.com/minio/minio/pkg/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
 )
 
 func cmdGateway() *cli.Command {
@@ -55,6 +58,27 @@ func cmdGateway() *cli.Command {
 			Name:  "keep-etag",
 			Usage: "keep the ETag for uploaded objects",
 		},
+		&cli.StringFlag{
+			Name:  "iam-config",
+			Usage: "YAML file of the users (access keys mapped to uid/gids, with policies of buckets and prefixes)",
+		},
+		&cli.BoolFlag{
+			Name:  "versioning",
+			Usage: "keep the previous versions of overwritten or deleted objects in the buckets whose versioning is not configured",
+		},
+		&cli.StringFlag{
+			Name:  "webhook",
+			Usage: "URL of a webhook to send the events of objects (ObjectCreated, ObjectRemoved) to",
+		},
+		&cli.BoolFlag{
+			Name:  "select-parquet",
+			Usage: "enable S3 Select on Parquet objects",
+		},
+		&cli.StringFlag{
+			Name:  "upload-expiry",
+			Value: "0",
+			Usage: "abort the multipart uploads which are not completed in the duration (e.g. 168h), 0 means never",
+		},
 		&cli.StringFlag{
 			Name:  "umask",
 			Value: "022",
@@ -85,6 +109,9 @@ $ export MINIO_ROOT_USER=admin
 $ export MINIO_ROOT_PASSWORD=12345678
 $ juicefs gateway redis://localhost localhost:9000
 
+# Serve more users as their uid/gids in JuiceFS
+$ juicefs gateway redis://localhost localhost:9000 --iam-config users.yaml
+
 Details: https://juicefs.com/docs/community/s3_gateway`,
 		Flags: expandFlags(compoundFlags),
 	}
@@ -107,6 +134,11 @@ func gateway(c *cli.Context) error {
 		logger.Fatalf("MINIO_ROOT_PASSWORD should be specified as an environment variable with at least 8 characters")
 	}
 
+	if c.Bool("select-parquet") {
+		// S3 Select on CSV and JSON is always enabled in MinIO, but Parquet is not
+		_ = os.Setenv("MINIO_API_SELECT_PARQUET", "on")
+	}
+
 	address := c.Args().Get(1)
 	gw = &GateWay{c}
 
@@ -162,23 +194,70 @@ func (g *GateWay) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, er
 	c := g.ctx
 	addr := c.Args().Get(0)
 	removePassword(addr)
-	m, store, conf := initForSvc(c, "s3gateway", addr)
+	m, store, conf, _ := initForSvc(c, "s3gateway", addr)
 
 	umask, err := strconv.ParseUint(c.String("umask"), 8, 16)
 	if err != nil {
 		logger.Fatalf("invalid umask %s: %s", c.String("umask"), err)
 	}
 
-	return jfsgateway.NewJFSGateway(conf, m, store, &jfsgateway.Config{MultiBucket: c.Bool("multi-buckets"), KeepEtag: c.Bool("keep-etag"), Mode: uint16(0777 &^ umask)})
+	gConf := &jfsgateway.Config{MultiBucket: c.Bool("multi-buckets"), KeepEtag: c.Bool("keep-etag"), Versioning: c.Bool("versioning"), Webhook: c.String("webhook"), UploadExpiry: duration(c.String("upload-expiry")), Mode: uint16(0777 &^ umask)}
+	if c.IsSet("iam-config") {
+		if gConf.Users, err = loadGatewayUsers(c.String("iam-config"), creds.AccessKey); err != nil {
+			logger.Fatalf("load users: %s", err)
+		}
+		gConf.RootUser = creds.AccessKey
+		logger.Infof("Loaded %d users from %s", len(gConf.Users), c.String("iam-config"))
+	}
+	return jfsgateway.NewJFSGateway(conf, m, store, gConf)
 }
 
-func initForSvc(c *cli.Context, mp string, metaUrl string) (meta.Meta, chunk.ChunkStore, *vfs.Config) {
+// loadGatewayUsers reads the users of gateway from a YAML file, like
+//
+//	users:
+//	  - access-key: alice
+//	    secret-key: alice-secret
+//	    uid: 1001
+//	    gids: [1001, 100]
+//	    policies:
+//	      - bucket: data
+//	        prefix: home/alice/
+func loadGatewayUsers(path, rootUser string) ([]jfsgateway.User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf struct {
+		Users []jfsgateway.User `yaml:"users"`
+	}
+	if err = yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, u := range conf.Users {
+		switch {
+		case len(u.AccessKey) < 3:
+			return nil, fmt.Errorf("access key %q should have at least 3 characters", u.AccessKey)
+		case len(u.SecretKey) < 8:
+			return nil, fmt.Errorf("secret key of %s should have at least 8 characters", u.AccessKey)
+		case u.AccessKey == rootUser || seen[u.AccessKey]:
+			return nil, fmt.Errorf("duplicated access key %s", u.AccessKey)
+		}
+		seen[u.AccessKey] = true
+	}
+	return conf.Users, nil
+}
+
+func initForSvc(c *cli.Context, mp string, metaUrl string) (meta.Meta, chunk.ChunkStore, *vfs.Config, prometheus.Registerer) {
 	metaConf := getMetaConf(c, mp, c.Bool("read-only"))
 	metaCli := meta.NewClient(metaUrl, metaConf)
 	format, err := metaCli.Load(true)
 	if err != nil {
 		logger.Fatalf("load setting: %s", err)
 	}
+	if err = format.CheckToken(metaConf); err != nil {
+		logger.Fatalf("%s", err)
+	}
 	registerer, registry := wrapRegister(mp, format.Name)
 	if !c.Bool("writeback") && c.IsSet("upload-delay") {
 		logger.Warnf("delayed upload only work in writeback mode")
@@ -209,5 +288,5 @@ func initForSvc(c *cli.Context, mp string, metaUrl string) (meta.Meta, chunk.Chu
 
 	initBackgroundTasks(c, vfsConf, metaConf, metaCli, blob, registerer, registry)
 
-	return metaCli, store, vfsConf
+	return metaCli, store, vfsConf, registerer
 }
@@ -0,0 +1,63 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGatewayUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", path, err)
+		}
+	}
+	write(`
+users:
+  - access-key: alice
+    secret-key: alice-secret
+    uid: 1001
+    gids: [1001, 100]
+    policies:
+      - bucket: data
+        prefix: home/alice/
+      - bucket: public
+        read-only: true
+`)
+	users, err := loadGatewayUsers(path, "admin")
+	if err != nil {
+		t.Fatalf("load users: %s", err)
+	}
+	if len(users) != 1 || users[0].Uid != 1001 || len(users[0].Gids) != 2 || len(users[0].Policies) != 2 ||
+		users[0].Policies[0].Prefix != "home/alice/" || !users[0].Policies[1].ReadOnly {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+
+	for _, content := range []string{
+		"users:\n  - access-key: admin\n    secret-key: 12345678\n",
+		"users:\n  - access-key: bob\n    secret-key: short\n",
+		"users:\n  - access-key: bob\n    secret-key: 12345678\n    home: /home/bob\n",
+	} {
+		write(content)
+		if _, err = loadGatewayUsers(path, "admin"); err == nil {
+			t.Fatalf("load users should fail: %s", content)
+		}
+	}
+}
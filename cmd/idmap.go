@@ -0,0 +1,203 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdIdmap() *cli.Command {
+	return &cli.Command{
+		Name:     "idmap",
+		Category: "ADMIN",
+		Usage:    "Manage the shared mapping from user and group names to ids",
+		Description: `
+The shared mapping is stored in the volume, clients mounted with --map-names map their
+local users and groups to the ids in the volume by name, so hosts with different
+/etc/passwd see the same owners.
+
+Examples:
+$ juicefs idmap set redis://localhost --user alice:2000 --group dev:3000
+$ juicefs mount redis://localhost /mnt/jfs --map-names
+$ juicefs idmap list redis://localhost
+$ juicefs idmap unset redis://localhost --user alice`,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "list",
+				Action:    listIdNames,
+				Usage:     "List the shared mapping",
+				ArgsUsage: "META-URL",
+			},
+			{
+				Name:      "set",
+				Action:    setIdNames,
+				Usage:     "Map users or groups to ids",
+				ArgsUsage: "META-URL",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "user",
+						Usage: "map a user to a uid (NAME:UID)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "group",
+						Usage: "map a group to a gid (NAME:GID)",
+					},
+				},
+			},
+			{
+				Name:      "unset",
+				Action:    unsetIdNames,
+				Usage:     "Remove users or groups from the mapping",
+				ArgsUsage: "META-URL",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "user",
+						Usage: "name of the user",
+					},
+					&cli.StringSliceFlag{
+						Name:  "group",
+						Usage: "name of the group",
+					},
+				},
+			},
+		},
+	}
+}
+
+func listIdNames(ctx *cli.Context) error {
+	setup(ctx, 1)
+	m := openMeta(ctx)
+	users, groups, err := m.ListIdNames()
+	if err != nil {
+		logger.Fatalf("list id names: %s", err)
+	}
+	fmt.Printf("%-6s %-32s %s\n", "Type", "Name", "Id")
+	for _, t := range []struct {
+		name  string
+		names map[string]uint32
+	}{{"user", users}, {"group", groups}} {
+		keys := make([]string, 0, len(t.names))
+		for name := range t.names {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			fmt.Printf("%-6s %-32s %d\n", t.name, name, t.names[name])
+		}
+	}
+	return nil
+}
+
+func setIdNames(ctx *cli.Context) error {
+	setup(ctx, 1)
+	if len(ctx.StringSlice("user")) == 0 && len(ctx.StringSlice("group")) == 0 {
+		logger.Fatalf("--user or --group is needed")
+	}
+	m := openMeta(ctx)
+	for _, group := range []bool{false, true} {
+		name := "user"
+		if group {
+			name = "group"
+		}
+		for _, v := range ctx.StringSlice(name) {
+			p := strings.LastIndex(v, ":")
+			if p < 0 {
+				logger.Fatalf("invalid %s %q, NAME:ID is expected", name, v)
+			}
+			id, err := strconv.ParseUint(v[p+1:], 10, 32)
+			if err != nil {
+				logger.Fatalf("invalid id of %s %q", name, v)
+			}
+			if err = m.SetIdName(group, v[:p], uint32(id)); err != nil {
+				logger.Fatalf("map %s %s: %s", name, v[:p], err)
+			}
+		}
+	}
+	return nil
+}
+
+func unsetIdNames(ctx *cli.Context) error {
+	setup(ctx, 1)
+	m := openMeta(ctx)
+	for _, name := range ctx.StringSlice("user") {
+		if err := m.RemoveIdName(false, name); err != nil {
+			logger.Fatalf("unmap user %s: %s", name, err)
+		}
+	}
+	for _, name := range ctx.StringSlice("group") {
+		if err := m.RemoveIdName(true, name); err != nil {
+			logger.Fatalf("unmap group %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// setIdMaps builds the mappings of uids and gids for a mount point: the shared mapping
+// of names (with --map-names) first, then --uid-map and --gid-map, and the mapping of
+// the token (already in conf.UidMap) overrides them.
+func setIdMaps(c *cli.Context, m meta.Meta, conf *meta.Config) error {
+	uids, gids := make(meta.IdMap), make(meta.IdMap)
+	if c.Bool("map-names") {
+		users, groups, err := m.ListIdNames()
+		if err != nil {
+			return fmt.Errorf("list id names: %s", err)
+		}
+		for name, id := range users {
+			u, err := user.Lookup(name)
+			if err != nil {
+				logger.Debugf("lookup user %s: %s", name, err)
+				continue
+			}
+			if uid, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+				uids[uint32(uid)] = id
+			}
+		}
+		for name, id := range groups {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				logger.Debugf("lookup group %s: %s", name, err)
+				continue
+			}
+			if gid, err := strconv.ParseUint(g.Gid, 10, 32); err == nil {
+				gids[uint32(gid)] = id
+			}
+		}
+	}
+	fu, err := meta.ParseIdMap(c.String("uid-map"))
+	if err != nil {
+		return err
+	}
+	fg, err := meta.ParseIdMap(c.String("gid-map"))
+	if err != nil {
+		return err
+	}
+	uids.Update(fu)
+	gids.Update(fg)
+	uids.Update(conf.UidMap)
+	conf.UidMap, conf.GidMap = uids, gids
+	if len(uids) > 0 || len(gids) > 0 {
+		logger.Infof("Map uids %q, gids %q", uids, gids)
+	}
+	return nil
+}
@@ -52,7 +52,10 @@ func Main(args []string) error {
 			cmdFormat(),
 			cmdConfig(),
 			cmdDestroy(),
+			cmdToken(),
+			cmdIdmap(),
 			cmdGC(),
+			cmdScrub(),
 			cmdFsck(),
 			cmdDump(),
 			cmdLoad(),
@@ -64,9 +67,13 @@ func Main(args []string) error {
 			cmdUmount(),
 			cmdGateway(),
 			cmdWebDav(),
+			cmdServeHTTP(),
 			cmdBench(),
 			cmdObjbench(),
 			cmdWarmup(),
+			cmdQos(),
+			cmdReload(),
+			cmdRetention(),
 			cmdRmr(),
 			cmdSync(),
 		},
@@ -196,6 +203,15 @@ func reorderOptions(app *cli.App, args []string) []string {
 
 	newArgs = append(newArgs, cmdName)
 	args, others = others[1:], nil
+	if len(args) > 0 {
+		for _, sub := range cmd.Subcommands {
+			if sub.Name == args[0] {
+				newArgs = append(newArgs, sub.Name)
+				cmd, args = sub, args[1:]
+				break
+			}
+		}
+	}
 	// -h is valid for all the commands
 	cmdFlags := append(cmd.Flags, cli.HelpFlag)
 	for i := 0; i < len(args); i++ {
@@ -44,6 +44,19 @@ func TestArgsOrder(t *testing.T) {
 					},
 				},
 			},
+			{
+				Name: "sub",
+				Subcommands: []*cli.Command{
+					{
+						Name: "create",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name: "dir",
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -52,6 +65,8 @@ func TestArgsOrder(t *testing.T) {
 		{"test", "--v", "cmd", "-k2", "v2", "a", "b"},
 		{"test", "cmd", "a", "-k2=v", "--h"},
 		{"test", "cmd", "-k2=v", "--h", "a"},
+		{"test", "sub", "create", "a", "--dir", "/d", "b"},
+		{"test", "sub", "create", "--dir", "/d", "a", "b"},
 	}
 	for i := 0; i < len(cases); i += 2 {
 		oreded := reorderOptions(app, cases[i])
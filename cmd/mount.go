@@ -17,6 +17,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -79,12 +80,17 @@ $ juicefs mount redis://localhost /mnt/jfs -d --writeback
 $ juicefs mount redis://localhost /mnt/jfs -d --read-only
 
 # Disable metadata backup
-$ juicefs mount redis://localhost /mnt/jfs --backup-meta 0`,
+$ juicefs mount redis://localhost /mnt/jfs --backup-meta 0
+
+# Mount the volumes listed in a YAML file in one process
+$ juicefs mount --volumes /etc/juicefs/volumes.yaml -d`,
 		Flags: expandFlags(compoundFlags),
 	}
 }
 
-func installHandler(mp string) {
+// installHandler umounts the mount points when the process is killed, SIGHUP reloads the
+// config if reload is not nil.
+func installHandler(reload func(), mps ...string) {
 	// Go will catch all the signals
 	signal.Ignore(syscall.SIGPIPE)
 	signalChan := make(chan os.Signal, 10)
@@ -92,8 +98,14 @@ func installHandler(mp string) {
 	go func() {
 		for {
 			sig := <-signalChan
+			if sig == syscall.SIGHUP && reload != nil {
+				go reload()
+				continue
+			}
 			logger.Infof("Received signal %s, exiting...", sig.String())
-			go func() { _ = doUmount(mp, true) }()
+			for _, mp := range mps {
+				go func(mp string) { _ = doUmount(mp, true) }(mp)
+			}
 			go func() {
 				time.Sleep(time.Second * 3)
 				logger.Warnf("Umount not finished after 3 seconds, force exit")
@@ -104,6 +116,15 @@ func installHandler(mp string) {
 }
 
 func exposeMetrics(c *cli.Context, m meta.Meta, registerer prometheus.Registerer, registry *prometheus.Registry) string {
+	meta.InitMetrics(registerer)
+	vfs.InitMetrics(registerer)
+	metric.InitMetrics(registerer)
+	go metric.UpdateMetrics(m, registerer)
+	return serveMetrics(c, registerer, registry)
+}
+
+// serveMetrics exposes the metrics in registry by HTTP, returns the listening address.
+func serveMetrics(c *cli.Context, registerer prometheus.Registerer, registry *prometheus.Registry) string {
 	var ip, port string
 	//default set
 	ip, port, err := net.SplitHostPort(c.String("metrics"))
@@ -111,9 +132,6 @@ func exposeMetrics(c *cli.Context, m meta.Meta, registerer prometheus.Registerer
 		logger.Fatalf("metrics format error: %v", err)
 	}
 
-	meta.InitMetrics(registerer)
-	vfs.InitMetrics(registerer)
-	go metric.UpdateMetrics(m, registerer)
 	http.Handle("/metrics", promhttp.HandlerFor(
 		registry,
 		promhttp.HandlerOpts{
@@ -184,7 +202,9 @@ func getFormat(c *cli.Context, metaCli meta.Meta) (*meta.Format, error) {
 	return format, nil
 }
 
-func daemonRun(c *cli.Context, addr string, vfsConf *vfs.Config, m meta.Meta) {
+// absCacheDir changes the cache directories in arguments into absolute paths,
+// since the current directory will be changed in daemon.
+func absCacheDir(c *cli.Context) {
 	if runtime.GOOS != "windows" {
 		if cd := c.String("cache-dir"); cd != "memory" {
 			ds := utils.SplitDir(cd)
@@ -212,6 +232,27 @@ func daemonRun(c *cli.Context, addr string, vfsConf *vfs.Config, m meta.Meta) {
 			}
 		}
 	}
+}
+
+// absPathArg changes the path of a flag in arguments into absolute path.
+func absPathArg(name, path string) {
+	if path == "" {
+		return
+	}
+	p, err := filepath.Abs(path)
+	if err != nil {
+		logger.Fatalf("Find absolute path of %s: %s", path, err)
+	}
+	for i, a := range os.Args {
+		if (a == path && i > 0 && strings.TrimLeft(os.Args[i-1], "-") == name) || a == "--"+name+"="+path {
+			os.Args[i] = a[:len(a)-len(path)] + p
+		}
+	}
+}
+
+func daemonRun(c *cli.Context, addr string, vfsConf *vfs.Config, m meta.Meta) {
+	absCacheDir(c)
+	absPathArg("config", c.String("config"))
 	sqliteScheme := "sqlite3://"
 	if strings.HasPrefix(addr, sqliteScheme) {
 		path := addr[len(sqliteScheme):]
@@ -281,6 +322,7 @@ func getMetaConf(c *cli.Context, mp string, readOnly bool) *meta.Config {
 		Heartbeat:  duration(c.String("heartbeat")),
 		MountPoint: mp,
 		Subdir:     c.String("subdir"),
+		Token:      c.String("token"),
 	}
 }
 
@@ -310,6 +352,24 @@ func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 		AutoCreate:     true,
 	}
 
+	qosConf := chunk.QoSConfig{}
+	if p := c.String("qos"); p != "" {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			logger.Fatalf("read QoS rules from %s: %s", p, err)
+		}
+		if err = json.Unmarshal(data, &qosConf); err != nil {
+			logger.Fatalf("parse QoS rules from %s: %s", p, err)
+		}
+	}
+	if c.IsSet("max-downloads") || qosConf.MaxDownloads == 0 {
+		qosConf.MaxDownloads = c.Int("max-downloads")
+	}
+	var err error
+	if chunkConf.QoS, err = chunk.NewQoS(qosConf); err != nil {
+		logger.Fatalf("QoS: %s", err)
+	}
+
 	if chunkConf.CacheDir != "memory" {
 		ds := utils.SplitDir(chunkConf.CacheDir)
 		for i := range ds {
@@ -368,9 +428,19 @@ func NewReloadableStorage(format *meta.Format, reload func() (*meta.Format, erro
 }
 
 func mount(c *cli.Context) error {
+	if c.IsSet("volumes") {
+		return mountVolumes(c)
+	}
 	setup(c, 2)
 	addr := c.Args().Get(0)
 	mp := c.Args().Get(1)
+	var reloader *configReloader
+	if path := c.String("config"); path != "" {
+		var err error
+		if c, reloader, err = newConfigReloader(c, path); err != nil {
+			return fmt.Errorf("load config: %s", err)
+		}
+	}
 
 	prepareMp(mp)
 	metaConf := getMetaConf(c, mp, c.Bool("read-only") || utils.StringContains(strings.Split(c.String("o"), ","), "ro"))
@@ -380,6 +450,9 @@ func mount(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if err = format.CheckToken(metaConf); err != nil {
+		return err
+	}
 
 	// Wrap the default registry, all prometheus.MustRegister() calls should be afterwards
 	registerer, registry := wrapRegister(mp, format.Name)
@@ -414,8 +487,17 @@ func mount(c *cli.Context) error {
 		logger.Fatalf("new session: %s", err)
 	}
 
-	installHandler(mp)
 	v := vfs.NewVFS(vfsConf, metaCli, store, registerer, registry)
+	if reloader != nil {
+		reloader.attach(v)
+		installHandler(func() {
+			if _, err := reloader.reload(); err != nil {
+				logger.Errorf("reload config: %s", err)
+			}
+		}, mp)
+	} else {
+		installHandler(nil, mp)
+	}
 	initBackgroundTasks(c, vfsConf, metaConf, metaCli, blob, registerer, registry)
 	mount_main(v, c)
 	return metaCli.CloseSession()
@@ -0,0 +1,220 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+// loadMountConfig reads the flags of mount (without "--") from a YAML file, like "cache-size: 102400".
+func loadMountConfig(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	options := make(map[string]interface{})
+	if err = yaml.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+	return options, nil
+}
+
+// overlayContext returns a context of the command in c with the flags overridden by options.
+func overlayContext(c *cli.Context, name string, options map[string]interface{}) (*cli.Context, error) {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	for name, value := range options {
+		var found cli.Flag
+		for _, f := range c.Command.Flags {
+			if utils.StringContains(f.Names(), name) {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown option %s", name)
+		}
+		if set.Lookup(name) == nil {
+			if err := found.Apply(set); err != nil {
+				return nil, fmt.Errorf("option %s: %s", name, err)
+			}
+		}
+		// every alias has its own value
+		for _, n := range found.Names() {
+			if err := set.Set(n, fmt.Sprint(value)); err != nil {
+				return nil, fmt.Errorf("option %s: %s", name, err)
+			}
+		}
+	}
+	return cli.NewContext(c.App, set, c), nil
+}
+
+// getAccessLogConf returns the format and filters of access log in the flags.
+func getAccessLogConf(c *cli.Context) (vfs.AccessLogConfig, error) {
+	conf := vfs.AccessLogConfig{
+		PathPrefix: c.String("access-log-prefix"),
+		MinLatency: time.Duration(c.Float64("access-log-min-latency") * float64(time.Second)),
+		MaxSize:    int64(c.Int("access-log-max-size")) << 20,
+		MaxBackups: c.Int("access-log-backups"),
+	}
+	switch format := c.String("access-log-format"); format {
+	case "", "text":
+	case "json":
+		conf.JSON = true
+	default:
+		return conf, fmt.Errorf("invalid format %q", format)
+	}
+	if ops := c.String("access-log-ops"); ops != "" {
+		conf.Ops = strings.Split(ops, ",")
+	}
+	return conf, nil
+}
+
+// configReloader reloads the config file of a mount point, and applies the changes
+// that can be changed without remount.
+type configReloader struct {
+	sync.Mutex
+	path    string
+	base    *cli.Context      // flags in command line
+	running map[string]string // values of flags in use
+	chunk   chunk.Config
+	v       *vfs.VFS
+}
+
+// newConfigReloader loads the config file, and returns a context with the flags in it.
+func newConfigReloader(c *cli.Context, path string) (*cli.Context, *configReloader, error) {
+	r := &configReloader{path: path, base: c, running: make(map[string]string)}
+	ctx, err := r.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range c.Command.Flags {
+		name := f.Names()[0]
+		r.running[name] = ctx.String(name)
+	}
+	return ctx, r, nil
+}
+
+func (r *configReloader) load() (*cli.Context, error) {
+	options, err := loadMountConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+	for name := range options {
+		if r.base.IsSet(name) {
+			logger.Warnf("%s in %s is overridden by command line", name, r.path)
+			delete(options, name)
+		}
+	}
+	return overlayContext(r.base, r.path, options)
+}
+
+// attach starts to apply changes to v.
+func (r *configReloader) attach(v *vfs.VFS) {
+	r.Lock()
+	defer r.Unlock()
+	r.v = v
+	r.chunk = *v.Conf.Chunk
+	v.OnReload(r.reload)
+}
+
+// reload reloads the config file and returns the changes.
+func (r *configReloader) reload() (string, error) {
+	r.Lock()
+	defer r.Unlock()
+	ctx, err := r.load()
+	if err != nil {
+		return "", err
+	}
+	var changes []string
+	for _, f := range r.base.Command.Flags {
+		name := f.Names()[0]
+		old, value := r.running[name], ctx.String(name)
+		if old == value {
+			continue
+		}
+		if r.v != nil && r.apply(ctx, name) {
+			r.running[name] = value
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, old, value))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s (need remount)", name, old, value))
+		}
+	}
+	if len(changes) == 0 {
+		return "no change", nil
+	}
+	msg := strings.Join(changes, "\n")
+	logger.Infof("Reload %s:\n%s", r.path, msg)
+	return msg, nil
+}
+
+// apply applies the change of a flag, returns false if it can't be changed at runtime.
+func (r *configReloader) apply(c *cli.Context, name string) bool {
+	switch name {
+	case "attr-cache":
+		r.v.SetAttrTimeout(time.Millisecond * time.Duration(c.Float64(name)*1000))
+	case "entry-cache":
+		r.v.SetEntryTimeout(time.Millisecond * time.Duration(c.Float64(name)*1000))
+	case "dir-entry-cache":
+		r.v.SetDirEntryTimeout(time.Millisecond * time.Duration(c.Float64(name)*1000))
+	case "access-log":
+		if err := r.v.SetAccessLog(c.String(name)); err != nil {
+			logger.Errorf("access log: %s", err)
+			return false
+		}
+	case "access-log-format", "access-log-ops", "access-log-prefix", "access-log-min-latency", "access-log-max-size", "access-log-backups":
+		logConf, err := getAccessLogConf(c)
+		if err != nil {
+			logger.Errorf("access log: %s", err)
+			return false
+		}
+		vfs.SetAccessLogConfig(logConf)
+	case "upload-limit":
+		r.chunk.UploadLimit = c.Int64(name) * 1e6 / 8
+		r.v.Store.UpdateConfig(r.chunk)
+	case "download-limit":
+		r.chunk.DownloadLimit = c.Int64(name) * 1e6 / 8
+		r.v.Store.UpdateConfig(r.chunk)
+	case "cache-size":
+		size := int64(c.Int(name))
+		if size == 0 || r.chunk.CacheSize == 0 { // enable or disable cache
+			return false
+		}
+		r.chunk.CacheSize = size
+		r.v.Store.UpdateConfig(r.chunk)
+	case "prefetch":
+		if r.chunk.CacheSize == 0 {
+			return false
+		}
+		r.chunk.Prefetch = c.Int(name)
+		r.v.Store.UpdateConfig(r.chunk)
+	default:
+		return false
+	}
+	return true
+}
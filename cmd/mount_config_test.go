@@ -0,0 +1,71 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestConfigReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vol.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", path, err)
+		}
+	}
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "subdir"},
+		&cli.IntFlag{Name: "cache-size", Value: 1024},
+		&cli.Int64Flag{Name: "upload-limit"},
+	}
+	set := flag.NewFlagSet("mount", flag.ContinueOnError)
+	for _, f := range flags {
+		_ = f.Apply(set)
+	}
+	_ = set.Set("subdir", "/cli")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	c.Command = &cli.Command{Name: "mount", Flags: flags}
+
+	write("subdir: /conf\ncache-size: 2048\n")
+	ctx, r, err := newConfigReloader(c, path)
+	if err != nil {
+		t.Fatalf("new reloader: %s", err)
+	}
+	if ctx.String("subdir") != "/cli" || ctx.Int("cache-size") != 2048 {
+		t.Fatalf("unexpected options: subdir %s cache-size %d", ctx.String("subdir"), ctx.Int("cache-size"))
+	}
+	if msg, err := r.reload(); err != nil || msg != "no change" {
+		t.Fatalf("reload without change: %q %v", msg, err)
+	}
+	write("subdir: /conf2\ncache-size: 4096\n")
+	if msg, err := r.reload(); err != nil || msg != "cache-size: 2048 -> 4096 (need remount)" {
+		t.Fatalf("reload: %q %v", msg, err)
+	}
+	write("unknown: 1\n")
+	if _, err := r.reload(); err == nil {
+		t.Fatalf("reload unknown option should fail")
+	}
+	write("cache-size: [\n")
+	if _, err := r.reload(); err == nil {
+		t.Fatalf("reload invalid file should fail")
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/juicedata/godaemon"
 	"github.com/juicedata/juicefs/pkg/fuse"
 	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/vfs"
 	"github.com/urfave/cli/v2"
 )
@@ -54,13 +55,21 @@ func checkMountpoint(name, mp, logPath string) {
 }
 
 func makeDaemon(c *cli.Context, name, mp string, m meta.Meta) error {
+	return makeDaemonFor(c, []string{name}, []string{mp}, []meta.Meta{m})
+}
+
+// makeDaemonFor runs the mount of volumes in background, the parent process exits
+// after all the mount points are ready.
+func makeDaemonFor(c *cli.Context, names, mps []string, ms []meta.Meta) error {
 	var attrs godaemon.DaemonAttr
 	logfile := c.String("log")
 	attrs.OnExit = func(stage int) error {
 		if stage != 0 {
 			return nil
 		}
-		checkMountpoint(name, mp, logfile)
+		for i, mp := range mps {
+			checkMountpoint(names[i], mp, logfile)
+		}
 		return nil
 	}
 
@@ -68,12 +77,12 @@ func makeDaemon(c *cli.Context, name, mp string, m meta.Meta) error {
 	// so the mount point has to be an absolute path.
 	if godaemon.Stage() == 0 {
 		for i, a := range os.Args {
-			if a == mp {
-				amp, err := filepath.Abs(mp)
+			if utils.StringContains(mps, a) {
+				amp, err := filepath.Abs(a)
 				if err == nil {
 					os.Args[i] = amp
 				} else {
-					logger.Warnf("abs of %s: %s", mp, err)
+					logger.Warnf("abs of %s: %s", a, err)
 				}
 			}
 		}
@@ -84,9 +93,10 @@ func makeDaemon(c *cli.Context, name, mp string, m meta.Meta) error {
 		}
 	}
 	if godaemon.Stage() <= 1 {
-		err := m.Shutdown()
-		if err != nil {
-			logger.Errorf("shutdown: %s", err)
+		for _, m := range ms {
+			if err := m.Shutdown(); err != nil {
+				logger.Errorf("shutdown: %s", err)
+			}
 		}
 	}
 	_, _, err := godaemon.MakeDaemon(&attrs)
@@ -132,6 +142,82 @@ func mount_flags() []cli.Flag {
 			Name:  "enable-xattr",
 			Usage: "enable extended attributes (xattr)",
 		},
+		&cli.BoolFlag{
+			Name:  "splice",
+			Usage: "send data in disk cache to kernel using splice to avoid copying",
+		},
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "a YAML file of mount flags (without \"--\"), reloaded on SIGHUP or by \"juicefs reload\"",
+		},
+		&cli.StringFlag{
+			Name:  "access-log",
+			Usage: "path of access log of all operations, or a local socket (unix://PATH)",
+		},
+		&cli.StringFlag{
+			Name:  "access-log-format",
+			Value: "text",
+			Usage: "format of access log (text or json), also used by .accesslog",
+		},
+		&cli.StringFlag{
+			Name:  "access-log-ops",
+			Usage: "log only these operations (separated by comma), e.g. read,write",
+		},
+		&cli.StringFlag{
+			Name:  "access-log-prefix",
+			Usage: "log only the operations under this path (relative to the mount point)",
+		},
+		&cli.Float64Flag{
+			Name:  "access-log-min-latency",
+			Usage: "log only the operations slower than this (in seconds)",
+		},
+		&cli.IntFlag{
+			Name:  "access-log-max-size",
+			Usage: "rotate the access log file when it's larger than this (in MiB), 0 means never",
+		},
+		&cli.IntFlag{
+			Name:  "access-log-backups",
+			Value: 7,
+			Usage: "number of rotated access log files to keep",
+		},
+		&cli.StringFlag{
+			Name:  "volumes",
+			Usage: "a YAML file of volumes to mount in one process (META-URL and MOUNTPOINT are omitted)",
+		},
+		&cli.IntFlag{
+			Name:  "fuse-readers",
+			Usage: "number of threads to read requests from FUSE (0 means the number of CPUs, up to 16)",
+		},
+		&cli.StringFlag{
+			Name:  "uid-map",
+			Usage: "map the local uids to the ones in the volume (e.g. 1000:2000,1001:2001)",
+		},
+		&cli.StringFlag{
+			Name:  "gid-map",
+			Usage: "map the local gids to the ones in the volume (e.g. 1000:2000,1001:2001)",
+		},
+		&cli.BoolFlag{
+			Name:  "map-names",
+			Usage: "map the local users and groups by name using the shared mapping in the volume (see \"juicefs idmap\")",
+		},
+		&cli.BoolFlag{
+			Name:  "root-squash",
+			Usage: "map the requests from root to the anonymous user",
+		},
+		&cli.BoolFlag{
+			Name:  "all-squash",
+			Usage: "map the requests from all users to the anonymous user",
+		},
+		&cli.UintFlag{
+			Name:  "anon-uid",
+			Value: 65534,
+			Usage: "uid of the anonymous user",
+		},
+		&cli.UintFlag{
+			Name:  "anon-gid",
+			Value: 65534,
+			Usage: "gid of the anonymous user",
+		},
 	}
 	return append(selfFlags, cacheFlags(1.0)...)
 }
@@ -174,8 +260,25 @@ func mount_main(v *vfs.VFS, c *cli.Context) {
 	conf.AttrTimeout = time.Millisecond * time.Duration(c.Float64("attr-cache")*1000)
 	conf.EntryTimeout = time.Millisecond * time.Duration(c.Float64("entry-cache")*1000)
 	conf.DirEntryTimeout = time.Millisecond * time.Duration(c.Float64("dir-entry-cache")*1000)
+	conf.Splice = c.Bool("splice")
+	conf.FuseReaders = c.Int("fuse-readers")
+	conf.RootSquash = c.Bool("root-squash")
+	conf.AllSquash = c.Bool("all-squash")
+	conf.AnonUid = uint32(c.Uint("anon-uid"))
+	conf.AnonGid = uint32(c.Uint("anon-gid"))
+	if err := setIdMaps(c, v.Meta, conf.Meta); err != nil {
+		logger.Fatalf("id mapping: %s", err)
+	}
+	logConf, err := getAccessLogConf(c)
+	if err != nil {
+		logger.Fatalf("access log: %s", err)
+	}
+	vfs.SetAccessLogConfig(logConf)
+	if err = v.SetAccessLog(c.String("access-log")); err != nil {
+		logger.Fatalf("access log: %s", err)
+	}
 	logger.Infof("Mounting volume %s at %s ...", conf.Format.Name, conf.Meta.MountPoint)
-	err := fuse.Serve(v, c.String("o"), c.Bool("enable-xattr"))
+	err = fuse.Serve(v, c.String("o"), c.Bool("enable-xattr"))
 	if err != nil {
 		logger.Fatalf("fuse: %s", err)
 	}
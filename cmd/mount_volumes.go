@@ -0,0 +1,230 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/metric"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/usage"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juicedata/juicefs/pkg/version"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+// volumeConf is a volume in the file of --volumes, the options are the flags of mount
+// (without "--"), which override the ones in command line.
+type volumeConf struct {
+	Meta       string                 `yaml:"meta"`
+	MountPoint string                 `yaml:"mountpoint"`
+	Options    map[string]interface{} `yaml:"options"`
+}
+
+// the options that are shared by all volumes in the process
+var processOptions = []string{"d", "background", "no-syslog", "log", "volumes", "config", "metrics", "consul",
+	"no-usage-report", "max-uploads", "access-log-format", "access-log-ops", "access-log-prefix",
+	"access-log-min-latency", "access-log-max-size", "access-log-backups"}
+
+// the budgets that are divided by all volumes if they are not specified for a volume
+var sharedBudgets = []string{"buffer-size", "cache-size"}
+
+func loadVolumes(path string) ([]*volumeConf, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf struct {
+		Volumes []*volumeConf `yaml:"volumes"`
+	}
+	if err = yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+	if len(conf.Volumes) == 0 {
+		return nil, fmt.Errorf("no volume in %s", path)
+	}
+	mps := make(map[string]bool)
+	for i, vol := range conf.Volumes {
+		if vol.Meta == "" || vol.MountPoint == "" {
+			return nil, fmt.Errorf("volume %d: meta and mountpoint are required", i)
+		}
+		if !filepath.IsAbs(vol.MountPoint) {
+			return nil, fmt.Errorf("volume %d: mountpoint %s is not an absolute path", i, vol.MountPoint)
+		}
+		vol.MountPoint = filepath.Clean(vol.MountPoint)
+		if mps[vol.MountPoint] {
+			return nil, fmt.Errorf("volume %d: mountpoint %s is used by another volume", i, vol.MountPoint)
+		}
+		mps[vol.MountPoint] = true
+		for name := range vol.Options {
+			if utils.StringContains(processOptions, name) {
+				return nil, fmt.Errorf("volume %d: option %s can't be set for a volume", i, name)
+			}
+		}
+	}
+	return conf.Volumes, nil
+}
+
+// newContext returns a context of the mount command with the options of the volume.
+func (vol *volumeConf) newContext(c *cli.Context) (*cli.Context, error) {
+	return overlayContext(c, vol.MountPoint, vol.Options)
+}
+
+type mountedVolume struct {
+	ctx        *cli.Context
+	conf       *vfs.Config
+	meta       meta.Meta
+	blob       object.ObjectStorage
+	store      chunk.ChunkStore
+	registerer prometheus.Registerer
+}
+
+// mountVolumes mounts the volumes in the file of --volumes in one process, the upload
+// connections are shared and the buffer and cache are divided among them.
+func mountVolumes(c *cli.Context) error {
+	setup(c, 0)
+	path := c.String("volumes")
+	vols, err := loadVolumes(path)
+	if err != nil {
+		return err
+	}
+	for _, vol := range vols {
+		if vol.Options == nil {
+			vol.Options = make(map[string]interface{})
+		}
+		for _, name := range sharedBudgets {
+			if _, ok := vol.Options[name]; !ok {
+				vol.Options[name] = c.Int(name) / len(vols)
+			}
+		}
+	}
+
+	registry := prometheus.NewRegistry() // replace default so only JuiceFS metrics are exposed
+	registerer := prometheus.WrapRegistererWithPrefix("juicefs_", registry)
+	registerer.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	registerer.MustRegister(collectors.NewGoCollector())
+	uploads := make(chan bool, c.Int("max-uploads"))
+
+	var mounted []*mountedVolume
+	var names, mps []string
+	var metas []meta.Meta
+	accessLogs := make(map[string]bool)
+	for _, vol := range vols {
+		vc, err := vol.newContext(c)
+		if err != nil {
+			return fmt.Errorf("volume %s: %s", vol.MountPoint, err)
+		}
+		mp := vol.MountPoint
+		if p := vc.String("access-log"); p != "" {
+			if accessLogs[p] {
+				return fmt.Errorf("volume %s: access log %s is used by another volume", mp, p)
+			}
+			accessLogs[p] = true
+		}
+		prepareMp(mp)
+		metaConf := getMetaConf(vc, mp, vc.Bool("read-only") || utils.StringContains(strings.Split(vc.String("o"), ","), "ro"))
+		metaCli := meta.NewClient(vol.Meta, metaConf)
+		format, err := getFormat(vc, metaCli)
+		if err == nil {
+			err = format.CheckToken(metaConf)
+		}
+		if err != nil {
+			return fmt.Errorf("volume %s: %s", mp, err)
+		}
+		volRegisterer := prometheus.WrapRegistererWith(prometheus.Labels{"mp": mp, "vol_name": format.Name}, registerer)
+		blob, err := NewReloadableStorage(format, func() (*meta.Format, error) {
+			return getFormat(vc, metaCli)
+		})
+		if err != nil {
+			return fmt.Errorf("volume %s: object storage: %s", mp, err)
+		}
+		logger.Infof("Volume %s use %s", format.Name, blob)
+
+		chunkConf := getChunkConf(vc, format)
+		chunkConf.UploadPool = uploads
+		store := chunk.NewCachedStore(blob, *chunkConf, volRegisterer)
+		registerMetaMsg(metaCli, store, chunkConf)
+		vfsConf := getVfsConf(vc, metaConf, format, chunkConf)
+		mounted = append(mounted, &mountedVolume{vc, vfsConf, metaCli, blob, store, volRegisterer})
+		names = append(names, format.Name)
+		mps = append(mps, mp)
+		metas = append(metas, metaCli)
+	}
+
+	if c.Bool("background") && os.Getenv("JFS_FOREGROUND") == "" {
+		absPathArg("volumes", path)
+		absCacheDir(c)
+		// The default log to syslog is only in daemon mode.
+		utils.InitLoggers(!c.Bool("no-syslog"))
+		if err := makeDaemonFor(c, names, mps, metas); err != nil {
+			logger.Fatalf("Failed to make daemon: %s", err)
+		}
+	} else {
+		for i, mp := range mps {
+			go checkMountpoint(names[i], mp, c.String("log"))
+		}
+	}
+
+	installHandler(nil, mps...)
+	// the metrics of meta and the process are aggregated for all volumes, the ones of operations
+	// are registered by each volume
+	meta.InitMetrics(registerer)
+	vfs.InitMetrics(registerer)
+	metric.InitMetrics(registerer)
+	metricsAddr := serveMetrics(c, registerer, registry)
+	var wg sync.WaitGroup
+	for _, mv := range mounted {
+		if err := mv.meta.NewSession(); err != nil {
+			logger.Fatalf("new session for %s: %s", mv.conf.Meta.MountPoint, err)
+		}
+		v := vfs.NewVFS(mv.conf, mv.meta, mv.store, mv.registerer, registry)
+		go metric.UpdateMetrics(mv.meta, mv.registerer)
+		if metaConf := mv.conf.Meta; !metaConf.ReadOnly && !metaConf.NoBGJob && mv.conf.BackupMeta > 0 {
+			go vfs.Backup(mv.meta, mv.blob, mv.conf.BackupMeta)
+		}
+		if c.IsSet("consul") {
+			metric.RegisterToConsul(c.String("consul"), metricsAddr, mv.conf.Meta.MountPoint)
+		}
+		if !c.Bool("no-usage-report") {
+			go usage.ReportUsage(mv.meta, version.Version())
+		}
+		wg.Add(1)
+		go func(mv *mountedVolume) {
+			defer wg.Done()
+			mount_main(v, mv.ctx)
+			if err := mv.meta.CloseSession(); err != nil {
+				logger.Errorf("close session for %s: %s", mv.conf.Meta.MountPoint, err)
+			}
+		}(mv)
+	}
+	wg.Wait()
+	return nil
+}
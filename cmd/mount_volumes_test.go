@@ -0,0 +1,100 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadVolumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "volumes.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", path, err)
+		}
+	}
+	write(`
+volumes:
+  - meta: redis://localhost/1
+    mountpoint: /mnt/jfs1
+  - meta: redis://localhost/2
+    mountpoint: /mnt/jfs2/
+    options:
+      subdir: /data
+      read-only: true
+      buffer-size: 100
+`)
+	vols, err := loadVolumes(path)
+	if err != nil {
+		t.Fatalf("load volumes: %s", err)
+	}
+	if len(vols) != 2 || vols[1].MountPoint != "/mnt/jfs2" || vols[1].Options["subdir"] != "/data" {
+		t.Fatalf("unexpected volumes: %+v", vols)
+	}
+
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "subdir"},
+		&cli.BoolFlag{Name: "read-only"},
+		&cli.IntFlag{Name: "buffer-size", Value: 300},
+		&cli.IntFlag{Name: "cache-size", Value: 1024},
+	}
+	set := flag.NewFlagSet("mount", flag.ContinueOnError)
+	for _, f := range flags {
+		_ = f.Apply(set)
+	}
+	_ = set.Set("cache-size", "2048")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	c.Command = &cli.Command{Name: "mount", Flags: flags}
+	vc, err := vols[1].newContext(c)
+	if err != nil {
+		t.Fatalf("context of volume: %s", err)
+	}
+	if vc.String("subdir") != "/data" || !vc.Bool("read-only") || vc.Int("buffer-size") != 100 || !vc.IsSet("buffer-size") {
+		t.Fatalf("options of volume are not applied")
+	}
+	if vc.Int("cache-size") != 2048 {
+		t.Fatalf("cache-size %d != expect 2048", vc.Int("cache-size"))
+	}
+	if vc, _ = vols[0].newContext(c); vc.String("subdir") != "" || vc.Bool("read-only") {
+		t.Fatalf("options of another volume are applied")
+	}
+
+	vols[0].Options = map[string]interface{}{"unknown": 1}
+	if _, err = vols[0].newContext(c); err == nil {
+		t.Fatalf("unknown option should fail")
+	}
+	for _, content := range []string{
+		"volumes: []",
+		"volumes:\n  - meta: redis://localhost/1\n    mountpoint: jfs",
+		"volumes:\n  - meta: redis://localhost/1\n    mountpoint: /jfs\n  - meta: redis://localhost/2\n    mountpoint: /jfs",
+		"volumes:\n  - meta: redis://localhost/1\n    mountpoint: /jfs\n    options:\n      metrics: 127.0.0.1:9567",
+		"volumes:\n  - meta: redis://localhost/1\n    mountpoint: /jfs\n    unknown: 1",
+	} {
+		write(content)
+		if _, err = loadVolumes(path); err == nil {
+			t.Fatalf("load invalid volumes should fail: %s", content)
+		}
+	}
+}
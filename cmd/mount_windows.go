@@ -17,6 +17,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/vfs"
 	"github.com/juicedata/juicefs/pkg/winfsp"
@@ -56,3 +58,7 @@ func mount_main(v *vfs.VFS, c *cli.Context) {
 
 func checkMountpoint(name, mp, logPath string) {
 }
+
+func mountVolumes(c *cli.Context) error {
+	return fmt.Errorf("mounting multiple volumes is not supported in Windows")
+}
@@ -18,6 +18,9 @@ package cmd
 
 import (
 	"bytes"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -49,7 +52,14 @@ Run basic benchmark on the target object storage to test if it works as expected
 
 Examples:
 # Run benchmark on S3
-$ ACCESS_KEY=myAccessKey SECRET_KEY=mySecretKey juicefs objbench --storage s3 --bucket https://mybucket.s3.us-east-2.amazonaws.com -p 4`,
+$ ACCESS_KEY=myAccessKey SECRET_KEY=mySecretKey juicefs objbench --storage s3 --bucket https://mybucket.s3.us-east-2.amazonaws.com -p 4
+
+# Print the result in JSON
+$ juicefs objbench --storage s3 https://mybucket.s3.us-east-2.amazonaws.com --json
+
+# Run a mixed workload, print the result in JSON and compare it with a previous one
+$ juicefs objbench --storage s3 https://mybucket.s3.us-east-2.amazonaws.com --json --mix get=70,put=20,list=10 \
+    --size-dist 4K:30,128K:50,4M:20 --baseline last.json --output new.json`,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:  "storage",
@@ -79,12 +89,52 @@ $ ACCESS_KEY=myAccessKey SECRET_KEY=mySecretKey juicefs objbench --storage s3 --
 				Value: 128,
 				Usage: "size of each small object in KiB",
 			},
+			&cli.StringFlag{
+				Name:  "encrypt-algo",
+				Usage: "encrypt objects with the algorithm (aes256gcm-rsa, chacha20-rsa, aes256gcm-hkdf, chacha20-hkdf) to benchmark the encryptor",
+			},
 			&cli.UintFlag{
 				Name:    "threads",
 				Aliases: []string{"p"},
 				Value:   1,
 				Usage:   "number of concurrent threads",
 			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result in JSON",
+			},
+			&cli.StringFlag{
+				Name:  "mix",
+				Usage: "run a mixed workload with the weights of operations (get, put, head, list, delete), e.g. get=70,put=20,list=10",
+			},
+			&cli.StringFlag{
+				Name:  "size-dist",
+				Value: "4K:30,128K:50,4M:20",
+				Usage: "weights of object sizes in the mixed workload",
+			},
+			&cli.UintFlag{
+				Name:  "ops",
+				Value: 1000,
+				Usage: "number of operations in the mixed workload",
+			},
+			&cli.UintFlag{
+				Name:  "objects",
+				Value: 100,
+				Usage: "number of objects to prepare before the mixed workload",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "write the result in JSON into this file",
+			},
+			&cli.StringFlag{
+				Name:  "baseline",
+				Usage: "a previous JSON result of the mixed workload to compare with (only with --mix)",
+			},
+			&cli.Float64Flag{
+				Name:  "threshold",
+				Value: 10,
+				Usage: "report a regression if an operation is slower than the baseline by this percent",
+			},
 		},
 	}
 }
@@ -98,6 +148,9 @@ var (
 
 func objbench(ctx *cli.Context) error {
 	setup(ctx, 1)
+	if ctx.String("baseline") != "" && ctx.String("mix") == "" {
+		logger.Fatalf("--baseline is only supported by the mixed workload (--mix)")
+	}
 	ak, sk := ctx.String("access-key"), ctx.String("secret-key")
 	if ak == "" {
 		ak = os.Getenv("ACCESS_KEY")
@@ -112,6 +165,13 @@ func objbench(ctx *cli.Context) error {
 
 	prefix := fmt.Sprintf("__juicefs_benchmark_%d__/", time.Now().UnixNano())
 	blob := object.WithPrefix(blobOrigin, prefix)
+	if algo := ctx.String("encrypt-algo"); algo != "" {
+		enc, err := newBenchEncryptor(algo)
+		if err != nil {
+			logger.Fatalf("create encryptor: %s", err)
+		}
+		blob = object.NewEncrypted(blob, enc)
+	}
 	defer func() {
 		_ = blobOrigin.Delete(prefix)
 	}()
@@ -120,7 +180,11 @@ func objbench(ctx *cli.Context) error {
 	smallBSize := int(ctx.Uint("small-object-size")) << 10
 	bCount := int(math.Ceil(float64(fsize) / float64(bSize)))
 	threads := int(ctx.Uint("threads"))
-	tty := isatty.IsTerminal(os.Stdout.Fd())
+	asJSON := ctx.Bool("json")
+	if ctx.String("mix") != "" {
+		return mixedBenchmark(ctx, blob, threads, asJSON)
+	}
+	tty := !asJSON && isatty.IsTerminal(os.Stdout.Fd())
 	progress := utils.NewProgress(!tty, false)
 	if tty {
 		nspt = fmt.Sprintf("%s%dm%s%s", COLOR_SEQ, YELLOW, nspt, RESET_SEQ)
@@ -128,13 +192,17 @@ func objbench(ctx *cli.Context) error {
 		pass = fmt.Sprintf("%s%dm%s%s", COLOR_SEQ, GREEN, pass, RESET_SEQ)
 		failed = fmt.Sprintf("%s%dm%s%s", COLOR_SEQ, RED, failed, RESET_SEQ)
 	}
-	fmt.Println("Start Functional Testing ...")
+	if !asJSON {
+		fmt.Println("Start Functional Testing ...")
+	}
 
 	var result [][]string
 	result = append(result, []string{"CATEGORY", "TEST", "RESULT"})
 	functionalTesting(blob, fsize, &result, tty)
-	printResult(result, tty)
-	fmt.Println("\nStart Performance Testing ...")
+	if !asJSON {
+		printResult(result, tty)
+		fmt.Println("\nStart Performance Testing ...")
+	}
 	var pResult [][]string
 	pResult = append(pResult, []string{"ITEM", "VALUE", "COST"})
 
@@ -354,13 +422,143 @@ func objbench(ctx *cli.Context) error {
 		_ = bm.delete(strconv.Itoa(i))
 	}
 
-	fmt.Printf("Benchmark finished! block-size: %d KiB, big-object-size: %d MiB, small-object-size: %d KiB, NumThreads: %d\n",
-		ctx.Uint("block-size"), ctx.Uint("big-object-size"), ctx.Uint("small-object-size"), ctx.Uint("threads"))
-
 	// adjust the print order
 	pResult[1], pResult[2] = pResult[2], pResult[1]
 	pResult[8], pResult[11] = pResult[11], pResult[8]
-	printResult(pResult, tty)
+	if asJSON || ctx.String("output") != "" {
+		writeReport(ctx, map[string]interface{}{
+			"Storage":         ctx.String("storage"),
+			"Time":            time.Now(),
+			"Threads":         threads,
+			"BlockSize":       ctx.Uint("block-size"),
+			"BigObjectSize":   ctx.Uint("big-object-size"),
+			"SmallObjectSize": ctx.Uint("small-object-size"),
+			"Functional":      tableToJSON(result),
+			"Performance":     tableToJSON(pResult),
+		}, asJSON)
+	}
+	if !asJSON {
+		fmt.Printf("Benchmark finished! block-size: %d KiB, big-object-size: %d MiB, small-object-size: %d KiB, NumThreads: %d\n",
+			ctx.Uint("block-size"), ctx.Uint("big-object-size"), ctx.Uint("small-object-size"), ctx.Uint("threads"))
+		printResult(pResult, tty)
+	}
+	return nil
+}
+
+// tableToJSON converts the rows of a table into objects keyed by the header in lower case.
+func tableToJSON(rows [][]string) []map[string]string {
+	if len(rows) == 0 {
+		return nil
+	}
+	objs := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		obj := make(map[string]string, len(row))
+		for i, v := range row {
+			obj[strings.ToLower(rows[0][i])] = v
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// writeReport writes the result in JSON into the file of --output, and prints it if toStdout
+// is true and --output is not set.
+func writeReport(ctx *cli.Context, report interface{}, toStdout bool) {
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if path := ctx.String("output"); path != "" {
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			logger.Fatalf("write result into %s: %s", path, err)
+		}
+	} else if toStdout {
+		fmt.Println(string(data))
+	}
+}
+
+func newBenchEncryptor(algo string) (object.Encryptor, error) {
+	if object.IsSymmetricAlgo(algo) {
+		secret := make([]byte, 32)
+		if _, err := crand.Read(secret); err != nil {
+			return nil, err
+		}
+		master, _, err := object.NewSymmetricKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		return object.NewSymmetricEncryptor(master, algo)
+	}
+	privKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewDataEncryptor(object.NewRSAEncryptor(privKey), algo)
+}
+
+func mixedBenchmark(ctx *cli.Context, blob object.ObjectStorage, threads int, asJSON bool) error {
+	mix, err := parseMix(ctx.String("mix"))
+	if err != nil {
+		logger.Fatalf("invalid mix: %s", err)
+	}
+	sizes, err := parseSizeDist(ctx.String("size-dist"))
+	if err != nil {
+		logger.Fatalf("invalid size distribution: %s", err)
+	}
+	var base *BenchReport
+	if path := ctx.String("baseline"); path != "" {
+		if base, err = loadReport(path); err != nil {
+			logger.Fatalf("load baseline: %s", err)
+		}
+	}
+	var maxSize int64
+	for _, s := range sizes {
+		if s.value > maxSize {
+			maxSize = s.value
+		}
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	preload := int(ctx.Uint("objects"))
+	if preload < 1 {
+		preload = 1
+	}
+	m := &mixBench{
+		blob:    blob,
+		threads: threads,
+		ops:     int(ctx.Uint("ops")),
+		mix:     mix,
+		sizes:   sizes,
+		content: make([]byte, maxSize),
+	}
+	rand.Read(m.content)
+	progress := utils.NewProgress(true, false)
+	stats, cost := m.run(progress, preload)
+	progress.Done()
+
+	report := &BenchReport{
+		Storage:    ctx.String("storage"),
+		Time:       time.Now(),
+		Threads:    threads,
+		Operations: m.ops,
+		Mix:        ctx.String("mix"),
+		Sizes:      ctx.String("size-dist"),
+		Duration:   cost.Seconds(),
+		Ops:        stats,
+	}
+	if base != nil {
+		report.Regressions = compareReports(base, report, ctx.Float64("threshold"))
+	}
+	if asJSON || ctx.String("output") != "" {
+		writeReport(ctx, report, asJSON)
+	}
+	if !asJSON {
+		printMixedReport(report)
+	}
+	if len(report.Regressions) > 0 {
+		for _, r := range report.Regressions {
+			logger.Warnf("Regression: %s", r)
+		}
+		return fmt.Errorf("found %d regressions against %s", len(report.Regressions), ctx.String("baseline"))
+	}
 	return nil
 }
 
@@ -0,0 +1,398 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+var mixOps = []string{"get", "put", "head", "list", "delete"}
+
+type weighted struct {
+	value  int64 // size in bytes for objects, index of mixOps for operations
+	weight int
+}
+
+type weightedChoice []weighted
+
+func (w weightedChoice) pick(r *rand.Rand) int64 {
+	var total int
+	for _, c := range w {
+		total += c.weight
+	}
+	n := r.Intn(total)
+	for _, c := range w {
+		if n < c.weight {
+			return c.value
+		}
+		n -= c.weight
+	}
+	return w[len(w)-1].value
+}
+
+// parseWeights parses a list like "get=70,put=20,list=10" or "4K:50,1M:50".
+func parseWeights(s string, value func(string) (int64, error)) (weightedChoice, error) {
+	var w weightedChoice
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ps := strings.FieldsFunc(item, func(r rune) bool { return r == '=' || r == ':' })
+		if len(ps) != 2 {
+			return nil, fmt.Errorf("invalid item %q, expect NAME=WEIGHT", item)
+		}
+		v, err := value(ps[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.Atoi(ps[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", ps[1])
+		}
+		if weight > 0 {
+			w = append(w, weighted{v, weight})
+		}
+	}
+	if len(w) == 0 {
+		return nil, fmt.Errorf("empty list: %q", s)
+	}
+	return w, nil
+}
+
+func parseMix(s string) (weightedChoice, error) {
+	return parseWeights(s, func(name string) (int64, error) {
+		for i, op := range mixOps {
+			if strings.EqualFold(name, op) {
+				return int64(i), nil
+			}
+		}
+		return 0, fmt.Errorf("unknown operation %q, should be one of %s", name, strings.Join(mixOps, ","))
+	})
+}
+
+func parseObjSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	var shift uint
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		}
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+func parseSizeDist(s string) (weightedChoice, error) {
+	return parseWeights(s, parseObjSize)
+}
+
+// LatencyStats is the latency of an operation in milliseconds.
+type LatencyStats struct {
+	Avg float64
+	P50 float64
+	P90 float64
+	P99 float64
+	Max float64
+}
+
+// OpStats is the result of one type of operation.
+type OpStats struct {
+	Count      int
+	Errors     int
+	Bytes      int64
+	Throughput float64 // ops per second
+	Bandwidth  float64 `json:",omitempty"` // MiB per second
+	Latency    LatencyStats
+}
+
+// BenchReport is the machine readable result of objbench.
+type BenchReport struct {
+	Storage     string
+	Time        time.Time
+	Threads     int
+	Operations  int
+	Mix         string
+	Sizes       string
+	Duration    float64 // seconds
+	Ops         map[string]*OpStats
+	Regressions []string `json:",omitempty"`
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return float64(sorted[idx]) / 1e6
+}
+
+func summarize(lats []time.Duration, errors int, size int64, cost time.Duration) *OpStats {
+	st := &OpStats{Count: len(lats), Errors: errors, Bytes: size}
+	if len(lats) == 0 {
+		return st
+	}
+	sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+	var total time.Duration
+	for _, l := range lats {
+		total += l
+	}
+	st.Latency = LatencyStats{
+		Avg: float64(total) / float64(len(lats)) / 1e6,
+		P50: percentile(lats, 0.5),
+		P90: percentile(lats, 0.9),
+		P99: percentile(lats, 0.99),
+		Max: float64(lats[len(lats)-1]) / 1e6,
+	}
+	if secs := cost.Seconds(); secs > 0 {
+		st.Throughput = float64(len(lats)) / secs
+		st.Bandwidth = float64(size) / (1 << 20) / secs
+	}
+	return st
+}
+
+type mixBench struct {
+	blob    object.ObjectStorage
+	threads int
+	ops     int
+	mix     weightedChoice
+	sizes   weightedChoice
+	content []byte
+
+	sync.Mutex
+	keys        []string
+	next        int
+	lats        map[string][]time.Duration
+	errs        map[string]int
+	transferred map[string]int64
+}
+
+func (m *mixBench) newKey() string {
+	m.Lock()
+	defer m.Unlock()
+	m.next++
+	return fmt.Sprintf("mix_%d", m.next)
+}
+
+func (m *mixBench) randomKey(r *rand.Rand, remove bool) string {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.keys) == 0 || remove && len(m.keys) == 1 {
+		return ""
+	}
+	i := r.Intn(len(m.keys))
+	key := m.keys[i]
+	if remove {
+		m.keys[i] = m.keys[len(m.keys)-1]
+		m.keys = m.keys[:len(m.keys)-1]
+	}
+	return key
+}
+
+func (m *mixBench) put(r *rand.Rand) (int64, error) {
+	key := m.newKey()
+	size := m.sizes.pick(r)
+	off := r.Int63n(int64(len(m.content)) - size + 1)
+	if err := m.blob.Put(key, bytes.NewReader(m.content[off:off+size])); err != nil {
+		return 0, err
+	}
+	m.Lock()
+	m.keys = append(m.keys, key)
+	m.Unlock()
+	return size, nil
+}
+
+func (m *mixBench) do(op string, r *rand.Rand) (int64, error) {
+	switch op {
+	case "put":
+		return m.put(r)
+	case "get":
+		in, err := m.blob.Get(m.randomKey(r, false), 0, -1)
+		if err != nil {
+			return 0, err
+		}
+		defer in.Close()
+		return io.Copy(io.Discard, in)
+	case "head":
+		_, err := m.blob.Head(m.randomKey(r, false))
+		return 0, err
+	case "list":
+		_, err := listAll(m.blob, "", "", 1000)
+		return 0, err
+	case "delete":
+		key := m.randomKey(r, true)
+		if key == "" {
+			return 0, nil
+		}
+		return 0, m.blob.Delete(key)
+	}
+	return 0, utils.ENOTSUP
+}
+
+func (m *mixBench) record(op string, start time.Time, size int64, err error) {
+	used := time.Since(start)
+	m.Lock()
+	defer m.Unlock()
+	if err != nil {
+		m.errs[op]++
+		logger.Debugf("%s: %s", op, err)
+		return
+	}
+	m.lats[op] = append(m.lats[op], used)
+	m.transferred[op] += size
+}
+
+func (m *mixBench) run(progress *utils.Progress, preload int) (map[string]*OpStats, time.Duration) {
+	m.lats = make(map[string][]time.Duration)
+	m.errs = make(map[string]int)
+	m.transferred = make(map[string]int64)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	bar := progress.AddCountBar("prepare objects", int64(preload))
+	for i := 0; i < preload; i++ {
+		if _, err := m.put(r); err != nil {
+			logger.Fatalf("prepare objects: %s", err)
+		}
+		bar.Increment()
+	}
+	bar.Done()
+
+	bar = progress.AddCountBar("mixed operations", int64(m.ops))
+	todo := make(chan string, m.threads)
+	var wg sync.WaitGroup
+	for i := 0; i < m.threads; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for op := range todo {
+				start := time.Now()
+				size, err := m.do(op, r)
+				m.record(op, start, size, err)
+				bar.Increment()
+			}
+		}(r.Int63())
+	}
+	start := time.Now()
+	for i := 0; i < m.ops; i++ {
+		todo <- mixOps[m.mix.pick(r)]
+	}
+	close(todo)
+	wg.Wait()
+	cost := time.Since(start)
+	bar.Done()
+
+	stats := make(map[string]*OpStats)
+	for _, op := range mixOps {
+		if len(m.lats[op]) > 0 || m.errs[op] > 0 {
+			stats[op] = summarize(m.lats[op], m.errs[op], m.transferred[op], cost)
+		}
+	}
+	for _, key := range m.keys {
+		_ = m.blob.Delete(key)
+	}
+	return stats, cost
+}
+
+// compareReports returns the operations that are slower than the baseline by more than threshold percent.
+func compareReports(base, cur *BenchReport, threshold float64) []string {
+	var regs []string
+	worse := func(op, item string, old, new float64, higherIsBetter bool) {
+		if old <= 0 {
+			return
+		}
+		change := (new - old) / old * 100
+		if higherIsBetter {
+			change = -change
+		}
+		if change > threshold {
+			regs = append(regs, fmt.Sprintf("%s %s: %.2f -> %.2f (%.1f%% worse)", op, item, old, new, change))
+		}
+	}
+	for _, op := range mixOps {
+		o, n := base.Ops[op], cur.Ops[op]
+		if o == nil || n == nil {
+			continue
+		}
+		worse(op, "throughput", o.Throughput, n.Throughput, true)
+		worse(op, "p50 latency", o.Latency.P50, n.Latency.P50, false)
+		worse(op, "p99 latency", o.Latency.P99, n.Latency.P99, false)
+		if o.Errors == 0 && n.Errors > 0 {
+			regs = append(regs, fmt.Sprintf("%s errors: 0 -> %d", op, n.Errors))
+		}
+	}
+	return regs
+}
+
+func loadReport(path string) (*BenchReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r BenchReport
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+	return &r, nil
+}
+
+// printMixedReport prints the result of a mixed workload as a table.
+func printMixedReport(r *BenchReport) {
+	fmt.Printf("Mixed workload finished! operations: %d, threads: %d, mix: %s, sizes: %s, duration: %.2f s\n",
+		r.Operations, r.Threads, r.Mix, r.Sizes, r.Duration)
+	ops := make([]string, 0, len(r.Ops))
+	for op := range r.Ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	rows := [][]string{{"OP", "COUNT", "ERRORS", "OPS/S", "MIB/S", "AVG(MS)", "P50(MS)", "P90(MS)", "P99(MS)", "MAX(MS)"}}
+	for _, op := range ops {
+		s := r.Ops[op]
+		rows = append(rows, []string{op, strconv.Itoa(s.Count), strconv.Itoa(s.Errors), fmt.Sprintf("%.1f", s.Throughput),
+			fmt.Sprintf("%.2f", s.Bandwidth), fmt.Sprintf("%.2f", s.Latency.Avg), fmt.Sprintf("%.2f", s.Latency.P50),
+			fmt.Sprintf("%.2f", s.Latency.P90), fmt.Sprintf("%.2f", s.Latency.P99), fmt.Sprintf("%.2f", s.Latency.Max)})
+	}
+	printResult(rows, false)
+}
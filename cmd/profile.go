@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -115,10 +116,42 @@ type logEntry struct {
 	latency       int // us
 }
 
+// parseJSONLine parses a line of access log in JSON (--access-log-format json).
+func parseJSONLine(line string) *logEntry {
+	var e struct {
+		Time    string
+		Op      string
+		Uid     uint32
+		Gid     uint32
+		Pid     uint32
+		Latency float64
+	}
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		logger.Warnf("Failed to parse log line: %s: %s", line, err)
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, e.Time)
+	if err != nil {
+		logger.Warnf("Failed to parse log line: %s: %s", line, err)
+		return nil
+	}
+	return &logEntry{
+		ts:      ts,
+		uid:     strconv.FormatUint(uint64(e.Uid), 10),
+		gid:     strconv.FormatUint(uint64(e.Gid), 10),
+		pid:     strconv.FormatUint(uint64(e.Pid), 10),
+		op:      e.Op,
+		latency: int(e.Latency * 1000000.0),
+	}
+}
+
 func parseLine(line string) *logEntry {
 	if len(line) < 3 { // dummy line: "#"
 		return nil
 	}
+	if line[0] == '{' {
+		return parseJSONLine(line)
+	}
 	fields := strings.Fields(line)
 	if len(fields) < 5 {
 		logger.Warnf("Log line is invalid: %s", line)
@@ -0,0 +1,41 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import "testing"
+
+func TestParseLine(t *testing.T) {
+	lines := []string{
+		"2022.06.01 10:00:00.123456 [uid:1,gid:2,pid:3] read (4,4096,0): OK (4096) <0.001500>",
+		`{"time":"2022-06-01T10:00:00.123456+08:00","op":"read","inode":4,"path":"/f","uid":1,"gid":2,"pid":3,"size":4096,"latency":0.0015,"errno":0,"args":"(4,4096,0): OK (4096)"}`,
+	}
+	for _, line := range lines {
+		e := parseLine(line)
+		if e == nil {
+			t.Fatalf("parse %s failed", line)
+		}
+		if e.uid != "1" || e.gid != "2" || e.pid != "3" || e.op != "read" || e.latency != 1500 || e.ts.Nanosecond() != 123456000 {
+			t.Fatalf("unexpected entry of %s: %+v", line, e)
+		}
+	}
+	if e := parseLine("#"); e != nil {
+		t.Fatalf("dummy line should be ignored")
+	}
+	if e := parseLine("{invalid"); e != nil {
+		t.Fatalf("invalid line should be ignored")
+	}
+}
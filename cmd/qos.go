@@ -0,0 +1,130 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdQos() *cli.Command {
+	return &cli.Command{
+		Name:      "qos",
+		Action:    qos,
+		Category:  "TOOL",
+		Usage:     "Show or update the QoS settings of a mount point",
+		ArgsUsage: "MOUNTPOINT",
+		Description: `
+This command shows the QoS settings of a mounted volume, or replaces them at runtime
+without remounting. The mount point must be mounted with --qos or --max-downloads,
+and it should be run by root.
+
+Examples:
+# Show current settings
+$ juicefs qos /mnt/jfs
+
+# Apply new rules from a file
+$ juicefs qos /mnt/jfs --rules /etc/juicefs/qos.json
+
+# Only change the max number of concurrent downloads
+$ juicefs qos /mnt/jfs --max-downloads 50`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "rules",
+				Usage: "path to a JSON file with the new QoS settings",
+			},
+			&cli.IntFlag{
+				Name:  "max-downloads",
+				Usage: "max number of concurrent downloads (0 means unlimited)",
+			},
+		},
+	}
+}
+
+func sendQoS(f *os.File, conf *chunk.QoSConfig) []byte {
+	var data []byte
+	size := uint32(0)
+	if conf != nil {
+		var err error
+		if data, err = json.Marshal(conf); err != nil {
+			logger.Fatalf("encode QoS settings: %s", err)
+		}
+		size = 4 + uint32(len(data))
+	}
+	wb := utils.NewBuffer(8 + size)
+	wb.Put32(meta.QoS)
+	wb.Put32(size)
+	if conf != nil {
+		wb.Put32(uint32(len(data)))
+		wb.Put(data)
+	}
+	if _, err := f.Write(wb.Bytes()); err != nil {
+		logger.Fatalf("write message: %s", err)
+	}
+	header := make([]byte, 5)
+	n := readControl(f, header)
+	if n == 1 && header[0] == byte(syscall.EINVAL&0xff) {
+		logger.Fatalf("QoS is not supported, please upgrade and mount again")
+	}
+	r := utils.ReadBuffer(header)
+	errno := syscall.Errno(r.Get8())
+	msg := make([]byte, r.Get32())
+	for got := 0; got < len(msg); {
+		got += readControl(f, msg[got:])
+	}
+	if errno != 0 {
+		logger.Fatalf("QoS: %s (%s)", string(msg), errno)
+	}
+	return msg
+}
+
+func qos(ctx *cli.Context) error {
+	setup(ctx, 1)
+	f := openController(ctx.Args().Get(0))
+	defer f.Close()
+
+	var conf *chunk.QoSConfig
+	if ctx.IsSet("rules") || ctx.IsSet("max-downloads") {
+		current := new(chunk.QoSConfig)
+		if err := json.Unmarshal(sendQoS(f, nil), current); err != nil {
+			logger.Fatalf("decode QoS settings: %s", err)
+		}
+		conf = current
+		if path := ctx.String("rules"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Fatalf("read %s: %s", path, err)
+			}
+			conf = new(chunk.QoSConfig)
+			if err = json.Unmarshal(data, conf); err != nil {
+				logger.Fatalf("parse %s: %s", path, err)
+			}
+		}
+		if ctx.IsSet("max-downloads") {
+			conf.MaxDownloads = ctx.Int("max-downloads")
+		}
+	}
+	fmt.Println(string(sendQoS(f, conf)))
+	return nil
+}
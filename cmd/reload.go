@@ -0,0 +1,74 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdReload() *cli.Command {
+	return &cli.Command{
+		Name:      "reload",
+		Action:    reload,
+		Category:  "TOOL",
+		Usage:     "Reload the config file of a mount point",
+		ArgsUsage: "MOUNTPOINT",
+		Description: `
+The mount point must be mounted with --config. The changes of upload/download limits,
+cache size, prefetch, attribute/entry cache timeouts and access log are applied at
+once, others are reported as needing a remount. Sending SIGHUP to the mount process
+has the same effect.
+
+Examples:
+$ juicefs mount redis://localhost /mnt/jfs --config /etc/juicefs/vol.yaml -d
+# Modify /etc/juicefs/vol.yaml and apply it
+$ juicefs reload /mnt/jfs`,
+	}
+}
+
+func reload(ctx *cli.Context) error {
+	setup(ctx, 1)
+	f := openController(ctx.Args().Get(0))
+	defer f.Close()
+	wb := utils.NewBuffer(8)
+	wb.Put32(meta.Reload)
+	wb.Put32(0)
+	if _, err := f.Write(wb.Bytes()); err != nil {
+		logger.Fatalf("write message: %s", err)
+	}
+	header := make([]byte, 5)
+	n := readControl(f, header)
+	if n == 1 && header[0] == byte(syscall.EINVAL&0xff) {
+		logger.Fatalf("Reload is not supported, please upgrade and mount again")
+	}
+	r := utils.ReadBuffer(header)
+	errno := syscall.Errno(r.Get8())
+	msg := make([]byte, r.Get32())
+	for got := 0; got < len(msg); {
+		got += readControl(f, msg[got:])
+	}
+	if errno != 0 {
+		logger.Fatalf("Reload: %s (%s)", string(msg), errno)
+	}
+	fmt.Println(string(msg))
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/pkg/xattr"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdRetention() *cli.Command {
+	return &cli.Command{
+		Name:      "retention",
+		Action:    retention,
+		Category:  "TOOL",
+		Usage:     "Show or extend the retention of WORM files",
+		ArgsUsage: "PATH ...",
+		Description: `
+Files created in or moved into a directory with the extended attribute "user.juicefs.retention"
+become read-only (once they are closed if being written), and can not be deleted or renamed
+until the retention time. This command shows the retention period of directories and the
+retention time of files, and can extend the retention time of files (it can never be shortened).
+The retention should be enabled on the volume first by "juicefs config --enable-retention".
+
+Examples:
+# Enable retention for 30 days on a directory
+$ setfattr -n user.juicefs.retention -v 30d /mnt/jfs/records
+
+# Show the retention of files in it
+$ juicefs retention -r /mnt/jfs/records
+
+# Extend the retention of a file by one year
+$ juicefs retention --extend 365d /mnt/jfs/records/2022.log`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "extend",
+				Usage: "extend the retention time of files by a period (e.g. 86400, 720h, 30d)",
+			},
+			&cli.BoolFlag{
+				Name:    "recursive",
+				Aliases: []string{"r"},
+				Usage:   "process files in directories recursively",
+			},
+		},
+	}
+}
+
+func showRetention(path string, isDir bool, extend time.Duration) error {
+	if isDir {
+		v, err := xattr.Get(path, meta.RetentionXattr)
+		if errors.Is(err, xattr.ENOATTR) {
+			fmt.Printf("%s: no retention\n", path)
+		} else if err != nil {
+			return err
+		} else if d, err := meta.ParseRetention(string(v)); err != nil {
+			fmt.Printf("%s: %s\n", path, err)
+		} else {
+			fmt.Printf("%s: retention %s\n", path, d)
+		}
+		return nil
+	}
+	v, err := xattr.Get(path, meta.RetainUntilXattr)
+	if errors.Is(err, xattr.ENOATTR) {
+		fmt.Printf("%s: not retained\n", path)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	until, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid retention time %q", v)
+	}
+	if extend > 0 {
+		if now := time.Now().Unix(); until < now {
+			until = now
+		}
+		until += int64(extend / time.Second)
+		if err = xattr.Set(path, meta.RetainUntilXattr, []byte(strconv.FormatInt(until, 10))); err != nil {
+			return err
+		}
+	}
+	t := time.Unix(until, 0)
+	if left := time.Until(t); left > 0 {
+		fmt.Printf("%s: retained until %s (%s left)\n", path, t.Format(time.RFC3339), left.Round(time.Second))
+	} else {
+		fmt.Printf("%s: retention expired at %s\n", path, t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func retention(ctx *cli.Context) error {
+	setup(ctx, 1)
+	var extend time.Duration
+	if s := ctx.String("extend"); s != "" {
+		var err error
+		if extend, err = meta.ParseRetention(s); err != nil {
+			logger.Fatalf("%s", err)
+		}
+	}
+	var failed bool
+	for _, p := range ctx.Args().Slice() {
+		fi, err := os.Stat(p)
+		if err != nil {
+			logger.Errorf("stat %s: %s", p, err)
+			failed = true
+			continue
+		}
+		if !fi.IsDir() || !ctx.Bool("recursive") {
+			if err = showRetention(p, fi.IsDir(), extend); err != nil {
+				logger.Errorf("%s: %s", p, err)
+				failed = true
+			}
+			continue
+		}
+		err = filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || fi.Mode().IsRegular() {
+				if err = showRetention(path, fi.IsDir(), extend); err != nil {
+					logger.Errorf("%s: %s", path, err)
+					failed = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Errorf("walk %s: %s", p, err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("failed to process some paths")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
+	osync "github.com/juicedata/juicefs/pkg/sync"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdScrub() *cli.Command {
+	return &cli.Command{
+		Name:      "scrub",
+		Action:    scrub,
+		Category:  "ADMIN",
+		Usage:     "Verify all objects in local storage against their checksums",
+		ArgsUsage: "META-URL",
+		Description: `
+It reads all objects of a volume using the local file storage, and verifies them against the
+checksums stored along with them, to find out any corrupted object (bitrot).
+The checksums are stored only when the bucket is created with option "checksum=true",
+e.g. "--storage file --bucket /data/jfs/?checksum=true&fsync=true".
+
+Examples:
+$ juicefs scrub redis://localhost
+
+# Check objects with 20 threads
+$ juicefs scrub redis://localhost -p 20`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "threads",
+				Aliases: []string{"p"},
+				Value:   10,
+				Usage:   "number of concurrent threads",
+			},
+		},
+	}
+}
+
+func scrub(ctx *cli.Context) error {
+	setup(ctx, 1)
+	removePassword(ctx.Args().Get(0))
+	m := meta.NewClient(ctx.Args().Get(0), &meta.Config{Retries: 10, Strict: true})
+	format, err := m.Load(true)
+	if err != nil {
+		logger.Fatalf("load setting: %s", err)
+	}
+	if strings.ToLower(format.Storage) != "file" {
+		logger.Fatalf("scrub is only supported for file storage, but got %s", format.Storage)
+	}
+	// the checksums are calculated on the encrypted data
+	format.EncryptKey = ""
+	blob, err := createStorage(*format)
+	if err != nil {
+		logger.Fatalf("object storage: %s", err)
+	}
+	scrubber, ok := blob.(object.SupportScrub)
+	if !ok {
+		logger.Fatalf("storage %s does not support scrub", blob)
+	}
+	logger.Infof("Data use %s", blob)
+
+	objs, err := osync.ListAll(blob, "", "")
+	if err != nil {
+		logger.Fatalf("list all objects: %s", err)
+	}
+	progress := utils.NewProgress(false, false)
+	bar := progress.AddCountBar("Scanned objects", 0)
+	var noChecksum, corrupted int64
+	var mu sync.Mutex
+	var corruptedKeys []string
+	todo := make(chan object.Object, 1024)
+	var wg sync.WaitGroup
+	for i := 0; i < ctx.Int("threads"); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range todo {
+				err := scrubber.Scrub(o.Key())
+				if err == object.ErrNoChecksum {
+					atomic.AddInt64(&noChecksum, 1)
+				} else if err != nil {
+					logger.Errorf("Object %s is corrupted: %s", o.Key(), err)
+					atomic.AddInt64(&corrupted, 1)
+					mu.Lock()
+					corruptedKeys = append(corruptedKeys, o.Key())
+					mu.Unlock()
+				}
+				bar.Increment()
+			}
+		}()
+	}
+	for o := range objs {
+		if o == nil {
+			logger.Fatalf("list all objects failed")
+		}
+		if o.IsDir() {
+			continue
+		}
+		bar.IncrTotal(1)
+		todo <- o
+	}
+	close(todo)
+	wg.Wait()
+	bar.Done()
+	progress.Done()
+
+	logger.Infof("Scanned %d objects, %d without checksum, %d corrupted", bar.Current(), noChecksum, corrupted)
+	if corrupted > 0 {
+		return fmt.Errorf("found %d corrupted objects: %s", corrupted, strings.Join(corruptedKeys, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdServeHTTP() *cli.Command {
+	selfFlags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "list-dir",
+			Usage: "list the directories in JSON",
+		},
+		&cli.StringFlag{
+			Name:  "access-log",
+			Usage: "path for JuiceFS access log",
+		},
+		&cli.StringFlag{
+			Name:  "http-access-log",
+			Usage: "path for the log of HTTP requests",
+		},
+		&cli.IntFlag{
+			Name:  "path-depth",
+			Value: 1,
+			Usage: "depth of the directories to label the metrics of requests",
+		},
+		&cli.StringFlag{
+			Name:  "cert-file",
+			Usage: "certificate file to serve with TLS",
+		},
+		&cli.StringFlag{
+			Name:  "key-file",
+			Usage: "private key file to serve with TLS",
+		},
+	}
+	compoundFlags := [][]cli.Flag{
+		clientFlags(),
+		selfFlags,
+		cacheFlags(0),
+		shareInfoFlags(),
+	}
+
+	return &cli.Command{
+		Name:      "serve-http",
+		Action:    serveHTTP,
+		Category:  "SERVICE",
+		Usage:     "Start a read-only HTTP server",
+		ArgsUsage: "META-URL ADDRESS",
+		Description: `
+Serve the files by HTTP in read-only mode, with range requests, ETag and conditional
+requests, and optional listings of directories in JSON.
+
+Examples:
+$ juicefs serve-http redis://localhost localhost:9008
+
+# List the directories (GET /dir/ returns a JSON array of the entries)
+$ juicefs serve-http redis://localhost :9008 --list-dir --http-access-log /var/log/juicefs-http.log`,
+		Flags: expandFlags(compoundFlags),
+	}
+}
+
+func serveHTTP(c *cli.Context) error {
+	setup(c, 2)
+	metaUrl := c.Args().Get(0)
+	listenAddr := c.Args().Get(1)
+	if (c.String("cert-file") == "") != (c.String("key-file") == "") {
+		logger.Fatalf("--cert-file and --key-file should be specified together")
+	}
+	_ = c.Set("read-only", "true")
+	m, store, conf, registerer := initForSvc(c, "serve-http", metaUrl)
+	jfs, err := fs.NewFileSystem(conf, m, store)
+	if err != nil {
+		logger.Fatalf("initialize failed: %s", err)
+	}
+	sconf := fs.ServeConfig{
+		Addr:       listenAddr,
+		ListDir:    c.Bool("list-dir"),
+		PathDepth:  c.Int("path-depth"),
+		Registerer: registerer,
+		CertFile:   c.String("cert-file"),
+		KeyFile:    c.String("key-file"),
+	}
+	if p := c.String("http-access-log"); p != "" {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			logger.Fatalf("open %s: %s", p, err)
+		}
+		defer f.Close()
+		sconf.AccessLog = f
+	}
+	fs.StartFileServer(jfs, sconf)
+	return m.CloseSession()
+}
@@ -0,0 +1,139 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdToken() *cli.Command {
+	return &cli.Command{
+		Name:     "token",
+		Category: "ADMIN",
+		Usage:    "Manage access tokens of a volume",
+		Description: `
+An access token limits the clients using it (with --token) to a subtree of the volume,
+optionally read-only and with the uids of the client mapped to others. The subtree is
+enforced whatever --subdir is given, which is resolved inside the subtree.
+
+Examples:
+$ juicefs token create redis://localhost tenant1 --subdir /tenants/1 --uid-map 1000:2000
+$ juicefs mount redis://localhost /mnt/jfs --token tenant1:1f0c0a5ad7a8e8c9b4c8a3d1c7e7a0b2
+$ juicefs token list redis://localhost
+$ juicefs token revoke redis://localhost tenant1`,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "create",
+				Action:    createToken,
+				Usage:     "Create a token and print it",
+				ArgsUsage: "META-URL NAME",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "subdir",
+						Value: "/",
+						Usage: "the subtree the clients are limited to (created if not exist)",
+					},
+					&cli.BoolFlag{
+						Name:  "read-only",
+						Usage: "allow lookup/read operations only",
+					},
+					&cli.StringFlag{
+						Name:  "uid-map",
+						Usage: "map the uids of clients to the ones in the volume (e.g. 1000:2000,1001:2001)",
+					},
+				},
+			},
+			{
+				Name:      "list",
+				Action:    listTokens,
+				Usage:     "List all tokens",
+				ArgsUsage: "META-URL",
+			},
+			{
+				Name:      "revoke",
+				Action:    revokeToken,
+				Usage:     "Revoke a token, the clients using it can't mount any more",
+				ArgsUsage: "META-URL NAME",
+			},
+		},
+	}
+}
+
+func openMeta(ctx *cli.Context) meta.Meta {
+	uri := ctx.Args().Get(0)
+	if !strings.Contains(uri, "://") {
+		uri = "redis://" + uri
+	}
+	removePassword(uri)
+	m := meta.NewClient(uri, &meta.Config{Retries: 10, Strict: true})
+	if _, err := m.Load(true); err != nil {
+		logger.Fatalf("load setting: %s", err)
+	}
+	return m
+}
+
+func createToken(ctx *cli.Context) error {
+	setup(ctx, 2)
+	m := openMeta(ctx)
+	uids, err := meta.ParseIdMap(ctx.String("uid-map"))
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+	token, err := m.CreateToken(&meta.Token{
+		Name:     ctx.Args().Get(1),
+		Subdir:   ctx.String("subdir"),
+		ReadOnly: ctx.Bool("read-only"),
+		UidMap:   uids,
+	})
+	if err != nil {
+		logger.Fatalf("create token: %s", err)
+	}
+	fmt.Println(token)
+	return nil
+}
+
+func listTokens(ctx *cli.Context) error {
+	setup(ctx, 1)
+	m := openMeta(ctx)
+	tokens, err := m.ListTokens()
+	if err != nil {
+		logger.Fatalf("list tokens: %s", err)
+	}
+	fmt.Printf("%-16s %-32s %-6s %-20s %s\n", "Name", "Subdir", "Access", "UidMap", "Created")
+	for _, t := range tokens {
+		access := "rw"
+		if t.ReadOnly {
+			access = "ro"
+		}
+		fmt.Printf("%-16s %-32s %-6s %-20s %s\n", t.Name, t.Subdir, access, t.UidMap, time.Unix(t.Created, 0).Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func revokeToken(ctx *cli.Context) error {
+	setup(ctx, 2)
+	m := openMeta(ctx)
+	if err := m.RevokeToken(ctx.Args().Get(1)); err != nil {
+		logger.Fatalf("revoke token: %s", err)
+	}
+	return nil
+}
@@ -39,6 +39,27 @@ func cmdWebDav() *cli.Command {
 			Name:  "access-log",
 			Usage: "path for JuiceFS access log",
 		},
+		&cli.StringFlag{
+			Name:  "users",
+			Usage: "file of users (NAME:HASH[:UID[:GID,GID...]] per line) to authenticate the clients",
+		},
+		&cli.BoolFlag{
+			Name:  "digest",
+			Usage: "use Digest authentication instead of Basic",
+		},
+		&cli.StringFlag{
+			Name:  "realm",
+			Value: "JuiceFS",
+			Usage: "realm of the authentication",
+		},
+		&cli.StringFlag{
+			Name:  "cert-file",
+			Usage: "certificate file to serve with TLS",
+		},
+		&cli.StringFlag{
+			Name:  "key-file",
+			Usage: "private key file to serve with TLS",
+		},
 	}
 	compoundFlags := [][]cli.Flag{
 		clientFlags(),
@@ -55,7 +76,10 @@ func cmdWebDav() *cli.Command {
 		ArgsUsage: "META-URL ADDRESS",
 		Description: `
 Examples:
-$ juicefs webdav redis://localhost localhost:9007`,
+$ juicefs webdav redis://localhost localhost:9007
+
+# Authenticate the clients with TLS
+$ juicefs webdav redis://localhost :9007 --users users.txt --cert-file server.crt --key-file server.key`,
 		Flags: expandFlags(compoundFlags),
 	}
 }
@@ -64,11 +88,23 @@ func webdav(c *cli.Context) error {
 	setup(c, 2)
 	metaUrl := c.Args().Get(0)
 	listenAddr := c.Args().Get(1)
-	m, store, conf := initForSvc(c, "webdav", metaUrl)
+	m, store, conf, _ := initForSvc(c, "webdav", metaUrl)
 	jfs, err := fs.NewFileSystem(conf, m, store)
 	if err != nil {
 		logger.Fatalf("initialize failed: %s", err)
 	}
-	fs.StartHTTPServer(jfs, listenAddr, c.Bool("gzip"), c.Bool("disallowList"))
+	if (c.String("cert-file") == "") != (c.String("key-file") == "") {
+		logger.Fatalf("--cert-file and --key-file should be specified together")
+	}
+	fs.StartHTTPServer(jfs, fs.WebdavConfig{
+		Addr:         listenAddr,
+		DisallowList: c.Bool("disallowList"),
+		EnableGzip:   c.Bool("gzip"),
+		Users:        c.String("users"),
+		Digest:       c.Bool("digest"),
+		Realm:        c.String("realm"),
+		CertFile:     c.String("cert-file"),
+		KeyFile:      c.String("key-file"),
+	})
 	return m.CloseSession()
 }
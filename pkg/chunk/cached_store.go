@@ -39,75 +39,7 @@ const chunkSize = 1 << 26 // 64M
 const pageSize = 1 << 16  // 64K
 const SlowRequest = time.Second * time.Duration(10)
 
-var (
-	logger = utils.GetLogger("juicefs")
-
-	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_hits",
-		Help: "read from cached block",
-	})
-	cacheMiss = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_miss",
-		Help: "missed read from cached block",
-	})
-	cacheWrites = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_writes",
-		Help: "written cached block",
-	})
-	cacheDrops = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_drops",
-		Help: "dropped block",
-	})
-	cacheEvicts = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_evicts",
-		Help: "evicted cache blocks",
-	})
-	cacheHitBytes = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_hit_bytes",
-		Help: "read bytes from cached block",
-	})
-	cacheMissBytes = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_miss_bytes",
-		Help: "missed bytes from cached block",
-	})
-	cacheWriteBytes = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "blockcache_write_bytes",
-		Help: "write bytes of cached block",
-	})
-	cacheReadHist = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "blockcache_read_hist_seconds",
-		Help:    "read cached block latency distribution",
-		Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
-	})
-	cacheWriteHist = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "blockcache_write_hist_seconds",
-		Help:    "write cached block latency distribution",
-		Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
-	})
-
-	objectReqsHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "object_request_durations_histogram_seconds",
-		Help:    "Object requests latency distributions.",
-		Buckets: prometheus.ExponentialBuckets(0.01, 1.5, 25),
-	}, []string{"method"})
-	objectReqErrors = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "object_request_errors",
-		Help: "failed requests to object store",
-	})
-	objectDataBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "object_request_data_bytes",
-		Help: "Object requests size in bytes.",
-	}, []string{"method"})
-
-	stageBlocks = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "staging_blocks",
-		Help: "Number of blocks in the staging path.",
-	})
-	stageBlockBytes = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "staging_block_bytes",
-		Help: "Total bytes of blocks in the staging path.",
-	})
-)
+var logger = utils.GetLogger("juicefs")
 
 type pendingItem struct {
 	key   string
@@ -197,9 +129,9 @@ func (c *rChunk) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 			n, err = r.ReadAt(p, int64(boff))
 			_ = r.Close()
 			if err == nil {
-				cacheHits.Add(1)
-				cacheHitBytes.Add(float64(n))
-				cacheReadHist.Observe(time.Since(start).Seconds())
+				c.store.cacheHits.Add(1)
+				c.store.cacheHitBytes.Add(float64(n))
+				c.store.cacheReadHist.Observe(time.Since(start).Seconds())
 				return n, nil
 			}
 			if f, ok := r.(*os.File); ok {
@@ -209,13 +141,12 @@ func (c *rChunk) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 		}
 	}
 
-	cacheMiss.Add(1)
-	cacheMissBytes.Add(float64(len(p)))
+	c.store.cacheMiss.Add(1)
+	c.store.cacheMissBytes.Add(float64(len(p)))
 
 	if c.store.seekable && boff > 0 && len(p) <= blockSize/4 {
-		if c.store.downLimit != nil {
-			c.store.downLimit.Wait(int64(len(p)))
-		}
+		done := c.store.conf.QoS.acquire(ctx, PriorityInteractive, len(p))
+		c.store.waitDownload(int64(len(p)))
 		// partial read
 		st := time.Now()
 		in, err := c.store.storage.Get(key, int64(boff), int64(len(p)))
@@ -223,18 +154,19 @@ func (c *rChunk) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 			n, err = io.ReadFull(in, p)
 			_ = in.Close()
 		}
+		done()
 		used := time.Since(st)
 		logger.Debugf("GET %s RANGE(%d,%d) (%s, %.3fs)", key, boff, len(p), err, used.Seconds())
 		if used > SlowRequest {
 			logger.Infof("slow request: GET %s (%v, %.3fs)", key, err, used.Seconds())
 		}
-		objectDataBytes.WithLabelValues("GET").Add(float64(n))
-		objectReqsHistogram.WithLabelValues("GET").Observe(used.Seconds())
+		c.store.objectDataBytes.WithLabelValues("GET").Add(float64(n))
+		c.store.objectReqsHistogram.WithLabelValues("GET").Observe(used.Seconds())
 		c.store.fetcher.fetch(key)
 		if err == nil {
 			return n, nil
 		} else {
-			objectReqErrors.Add(1)
+			c.store.objectReqErrors.Add(1)
 		}
 	}
 
@@ -248,7 +180,7 @@ func (c *rChunk) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 		tmp.Acquire()
 		err := utils.WithTimeout(func() error {
 			defer tmp.Release()
-			return c.store.load(key, tmp, c.store.shouldCache(blockSize), false)
+			return c.store.load(ctx, key, tmp, c.store.shouldCache(blockSize), false)
 		}, c.store.conf.GetTimeout)
 		return tmp, err
 	})
@@ -262,6 +194,25 @@ func (c *rChunk) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 	return len(p), nil
 }
 
+func (c *rChunk) ReadFile(off, size int) (uintptr, int64, io.Closer, error) {
+	if c.store.conf.CacheSize == 0 || off+size > c.length {
+		return 0, 0, nil, errors.New("not cached")
+	}
+	indx := c.index(off)
+	boff := off % c.store.conf.BlockSize
+	if boff+size > c.blockSize(indx) {
+		return 0, 0, nil, errors.New("cross blocks")
+	}
+	c.store.filesOnce.Do(func() { c.store.files = newFileCache(1024, time.Minute) })
+	of, err := c.store.files.open(c.key(indx), c.blockSize(indx), c.store.bcache.load)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	c.store.cacheHits.Add(1)
+	c.store.cacheHitBytes.Add(float64(size))
+	return of.f.Fd(), int64(boff), of, nil
+}
+
 func (c *rChunk) delete(indx int) error {
 	key := c.key(indx)
 	st := time.Now()
@@ -271,9 +222,9 @@ func (c *rChunk) delete(indx int) error {
 	if used > SlowRequest {
 		logger.Infof("slow request: DELETE %v (%v, %.3fs)", key, err, used.Seconds())
 	}
-	objectReqsHistogram.WithLabelValues("DELETE").Observe(used.Seconds())
+	c.store.objectReqsHistogram.WithLabelValues("DELETE").Observe(used.Seconds())
 	if err != nil {
-		objectReqErrors.Add(1)
+		c.store.objectReqErrors.Add(1)
 	}
 	return err
 }
@@ -340,6 +291,7 @@ type wChunk struct {
 	errors      chan error
 	uploadError error
 	pendings    int
+	owner       context.Context // the identity of the writer for QoS
 }
 
 func chunkForWrite(id uint64, store *cachedStore) *wChunk {
@@ -347,6 +299,7 @@ func chunkForWrite(id uint64, store *cachedStore) *wChunk {
 		rChunk: rChunk{id, 0, store},
 		pages:  make([][]*Page, chunkSize/store.conf.BlockSize),
 		errors: make(chan error, chunkSize/store.conf.BlockSize),
+		owner:  context.Background(),
 	}
 }
 
@@ -354,6 +307,10 @@ func (c *wChunk) SetID(id uint64) {
 	c.id = id
 }
 
+func (c *wChunk) SetOwner(id Identity) {
+	c.owner = WithIdentity(context.Background(), id)
+}
+
 func (c *wChunk) WriteAt(p []byte, off int64) (n int, err error) {
 	if int(off)+len(p) > chunkSize {
 		return 0, fmt.Errorf("write out of chunk boudary: %d > %d", int(off)+len(p), chunkSize)
@@ -400,9 +357,7 @@ func (c *wChunk) WriteAt(p []byte, off int64) (n int, err error) {
 }
 
 func (store *cachedStore) put(key string, p *Page) error {
-	if store.upLimit != nil {
-		store.upLimit.Wait(int64(len(p.Data)))
-	}
+	store.waitUpload(int64(len(p.Data)))
 	p.Acquire()
 	return utils.WithTimeout(func() error {
 		defer p.Release()
@@ -413,16 +368,16 @@ func (store *cachedStore) put(key string, p *Page) error {
 		if used > SlowRequest {
 			logger.Infof("slow request: PUT %v (%v, %.3fs)", key, err, used.Seconds())
 		}
-		objectDataBytes.WithLabelValues("PUT").Add(float64(len(p.Data)))
-		objectReqsHistogram.WithLabelValues("PUT").Observe(used.Seconds())
+		store.objectDataBytes.WithLabelValues("PUT").Add(float64(len(p.Data)))
+		store.objectReqsHistogram.WithLabelValues("PUT").Observe(used.Seconds())
 		if err != nil {
-			objectReqErrors.Add(1)
+			store.objectReqErrors.Add(1)
 		}
 		return err
 	}, store.conf.PutTimeout)
 }
 
-func (store *cachedStore) upload(key string, block *Page, c *wChunk) error {
+func (store *cachedStore) upload(ctx context.Context, key string, block *Page, c *wChunk) error {
 	sync := c != nil
 	blen := len(block.Data)
 	bufSize := store.compressor.CompressBound(blen)
@@ -444,6 +399,8 @@ func (store *cachedStore) upload(key string, block *Page, c *wChunk) error {
 		return fmt.Errorf("Compress block key %s: %s", key, err)
 	}
 	buf.Data = buf.Data[:n]
+	store.compressInputBytes.Add(float64(blen))
+	store.compressOutputBytes.Add(float64(n))
 
 	try, max := 0, 3
 	if sync {
@@ -455,6 +412,7 @@ func (store *cachedStore) upload(key string, block *Page, c *wChunk) error {
 			err = fmt.Errorf("(cancelled) upload block %s: %s (after %d tries)", key, err, try)
 			break
 		}
+		store.conf.QoS.limit(ctx, len(buf.Data))
 		if err = store.put(key, buf); err == nil {
 			break
 		}
@@ -499,11 +457,11 @@ func (c *wChunk) upload(indx int) {
 					select {
 					case c.store.currentUpload <- true:
 						defer func() { <-c.store.currentUpload }()
-						if err = c.store.upload(key, block, nil); err == nil {
+						if err = c.store.upload(c.owner, key, block, nil); err == nil {
 							c.store.bcache.uploaded(key, blen)
 							if os.Remove(stagingPath) == nil {
-								stageBlocks.Sub(1)
-								stageBlockBytes.Sub(float64(blen))
+								c.store.stageBlocks.Sub(1)
+								c.store.stageBlockBytes.Sub(float64(blen))
 							}
 						} else { // add to delay list and wait for later scanning
 							c.store.addDelayedStaging(key, stagingPath, time.Now().Add(time.Second*30), false)
@@ -519,7 +477,7 @@ func (c *wChunk) upload(indx int) {
 		}
 		c.store.currentUpload <- true
 		defer func() { <-c.store.currentUpload }()
-		c.errors <- c.store.upload(key, block, c)
+		c.errors <- c.store.upload(c.owner, key, block, c)
 	}()
 }
 
@@ -602,6 +560,9 @@ type Config struct {
 	BufferSize     int
 	Readahead      int
 	Prefetch       int
+	QoS            *QoS `json:"-"`
+	// UploadPool limits the concurrent uploads shared by multiple stores, MaxUpload is used if it's nil
+	UploadPool chan bool `json:"-"`
 }
 
 type cachedStore struct {
@@ -617,22 +578,28 @@ type cachedStore struct {
 	pendingMutex  sync.Mutex
 	compressor    compress.Compressor
 	seekable      bool
+	limitLock     sync.RWMutex
 	upLimit       *ratelimit.Bucket
 	downLimit     *ratelimit.Bucket
+	filesOnce     sync.Once
+	files         *fileCache
+	*storeMetrics
 }
 
-func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bool) (err error) {
+func (store *cachedStore) load(ctx context.Context, key string, page *Page, cache bool, forceCache bool) (err error) {
 	defer func() {
 		e := recover()
 		if e != nil {
 			err = fmt.Errorf("recovered from %s", e)
 		}
 	}()
+	done := store.conf.QoS.acquire(ctx, PriorityInteractive, len(page.Data))
+	defer done()
 	needed := store.compressor.CompressBound(len(page.Data))
 	compressed := needed > len(page.Data)
 	// we don't know the actual size for compressed block
-	if store.downLimit != nil && !compressed {
-		store.downLimit.Wait(int64(len(page.Data)))
+	if !compressed {
+		store.waitDownload(int64(len(page.Data)))
 	}
 	err = errors.New("Not downloaded")
 	var in io.ReadCloser
@@ -643,7 +610,7 @@ func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bo
 		time.Sleep(time.Second * time.Duration(tried*tried))
 		if tried > 0 {
 			logger.Warnf("GET %s: %s; retrying", key, err)
-			objectReqErrors.Add(1)
+			store.objectReqErrors.Add(1)
 			start = time.Now()
 		}
 		in, err = store.storage.Get(key, 0, -1)
@@ -670,13 +637,13 @@ func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bo
 	if used > SlowRequest {
 		logger.Infof("slow request: GET %s (%v, %.3fs)", key, err, used.Seconds())
 	}
-	if store.downLimit != nil && compressed {
-		store.downLimit.Wait(int64(n))
+	if compressed {
+		store.waitDownload(int64(n))
 	}
-	objectDataBytes.WithLabelValues("GET").Add(float64(n))
-	objectReqsHistogram.WithLabelValues("GET").Observe(used.Seconds())
+	store.objectDataBytes.WithLabelValues("GET").Add(float64(n))
+	store.objectReqsHistogram.WithLabelValues("GET").Observe(used.Seconds())
 	if err != nil {
-		objectReqErrors.Add(1)
+		store.objectReqErrors.Add(1)
 		return fmt.Errorf("get %s: %s", key, err)
 	}
 	if compressed {
@@ -717,15 +684,13 @@ func NewCachedStore(storage object.ObjectStorage, config Config, registerer prom
 		pendingCh:     make(chan pendingItem, 100*config.MaxUpload),
 		pendingKeys:   make(map[string]time.Time),
 		group:         &Controller{},
+		storeMetrics:  newStoreMetrics(),
 	}
-	if config.UploadLimit > 0 {
-		// there are overheads coming from HTTP/TCP/IP
-		store.upLimit = ratelimit.NewBucketWithRate(float64(config.UploadLimit)*0.85, config.UploadLimit)
-	}
-	if config.DownloadLimit > 0 {
-		store.downLimit = ratelimit.NewBucketWithRate(float64(config.DownloadLimit)*0.85, config.DownloadLimit)
+	if config.UploadPool != nil {
+		store.currentUpload = config.UploadPool
 	}
-	store.bcache = newCacheManager(&config, func(key, fpath string, force bool) bool {
+	store.setLimits(config.UploadLimit, config.DownloadLimit)
+	store.bcache = newCacheManager(&config, store.storeMetrics, func(key, fpath string, force bool) bool {
 		if force {
 			return store.addDelayedStaging(key, fpath, time.Time{}, true)
 		} else if fi, err := os.Stat(fpath); err == nil {
@@ -744,9 +709,9 @@ func NewCachedStore(storage object.ObjectStorage, config Config, registerer prom
 		}
 		p := NewOffPage(size)
 		defer p.Release()
-		_ = store.load(key, p, true, true)
+		_ = store.load(WithIdentity(context.Background(), Identity{Priority: PriorityPrefetch}), key, p, true, true)
 	})
-	initMetrics(store, registerer)
+	store.registerMetrics(registerer)
 	if store.conf.CacheDir != "memory" && store.conf.Writeback {
 		for i := 0; i < store.conf.MaxUpload; i++ {
 			go store.uploader()
@@ -768,45 +733,6 @@ func NewCachedStore(storage object.ObjectStorage, config Config, registerer prom
 	return store
 }
 
-func initMetrics(store *cachedStore, registerer prometheus.Registerer) {
-	if registerer == nil {
-		return
-	}
-	_ = registerer.Register(cacheHits)
-	_ = registerer.Register(cacheHitBytes)
-	_ = registerer.Register(cacheMiss)
-	_ = registerer.Register(cacheMissBytes)
-	_ = registerer.Register(cacheWrites)
-	_ = registerer.Register(cacheWriteBytes)
-	_ = registerer.Register(cacheDrops)
-	_ = registerer.Register(cacheEvicts)
-	_ = registerer.Register(cacheReadHist)
-	_ = registerer.Register(cacheWriteHist)
-	_ = registerer.Register(prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Name: "blockcache_blocks",
-			Help: "number of cached blocks",
-		},
-		func() float64 {
-			cnt, _ := store.bcache.stats()
-			return float64(cnt)
-		}))
-	_ = registerer.Register(prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Name: "blockcache_bytes",
-			Help: "number of cached bytes",
-		},
-		func() float64 {
-			_, used := store.bcache.stats()
-			return float64(used)
-		}))
-	_ = registerer.Register(objectReqsHistogram)
-	_ = registerer.Register(objectReqErrors)
-	_ = registerer.Register(objectDataBytes)
-	_ = registerer.Register(stageBlocks)
-	_ = registerer.Register(stageBlockBytes)
-}
-
 func (store *cachedStore) shouldCache(size int) bool {
 	return store.conf.CacheFullBlock || size < store.conf.BlockSize || store.conf.UploadDelay > 0
 }
@@ -852,12 +778,12 @@ func (store *cachedStore) uploadStagingFile(key string, stagingPath string) {
 		return
 	}
 
-	if err = store.upload(key, block, nil); err == nil {
+	if err = store.upload(context.Background(), key, block, nil); err == nil {
 		store.bcache.uploaded(key, blen)
 		store.removeStaging(key)
 		if os.Remove(stagingPath) == nil {
-			stageBlocks.Sub(1)
-			stageBlockBytes.Sub(float64(blen))
+			store.stageBlocks.Sub(1)
+			store.stageBlockBytes.Sub(float64(blen))
 		}
 	}
 }
@@ -918,6 +844,7 @@ func (store *cachedStore) Remove(chunkid uint64, length int) error {
 func (store *cachedStore) FillCache(chunkid uint64, length uint32) error {
 	r := chunkForRead(chunkid, int(length), store)
 	keys := r.keys()
+	ctx := WithIdentity(context.Background(), Identity{Priority: PriorityBatch})
 	var err error
 	for _, k := range keys {
 		f, e := store.bcache.load(k)
@@ -932,7 +859,7 @@ func (store *cachedStore) FillCache(chunkid uint64, length uint32) error {
 		}
 		p := NewOffPage(size)
 		defer p.Release()
-		if e := store.load(k, p, true, true); e != nil {
+		if e := store.load(ctx, k, p, true, true); e != nil {
 			logger.Warnf("Failed to load key: %s %s", k, e)
 			err = e
 		}
@@ -940,6 +867,48 @@ func (store *cachedStore) FillCache(chunkid uint64, length uint32) error {
 	return err
 }
 
+func (store *cachedStore) setLimits(upload, download int64) {
+	var up, down *ratelimit.Bucket
+	if upload > 0 {
+		// there are overheads coming from HTTP/TCP/IP
+		up = ratelimit.NewBucketWithRate(float64(upload)*0.85, upload)
+	}
+	if download > 0 {
+		down = ratelimit.NewBucketWithRate(float64(download)*0.85, download)
+	}
+	store.limitLock.Lock()
+	store.upLimit, store.downLimit = up, down
+	store.limitLock.Unlock()
+}
+
+func (store *cachedStore) waitUpload(size int64) {
+	store.limitLock.RLock()
+	up := store.upLimit
+	store.limitLock.RUnlock()
+	if up != nil {
+		up.Wait(size)
+	}
+}
+
+func (store *cachedStore) waitDownload(size int64) {
+	store.limitLock.RLock()
+	down := store.downLimit
+	store.limitLock.RUnlock()
+	if down != nil {
+		down.Wait(size)
+	}
+}
+
+// UpdateConfig applies the settings that can be changed at runtime: UploadLimit, DownloadLimit,
+// CacheSize (can't be enabled or disabled) and Prefetch, others are ignored.
+func (store *cachedStore) UpdateConfig(conf Config) {
+	store.setLimits(conf.UploadLimit, conf.DownloadLimit)
+	if conf.CacheSize > 0 && store.conf.CacheSize > 0 {
+		store.bcache.setCapacity(conf.CacheSize << 20)
+		store.fetcher.setParallel(conf.Prefetch)
+	}
+}
+
 func (store *cachedStore) UsedMemory() int64 {
 	return store.bcache.usedMemory()
 }
@@ -23,10 +23,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func forgeChunk(store ChunkStore, chunkid uint64, size int) error {
@@ -116,6 +119,31 @@ func TestStoreDefault(t *testing.T) {
 	}
 }
 
+func TestStoreMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	var stores []ChunkStore
+	for _, vol := range []string{"a", "b"} {
+		mem, _ := object.CreateStorage("mem", "", "", "")
+		conf := defaultConf
+		conf.CacheDir = "memory"
+		reg := prometheus.WrapRegistererWith(prometheus.Labels{"vol_name": vol}, registry)
+		stores = append(stores, NewCachedStore(mem, conf, reg))
+	}
+	if err := forgeChunk(stores[0], 1, 100); err != nil {
+		t.Fatalf("forge chunk: %s", err)
+	}
+	a, b := stores[0].(*cachedStore), stores[1].(*cachedStore)
+	if n := testutil.ToFloat64(a.objectDataBytes.WithLabelValues("PUT")); n != 100 {
+		t.Fatalf("uploaded bytes of a: %f", n)
+	}
+	if n := testutil.ToFloat64(b.objectDataBytes.WithLabelValues("PUT")); n != 0 {
+		t.Fatalf("uploaded bytes of b: %f", n)
+	}
+	if n, err := testutil.GatherAndCount(registry, "blockcache_writes"); err != nil || n != 2 {
+		t.Fatalf("series of blockcache_writes: %d %s", n, err)
+	}
+}
+
 func TestStoreMemCache(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "")
 	conf := defaultConf
@@ -129,6 +157,108 @@ func TestStoreMemCache(t *testing.T) {
 		t.Fatalf("cache cnt %d used %d, expect both 0", cnt, used)
 	}
 }
+func TestStoreReadFile(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "")
+	conf := defaultConf
+	conf.CacheDir = filepath.Join(os.TempDir(), "diskCache3")
+	_ = os.RemoveAll(conf.CacheDir)
+	store := NewCachedStore(mem, conf, nil)
+	size := conf.BlockSize * 3 / 2
+	if err := forgeChunk(store, 10, size); err != nil {
+		t.Fatalf("forge chunk: %s", err)
+	}
+	defer store.Remove(10, size)
+	time.Sleep(time.Millisecond * 100) // wait for cache written
+
+	r := store.NewReader(10, size).(FileReader)
+	fd, off, pin, err := r.ReadFile(conf.BlockSize+10, 100)
+	if err != nil {
+		t.Fatalf("read file: %s", err)
+	}
+	defer pin.Close()
+	if off != 10 {
+		t.Fatalf("offset %d != expect 10", off)
+	}
+	var f *os.File
+	for _, of := range store.(*cachedStore).files.files {
+		if of.f.Fd() == fd {
+			f = of.f
+		}
+	}
+	if f == nil {
+		t.Fatalf("fd %d is not opened", fd)
+	}
+	buf := make([]byte, 100)
+	if n, err := f.ReadAt(buf, off); err != nil || n != 100 || !bytes.Equal(buf, bytes.Repeat([]byte{0x41}, 100)) {
+		t.Fatalf("read from fd: %d %s", n, err)
+	}
+	if _, _, _, err = r.ReadFile(conf.BlockSize-10, 20); err == nil {
+		t.Fatalf("read across blocks should fail")
+	}
+	if _, _, _, err = r.ReadFile(size-10, 20); err == nil {
+		t.Fatalf("read beyond the chunk should fail")
+	}
+
+	conf.CacheDir = "memory"
+	store = NewCachedStore(mem, conf, nil)
+	if err := forgeChunk(store, 11, size); err != nil {
+		t.Fatalf("forge chunk: %s", err)
+	}
+	defer store.Remove(11, size)
+	if _, _, _, err = store.NewReader(11, size).(FileReader).ReadFile(0, 100); err == nil {
+		t.Fatalf("read file from memory cache should fail")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	load := func(key string) (ReadCloser, error) {
+		return os.Open(filepath.Join(dir, key))
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, key), []byte("data"), 0644); err != nil {
+			t.Fatalf("write %s: %s", key, err)
+		}
+	}
+	c := newFileCache(2, time.Millisecond*50)
+	a, err := c.open("a", 4, load)
+	if err != nil {
+		t.Fatalf("open a: %s", err)
+	}
+	b, err := c.open("b", 4, load)
+	if err != nil {
+		t.Fatalf("open b: %s", err)
+	}
+	if _, err = c.open("c", 4, load); err == nil {
+		t.Fatalf("open c should fail with too many opened blocks")
+	}
+	if a2, err := c.open("a", 4, load); err != nil || a2 != a {
+		t.Fatalf("open a again: %v %s", a2, err)
+	} else {
+		_ = a2.Close()
+	}
+	_ = b.Close()
+	time.Sleep(time.Millisecond * 200)
+	c.Lock()
+	_, okA := c.files["a"]
+	_, okB := c.files["b"]
+	c.Unlock()
+	if !okA || okB {
+		t.Fatalf("only the pinned file should be kept: %v %v", okA, okB)
+	}
+	if _, err = syscall.Pread(int(a.f.Fd()), make([]byte, 4), 0); err != nil {
+		t.Fatalf("read pinned file: %s", err)
+	}
+	_ = a.Close()
+	time.Sleep(time.Millisecond * 200)
+	c.Lock()
+	n, cleaning := len(c.files), c.cleaning
+	c.Unlock()
+	if n != 0 || cleaning {
+		t.Fatalf("all files should be closed and the cleanup should stop: %d %v", n, cleaning)
+	}
+}
+
 func TestStoreCompressed(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "")
 	conf := defaultConf
@@ -138,6 +268,14 @@ func TestStoreCompressed(t *testing.T) {
 	testStore(t, store)
 }
 
+func TestStoreAdaptiveCompressed(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "")
+	conf := defaultConf
+	conf.Compress = "zstd:3+adaptive"
+	store := NewCachedStore(mem, conf, nil)
+	testStore(t, store)
+}
+
 func TestStoreLimited(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "")
 	conf := defaultConf
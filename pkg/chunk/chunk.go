@@ -25,10 +25,20 @@ type Reader interface {
 	ReadAt(ctx context.Context, p *Page, off int) (int, error)
 }
 
+// FileReader is implemented by the readers which can serve data from a local file,
+// so it can be sent to the kernel without copying (splice).
+type FileReader interface {
+	// ReadFile returns the file descriptor and the offset in it of the data in [off, off+size),
+	// which should be in a single block. The fd must not be closed, it's kept open until the
+	// returned pin is closed.
+	ReadFile(off, size int) (uintptr, int64, io.Closer, error)
+}
+
 type Writer interface {
 	io.WriterAt
 	ID() uint64
 	SetID(chunkid uint64)
+	SetOwner(id Identity) // who writes it, for QoS of the uploads
 	FlushTo(offset int) error
 	Finish(length int) error
 	Abort()
@@ -40,4 +50,5 @@ type ChunkStore interface {
 	Remove(chunkid uint64, length int) error
 	FillCache(chunkid uint64, length uint32) error
 	UsedMemory() int64
+	UpdateConfig(conf Config)
 }
@@ -63,9 +63,10 @@ type cacheStore struct {
 	scanned  bool
 	full     bool
 	uploader func(key, path string, force bool) bool
+	*storeMetrics
 }
 
-func newCacheStore(dir string, cacheSize int64, pendingPages int, config *Config, uploader func(key, path string, force bool) bool) *cacheStore {
+func newCacheStore(m *storeMetrics, dir string, cacheSize int64, pendingPages int, config *Config, uploader func(key, path string, force bool) bool) *cacheStore {
 	if config.CacheMode == 0 {
 		config.CacheMode = 0600 // only owner can read/write cache
 	}
@@ -81,6 +82,8 @@ func newCacheStore(dir string, cacheSize int64, pendingPages int, config *Config
 		pending:   make(chan pendingFile, pendingPages),
 		pages:     make(map[string]*Page),
 		uploader:  uploader,
+
+		storeMetrics: m,
 	}
 	c.createDir(c.dir)
 	br, fr := c.curFreeRatio()
@@ -153,7 +156,7 @@ func (cache *cacheStore) cache(key string, p *Page, force bool) {
 		} else {
 			// does not have enough bandwidth to write it into disk, discard it
 			logger.Debugf("Caching queue is full (%s), drop %s (%d bytes)", cache.dir, key, len(p.Data))
-			cacheDrops.Add(1)
+			cache.cacheDrops.Add(1)
 			delete(cache.pages, key)
 			atomic.AddInt64(&cache.totalPages, -int64(cap(p.Data)))
 			p.Release()
@@ -168,10 +171,10 @@ func (cache *cacheStore) curFreeRatio() (float32, float32) {
 
 func (cache *cacheStore) flushPage(path string, data []byte) (err error) {
 	start := time.Now()
-	cacheWrites.Add(1)
-	cacheWriteBytes.Add(float64(len(data)))
+	cache.cacheWrites.Add(1)
+	cache.cacheWriteBytes.Add(float64(len(data)))
 	defer func() {
-		cacheWriteHist.Observe(time.Since(start).Seconds())
+		cache.cacheWriteHist.Observe(time.Since(start).Seconds())
 	}()
 	cache.createDir(filepath.Dir(path))
 	tmp := path + ".tmp"
@@ -233,8 +236,8 @@ func (cache *cacheStore) remove(key string) {
 		if fi, err := os.Stat(stagingPath); err == nil {
 			size := fi.Size()
 			if err = os.Remove(stagingPath); err == nil {
-				stageBlocks.Sub(1)
-				stageBlockBytes.Sub(float64(size))
+				cache.stageBlocks.Sub(1)
+				cache.stageBlockBytes.Sub(float64(size))
 			}
 		}
 	}
@@ -318,8 +321,8 @@ func (cache *cacheStore) stage(key string, data []byte, keepCache bool) (string,
 	}
 	err := cache.flushPage(stagingPath, data)
 	if err == nil {
-		stageBlocks.Add(1)
-		stageBlockBytes.Add(float64(len(data)))
+		cache.stageBlocks.Add(1)
+		cache.stageBlockBytes.Add(float64(len(data)))
 		if cache.capacity > 0 && keepCache {
 			path := cache.cachePath(key)
 			cache.createDir(filepath.Dir(path))
@@ -337,6 +340,15 @@ func (cache *cacheStore) uploaded(key string, size int) {
 	cache.add(key, int32(size), 0)
 }
 
+func (cache *cacheStore) setCapacity(size int64) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.capacity = size
+	if cache.used > cache.capacity {
+		cache.cleanup()
+	}
+}
+
 // locked
 func (cache *cacheStore) cleanup() {
 	if !cache.scanned {
@@ -387,7 +399,7 @@ func (cache *cacheStore) cleanup() {
 			cache.used -= int64(lastValue.size + 4096)
 			todel = append(todel, lastKey)
 			logger.Debugf("remove %s from cache, age: %d", lastKey, now-lastValue.atime)
-			cacheEvicts.Add(1)
+			cache.cacheEvicts.Add(1)
 			cnt = 0
 			if len(cache.keys) < num && cache.used < goal {
 				break
@@ -524,8 +536,8 @@ func (cache *cacheStore) scanStaging() {
 				}
 			} else {
 				logger.Debugf("Found staging block: %s", path)
-				stageBlocks.Add(1)
-				stageBlockBytes.Add(float64(fi.Size()))
+				cache.stageBlocks.Add(1)
+				cache.stageBlockBytes.Add(float64(fi.Size()))
 				key := path[len(stagingPrefix)+1:]
 				if runtime.GOOS == "windows" {
 					key = strings.ReplaceAll(key, "\\", "/")
@@ -599,11 +611,12 @@ type CacheManager interface {
 	stagePath(key string) string
 	stats() (int64, int64)
 	usedMemory() int64
+	setCapacity(size int64)
 }
 
-func newCacheManager(config *Config, uploader func(key, path string, force bool) bool) CacheManager {
+func newCacheManager(config *Config, metrics *storeMetrics, uploader func(key, path string, force bool) bool) CacheManager {
 	if config.CacheDir == "memory" || config.CacheSize == 0 {
-		return newMemStore(config)
+		return newMemStore(config, metrics)
 	}
 	var dirs []string
 	for _, d := range utils.SplitDir(config.CacheDir) {
@@ -620,7 +633,7 @@ func newCacheManager(config *Config, uploader func(key, path string, force bool)
 	}
 	if len(dirs) == 0 {
 		logger.Warnf("No cache dir existed")
-		return newMemStore(config)
+		return newMemStore(config, metrics)
 	}
 	sort.Strings(dirs)
 	dirCacheSize := config.CacheSize << 20
@@ -631,7 +644,7 @@ func newCacheManager(config *Config, uploader func(key, path string, force bool)
 	// 20% of buffer could be used for pending pages
 	pendingPages := config.BufferSize * 2 / 10 / config.BlockSize / len(dirs)
 	for i, d := range dirs {
-		m.stores[i] = newCacheStore(strings.TrimSpace(d)+string(filepath.Separator), dirCacheSize, pendingPages, config, uploader)
+		m.stores[i] = newCacheStore(metrics, strings.TrimSpace(d)+string(filepath.Separator), dirCacheSize, pendingPages, config, uploader)
 	}
 	return m
 }
@@ -684,6 +697,12 @@ func (m *cacheManager) stagePath(key string) string {
 	return m.getStore(key).stagePath(key)
 }
 
+func (m *cacheManager) setCapacity(size int64) {
+	for _, s := range m.stores {
+		s.setCapacity(size / int64(len(m.stores)))
+	}
+}
+
 func (m *cacheManager) uploaded(key string, size int) {
 	m.getStore(key).uploaded(key, size)
 }
@@ -0,0 +1,130 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type openedFile struct {
+	c    *fileCache
+	key  string
+	f    *os.File
+	refs int // the pins of it, which can't be closed until all of them are released
+	last time.Time
+}
+
+// Close releases a pin of the file.
+func (of *openedFile) Close() error {
+	of.c.Lock()
+	defer of.c.Unlock()
+	if of.refs > 0 {
+		of.refs--
+	}
+	of.last = time.Now()
+	return nil
+}
+
+// fileCache keeps the cached blocks opened, so the file descriptors returned by ReadFile
+// are still valid when they are used later. The files are pinned until the users release
+// them (there is no callback after the data is sent), then they are closed after expiration.
+type fileCache struct {
+	sync.Mutex
+	files    map[string]*openedFile
+	limit    int
+	expire   time.Duration
+	cleaning bool // the cleanup is running, which stops when no file is opened
+}
+
+func newFileCache(limit int, expire time.Duration) *fileCache {
+	return &fileCache{
+		files:  make(map[string]*openedFile),
+		limit:  limit,
+		expire: expire,
+	}
+}
+
+// open returns the opened file of a block with a pin, which should be closed after use.
+func (c *fileCache) open(key string, size int, load func(key string) (ReadCloser, error)) (*openedFile, error) {
+	c.Lock()
+	if of, ok := c.files[key]; ok {
+		of.refs++
+		of.last = time.Now()
+		c.Unlock()
+		return of, nil
+	}
+	full := len(c.files) >= c.limit
+	c.Unlock()
+	if full {
+		return nil, errors.New("too many opened blocks")
+	}
+
+	r, err := load(key)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := r.(*os.File)
+	if !ok {
+		_ = r.Close()
+		return nil, errors.New("not cached in disk")
+	}
+	// a partial block would be seen as EOF by the kernel
+	if fi, err := f.Stat(); err != nil || fi.Size() < int64(size) {
+		_ = f.Close()
+		return nil, fmt.Errorf("partial cached block %s", f.Name())
+	}
+	c.Lock()
+	defer c.Unlock()
+	if of, ok := c.files[key]; ok {
+		_ = f.Close()
+		of.refs++
+		of.last = time.Now()
+		return of, nil
+	}
+	of := &openedFile{c, key, f, 1, time.Now()}
+	c.files[key] = of
+	if !c.cleaning {
+		c.cleaning = true
+		go c.cleanup()
+	}
+	return of, nil
+}
+
+// cleanup closes the files which are not pinned and expired, it exits when all files are closed.
+func (c *fileCache) cleanup() {
+	for {
+		time.Sleep(c.expire / 2)
+		now := time.Now()
+		c.Lock()
+		for key, of := range c.files {
+			if of.refs == 0 && now.Sub(of.last) > c.expire {
+				_ = of.f.Close()
+				delete(c.files, key)
+			}
+		}
+		if len(c.files) == 0 {
+			c.cleaning = false
+			c.Unlock()
+			return
+		}
+		c.Unlock()
+	}
+}
@@ -33,12 +33,14 @@ type memcache struct {
 	capacity int64
 	used     int64
 	pages    map[string]memItem
+	*storeMetrics
 }
 
-func newMemStore(config *Config) *memcache {
+func newMemStore(config *Config, m *storeMetrics) *memcache {
 	c := &memcache{
-		capacity: config.CacheSize << 20,
-		pages:    make(map[string]memItem),
+		capacity:     config.CacheSize << 20,
+		pages:        make(map[string]memItem),
+		storeMetrics: m,
 	}
 	runtime.SetFinalizer(c, func(c *memcache) {
 		for _, p := range c.pages {
@@ -71,8 +73,8 @@ func (c *memcache) cache(key string, p *Page, force bool) {
 		return
 	}
 	size := int64(cap(p.Data))
-	cacheWrites.Add(1)
-	cacheWriteBytes.Add(float64(size))
+	c.cacheWrites.Add(1)
+	c.cacheWriteBytes.Add(float64(size))
 	p.Acquire()
 	c.pages[key] = memItem{time.Now(), p}
 	c.used += size
@@ -122,7 +124,7 @@ func (c *memcache) cleanup() {
 		cnt++
 		if cnt > 1 {
 			logger.Debugf("remove %s from cache, age: %d", lastKey, now.Sub(lastValue.atime))
-			cacheEvicts.Add(1)
+			c.cacheEvicts.Add(1)
 			c.delete(lastKey, lastValue.page)
 			cnt = 0
 			if c.used < c.capacity {
@@ -135,5 +137,14 @@ func (c *memcache) cleanup() {
 func (c *memcache) stage(key string, data []byte, keepCache bool) (string, error) {
 	return "", errors.New("not supported")
 }
+func (c *memcache) setCapacity(size int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.capacity = size
+	if c.used > c.capacity {
+		c.cleanup()
+	}
+}
+
 func (c *memcache) uploaded(key string, size int) {}
 func (c *memcache) stagePath(key string) string   { return "" }
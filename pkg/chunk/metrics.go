@@ -0,0 +1,181 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+// storeMetrics are the metrics of a store and its cache, each store has its own ones so
+// the volumes mounted in one process are counted separately.
+type storeMetrics struct {
+	cacheHits       prometheus.Counter
+	cacheMiss       prometheus.Counter
+	cacheWrites     prometheus.Counter
+	cacheDrops      prometheus.Counter
+	cacheEvicts     prometheus.Counter
+	cacheHitBytes   prometheus.Counter
+	cacheMissBytes  prometheus.Counter
+	cacheWriteBytes prometheus.Counter
+	cacheReadHist   prometheus.Histogram
+	cacheWriteHist  prometheus.Histogram
+
+	objectReqsHistogram *prometheus.HistogramVec
+	objectReqErrors     prometheus.Counter
+	objectDataBytes     *prometheus.CounterVec
+
+	stageBlocks     prometheus.Gauge
+	stageBlockBytes prometheus.Gauge
+
+	compressInputBytes  prometheus.Counter
+	compressOutputBytes prometheus.Counter
+}
+
+func newStoreMetrics() *storeMetrics {
+	return &storeMetrics{
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_hits",
+			Help: "read from cached block",
+		}),
+		cacheMiss: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_miss",
+			Help: "missed read from cached block",
+		}),
+		cacheWrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_writes",
+			Help: "written cached block",
+		}),
+		cacheDrops: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_drops",
+			Help: "dropped block",
+		}),
+		cacheEvicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_evicts",
+			Help: "evicted cache blocks",
+		}),
+		cacheHitBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_hit_bytes",
+			Help: "read bytes from cached block",
+		}),
+		cacheMissBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_miss_bytes",
+			Help: "missed bytes from cached block",
+		}),
+		cacheWriteBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blockcache_write_bytes",
+			Help: "write bytes of cached block",
+		}),
+		cacheReadHist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "blockcache_read_hist_seconds",
+			Help:    "read cached block latency distribution",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
+		}),
+		cacheWriteHist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "blockcache_write_hist_seconds",
+			Help:    "write cached block latency distribution",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
+		}),
+
+		objectReqsHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "object_request_durations_histogram_seconds",
+			Help:    "Object requests latency distributions.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.5, 25),
+		}, []string{"method"}),
+		objectReqErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "object_request_errors",
+			Help: "failed requests to object store",
+		}),
+		objectDataBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "object_request_data_bytes",
+			Help: "Object requests size in bytes.",
+		}, []string{"method"}),
+
+		stageBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "staging_blocks",
+			Help: "Number of blocks in the staging path.",
+		}),
+		stageBlockBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "staging_block_bytes",
+			Help: "Total bytes of blocks in the staging path.",
+		}),
+
+		compressInputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "compress_input_bytes",
+			Help: "Total bytes of uploaded blocks before compression.",
+		}),
+		compressOutputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "compress_output_bytes",
+			Help: "Total bytes of uploaded blocks after compression.",
+		}),
+	}
+}
+
+func (store *cachedStore) registerMetrics(registerer prometheus.Registerer) {
+	if registerer == nil {
+		return
+	}
+	_ = registerer.Register(store.cacheHits)
+	_ = registerer.Register(store.cacheHitBytes)
+	_ = registerer.Register(store.cacheMiss)
+	_ = registerer.Register(store.cacheMissBytes)
+	_ = registerer.Register(store.cacheWrites)
+	_ = registerer.Register(store.cacheWriteBytes)
+	_ = registerer.Register(store.cacheDrops)
+	_ = registerer.Register(store.cacheEvicts)
+	_ = registerer.Register(store.cacheReadHist)
+	_ = registerer.Register(store.cacheWriteHist)
+	_ = registerer.Register(store.compressInputBytes)
+	_ = registerer.Register(store.compressOutputBytes)
+	_ = registerer.Register(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "compress_ratio",
+			Help: "ratio of compressed bytes to original bytes of uploaded blocks",
+		},
+		func() float64 {
+			var in, out io_prometheus_client.Metric
+			_ = store.compressInputBytes.Write(&in)
+			_ = store.compressOutputBytes.Write(&out)
+			if in.Counter.GetValue() == 0 {
+				return 1
+			}
+			return out.Counter.GetValue() / in.Counter.GetValue()
+		}))
+	_ = registerer.Register(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "blockcache_blocks",
+			Help: "number of cached blocks",
+		},
+		func() float64 {
+			cnt, _ := store.bcache.stats()
+			return float64(cnt)
+		}))
+	_ = registerer.Register(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "blockcache_bytes",
+			Help: "number of cached bytes",
+		},
+		func() float64 {
+			_, used := store.bcache.stats()
+			return float64(used)
+		}))
+	_ = registerer.Register(store.objectReqsHistogram)
+	_ = registerer.Register(store.objectReqErrors)
+	_ = registerer.Register(store.objectDataBytes)
+	_ = registerer.Register(store.stageBlocks)
+	_ = registerer.Register(store.stageBlockBytes)
+}
@@ -23,6 +23,8 @@ type prefetcher struct {
 	pending chan string
 	busy    map[string]bool
 	op      func(key string)
+	workers int
+	quit    chan struct{}
 }
 
 func newPrefetcher(parallel int, fetch func(string)) *prefetcher {
@@ -30,15 +32,32 @@ func newPrefetcher(parallel int, fetch func(string)) *prefetcher {
 		pending: make(chan string, 10),
 		busy:    make(map[string]bool),
 		op:      fetch,
+		quit:    make(chan struct{}),
 	}
-	for i := 0; i < parallel; i++ {
+	p.setParallel(parallel)
+	return p
+}
+
+// setParallel changes the number of workers.
+func (p *prefetcher) setParallel(parallel int) {
+	p.Lock()
+	defer p.Unlock()
+	for ; p.workers < parallel; p.workers++ {
 		go p.do()
 	}
-	return p
+	for ; p.workers > parallel; p.workers-- {
+		go func() { p.quit <- struct{}{} }()
+	}
 }
 
 func (p *prefetcher) do() {
-	for key := range p.pending {
+	for {
+		var key string
+		select {
+		case key = <-p.pending:
+		case <-p.quit:
+			return
+		}
 		p.Lock()
 		if _, ok := p.busy[key]; !ok {
 			p.busy[key] = true
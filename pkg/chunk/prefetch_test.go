@@ -45,4 +45,34 @@ func TestPrefetcher(t *testing.T) {
 			t.Errorf("Duplicate keys fetched")
 		}
 	})
+	t.Run("should change the number of workers", func(t *testing.T) {
+		var running, max int32
+		f := newPrefetcher(1, func(k string) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 50)
+			atomic.AddInt32(&running, -1)
+		})
+		f.setParallel(4)
+		for i := 0; i < 4; i++ {
+			f.fetch(string(rune('a' + i)))
+		}
+		time.Sleep(time.Millisecond * 200)
+		if m := atomic.LoadInt32(&max); m < 2 {
+			t.Errorf("workers are not added: %d", m)
+		}
+		f.setParallel(0)
+		time.Sleep(time.Millisecond * 50)
+		atomic.StoreInt32(&max, 0)
+		f.fetch("e")
+		time.Sleep(time.Millisecond * 100)
+		if m := atomic.LoadInt32(&max); m != 0 {
+			t.Errorf("workers are not removed")
+		}
+	})
 }
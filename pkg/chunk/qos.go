@@ -0,0 +1,267 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/juju/ratelimit"
+)
+
+// Priority of the requests to object storage, lower value wins.
+type Priority int
+
+const (
+	// PriorityInteractive is used for the reads requested by applications.
+	PriorityInteractive Priority = iota
+	// PriorityPrefetch is used for readahead and prefetching of blocks.
+	PriorityPrefetch
+	// PriorityBatch is used for background jobs, e.g. warmup.
+	PriorityBatch
+	numPriorities
+)
+
+// Identity describes who issues a request and how urgent it is.
+type Identity struct {
+	Uid      uint32
+	Gid      uint32
+	Pid      uint32
+	Priority Priority
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+func identityFrom(ctx context.Context, def Priority) Identity {
+	if ctx != nil {
+		if id, ok := ctx.Value(identityKey{}).(Identity); ok {
+			return id
+		}
+	}
+	return Identity{Priority: def}
+}
+
+// QoSRule limits the requests to object storage from a class of users or processes.
+// Empty Uids, Gids or Pgids match any; the first matched rule is used.
+type QoSRule struct {
+	Name      string
+	Uids      []uint32 `json:",omitempty"`
+	Gids      []uint32 `json:",omitempty"`
+	Pgids     []uint32 `json:",omitempty"`
+	Bandwidth int64    `json:",omitempty"` // bytes per second
+	Requests  int64    `json:",omitempty"` // requests per second
+	Batch     bool     `json:",omitempty"` // schedule all requests of this class as batch
+	PerUid    bool     `json:",omitempty"` // apply the limits to each uid instead of the whole class
+}
+
+// QoSConfig is the settings of QoS, which can be changed at runtime.
+type QoSConfig struct {
+	MaxDownloads int // max number of concurrent downloads, 0 means unlimited
+	Rules        []QoSRule
+}
+
+type qosLimits struct {
+	bandwidth *ratelimit.Bucket
+	requests  *ratelimit.Bucket
+}
+
+func newLimits(r *QoSRule) *qosLimits {
+	l := &qosLimits{}
+	if r.Bandwidth > 0 {
+		l.bandwidth = ratelimit.NewBucketWithRate(float64(r.Bandwidth), r.Bandwidth)
+	}
+	if r.Requests > 0 {
+		l.requests = ratelimit.NewBucketWithRate(float64(r.Requests), r.Requests)
+	}
+	return l
+}
+
+func (l *qosLimits) wait(size int) {
+	if l.requests != nil {
+		l.requests.Wait(1)
+	}
+	if l.bandwidth != nil && size > 0 {
+		l.bandwidth.Wait(int64(size))
+	}
+}
+
+type qosClass struct {
+	QoSRule
+	limits *qosLimits
+	perUid map[uint32]*qosLimits // protected by QoS
+}
+
+func contains(ids []uint32, id uint32) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *qosClass) match(id Identity, pgid func() uint32) bool {
+	return contains(c.Uids, id.Uid) && contains(c.Gids, id.Gid) && (len(c.Pgids) == 0 || contains(c.Pgids, pgid()))
+}
+
+// QoS schedules the requests to object storage by priority, and limits the bandwidth
+// and request rate for each class of users.
+type QoS struct {
+	sync.Mutex
+	cond    *sync.Cond
+	conf    QoSConfig
+	classes []*qosClass
+	running int
+	waiting [numPriorities]int
+}
+
+// NewQoS creates a QoS with the config.
+func NewQoS(conf QoSConfig) (*QoS, error) {
+	q := &QoS{}
+	q.cond = sync.NewCond(q)
+	if err := q.Update(conf); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Update replaces the config of QoS, the in-flight requests are not affected.
+func (q *QoS) Update(conf QoSConfig) error {
+	if conf.MaxDownloads < 0 {
+		return fmt.Errorf("invalid max downloads: %d", conf.MaxDownloads)
+	}
+	classes := make([]*qosClass, 0, len(conf.Rules))
+	for _, r := range conf.Rules {
+		if r.Bandwidth < 0 || r.Requests < 0 {
+			return fmt.Errorf("invalid limits for QoS rule %s: %d %d", r.Name, r.Bandwidth, r.Requests)
+		}
+		c := &qosClass{QoSRule: r}
+		if r.PerUid {
+			c.perUid = make(map[uint32]*qosLimits)
+		} else {
+			c.limits = newLimits(&c.QoSRule)
+		}
+		classes = append(classes, c)
+	}
+	q.Lock()
+	q.conf = conf
+	q.classes = classes
+	q.cond.Broadcast()
+	q.Unlock()
+	logger.Infof("QoS updated: max downloads %d, %d rules", conf.MaxDownloads, len(conf.Rules))
+	return nil
+}
+
+// Config returns current config of QoS.
+func (q *QoS) Config() QoSConfig {
+	q.Lock()
+	defer q.Unlock()
+	return q.conf
+}
+
+func (q *QoS) higherWaiting(p Priority) bool {
+	for i := Priority(0); i < p; i++ {
+		if q.waiting[i] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// limits returns the limits for the identity, or nil if it does not match any rule.
+// protected by q
+func (q *QoS) limits(id Identity) (*qosClass, *qosLimits) {
+	var pgid uint32
+	var pgidKnown bool
+	for _, c := range q.classes {
+		if c.match(id, func() uint32 {
+			if !pgidKnown {
+				pgid, pgidKnown = getPgid(id.Pid), true
+			}
+			return pgid
+		}) {
+			if !c.PerUid {
+				return c, c.limits
+			}
+			l := c.perUid[id.Uid]
+			if l == nil {
+				l = newLimits(&c.QoSRule)
+				c.perUid[id.Uid] = l
+			}
+			return c, l
+		}
+	}
+	return nil, nil
+}
+
+// acquire waits until a request of size bytes is allowed to be sent, the returned
+// function must be called after the request is done.
+func (q *QoS) acquire(ctx context.Context, def Priority, size int) func() {
+	if q == nil {
+		return func() {}
+	}
+	id := identityFrom(ctx, def)
+	q.Lock()
+	class, limits := q.limits(id)
+	p := id.Priority
+	if class != nil && class.Batch {
+		p = PriorityBatch
+	}
+	if p < 0 || p >= numPriorities {
+		p = PriorityBatch
+	}
+	q.waiting[p]++
+	for q.conf.MaxDownloads > 0 && (q.running >= q.conf.MaxDownloads || q.higherWaiting(p)) {
+		q.cond.Wait()
+	}
+	q.waiting[p]--
+	q.running++
+	q.Unlock()
+
+	if limits != nil {
+		limits.wait(size)
+	}
+	return func() {
+		q.Lock()
+		q.running--
+		q.cond.Broadcast()
+		q.Unlock()
+	}
+}
+
+// limit waits until an upload of size bytes is allowed by the limits of the class, the
+// uploads are not scheduled by priority.
+func (q *QoS) limit(ctx context.Context, size int) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	_, limits := q.limits(identityFrom(ctx, PriorityInteractive))
+	q.Unlock()
+	if limits != nil {
+		limits.wait(size)
+	}
+}
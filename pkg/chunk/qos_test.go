@@ -0,0 +1,132 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQoSConfig(t *testing.T) {
+	if _, err := NewQoS(QoSConfig{MaxDownloads: -1}); err == nil {
+		t.Fatalf("negative max downloads should fail")
+	}
+	q, err := NewQoS(QoSConfig{MaxDownloads: 2})
+	if err != nil {
+		t.Fatalf("new qos: %s", err)
+	}
+	if err = q.Update(QoSConfig{Rules: []QoSRule{{Name: "bad", Bandwidth: -1}}}); err == nil {
+		t.Fatalf("negative bandwidth should fail")
+	}
+	if q.Config().MaxDownloads != 2 {
+		t.Fatalf("config should not be changed by a failed update")
+	}
+	if err = q.Update(QoSConfig{MaxDownloads: 3, Rules: []QoSRule{{Name: "etl", Uids: []uint32{1000}, Batch: true}}}); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if c := q.Config(); c.MaxDownloads != 3 || len(c.Rules) != 1 || c.Rules[0].Name != "etl" {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+
+	var nilQoS *QoS
+	nilQoS.acquire(context.TODO(), PriorityInteractive, 100)()
+}
+
+func TestQoSRuleMatch(t *testing.T) {
+	c := &qosClass{QoSRule: QoSRule{Uids: []uint32{1000, 1001}, Pgids: []uint32{42}}}
+	pgid := func() uint32 { return 42 }
+	if !c.match(Identity{Uid: 1001}, pgid) {
+		t.Fatalf("uid 1001 should match")
+	}
+	if c.match(Identity{Uid: 0}, pgid) {
+		t.Fatalf("uid 0 should not match")
+	}
+	if c.match(Identity{Uid: 1000}, func() uint32 { return 7 }) {
+		t.Fatalf("pgid 7 should not match")
+	}
+	any := &qosClass{}
+	if !any.match(Identity{Uid: 5, Gid: 6}, func() uint32 { t.Fatalf("pgid should not be checked"); return 0 }) {
+		t.Fatalf("empty rule should match any")
+	}
+}
+
+func TestQoSPriority(t *testing.T) {
+	q, _ := NewQoS(QoSConfig{MaxDownloads: 1})
+	release := q.acquire(context.TODO(), PriorityInteractive, 0)
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	start := func(p Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := q.acquire(WithIdentity(context.TODO(), Identity{Priority: p}), PriorityBatch, 0)
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			r()
+		}()
+	}
+	start(PriorityBatch)
+	start(PriorityPrefetch)
+	time.Sleep(time.Millisecond * 50)
+	start(PriorityInteractive)
+	time.Sleep(time.Millisecond * 50)
+	release()
+	wg.Wait()
+
+	if len(order) != 3 || order[0] != PriorityInteractive || order[1] != PriorityPrefetch || order[2] != PriorityBatch {
+		t.Fatalf("requests should be served by priority: %v", order)
+	}
+}
+
+func TestQoSBandwidth(t *testing.T) {
+	q, _ := NewQoS(QoSConfig{Rules: []QoSRule{{Name: "slow", Uids: []uint32{1000}, Bandwidth: 1 << 20}}})
+	ctx := WithIdentity(context.TODO(), Identity{Uid: 1000})
+	start := time.Now()
+	q.acquire(ctx, PriorityInteractive, 1<<20)()   // drains the bucket
+	q.acquire(ctx, PriorityInteractive, 512<<10)() // waits about 0.5s
+	if used := time.Since(start); used < time.Millisecond*400 {
+		t.Fatalf("bandwidth is not limited: %s", used)
+	}
+	start = time.Now()
+	q.acquire(context.TODO(), PriorityInteractive, 1<<30)()
+	if used := time.Since(start); used > time.Millisecond*100 {
+		t.Fatalf("other users should not be limited: %s", used)
+	}
+}
+
+func TestQoSPerUid(t *testing.T) {
+	q, _ := NewQoS(QoSConfig{Rules: []QoSRule{{Name: "each", Requests: 2, PerUid: true}}})
+	ctx1 := WithIdentity(context.TODO(), Identity{Uid: 1000})
+	ctx2 := WithIdentity(context.TODO(), Identity{Uid: 1001})
+	start := time.Now()
+	q.limit(ctx1, 0)
+	q.limit(ctx1, 0) // drains the bucket of uid 1000
+	q.limit(ctx2, 0)
+	q.limit(ctx2, 0)
+	if used := time.Since(start); used > time.Millisecond*100 {
+		t.Fatalf("uids should have their own limits: %s", used)
+	}
+	q.limit(ctx1, 0) // waits about 0.5s
+	if used := time.Since(start); used < time.Millisecond*400 {
+		t.Fatalf("request rate is not limited: %s", used)
+	}
+}
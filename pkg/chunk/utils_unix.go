@@ -47,3 +47,14 @@ func changeMode(dir string, st os.FileInfo, mode os.FileMode) {
 		_ = os.Chmod(dir, mode)
 	}
 }
+
+func getPgid(pid uint32) uint32 {
+	if pid == 0 {
+		return 0
+	}
+	pgid, err := syscall.Getpgid(int(pid))
+	if err != nil {
+		return 0
+	}
+	return uint32(pgid)
+}
@@ -17,10 +17,15 @@
 package compress
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 
 	"github.com/DataDog/zstd"
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
 	"github.com/hungys/go-lz4"
 )
 
@@ -35,15 +40,59 @@ type Compressor interface {
 	Decompress(dst, src []byte) (int, error)
 }
 
-// NewCompressor returns a struct implementing Compressor interface
+// BROTLI_LEVEL compression level used by Brotli
+const BROTLI_LEVEL = 1
+
+// NewCompressor returns a struct implementing Compressor interface.
+// The algorithm can be followed by a level (e.g. "zstd:3"), and "+adaptive"
+// (e.g. "zstd:3+adaptive") stores the blocks which can not be shrunk as is.
 func NewCompressor(algr string) Compressor {
 	algr = strings.ToLower(algr)
-	if algr == "zstd" {
-		return ZStandard{ZSTD_LEVEL}
-	} else if algr == "lz4" {
-		return LZ4{}
-	} else if algr == "none" || algr == "" {
-		return noOp{}
+	if strings.HasSuffix(algr, "+adaptive") {
+		c := NewCompressor(strings.TrimSuffix(algr, "+adaptive"))
+		if c == nil {
+			return nil
+		}
+		if _, ok := c.(noOp); ok {
+			return c
+		}
+		return Adaptive{c}
+	}
+	level := -1
+	if p := strings.Index(algr, ":"); p > 0 {
+		l, err := strconv.Atoi(algr[p+1:])
+		if err != nil || l < 0 {
+			return nil
+		}
+		algr, level = algr[:p], l
+	}
+	switch algr {
+	case "zstd":
+		if level < 0 {
+			level = ZSTD_LEVEL
+		} else if level < 1 || level > 22 {
+			return nil
+		}
+		return ZStandard{level}
+	case "brotli":
+		if level < 0 {
+			level = BROTLI_LEVEL
+		} else if level > brotli.BestCompression {
+			return nil
+		}
+		return Brotli{level}
+	case "lz4":
+		if level < 0 {
+			return LZ4{}
+		}
+	case "snappy":
+		if level < 0 {
+			return Snappy{}
+		}
+	case "none", "":
+		if level < 0 {
+			return noOp{}
+		}
 	}
 	return nil
 }
@@ -120,3 +169,136 @@ func (l LZ4) Compress(dst, src []byte) (int, error) {
 func (l LZ4) Decompress(dst, src []byte) (int, error) {
 	return lz4.DecompressSafe(src, dst)
 }
+
+// Snappy implements Compressor using Snappy library
+type Snappy struct{}
+
+// Name returns name of the algorithm Snappy
+func (s Snappy) Name() string { return "Snappy" }
+
+// CompressBound max size of compressed data
+func (s Snappy) CompressBound(size int) int { return snappy.MaxEncodedLen(size) }
+
+// Compress using Snappy algorithm
+func (s Snappy) Compress(dst, src []byte) (int, error) {
+	if n := snappy.MaxEncodedLen(len(src)); len(dst) < n {
+		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), n)
+	}
+	return len(snappy.Encode(dst, src)), nil
+}
+
+// Decompress using Snappy algorithm
+func (s Snappy) Decompress(dst, src []byte) (int, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return 0, err
+	}
+	if len(dst) < n {
+		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), n)
+	}
+	d, err := snappy.Decode(dst, src)
+	return len(d), err
+}
+
+// Brotli implements Compressor using Brotli library
+type Brotli struct {
+	level int
+}
+
+// Name returns name of the algorithm Brotli
+func (b Brotli) Name() string { return "Brotli" }
+
+// CompressBound max size of compressed data
+func (b Brotli) CompressBound(size int) int { return size + size>>10 + 64 }
+
+type fixedWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *fixedWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > len(w.buf) {
+		return 0, fmt.Errorf("buffer too short: %d < %d", len(w.buf), w.n+len(p))
+	}
+	w.n += copy(w.buf[w.n:], p)
+	return len(p), nil
+}
+
+// Compress using Brotli algorithm
+func (b Brotli) Compress(dst, src []byte) (int, error) {
+	out := &fixedWriter{buf: dst}
+	w := brotli.NewWriterLevel(out, b.level)
+	if _, err := w.Write(src); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return out.n, nil
+}
+
+// Decompress using Brotli algorithm
+func (b Brotli) Decompress(dst, src []byte) (int, error) {
+	r := brotli.NewReader(bytes.NewReader(src))
+	n, err := io.ReadFull(r, dst)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var one [1]byte
+	if m, _ := r.Read(one[:]); m > 0 {
+		return 0, fmt.Errorf("buffer too short: %d", len(dst))
+	}
+	return n, nil
+}
+
+const (
+	adaptiveRaw        = 0
+	adaptiveCompressed = 1
+)
+
+// Adaptive wraps a Compressor and keeps the blocks which do not shrink after compression
+// (e.g. already compressed media) as is. A one byte header tells whether the block is
+// compressed, so reading them does not need the decompression.
+type Adaptive struct {
+	Compressor
+}
+
+// Name returns name of the wrapped algorithm
+func (a Adaptive) Name() string { return a.Compressor.Name() + "+adaptive" }
+
+// CompressBound max size of compressed data
+func (a Adaptive) CompressBound(size int) int { return a.Compressor.CompressBound(size) + 1 }
+
+// Compress the data, or copy it if it can not be shrunk
+func (a Adaptive) Compress(dst, src []byte) (int, error) {
+	if len(dst) < 1+len(src) {
+		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), 1+len(src))
+	}
+	n, err := a.Compressor.Compress(dst[1:], src)
+	if err == nil && n < len(src) {
+		dst[0] = adaptiveCompressed
+		return n + 1, nil
+	}
+	dst[0] = adaptiveRaw
+	return 1 + copy(dst[1:], src), nil
+}
+
+// Decompress the data, or copy it if it was not compressed
+func (a Adaptive) Decompress(dst, src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, fmt.Errorf("empty input")
+	}
+	switch src[0] {
+	case adaptiveRaw:
+		if len(dst) < len(src)-1 {
+			return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), len(src)-1)
+		}
+		return copy(dst, src[1:]), nil
+	case adaptiveCompressed:
+		return a.Compressor.Decompress(dst, src[1:])
+	default:
+		return 0, fmt.Errorf("unknown block header: %d", src[0])
+	}
+}
@@ -17,6 +17,8 @@
 package compress
 
 import (
+	"bytes"
+	"crypto/rand"
 	"io"
 	"os"
 	"testing"
@@ -59,6 +61,55 @@ func TestLZ4(t *testing.T) {
 	testCompress(t, NewCompressor("lz4"))
 }
 
+func TestSnappy(t *testing.T) {
+	testCompress(t, NewCompressor("snappy"))
+}
+
+func TestBrotli(t *testing.T) {
+	testCompress(t, NewCompressor("brotli"))
+	testCompress(t, NewCompressor("brotli:5"))
+}
+
+func TestZstdLevel(t *testing.T) {
+	testCompress(t, NewCompressor("zstd:9"))
+}
+
+func TestAdaptive(t *testing.T) {
+	c := NewCompressor("zstd:3+adaptive")
+	testCompress(t, c)
+
+	src := make([]byte, 1<<16)
+	_, _ = rand.Read(src)
+	dst := make([]byte, c.CompressBound(len(src)))
+	n, err := c.Compress(dst, src)
+	if err != nil {
+		t.Fatalf("compress: %s", err)
+	}
+	if n != len(src)+1 || dst[0] != adaptiveRaw {
+		t.Fatalf("random data should be stored as is: %d %d", n, dst[0])
+	}
+	src2 := make([]byte, len(src))
+	if n, err = c.Decompress(src2, dst[:n]); err != nil || !bytes.Equal(src, src2[:n]) {
+		t.Fatalf("decompress raw block: %s", err)
+	}
+
+	src = bytes.Repeat([]byte("juicefs"), 1<<12)
+	if n, err = c.Compress(dst, src); err != nil || n >= len(src) || dst[0] != adaptiveCompressed {
+		t.Fatalf("repeated data should be compressed: %d %s", n, err)
+	}
+	if _, ok := NewCompressor("none+adaptive").(noOp); !ok {
+		t.Fatalf("adaptive none should be none")
+	}
+}
+
+func TestInvalidCompressor(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd:x", "zstd:30", "lz4:1", "brotli:12", "gzip+adaptive"} {
+		if NewCompressor(name) != nil {
+			t.Fatalf("compressor %s should be invalid", name)
+		}
+	}
+}
+
 func benchmarkDecompress(b *testing.B, comp Compressor) {
 	f, _ := os.Open(os.Getenv("PAYLOAD"))
 	var c = make([]byte, 5<<20)
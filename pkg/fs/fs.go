@@ -555,6 +555,48 @@ func (fs *FileSystem) CopyFileRange(ctx meta.Context, src string, soff uint64, d
 	return
 }
 
+// Concat appends the content of srcs to dst by referencing their slices, no data is copied.
+// It's not atomic, dst could have some of srcs appended if it fails, so the callers should
+// concat into a temporary file and rename it.
+func (fs *FileSystem) Concat(ctx meta.Context, dst string, srcs ...string) (size uint64, err syscall.Errno) {
+	defer trace.StartRegion(context.TODO(), "fs.Concat").End()
+	l := vfs.NewLogContext(ctx)
+	defer func() { fs.log(l, "Concat (%s,%d): (%d,%s)", dst, len(srcs), size, errstr(err)) }()
+	var dfi *FileStat
+	dfi, err = fs.resolve(ctx, dst, true)
+	if err != 0 {
+		return
+	}
+	err = fs.m.Access(ctx, dfi.inode, mMaskW, dfi.attr)
+	if err != 0 {
+		return
+	}
+	var attr Attr
+	if err = fs.m.GetAttr(ctx, dfi.inode, &attr); err != 0 {
+		return
+	}
+	size = attr.Length
+	for _, src := range srcs {
+		var sfi *FileStat
+		sfi, err = fs.resolve(ctx, src, true)
+		if err != 0 {
+			return
+		}
+		err = fs.m.Access(ctx, sfi.inode, mMaskR, sfi.attr)
+		if err != 0 {
+			return
+		}
+		var copied uint64
+		err = fs.m.CopyFileRange(ctx, sfi.inode, 0, dfi.inode, size, 1<<63, 0, &copied)
+		if err != 0 {
+			return
+		}
+		size += copied
+	}
+	fs.invalidateAttr(dfi.inode)
+	return
+}
+
 func (fs *FileSystem) SetXattr(ctx meta.Context, p string, name string, value []byte, flags uint32) (err syscall.Errno) {
 	defer trace.StartRegion(context.TODO(), "fs.SetXattr").End()
 	l := vfs.NewLogContext(ctx)
@@ -895,6 +937,7 @@ func (f *File) Utime(ctx meta.Context, atime, mtime int64) (err syscall.Errno) {
 	attr.Mtime = mtime / 1000
 	attr.Mtimensec = uint32(mtime%1000) * 1e6
 	err = f.fs.m.SetAttr(ctx, f.inode, flag, 0, &attr)
+	f.fs.invalidateAttr(f.inode)
 	return
 }
 
@@ -911,6 +954,26 @@ func (f *File) Seek(ctx meta.Context, offset int64, whence int) (int64, error) {
 		f.offset += offset
 	case io.SeekEnd:
 		f.offset = f.info.Size() + offset
+	case meta.SeekData, meta.SeekHole:
+		if offset < 0 {
+			return f.offset, syscall.ENXIO
+		}
+		if f.wdata != nil {
+			if err := f.wdata.Flush(ctx); err != 0 {
+				return f.offset, err
+			}
+		}
+		var attr Attr
+		if err := f.fs.m.GetAttr(ctx, f.inode, &attr); err != 0 {
+			return f.offset, err
+		}
+		pos, err := meta.SeekDataHole(f.fs.m, ctx, f.inode, uint64(offset), attr.Length, whence)
+		if err != 0 {
+			return f.offset, err
+		}
+		f.offset = int64(pos)
+	default:
+		return f.offset, syscall.EINVAL
 	}
 	return f.offset, nil
 }
@@ -994,7 +1057,7 @@ func (f *File) Pwrite(ctx meta.Context, b []byte, offset int64) (n int, err sysc
 
 func (f *File) pwrite(ctx meta.Context, b []byte, offset int64) (n int, err syscall.Errno) {
 	if f.wdata == nil {
-		f.wdata = f.fs.writer.Open(f.inode, uint64(f.info.Size()))
+		f.wdata = f.fs.writer.Open(f.inode, f.info.attr)
 	}
 	err = f.wdata.Write(ctx, uint64(offset), b)
 	if err != 0 {
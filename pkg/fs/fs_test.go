@@ -122,6 +122,29 @@ func TestFileSystem(t *testing.T) {
 	if e := f.Flush(ctx); e != 0 {
 		t.Fatalf("flush /hello: %s", e)
 	}
+	if sf, err := fs.Create(ctx, "/sparse", 0644); err != 0 {
+		t.Fatalf("create /sparse: %s", err)
+	} else {
+		if n, err := sf.Pwrite(ctx, []byte("hello"), 0); err != 0 || n != 5 {
+			t.Fatalf("write at 0: %d %s", n, err)
+		}
+		if n, err := sf.Pwrite(ctx, []byte("sparse"), 100<<20); err != 0 || n != 6 {
+			t.Fatalf("write at 100M: %d %s", n, err)
+		}
+		if n, err := sf.Seek(ctx, 10, meta.SeekData); err != nil || n != 100<<20 {
+			t.Fatalf("seek data from 10: %d %s", n, err)
+		}
+		if n, err := sf.Seek(ctx, 0, meta.SeekHole); err != nil || n != 5 {
+			t.Fatalf("seek hole from 0: %d %s", n, err)
+		}
+		if n, err := sf.Seek(ctx, (100<<20)+6, meta.SeekData); err != syscall.ENXIO {
+			t.Fatalf("seek data from EOF: %d %s", n, err)
+		}
+		_ = sf.Close(ctx)
+		if e := fs.Delete(ctx, "/sparse"); e != 0 {
+			t.Fatalf("delete /sparse: %s", e)
+		}
+	}
 
 	if e := f.Chmod(ctx, 0640); e != 0 {
 		t.Fatalf("chown: %s", e)
@@ -149,6 +172,21 @@ func TestFileSystem(t *testing.T) {
 	if n, e := fs.CopyFileRange(ctx, "/hello", 0, "/hello", 5, 5); e != 0 || n != 2 {
 		t.Fatalf("copyfilerange: %s %d", e, n)
 	}
+	if _, e := fs.Create(ctx, "/concat", 0644); e != 0 {
+		t.Fatalf("create /concat: %s", e)
+	}
+	if n, e := fs.Concat(ctx, "/concat", "/hello", "/hello"); e != 0 || n != 14 {
+		t.Fatalf("concat: %s %d", e, n)
+	}
+	if fi, e := fs.Stat(ctx, "/concat"); e != 0 || fi.Size() != 14 {
+		t.Fatalf("stat /concat: %s %+v", e, fi)
+	}
+	if _, e := fs.Concat(ctx, "/concat", "/nonexist"); e != syscall.ENOENT {
+		t.Fatalf("concat /nonexist: %s", e)
+	}
+	if e := fs.Delete(ctx, "/concat"); e != 0 {
+		t.Fatalf("delete /concat: %s", e)
+	}
 
 	if e := fs.SetXattr(ctx, "/hello", "k", []byte("value"), 0); e != 0 {
 		t.Fatalf("setxattr /hello: %s", e)
@@ -19,6 +19,9 @@ package fs
 import (
 	"compress/gzip"
 	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -62,6 +65,7 @@ func makeGzipHandler(h http.Handler) http.Handler {
 var errmap = map[syscall.Errno]error{
 	0:              nil,
 	syscall.EPERM:  os.ErrPermission,
+	syscall.EACCES: os.ErrPermission,
 	syscall.ENOENT: os.ErrNotExist,
 	syscall.EEXIST: os.ErrExist,
 }
@@ -85,13 +89,21 @@ type webdavFS struct {
 	fs  *FileSystem
 }
 
+// mctx returns the context of the authenticated user of a request, or the one of the server.
+func (hfs *webdavFS) mctx(ctx context.Context) meta.Context {
+	if u, ok := ctx.Value(webdavUserKey{}).(*webdavUser); ok {
+		return u.ctx
+	}
+	return hfs.ctx
+}
+
 func (hfs *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
-	return econv(hfs.fs.Mkdir(hfs.ctx, name, uint16(perm)))
+	return econv(hfs.fs.Mkdir(hfs.mctx(ctx), name, uint16(perm)))
 }
 
 func (hfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
 	var mode int
-	if flag&(os.O_RDONLY|os.O_RDWR) != 0 {
+	if flag&syscall.O_ACCMODE != os.O_WRONLY {
 		mode |= vfs.MODE_MASK_R
 	}
 	if flag&(os.O_APPEND|os.O_RDWR|os.O_WRONLY) != 0 {
@@ -101,57 +113,60 @@ func (hfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		mode |= vfs.MODE_MASK_X
 	}
 	name = strings.TrimRight(name, "/")
-	f, err := hfs.fs.Open(hfs.ctx, name, uint32(mode))
+	jctx := hfs.mctx(ctx)
+	f, err := hfs.fs.Open(jctx, name, uint32(mode))
 	if err != 0 {
 		if err == syscall.ENOENT && flag&os.O_CREATE != 0 {
-			f, err = hfs.fs.Create(hfs.ctx, name, uint16(perm))
+			f, err = hfs.fs.Create(jctx, name, uint16(perm))
 		}
 	} else if flag&os.O_TRUNC != 0 {
-		if errno := hfs.fs.Truncate(hfs.ctx, name, 0); errno != 0 {
+		if errno := hfs.fs.Truncate(jctx, name, 0); errno != 0 {
 			return nil, errno
 		}
 	} else if flag&os.O_APPEND != 0 {
-		if _, err := f.Seek(hfs.ctx, 0, 2); err != nil {
+		if _, err := f.Seek(jctx, 0, 2); err != nil {
 			return nil, err
 		}
 	}
-	return &davFile{f}, econv(err)
+	return &davFile{f, jctx}, econv(err)
 }
 
 func (hfs *webdavFS) RemoveAll(ctx context.Context, name string) error {
-	return econv(hfs.fs.Rmr(hfs.ctx, name))
+	return econv(hfs.fs.Rmr(hfs.mctx(ctx), name))
 }
 
 func (hfs *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
-	return econv(hfs.fs.Rename(hfs.ctx, oldName, newName, 0))
+	return econv(hfs.fs.Rename(hfs.mctx(ctx), oldName, newName, 0))
 }
 
 func (hfs *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	fi, err := hfs.fs.Stat(hfs.ctx, removeNewLine(name))
+	fi, err := hfs.fs.Stat(hfs.mctx(ctx), removeNewLine(name))
 	return fi, econv(err)
 }
 
+// davFile is a file opened by a request, which is accessed as the user of the request.
 type davFile struct {
 	*File
+	ctx meta.Context
 }
 
 func (f *davFile) Seek(offset int64, whence int) (int64, error) {
-	n, err := f.File.Seek(meta.Background, offset, whence)
+	n, err := f.File.Seek(f.ctx, offset, whence)
 	return n, econv(err)
 }
 
 func (f *davFile) Read(b []byte) (n int, err error) {
-	n, err = f.File.Read(meta.Background, b)
+	n, err = f.File.Read(f.ctx, b)
 	return n, econv(err)
 }
 
 func (f *davFile) Write(buf []byte) (n int, err error) {
-	n, err = f.File.Write(meta.Background, buf)
+	n, err = f.File.Write(f.ctx, buf)
 	return n, econv(err)
 }
 
 func (f *davFile) Readdir(count int) (fi []os.FileInfo, err error) {
-	fi, err = f.File.Readdir(meta.Background, count)
+	fi, err = f.File.Readdir(f.ctx, count)
 	// skip the first two (. and ..)
 	for len(fi) > 0 && (fi[0].Name() == "." || fi[0].Name() == "..") {
 		fi = fi[1:]
@@ -160,11 +175,97 @@ func (f *davFile) Readdir(count int) (fi []os.FileInfo, err error) {
 }
 
 func (f *davFile) Close() error {
-	return econv(f.File.Close(meta.Background))
+	return econv(f.File.Close(f.ctx))
+}
+
+// the dead properties are kept as extended attributes, named with the namespace and name
+// of the property as "user.webdav.prop.{namespace}name".
+const davPropPrefix = "user.webdav.prop."
+
+type davProp struct {
+	Lang     string `json:",omitempty"`
+	InnerXML string
+}
+
+func propXattr(name xml.Name) string {
+	return davPropPrefix + "{" + name.Space + "}" + name.Local
+}
+
+func (f *davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	m := f.fs.m
+	if m.Access(f.ctx, f.inode, vfs.MODE_MASK_R, nil) != 0 {
+		return nil, nil // hidden from the users who can't read the file
+	}
+	var names []byte
+	if eno := m.ListXattr(f.ctx, f.inode, &names); eno != 0 {
+		return nil, econv(eno)
+	}
+	var props map[xml.Name]webdav.Property
+	for _, name := range strings.Split(string(names), "\x00") {
+		if !strings.HasPrefix(name, davPropPrefix+"{") {
+			continue
+		}
+		i := strings.IndexByte(name, '}')
+		if i < 0 {
+			continue
+		}
+		var v []byte
+		if eno := m.GetXattr(f.ctx, f.inode, name, &v); eno == meta.ENOATTR {
+			continue
+		} else if eno != 0 {
+			return nil, econv(eno)
+		}
+		var p davProp
+		if err := json.Unmarshal(v, &p); err != nil {
+			logger.Warnf("invalid WebDAV property %s of %s: %s", name, f.path, err)
+			continue
+		}
+		xname := xml.Name{Space: name[len(davPropPrefix)+1 : i], Local: name[i+1:]}
+		if props == nil {
+			props = make(map[xml.Name]webdav.Property)
+		}
+		props[xname] = webdav.Property{XMLName: xname, Lang: p.Lang, InnerXML: []byte(p.InnerXML)}
+	}
+	return props, nil
+}
+
+func (f *davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	m := f.fs.m
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	// the directories are opened without checking the permission
+	if m.Access(f.ctx, f.inode, vfs.MODE_MASK_W, nil) != 0 {
+		pstat.Status = http.StatusForbidden
+		for _, patch := range patches {
+			for _, p := range patch.Props {
+				pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+			}
+		}
+		return []webdav.Propstat{pstat}, nil
+	}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+			if patch.Remove {
+				if eno := m.RemoveXattr(f.ctx, f.inode, propXattr(p.XMLName)); eno != 0 && eno != meta.ENOATTR {
+					return nil, econv(eno)
+				}
+				continue
+			}
+			v, err := json.Marshal(&davProp{p.Lang, string(p.InnerXML)})
+			if err != nil {
+				return nil, err
+			}
+			if eno := m.SetXattr(f.ctx, f.inode, propXattr(p.XMLName), v, meta.XattrCreateOrReplace); eno != 0 {
+				return nil, econv(eno)
+			}
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
 }
 
 type indexHandler struct {
 	*webdav.Handler
+	locks        *davLockSystem
 	disallowList bool
 }
 
@@ -176,9 +277,10 @@ func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//		the collection, or something else altogether.
 	//
 	// Get, when applied to collection, will return the same as PROPFIND method.
-	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, h.Handler.Prefix) {
-		info, err := h.Handler.FileSystem.Stat(context.TODO(), strings.TrimPrefix(r.URL.Path, h.Handler.Prefix))
-		if err == nil && info.IsDir() {
+	if (r.Method == "GET" || r.Method == "HEAD") && strings.HasPrefix(r.URL.Path, h.Handler.Prefix) {
+		name := strings.TrimPrefix(r.URL.Path, h.Handler.Prefix)
+		info, err := h.Handler.FileSystem.Stat(r.Context(), name)
+		if err == nil && info.IsDir() && r.Method == "GET" {
 			if h.disallowList {
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
@@ -187,17 +289,44 @@ func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if r.Header.Get("Depth") == "" {
 				r.Header.Add("Depth", "1")
 			}
+		} else if err == nil && !info.IsDir() {
+			// the handler of WebDAV responds 404 for any failure to open the file
+			f, err := h.Handler.FileSystem.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+			if err == os.ErrPermission {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			} else if err == nil {
+				_ = f.Close()
+			}
 		}
 	}
-	h.Handler.ServeHTTP(w, r)
+	// the locks are bound to the user, and the ones taken by the writes without locks are temporary
+	var user string
+	if u, ok := r.Context().Value(webdavUserKey{}).(*webdavUser); ok {
+		user = u.name
+	}
+	srv := *h.Handler
+	srv.LockSystem = h.locks.view(user, r.Method != "LOCK")
+	srv.ServeHTTP(w, r)
 }
 
-func StartHTTPServer(fs *FileSystem, addr string, gzipEnabled bool, disallowList bool) {
+// WebdavConfig is the configuration of the WebDAV server.
+type WebdavConfig struct {
+	Addr         string
+	DisallowList bool
+	EnableGzip   bool
+	Users        string // the file of users, no authentication if it's empty
+	Digest       bool   // use Digest authentication instead of Basic
+	Realm        string
+	CertFile     string
+	KeyFile      string
+}
+
+func newWebdavHandler(fs *FileSystem, conf WebdavConfig) (http.Handler, error) {
 	ctx := meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
 	hfs := &webdavFS{ctx, fs}
 	srv := &webdav.Handler{
 		FileSystem: hfs,
-		LockSystem: webdav.NewMemLS(),
 		Logger: func(r *http.Request, err error) {
 			if err != nil {
 				logger.Errorf("WEBDAV [%s]: %s, ERROR: %s", r.Method, r.URL, err)
@@ -206,13 +335,39 @@ func StartHTTPServer(fs *FileSystem, addr string, gzipEnabled bool, disallowList
 			}
 		},
 	}
-	var h http.Handler = &indexHandler{srv, disallowList}
-	if gzipEnabled {
+	var h http.Handler = &indexHandler{srv, newLockSystem(fs.m), conf.DisallowList}
+	if conf.EnableGzip {
 		h = makeGzipHandler(h)
 	}
+	if conf.Users != "" {
+		users, err := loadWebdavUsers(conf.Users)
+		if err != nil {
+			return nil, fmt.Errorf("load users: %s", err)
+		}
+		if h, err = newAuthHandler(h, users, conf.Realm, conf.Digest); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func StartHTTPServer(fs *FileSystem, conf WebdavConfig) {
+	h, err := newWebdavHandler(fs, conf)
+	if err != nil {
+		logger.Fatalf("Error with WebDAV server: %s", err)
+	}
 	http.Handle("/", h)
-	logger.Infof("WebDAV listening on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if conf.CertFile != "" {
+		logger.Infof("WebDAV listening on %s with TLS", conf.Addr)
+		err = http.ListenAndServeTLS(conf.Addr, conf.CertFile, conf.KeyFile, nil)
+	} else {
+		if conf.Users != "" && !conf.Digest {
+			logger.Warnf("The passwords of Basic authentication are sent in plain text without TLS")
+		}
+		logger.Infof("WebDAV listening on %s", conf.Addr)
+		err = http.ListenAndServe(conf.Addr, nil)
+	}
+	if err != nil {
 		logger.Fatalf("Error with WebDAV server: %v", err)
 	}
 }
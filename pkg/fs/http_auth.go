@@ -0,0 +1,319 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long a nonce of Digest authentication is valid
+const nonceTimeout = time.Minute * 5
+
+// webdavUser is a user of the WebDAV server, whose requests are served as Uid and Gids.
+type webdavUser struct {
+	name string
+	hash string
+	ctx  meta.Context
+}
+
+// loadWebdavUsers reads the users from a file like the one of htpasswd, each line is
+//
+//	NAME:HASH[:UID[:GID,GID...]]
+//
+// HASH is the bcrypt ($2y$...) or SHA1 ({SHA}...) of the password, or the MD5 of
+// NAME:REALM:PASSWORD (as in the files of htdigest), which is required by Digest
+// authentication. The users are served as the owner of the server if UID is omitted,
+// and as the group of the same ID if GID is omitted.
+func loadWebdavUsers(path string) (map[string]*webdavUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]*webdavUser)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ps := strings.Split(line, ":")
+		if len(ps) < 2 || len(ps) > 4 || ps[0] == "" {
+			return nil, fmt.Errorf("line %d of %s: invalid user", n, path)
+		}
+		if !validHash(ps[1]) {
+			return nil, fmt.Errorf("line %d of %s: unknown hash of password", n, path)
+		}
+		uid, gids := uint32(os.Getuid()), []uint32{uint32(os.Getgid())}
+		if len(ps) > 2 && ps[2] != "" {
+			id, err := strconv.ParseUint(ps[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of %s: invalid uid %s", n, path, ps[2])
+			}
+			uid, gids = uint32(id), []uint32{uint32(id)}
+		}
+		if len(ps) > 3 && ps[3] != "" {
+			gids = gids[:0]
+			for _, g := range strings.Split(ps[3], ",") {
+				id, err := strconv.ParseUint(g, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d of %s: invalid gid %s", n, path, g)
+				}
+				gids = append(gids, uint32(id))
+			}
+		}
+		users[ps[0]] = &webdavUser{ps[0], ps[1], meta.NewContext(uint32(os.Getpid()), uid, gids)}
+	}
+	return users, s.Err()
+}
+
+func validHash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		b, err := base64.StdEncoding.DecodeString(hash[5:])
+		return err == nil && len(b) == sha1.Size
+	default:
+		b, err := hex.DecodeString(hash)
+		return err == nil && len(b) == md5.Size
+	}
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (u *webdavUser) checkPassword(realm, password string) bool {
+	switch {
+	case strings.HasPrefix(u.hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(u.hash), []byte(password)) == nil
+	case strings.HasPrefix(u.hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(u.hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(u.hash)), []byte(md5hex(u.name+":"+realm+":"+password))) == 1
+	}
+}
+
+type webdavUserKey struct{}
+
+// authHandler authenticates the requests with Basic or Digest authentication, and passes
+// the user to webdavFS and the lock system within the request.
+type authHandler struct {
+	handler http.Handler
+	users   map[string]*webdavUser
+	realm   string
+	digest  bool
+	secret  []byte // to sign the nonces
+
+	mu     sync.Mutex
+	counts map[string]uint64 // the last nonce count of the nonces in use, to refuse replayed requests
+}
+
+func newAuthHandler(h http.Handler, users map[string]*webdavUser, realm string, digest bool) (*authHandler, error) {
+	if digest {
+		for _, u := range users {
+			if strings.HasPrefix(u.hash, "$2") || strings.HasPrefix(u.hash, "{SHA}") {
+				return nil, fmt.Errorf("password of user %s is not hashed with the realm, which is required by Digest authentication", u.name)
+			}
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &authHandler{handler: h, users: users, realm: realm, digest: digest, secret: secret, counts: make(map[string]uint64)}, nil
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var user *webdavUser
+	var stale bool
+	if h.digest {
+		user, stale = h.checkDigest(r)
+	} else if name, password, ok := r.BasicAuth(); ok {
+		if u := h.users[name]; u != nil && u.checkPassword(h.realm, password) {
+			user = u
+		}
+	}
+	if user == nil {
+		if h.digest {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s", stale=%t`, h.realm, h.nonce(time.Now()), stale))
+		} else {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, h.realm))
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), webdavUserKey{}, user)))
+}
+
+// nonce returns a nonce signed with the time it's created, so it can be verified without
+// keeping any state.
+func (h *authHandler) nonce(now time.Time) string {
+	buf := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(now.UnixNano()))
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(buf))
+}
+
+// nonceTime returns the time when the nonce is created, or zero time if it's not signed by the server.
+func (h *authHandler) nonceTime(nonce string) time.Time {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 8+sha256.Size {
+		return time.Time{}
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(buf[:8])
+	if !hmac.Equal(mac.Sum(nil), buf[8:]) {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+}
+
+// checkNonce returns whether the nonce is signed by the server, and whether it's expired.
+func (h *authHandler) checkNonce(nonce string) (valid, stale bool) {
+	created := h.nonceTime(nonce)
+	if created.IsZero() {
+		return false, false
+	}
+	return true, time.Since(created) > nonceTimeout
+}
+
+// useNonce records the nonce count of an authenticated request, and returns false if it's not
+// larger than the one of previous requests with the same nonce, which means the request is replayed.
+func (h *authHandler) useNonce(nonce string, nc uint64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if last, ok := h.counts[nonce]; ok && nc <= last {
+		return false
+	}
+	h.counts[nonce] = nc
+	if len(h.counts)%100 == 0 {
+		for n := range h.counts {
+			if time.Since(h.nonceTime(n)) > nonceTimeout {
+				delete(h.counts, n)
+			}
+		}
+	}
+	return true
+}
+
+func (h *authHandler) checkDigest(r *http.Request) (user *webdavUser, stale bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return nil, false
+	}
+	ps := parseAuthParams(auth[7:])
+	u := h.users[ps["username"]]
+	if u == nil || ps["realm"] != h.realm || ps["uri"] != r.RequestURI {
+		return nil, false
+	}
+	if alg := ps["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return nil, false
+	}
+	valid, stale := h.checkNonce(ps["nonce"])
+	if !valid {
+		return nil, false
+	}
+	ha2 := md5hex(r.Method + ":" + ps["uri"])
+	var expected string
+	var nc uint64 = 1 // a nonce can be used only once without qop
+	switch ps["qop"] {
+	case "auth":
+		var err error
+		if nc, err = strconv.ParseUint(ps["nc"], 16, 32); err != nil {
+			return nil, false
+		}
+		expected = md5hex(strings.Join([]string{strings.ToLower(u.hash), ps["nonce"], ps["nc"], ps["cnonce"], "auth", ha2}, ":"))
+	case "":
+		expected = md5hex(strings.ToLower(u.hash) + ":" + ps["nonce"] + ":" + ha2)
+	default:
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(ps["response"])) != 1 {
+		return nil, false
+	}
+	if stale {
+		return nil, true
+	}
+	if !h.useNonce(ps["nonce"], nc) {
+		return nil, false
+	}
+	return u, false
+}
+
+// parseAuthParams parses the parameters like `a="x, y", b=z` in the header of Authorization.
+func parseAuthParams(s string) map[string]string {
+	ps := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return ps
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			j := 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			value = b.String()
+			if j < len(s) {
+				j++
+			}
+			s = s[j:]
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value = strings.TrimSpace(s[:j])
+			s = s[j:]
+		}
+		ps[key] = value
+	}
+}
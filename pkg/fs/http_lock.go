@@ -0,0 +1,343 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	davLockPrefix  = "user.webdav.lock."
+	davTokenPrefix = "opaquelocktoken:"
+
+	davRoot Ino = 1 // the locks are kept in the root directory
+)
+
+// the max duration of locks, the clients should refresh the locks in time.
+var maxLockDuration = time.Hour
+
+// the duration of the temporary locks taken by the writes without locks, which are refreshed
+// by the server while the writes are being served, so the ones left by a crashed server
+// expire soon.
+var tempLockDuration = time.Minute
+
+// the expired locks are removed after this, so they are not removed while being refreshed by
+// a server whose clock is a bit behind.
+const expiredLockGrace = time.Minute
+
+// davLock is a lock of WebDAV, which is kept as an extended attribute of the root directory.
+type davLock struct {
+	Token     string
+	Root      string
+	User      string `json:",omitempty"` // who creates the lock, the only one can use it
+	Duration  time.Duration
+	Expire    int64  // in nanoseconds
+	OwnerXML  string `json:",omitempty"`
+	ZeroDepth bool   `json:",omitempty"`
+}
+
+func (l *davLock) covers(name string) bool {
+	return l.Root == name || !l.ZeroDepth && isAncestor(l.Root, name)
+}
+
+func (l *davLock) conflicts(o *davLock) bool {
+	return l.covers(o.Root) || o.covers(l.Root)
+}
+
+func isAncestor(dir, name string) bool {
+	return dir == "/" && name != "/" || strings.HasPrefix(name, dir+"/")
+}
+
+// davLockSystem keeps the locks of WebDAV in the meta engine, so they are shared by all the
+// WebDAV servers of a volume and survive the restarts. There is no mutex among the servers,
+// a new lock is created exclusively before checking the others, and removed if it conflicts
+// with any of them, so two conflicting locks created at the same time may both fail, but
+// never both succeed.
+type davLockSystem struct {
+	mu    sync.Mutex
+	m     meta.Meta
+	held  map[string]bool     // the locks used by the requests being served by this server
+	temps map[string]*davLock // the temporary locks taken by this server
+}
+
+func newLockSystem(m meta.Meta) *davLockSystem {
+	ls := &davLockSystem{m: m, held: make(map[string]bool), temps: make(map[string]*davLock)}
+	go ls.refreshTemps()
+	return ls
+}
+
+// refreshTemps keeps the temporary locks of this server alive.
+func (ls *davLockSystem) refreshTemps() {
+	for {
+		time.Sleep(tempLockDuration / 3)
+		ls.mu.Lock()
+		var temps []*davLock
+		for _, l := range ls.temps {
+			temps = append(temps, l)
+		}
+		ls.mu.Unlock()
+		now := time.Now()
+		for _, l := range temps {
+			l := *l
+			l.refresh(now, tempLockDuration)
+			if err := ls.save(&l, meta.XattrReplace); err != nil && err != meta.ENOATTR {
+				logger.Warnf("refresh WebDAV lock %s: %s", l.Token, err)
+			}
+		}
+	}
+}
+
+// davLocks is the lock system used by a request, the locks are bound to the user of the request,
+// and the ones created by the requests other than LOCK are temporary.
+type davLocks struct {
+	*davLockSystem
+	user string
+	temp bool
+}
+
+func (ls *davLockSystem) view(user string, temp bool) *davLocks {
+	return &davLocks{ls, user, temp}
+}
+
+func lockName(token string) string {
+	return davLockPrefix + strings.TrimPrefix(token, davTokenPrefix)
+}
+
+// get returns the lock of the token, or nil if it does not exist or is expired.
+func (ls *davLockSystem) get(now time.Time, token string) (*davLock, error) {
+	if !strings.HasPrefix(token, davTokenPrefix) {
+		return nil, nil
+	}
+	var v []byte
+	if eno := ls.m.GetXattr(meta.Background, davRoot, lockName(token), &v); eno == meta.ENOATTR {
+		return nil, nil
+	} else if eno != 0 {
+		return nil, eno
+	}
+	var l davLock
+	if err := json.Unmarshal(v, &l); err != nil {
+		logger.Warnf("invalid WebDAV lock %s: %s", token, err)
+		return nil, nil
+	}
+	if l.Expire < now.Add(-expiredLockGrace).UnixNano() {
+		return nil, ls.remove(&l)
+	} else if l.Expire < now.UnixNano() {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+// list returns all the locks which are not expired.
+func (ls *davLockSystem) list(now time.Time) ([]*davLock, error) {
+	var names []byte
+	if eno := ls.m.ListXattr(meta.Background, davRoot, &names); eno != 0 {
+		return nil, eno
+	}
+	var locks []*davLock
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if !bytes.HasPrefix(name, []byte(davLockPrefix)) {
+			continue
+		}
+		l, err := ls.get(now, davTokenPrefix+string(name[len(davLockPrefix):]))
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			locks = append(locks, l)
+		}
+	}
+	return locks, nil
+}
+
+func (ls *davLockSystem) save(l *davLock, flags uint32) error {
+	v, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if eno := ls.m.SetXattr(meta.Background, davRoot, lockName(l.Token), v, flags); eno != 0 {
+		return eno
+	}
+	return nil
+}
+
+func (ls *davLockSystem) remove(l *davLock) error {
+	if eno := ls.m.RemoveXattr(meta.Background, davRoot, lockName(l.Token)); eno != 0 && eno != meta.ENOATTR {
+		return eno
+	}
+	return nil
+}
+
+func (l *davLock) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: l.Root, Duration: l.Duration, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+func (l *davLock) refresh(now time.Time, duration time.Duration) {
+	if duration < 0 || duration > maxLockDuration { // negative means infinite
+		duration = maxLockDuration
+	}
+	l.Duration = duration
+	l.Expire = now.Add(duration).UnixNano()
+}
+
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+func (ls *davLocks) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	// find the lock of the user which covers the name and is not being used by other requests
+	lookup := func(name string) (string, error) {
+		name = slashClean(name)
+		for _, c := range conditions {
+			ls.mu.Lock()
+			held := ls.held[c.Token]
+			ls.mu.Unlock()
+			if held {
+				continue
+			}
+			l, err := ls.get(now, c.Token)
+			if err != nil {
+				return "", err
+			}
+			if l != nil && l.User == ls.user && l.covers(name) {
+				return l.Token, nil
+			}
+		}
+		return "", webdav.ErrConfirmationFailed
+	}
+	var t0, t1 string
+	var err error
+	if name0 != "" {
+		if t0, err = lookup(name0); err != nil {
+			return nil, err
+		}
+	}
+	if name1 != "" {
+		if t1, err = lookup(name1); err != nil {
+			return nil, err
+		}
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.held[t0] || ls.held[t1] { // taken by another request just now
+		return nil, webdav.ErrConfirmationFailed
+	}
+	for _, t := range []string{t0, t1} {
+		if t != "" {
+			ls.held[t] = true
+		}
+	}
+	return func() {
+		ls.mu.Lock()
+		delete(ls.held, t0)
+		delete(ls.held, t1)
+		ls.mu.Unlock()
+	}, nil
+}
+
+func (ls *davLocks) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	l := &davLock{
+		Token:     davTokenPrefix + uuid.New().String(),
+		Root:      slashClean(details.Root),
+		User:      ls.user,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+	}
+	if ls.temp {
+		l.refresh(now, tempLockDuration)
+	} else {
+		l.refresh(now, details.Duration)
+	}
+	if err := ls.save(l, meta.XattrCreate); err != nil {
+		return "", err
+	}
+	locks, err := ls.list(now)
+	if err == nil {
+		for _, o := range locks {
+			if o.Token != l.Token && o.conflicts(l) {
+				err = webdav.ErrLocked
+				break
+			}
+		}
+	}
+	if err != nil {
+		if e := ls.remove(l); e != nil {
+			logger.Warnf("remove WebDAV lock %s: %s", l.Token, e)
+		}
+		return "", err
+	}
+	if ls.temp {
+		ls.mu.Lock()
+		ls.temps[l.Token] = l
+		ls.mu.Unlock()
+	}
+	return l.Token, nil
+}
+
+func (ls *davLocks) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	l, err := ls.get(now, token)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	if l == nil || l.User != ls.user {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	ls.mu.Lock()
+	held := ls.held[token]
+	ls.mu.Unlock()
+	if held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	l.refresh(now, duration)
+	if err = ls.save(l, meta.XattrReplace); err == meta.ENOATTR {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	} else if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return l.details(), nil
+}
+
+func (ls *davLocks) Unlock(now time.Time, token string) error {
+	l, err := ls.get(now, token)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if l.User != ls.user {
+		return webdav.ErrForbidden
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.held[token] {
+		return webdav.ErrLocked
+	}
+	delete(ls.temps, token)
+	return ls.remove(l)
+}
@@ -0,0 +1,265 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/vfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServeConfig is the configuration of the read-only HTTP server.
+type ServeConfig struct {
+	Addr       string
+	ListDir    bool      // list the directories in JSON
+	AccessLog  io.Writer // the log of requests, disabled if it's nil
+	PathDepth  int       // the depth of paths in the label of metrics
+	Registerer prometheus.Registerer
+	CertFile   string
+	KeyFile    string
+}
+
+// fileServer serves the files with Range, ETag and conditional requests by http.ServeContent,
+// which reads the files with Pread.
+type fileServer struct {
+	fs      *FileSystem
+	ctx     meta.Context
+	listDir bool
+}
+
+// dirEntry is an entry in the JSON listing of a directory.
+type dirEntry struct {
+	Name  string    `json:"name"`
+	IsDir bool      `json:"is_dir"`
+	Size  int64     `json:"size"`
+	Mode  string    `json:"mode"`
+	MTime time.Time `json:"mtime"`
+}
+
+type fileReaderAt struct {
+	*File
+	ctx meta.Context
+}
+
+func (f *fileReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.Pread(f.ctx, b, off)
+	if n == len(b) && err == io.EOF {
+		err = nil
+	}
+	return n, econv(err)
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	f, eno := s.fs.Open(s.ctx, p, vfs.MODE_MASK_R)
+	if eno != 0 {
+		s.error(w, eno)
+		return
+	}
+	defer f.Close(s.ctx)
+	fi, _ := f.Stat()
+	st := fi.(*FileStat)
+	if sw, ok := w.(*statusWriter); ok {
+		if fi.IsDir() {
+			sw.dir = p
+		} else {
+			sw.dir = path.Dir(p)
+		}
+	}
+	if !fi.IsDir() {
+		// strong validator, which is changed with the content
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-%x"`, st.inode, st.attr.Mtime*1e9+int64(st.attr.Mtimensec), st.attr.Length))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), io.NewSectionReader(&fileReaderAt{f, s.ctx}, 0, fi.Size()))
+		return
+	}
+	if !s.listDir {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	entries, eno := f.Readdir(s.ctx, 0)
+	if eno != 0 {
+		s.error(w, eno)
+		return
+	}
+	list := make([]dirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, dirEntry{e.Name(), e.IsDir(), e.Size(), fmt.Sprintf("%#o", e.Mode().Perm()), e.ModTime().UTC()})
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the listing is changed with the entries without changing the directory, so it's validated
+	// by the hash of itself only
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	http.ServeContent(w, r, "index.json", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *fileServer) error(w http.ResponseWriter, eno syscall.Errno) {
+	switch eno {
+	case syscall.ENOENT, syscall.ENOTDIR:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case syscall.EACCES, syscall.EPERM:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, eno.Error(), http.StatusInternalServerError)
+	}
+}
+
+// httpMetrics counts the requests by the directories in the first depth levels of paths.
+type httpMetrics struct {
+	depth     int
+	requests  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	sent      *prometheus.CounterVec
+}
+
+func newHTTPMetrics(reg prometheus.Registerer, depth int) *httpMetrics {
+	m := &httpMetrics{
+		depth: depth,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "number of HTTP requests.",
+		}, []string{"method", "code", "path"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_durations_histogram_seconds",
+			Help:    "HTTP requests latency distributions.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 30),
+		}, []string{"method", "path"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_sent_bytes",
+			Help: "number of bytes sent to HTTP clients.",
+		}, []string{"path"}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.requests, m.durations, m.sent)
+	}
+	return m
+}
+
+// label returns the path in the metrics, which is the first levels of the existing directory
+// served, the failed requests are counted together to limit the number of series.
+func (m *httpMetrics) label(dir string, code int) string {
+	if dir == "" || code >= http.StatusBadRequest {
+		return "-"
+	}
+	ps := strings.Split(strings.Trim(dir, "/"), "/")
+	if len(ps) > m.depth {
+		ps = ps[:m.depth]
+	}
+	return "/" + strings.Join(ps, "/")
+}
+
+// statusClass returns the class of status code, such as 2xx.
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+	dir  string // the directory of the served file, or the served directory
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// logHandler writes the access log and the metrics of requests.
+type logHandler struct {
+	handler   http.Handler
+	accessLog io.Writer
+	metrics   *httpMetrics
+}
+
+func (h *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+	h.handler.ServeHTTP(sw, r)
+	used := time.Since(start)
+
+	label := h.metrics.label(sw.dir, sw.code)
+	h.metrics.requests.WithLabelValues(r.Method, statusClass(sw.code), label).Inc()
+	h.metrics.durations.WithLabelValues(r.Method, label).Observe(used.Seconds())
+	h.metrics.sent.WithLabelValues(label).Add(float64(sw.size))
+	if h.accessLog != nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		// Combined Log Format, with the time used in seconds
+		_, _ = fmt.Fprintf(h.accessLog, "%s - - [%s] %q %d %d %q %q %.6f\n", host, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, sw.code, sw.size, r.Referer(), r.UserAgent(), used.Seconds())
+	}
+}
+
+func newFileServer(fs *FileSystem, conf ServeConfig) http.Handler {
+	ctx := meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
+	depth := conf.PathDepth
+	if depth <= 0 {
+		depth = 1
+	}
+	return &logHandler{
+		handler:   &fileServer{fs, ctx, conf.ListDir},
+		accessLog: conf.AccessLog,
+		metrics:   newHTTPMetrics(conf.Registerer, depth),
+	}
+}
+
+// StartFileServer serves the files of fs by HTTP in read-only mode.
+func StartFileServer(fs *FileSystem, conf ServeConfig) {
+	srv := &http.Server{Addr: conf.Addr, Handler: newFileServer(fs, conf)}
+	var err error
+	if conf.CertFile != "" {
+		logger.Infof("HTTP server listening on %s with TLS", conf.Addr)
+		err = srv.ListenAndServeTLS(conf.CertFile, conf.KeyFile)
+	} else {
+		logger.Infof("HTTP server listening on %s", conf.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		logger.Fatalf("Error with HTTP server: %v", err)
+	}
+}
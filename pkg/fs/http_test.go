@@ -17,12 +17,26 @@
 package fs
 
 import (
+	"bytes"
 	"context"
-	"github.com/juicedata/juicefs/pkg/meta"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/vfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/webdav"
 )
 
 func TestWebdav(t *testing.T) {
@@ -83,3 +97,490 @@ func TestWebdav(t *testing.T) {
 		t.Fatalf("webdavFS close file failed: %s", err)
 	}
 }
+
+func TestWebdavAuth(t *testing.T) {
+	jfs := createTestFS(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	users := filepath.Join(t.TempDir(), "users")
+	content := fmt.Sprintf("# name:hash:uid:gids\nalice:%s\nbob:%s:1000:1000,2000\n", hash, md5hex("bob:JuiceFS:pass"))
+	if err := os.WriteFile(users, []byte(content), 0600); err != nil {
+		t.Fatalf("write users: %s", err)
+	}
+	h, err := newWebdavHandler(jfs, WebdavConfig{Users: users, Realm: "JuiceFS"})
+	if err != nil {
+		t.Fatalf("webdav handler: %s", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	do := func(method, path, user, password string) *http.Response {
+		var body io.Reader
+		if method == "PUT" {
+			body = strings.NewReader("data")
+		}
+		req, _ := http.NewRequest(method, srv.URL+path, body)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := do("PUT", "/a", "", ""); resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("anonymous put: %s %s", resp.Status, resp.Header.Get("WWW-Authenticate"))
+	}
+	if resp := do("PUT", "/a", "alice", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("put with wrong password: %s", resp.Status)
+	}
+	if resp := do("PUT", "/a", "alice", "secret"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put by alice: %s", resp.Status)
+	}
+	if resp := do("PUT", "/b", "bob", "pass"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put by bob: %s", resp.Status)
+	}
+	if fi, eno := jfs.Stat(meta.Background, "/b"); eno != 0 || fi.Uid() != 1000 || fi.Gid() != 1000 {
+		t.Fatalf("owner of /b: %+v %s", fi, eno)
+	}
+	if f, eno := jfs.Open(meta.Background, "/a", 0); eno != 0 || f.Chmod(meta.Background, 0600) != 0 {
+		t.Fatalf("chmod /a: %s", eno)
+	}
+	if resp := do("GET", "/a", "bob", "pass"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("get private file by bob: %s", resp.Status)
+	}
+	if resp := do("GET", "/a", "alice", "secret"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("get private file by alice: %s", resp.Status)
+	}
+	// the properties of the root directory are not writable by bob
+	if d, eno := jfs.Open(meta.Background, "/", 0); eno != 0 || d.Chmod(meta.Background, 0755) != 0 {
+		t.Fatalf("chmod /: %s", eno)
+	}
+	proppatch := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:color>red</Z:color></D:prop></D:set></D:propertyupdate>`
+	req, _ := http.NewRequest("PROPPATCH", srv.URL+"/", strings.NewReader(proppatch))
+	req.SetBasicAuth("bob", "pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("proppatch by bob: %s", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(string(data), "403 Forbidden") {
+		t.Fatalf("proppatch by bob: %s %s", resp.Status, data)
+	}
+	if _, eno := jfs.GetXattr(meta.Background, "/", davPropPrefix+"{urn:test}color"); eno != meta.ENOATTR {
+		t.Fatalf("property of / should not be set: %s", eno)
+	}
+	if resp := do("MKCOL", "/private", "alice", "secret"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("mkcol by alice: %s", resp.Status)
+	}
+	if d, eno := jfs.Open(meta.Background, "/private", 0); eno != 0 || d.Chmod(meta.Background, 0700) != 0 {
+		t.Fatalf("chmod /private: %s", eno)
+	}
+	if resp := do("PUT", "/private/b", "bob", "pass"); resp.StatusCode == http.StatusCreated {
+		t.Fatalf("put into private directory by bob: %s", resp.Status)
+	}
+	if _, eno := jfs.Stat(meta.Background, "/private/b"); eno == 0 {
+		t.Fatalf("/private/b should not be created")
+	}
+	if resp := do("DELETE", "/private", "bob", "pass"); resp.StatusCode == http.StatusNoContent {
+		t.Fatalf("delete private directory by bob: %s", resp.Status)
+	}
+	// the lock of alice can't be released by bob
+	lockinfo := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	req, _ = http.NewRequest("LOCK", srv.URL+"/a", strings.NewReader(lockinfo))
+	req.SetBasicAuth("alice", "secret")
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("lock by alice: %+v %v", resp, err)
+	}
+	resp.Body.Close()
+	token := resp.Header.Get("Lock-Token")
+	for _, c := range []struct {
+		user, password string
+		status         int
+	}{{"bob", "pass", http.StatusForbidden}, {"alice", "secret", http.StatusNoContent}} {
+		req, _ = http.NewRequest("UNLOCK", srv.URL+"/a", nil)
+		req.SetBasicAuth(c.user, c.password)
+		req.Header.Set("Lock-Token", token)
+		if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != c.status {
+			t.Fatalf("unlock by %s: %+v %v", c.user, resp, err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err = newWebdavHandler(jfs, WebdavConfig{Users: users, Realm: "JuiceFS", Digest: true}); err == nil {
+		t.Fatalf("bcrypt password should not be allowed for digest")
+	}
+}
+
+func TestWebdavDigest(t *testing.T) {
+	jfs := createTestFS(t)
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, []byte("bob:"+md5hex("bob:JuiceFS:pass")+":1000\n"), 0600); err != nil {
+		t.Fatalf("write users: %s", err)
+	}
+	h, err := newWebdavHandler(jfs, WebdavConfig{Users: users, Realm: "JuiceFS", Digest: true})
+	if err != nil {
+		t.Fatalf("webdav handler: %s", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	do := func(method, path, auth string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader("data"))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	var nc int
+	digest := func(method, path, password, nonce string) string {
+		nc++
+		ha1 := md5hex("bob:JuiceFS:" + password)
+		ha2 := md5hex(method + ":" + path)
+		response := md5hex(strings.Join([]string{ha1, nonce, fmt.Sprintf("%08x", nc), "abcdef", "auth", ha2}, ":"))
+		return fmt.Sprintf(`Digest username="bob", realm="JuiceFS", nonce="%s", uri="%s", qop=auth, nc=%08x, cnonce="abcdef", response="%s"`, nonce, path, nc, response)
+	}
+
+	resp := do("PUT", "/a", "")
+	challenge := parseAuthParams(strings.TrimPrefix(resp.Header.Get("WWW-Authenticate"), "Digest "))
+	if resp.StatusCode != http.StatusUnauthorized || challenge["realm"] != "JuiceFS" || challenge["nonce"] == "" || challenge["qop"] != "auth" {
+		t.Fatalf("anonymous put: %s %s", resp.Status, resp.Header.Get("WWW-Authenticate"))
+	}
+	nonce := challenge["nonce"]
+	if resp = do("PUT", "/a", digest("PUT", "/a", "wrong", nonce)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("put with wrong password: %s", resp.Status)
+	}
+	if resp = do("PUT", "/a", digest("PUT", "/b", "pass", nonce)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("put with digest of another uri: %s", resp.Status)
+	}
+	auth := digest("PUT", "/a", "pass", nonce)
+	if resp = do("PUT", "/a", auth); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put by bob: %s", resp.Status)
+	}
+	if resp = do("PUT", "/a", auth); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed put: %s", resp.Status)
+	}
+	if resp = do("PUT", "/a", digest("PUT", "/a", "pass", nonce)); resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put with the next nonce count: %s", resp.Status)
+	}
+	if fi, eno := jfs.Stat(meta.Background, "/a"); eno != 0 || fi.Uid() != 1000 {
+		t.Fatalf("owner of /a: %+v %s", fi, eno)
+	}
+	if resp = do("PUT", "/a", digest("PUT", "/a", "pass", "bad"+nonce)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("put with forged nonce: %s", resp.Status)
+	}
+	old := h.(*authHandler).nonce(time.Now().Add(-nonceTimeout * 2))
+	resp = do("PUT", "/a", digest("PUT", "/a", "pass", old))
+	if resp.StatusCode != http.StatusUnauthorized || parseAuthParams(resp.Header.Get("WWW-Authenticate")[7:])["stale"] != "true" {
+		t.Fatalf("put with stale nonce: %s %s", resp.Status, resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestWebdavLock(t *testing.T) {
+	jfs := createTestFS(t)
+	// two servers of the same volume
+	var srvs []*httptest.Server
+	for i := 0; i < 2; i++ {
+		h, err := newWebdavHandler(jfs, WebdavConfig{})
+		if err != nil {
+			t.Fatalf("webdav handler: %s", err)
+		}
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		srvs = append(srvs, srv)
+	}
+	do := func(srv *httptest.Server, method, path, body string, header ...string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	lockinfo := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>alice</D:owner></D:lockinfo>`
+	resp := do(srvs[0], "MKCOL", "/d", "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("mkcol: %s", resp.Status)
+	}
+	// the flock of the root directory taken by a user does not block the locks
+	if eno := jfs.m.Flock(meta.Background, davRoot, 1234, meta.F_WRLCK, false); eno != 0 {
+		t.Fatalf("flock root: %s", eno)
+	}
+	defer jfs.m.Flock(meta.Background, davRoot, 1234, meta.F_UNLCK, false)
+	resp = do(srvs[0], "LOCK", "/d", lockinfo, "Timeout", "Second-600")
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(token, davTokenPrefix) {
+		t.Fatalf("lock /d: %s %s", resp.Status, token)
+	}
+	if resp = do(srvs[1], "LOCK", "/d/a", lockinfo); resp.StatusCode != http.StatusLocked {
+		t.Fatalf("lock /d/a in locked directory: %s", resp.Status)
+	}
+	if resp = do(srvs[1], "PUT", "/d/a", "data"); resp.StatusCode != http.StatusLocked {
+		t.Fatalf("put without token: %s", resp.Status)
+	}
+	if resp = do(srvs[1], "PUT", "/d/a", "data", "If", "(<"+token+">)"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put with token: %s", resp.Status)
+	}
+	if resp = do(srvs[1], "LOCK", "/d", "", "If", "(<"+token+">)", "Timeout", "Second-1200"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh lock: %s", resp.Status)
+	}
+	if resp = do(srvs[1], "UNLOCK", "/d", "", "Lock-Token", "<"+token+">"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unlock: %s", resp.Status)
+	}
+	if resp = do(srvs[0], "UNLOCK", "/d", "", "Lock-Token", "<"+token+">"); resp.StatusCode == http.StatusNoContent {
+		t.Fatalf("unlock twice: %s", resp.Status)
+	}
+	if resp = do(srvs[0], "PUT", "/d/a", "data"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put after unlock: %s", resp.Status)
+	}
+
+	ls := newLockSystem(jfs.m).view("alice", false)
+	now := time.Now()
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/d/a", Duration: time.Second, ZeroDepth: true})
+	if err != nil {
+		t.Fatalf("create lock: %s", err)
+	}
+	if _, err = ls.Create(now, webdav.LockDetails{Root: "/d", Duration: time.Second}); err != webdav.ErrLocked {
+		t.Fatalf("create lock on the parent: %v", err)
+	}
+	if locks, err := ls.list(now); err != nil || len(locks) != 1 {
+		t.Fatalf("the conflicting lock should be removed: %+v %v", locks, err)
+	}
+	if _, err = ls.Create(now, webdav.LockDetails{Root: "/d", Duration: time.Second, ZeroDepth: true}); err != nil {
+		t.Fatalf("create zero depth lock on the parent: %v", err)
+	}
+	// the locks can't be used by other users
+	bob := ls.view("bob", false)
+	if _, err = bob.Confirm(now, "/d/a", "", webdav.Condition{Token: token}); err != webdav.ErrConfirmationFailed {
+		t.Fatalf("confirm the lock of others: %v", err)
+	}
+	if _, err = bob.Refresh(now, token, time.Second); err != webdav.ErrNoSuchLock {
+		t.Fatalf("refresh the lock of others: %v", err)
+	}
+	if err = bob.Unlock(now, token); err != webdav.ErrForbidden {
+		t.Fatalf("unlock the lock of others: %v", err)
+	}
+	if release, err := ls.Confirm(now, "/d/a", "", webdav.Condition{Token: token}); err != nil {
+		t.Fatalf("confirm: %s", err)
+	} else {
+		if _, err = ls.Confirm(now, "/d/a", "", webdav.Condition{Token: token}); err != webdav.ErrConfirmationFailed {
+			t.Fatalf("confirm held lock: %v", err)
+		}
+		release()
+	}
+	now = now.Add(time.Second * 2)
+	if locks, err := ls.list(now); err != nil || len(locks) != 0 {
+		t.Fatalf("expired locks: %+v %v", locks, err)
+	}
+	if err = ls.Unlock(now, token); err != webdav.ErrNoSuchLock {
+		t.Fatalf("unlock expired lock: %v", err)
+	}
+	if _, err = ls.list(now.Add(expiredLockGrace)); err != nil {
+		t.Fatalf("list locks: %s", err)
+	}
+	var v []byte
+	if eno := jfs.m.GetXattr(meta.Background, davRoot, lockName(token), &v); eno != meta.ENOATTR {
+		t.Fatalf("expired lock should be removed: %s", eno)
+	}
+	if _, err = ls.Create(now, webdav.LockDetails{Root: "/d/a", Duration: -1}); err != nil {
+		t.Fatalf("create infinite lock: %s", err)
+	}
+	if locks, err := ls.list(now.Add(maxLockDuration + time.Second)); err != nil || len(locks) != 0 {
+		t.Fatalf("infinite locks should expire after %s: %+v %v", maxLockDuration, locks, err)
+	}
+	// the temporary locks left by a crashed server expire soon
+	temp := ls.view("", true)
+	if _, err = temp.Create(now, webdav.LockDetails{Root: "/e", Duration: -1, ZeroDepth: true}); err != nil {
+		t.Fatalf("create temporary lock: %s", err)
+	}
+	if locks, err := ls.list(now.Add(tempLockDuration + time.Second)); err != nil || len(locks) != 1 || locks[0].Root != "/d/a" {
+		t.Fatalf("temporary locks should expire after %s: %+v %v", tempLockDuration, locks, err)
+	}
+}
+
+func TestWebdavDeadProps(t *testing.T) {
+	jfs := createTestFS(t)
+	h, err := newWebdavHandler(jfs, WebdavConfig{})
+	if err != nil {
+		t.Fatalf("webdav handler: %s", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	do := func(method, path, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Depth", "0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+	if resp, _ := do("PUT", "/a", "data"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put: %s", resp.Status)
+	}
+	if resp, _ := do("MKCOL", "/d", ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("mkcol: %s", resp.Status)
+	}
+	set := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:color xml:lang="en">red</Z:color></D:prop></D:set></D:propertyupdate>`
+	remove := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:remove><D:prop><Z:color/></D:prop></D:remove></D:propertyupdate>`
+	find := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:test"><D:prop><Z:color/></D:prop></D:propfind>`
+	for _, p := range []string{"/a", "/d"} {
+		if resp, body := do("PROPPATCH", p, set); resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "200 OK") {
+			t.Fatalf("proppatch %s: %s %s", p, resp.Status, body)
+		}
+		if v, eno := jfs.GetXattr(meta.Background, p, davPropPrefix+"{urn:test}color"); eno != 0 || !strings.Contains(string(v), "red") {
+			t.Fatalf("xattr of %s: %s %s", p, v, eno)
+		}
+		if resp, body := do("PROPFIND", p, find); resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, ">red</") || !strings.Contains(body, `lang="en"`) {
+			t.Fatalf("propfind %s: %s %s", p, resp.Status, body)
+		}
+		if resp, body := do("PROPPATCH", p, remove); resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "200 OK") {
+			t.Fatalf("proppatch %s: %s %s", p, resp.Status, body)
+		}
+		if resp, body := do("PROPFIND", p, find); resp.StatusCode != http.StatusMultiStatus || strings.Contains(body, ">red</") {
+			t.Fatalf("propfind %s after removed: %s %s", p, resp.Status, body)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	jfs := createTestFS(t)
+	ctx := meta.Background
+	if eno := jfs.Mkdir(ctx, "/data", 0755); eno != 0 {
+		t.Fatalf("mkdir: %s", eno)
+	}
+	f, eno := jfs.Create(ctx, "/data/model.bin", 0644)
+	if eno != 0 {
+		t.Fatalf("create: %s", eno)
+	}
+	if _, eno = f.Write(ctx, []byte("0123456789")); eno != 0 {
+		t.Fatalf("write: %s", eno)
+	}
+	_ = f.Close(ctx)
+
+	var accessLog bytes.Buffer
+	h := newFileServer(jfs, ServeConfig{AccessLog: &accessLog, Registerer: prometheus.NewRegistry()})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	do := func(method, path string, header ...string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+
+	resp, body := do("GET", "/data/model.bin")
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" || etag == "" || strings.HasPrefix(etag, "W/") || lastModified == "" {
+		t.Fatalf("get: %s %q %s %s", resp.Status, body, etag, lastModified)
+	}
+	if resp, body = do("GET", "/data/model.bin", "Range", "bytes=2-5"); resp.StatusCode != http.StatusPartialContent || body != "2345" ||
+		resp.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("get range: %s %q %s", resp.Status, body, resp.Header.Get("Content-Range"))
+	}
+	if resp, body = do("GET", "/data/model.bin", "Range", "bytes=-3"); resp.StatusCode != http.StatusPartialContent || body != "789" {
+		t.Fatalf("get suffix range: %s %q", resp.Status, body)
+	}
+	if resp, _ = do("GET", "/data/model.bin", "Range", "bytes=20-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("get invalid range: %s", resp.Status)
+	}
+	if resp, _ = do("GET", "/data/model.bin", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("get if none match: %s", resp.Status)
+	}
+	if resp, _ = do("GET", "/data/model.bin", "If-Modified-Since", lastModified); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("get if modified since: %s", resp.Status)
+	}
+	if resp, body = do("HEAD", "/data/model.bin"); resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != 10 {
+		t.Fatalf("head: %s %q %d", resp.Status, body, resp.ContentLength)
+	}
+	if resp, _ = do("PUT", "/data/model.bin"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("put: %s", resp.Status)
+	}
+	if resp, _ = do("GET", "/data/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get missing file: %s", resp.Status)
+	}
+	if resp, _ = do("GET", "/data/"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("list directory: %s", resp.Status)
+	}
+
+	// the content is changed
+	time.Sleep(time.Millisecond * 10)
+	if f, eno = jfs.Open(ctx, "/data/model.bin", vfs.MODE_MASK_W); eno != 0 {
+		t.Fatalf("open: %s", eno)
+	}
+	if _, eno = f.Pwrite(ctx, []byte("ab"), 0); eno != 0 {
+		t.Fatalf("write: %s", eno)
+	}
+	_ = f.Close(ctx)
+	time.Sleep(time.Millisecond * 200) // wait for the attribute cache
+	if resp, body = do("GET", "/data/model.bin", "Range", "bytes=0-3", "If-Range", etag); resp.StatusCode != http.StatusOK || body != "ab23456789" {
+		t.Fatalf("get if range with old etag: %s %q", resp.Status, body)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Fatalf("etag is not changed: %s", etag)
+	}
+
+	metrics := h.(*logHandler).metrics
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "2xx", "/data")); n != 4 {
+		t.Fatalf("requests of /data: %f", n)
+	}
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "4xx", "-")); n != 3 {
+		t.Fatalf("failed requests: %f", n)
+	}
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "2xx", "/data/model.bin")); n != 0 {
+		t.Fatalf("files should not be labelled: %f", n)
+	}
+	if !strings.Contains(accessLog.String(), `"GET /data/model.bin HTTP/1.1" 206 4 `) {
+		t.Fatalf("access log: %s", accessLog.String())
+	}
+
+	h = newFileServer(jfs, ServeConfig{ListDir: true})
+	srv2 := httptest.NewServer(h)
+	defer srv2.Close()
+	srv = srv2
+	resp, body = do("GET", "/data")
+	var entries []dirEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("list directory: %s %s %s", resp.Status, body, err)
+	}
+	if len(entries) != 1 || entries[0].Name != "model.bin" || entries[0].IsDir || entries[0].Size != 10 || entries[0].Mode != "0644" {
+		t.Fatalf("entries: %+v", entries)
+	}
+	etag = resp.Header.Get("ETag")
+	if resp, _ = do("GET", "/data", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("list directory if none match: %s", resp.Status)
+	}
+	// the size of entry is changed without changing the directory
+	if f, eno = jfs.Open(ctx, "/data/model.bin", vfs.MODE_MASK_W); eno != 0 {
+		t.Fatalf("open: %s", eno)
+	}
+	if _, eno = f.Pwrite(ctx, []byte("ab"), 10); eno != 0 {
+		t.Fatalf("write: %s", eno)
+	}
+	_ = f.Close(ctx)
+	time.Sleep(time.Millisecond * 200) // wait for the attribute cache
+	if resp, body = do("GET", "/data", "If-None-Match", etag); resp.StatusCode != http.StatusOK || !strings.Contains(body, `"size":12`) {
+		t.Fatalf("list changed directory if none match: %s %s", resp.Status, body)
+	}
+}
@@ -43,6 +43,7 @@ type fuseContext struct {
 	header   *fuse.InHeader
 	canceled bool
 	cancel   <-chan struct{}
+	ids      *idMapper
 }
 
 var contextPool = sync.Pool{
@@ -58,23 +59,71 @@ func newContext(cancel <-chan struct{}, header *fuse.InHeader) *fuseContext {
 	ctx.canceled = false
 	ctx.cancel = cancel
 	ctx.header = header
+	ctx.ids = nil
 	return ctx
 }
 
+// idMapper maps the ids of requests to the ones in the volume, and the ids in attributes back.
+type idMapper struct {
+	uids, gids meta.IdMap
+	rootSquash bool
+	allSquash  bool
+	anonUid    uint32
+	anonGid    uint32
+}
+
+// newIdMapper returns nil if the ids are not changed.
+func newIdMapper(conf *vfs.Config) *idMapper {
+	m := &idMapper{
+		rootSquash: conf.RootSquash,
+		allSquash:  conf.AllSquash,
+		anonUid:    conf.AnonUid,
+		anonGid:    conf.AnonGid,
+	}
+	if conf.Meta != nil {
+		m.uids, m.gids = conf.Meta.UidMap, conf.Meta.GidMap
+	}
+	if len(m.uids) == 0 && len(m.gids) == 0 && !m.rootSquash && !m.allSquash {
+		return nil
+	}
+	return m
+}
+
+func (m *idMapper) uid(id uint32) uint32 {
+	if m == nil {
+		return id
+	}
+	if m.allSquash || m.rootSquash && id == 0 {
+		return m.anonUid
+	}
+	return m.uids.Map(id)
+}
+
+// gid maps the group of a request from user uid, the group is squashed with its user only.
+func (m *idMapper) gid(uid, id uint32) uint32 {
+	if m == nil {
+		return id
+	}
+	if m.allSquash || m.rootSquash && uid == 0 {
+		return m.anonGid
+	}
+	return m.gids.Map(id)
+}
+
 func releaseContext(ctx *fuseContext) {
 	contextPool.Put(ctx)
 }
 
 func (c *fuseContext) Uid() uint32 {
-	return c.header.Uid
+	return c.ids.uid(c.header.Uid)
 }
 
 func (c *fuseContext) Gid() uint32 {
-	return c.header.Gid
+	return c.ids.gid(c.header.Uid, c.header.Gid)
 }
 
 func (c *fuseContext) Gids() []uint32 {
-	return []uint32{c.header.Gid}
+	return []uint32{c.Gid()}
 }
 
 func (c *fuseContext) Pid() uint32 {
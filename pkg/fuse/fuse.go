@@ -21,6 +21,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -37,6 +38,7 @@ type fileSystem struct {
 	fuse.RawFileSystem
 	conf *vfs.Config
 	v    *vfs.VFS
+	ids  *idMapper
 }
 
 func newFileSystem(conf *vfs.Config, v *vfs.VFS) *fileSystem {
@@ -44,27 +46,38 @@ func newFileSystem(conf *vfs.Config, v *vfs.VFS) *fileSystem {
 		RawFileSystem: fuse.NewDefaultRawFileSystem(),
 		conf:          conf,
 		v:             v,
+		ids:           newIdMapper(conf),
+	}
+}
+
+func (fs *fileSystem) newContext(cancel <-chan struct{}, header *fuse.InHeader) *fuseContext {
+	ctx := newContext(cancel, header)
+	ctx.ids = fs.ids
+	return ctx
+}
+
+func (fs *fileSystem) attrToStat(inode Ino, attr *Attr, out *fuse.Attr) {
+	attrToStat(inode, attr, out)
+	if fs.ids != nil {
+		out.Uid = fs.ids.uids.Unmap(out.Uid)
+		out.Gid = fs.ids.gids.Unmap(out.Gid)
 	}
 }
 
 func (fs *fileSystem) replyEntry(out *fuse.EntryOut, e *meta.Entry) fuse.Status {
 	out.NodeId = uint64(e.Inode)
 	out.Generation = 1
-	out.SetAttrTimeout(fs.conf.AttrTimeout)
-	if e.Attr.Typ == meta.TypeDirectory {
-		out.SetEntryTimeout(fs.conf.DirEntryTimeout)
-	} else {
-		out.SetEntryTimeout(fs.conf.EntryTimeout)
-	}
+	out.SetAttrTimeout(fs.v.AttrTimeout())
+	out.SetEntryTimeout(fs.v.EntryTimeout(e.Attr.Typ == meta.TypeDirectory))
 	if vfs.IsSpecialNode(e.Inode) {
 		out.SetAttrTimeout(time.Hour)
 	}
-	attrToStat(e.Inode, e.Attr, &out.Attr)
+	fs.attrToStat(e.Inode, e.Attr, &out.Attr)
 	return 0
 }
 
 func (fs *fileSystem) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) (status fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	entry, err := fs.v.Lookup(ctx, Ino(header.NodeId), name)
 	if err != 0 {
@@ -74,7 +87,7 @@ func (fs *fileSystem) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name
 }
 
 func (fs *fileSystem) GetAttr(cancel <-chan struct{}, in *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	var opened uint8
 	if in.Fh() != 0 {
@@ -84,8 +97,8 @@ func (fs *fileSystem) GetAttr(cancel <-chan struct{}, in *fuse.GetAttrIn, out *f
 	if err != 0 {
 		return fuse.Status(err)
 	}
-	attrToStat(entry.Inode, entry.Attr, &out.Attr)
-	out.AttrValid = uint64(fs.conf.AttrTimeout.Seconds())
+	fs.attrToStat(entry.Inode, entry.Attr, &out.Attr)
+	out.AttrValid = uint64(fs.v.AttrTimeout().Seconds())
 	if vfs.IsSpecialNode(Ino(in.NodeId)) {
 		out.AttrValid = 3600
 	}
@@ -93,26 +106,30 @@ func (fs *fileSystem) GetAttr(cancel <-chan struct{}, in *fuse.GetAttrIn, out *f
 }
 
 func (fs *fileSystem) SetAttr(cancel <-chan struct{}, in *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	var opened uint8
 	if in.Fh != 0 {
 		opened = 1
 	}
-	entry, err := fs.v.SetAttr(ctx, Ino(in.NodeId), int(in.Valid), opened, in.Mode, in.Uid, in.Gid, int64(in.Atime), int64(in.Mtime), in.Atimensec, in.Mtimensec, in.Size)
+	uid, gid := in.Uid, in.Gid
+	if fs.ids != nil {
+		uid, gid = fs.ids.uids.Map(uid), fs.ids.gids.Map(gid)
+	}
+	entry, err := fs.v.SetAttr(ctx, Ino(in.NodeId), int(in.Valid), opened, in.Mode, uid, gid, int64(in.Atime), int64(in.Mtime), in.Atimensec, in.Mtimensec, in.Size)
 	if err != 0 {
 		return fuse.Status(err)
 	}
-	out.AttrValid = uint64(fs.conf.AttrTimeout.Seconds())
+	out.AttrValid = uint64(fs.v.AttrTimeout().Seconds())
 	if vfs.IsSpecialNode(entry.Inode) {
 		out.AttrValid = 3600
 	}
-	attrToStat(entry.Inode, entry.Attr, &out.Attr)
+	fs.attrToStat(entry.Inode, entry.Attr, &out.Attr)
 	return 0
 }
 
 func (fs *fileSystem) Mknod(cancel <-chan struct{}, in *fuse.MknodIn, name string, out *fuse.EntryOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entry, err := fs.v.Mknod(ctx, Ino(in.NodeId), name, uint16(in.Mode), getUmask(in), in.Rdev)
 	if err != 0 {
@@ -122,7 +139,7 @@ func (fs *fileSystem) Mknod(cancel <-chan struct{}, in *fuse.MknodIn, name strin
 }
 
 func (fs *fileSystem) Mkdir(cancel <-chan struct{}, in *fuse.MkdirIn, name string, out *fuse.EntryOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entry, err := fs.v.Mkdir(ctx, Ino(in.NodeId), name, uint16(in.Mode), uint16(in.Umask))
 	if err != 0 {
@@ -132,28 +149,28 @@ func (fs *fileSystem) Mkdir(cancel <-chan struct{}, in *fuse.MkdirIn, name strin
 }
 
 func (fs *fileSystem) Unlink(cancel <-chan struct{}, header *fuse.InHeader, name string) (code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	err := fs.v.Unlink(ctx, Ino(header.NodeId), name)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Rmdir(cancel <-chan struct{}, header *fuse.InHeader, name string) (code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	err := fs.v.Rmdir(ctx, Ino(header.NodeId), name)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Rename(cancel <-chan struct{}, in *fuse.RenameIn, oldName string, newName string) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Rename(ctx, Ino(in.NodeId), oldName, Ino(in.Newdir), newName, in.Flags)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Link(cancel <-chan struct{}, in *fuse.LinkIn, name string, out *fuse.EntryOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entry, err := fs.v.Link(ctx, Ino(in.Oldnodeid), Ino(in.NodeId), name)
 	if err != 0 {
@@ -163,7 +180,7 @@ func (fs *fileSystem) Link(cancel <-chan struct{}, in *fuse.LinkIn, name string,
 }
 
 func (fs *fileSystem) Symlink(cancel <-chan struct{}, header *fuse.InHeader, target string, name string, out *fuse.EntryOut) (code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	entry, err := fs.v.Symlink(ctx, target, Ino(header.NodeId), name)
 	if err != 0 {
@@ -173,14 +190,14 @@ func (fs *fileSystem) Symlink(cancel <-chan struct{}, header *fuse.InHeader, tar
 }
 
 func (fs *fileSystem) Readlink(cancel <-chan struct{}, header *fuse.InHeader) (out []byte, code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	path, err := fs.v.Readlink(ctx, Ino(header.NodeId))
 	return path, fuse.Status(err)
 }
 
 func (fs *fileSystem) GetXAttr(cancel <-chan struct{}, header *fuse.InHeader, attr string, dest []byte) (sz uint32, code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	value, err := fs.v.GetXattr(ctx, Ino(header.NodeId), attr, uint32(len(dest)))
 	if err != 0 {
@@ -191,7 +208,7 @@ func (fs *fileSystem) GetXAttr(cancel <-chan struct{}, header *fuse.InHeader, at
 }
 
 func (fs *fileSystem) ListXAttr(cancel <-chan struct{}, header *fuse.InHeader, dest []byte) (uint32, fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	data, err := fs.v.ListXattr(ctx, Ino(header.NodeId), len(dest))
 	if err != 0 {
@@ -202,21 +219,21 @@ func (fs *fileSystem) ListXAttr(cancel <-chan struct{}, header *fuse.InHeader, d
 }
 
 func (fs *fileSystem) SetXAttr(cancel <-chan struct{}, in *fuse.SetXAttrIn, attr string, data []byte) fuse.Status {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.SetXattr(ctx, Ino(in.NodeId), attr, data, in.Flags)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) RemoveXAttr(cancel <-chan struct{}, header *fuse.InHeader, attr string) (code fuse.Status) {
-	ctx := newContext(cancel, header)
+	ctx := fs.newContext(cancel, header)
 	defer releaseContext(ctx)
 	err := fs.v.RemoveXattr(ctx, Ino(header.NodeId), attr)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Create(cancel <-chan struct{}, in *fuse.CreateIn, name string, out *fuse.CreateOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entry, fh, err := fs.v.Create(ctx, Ino(in.NodeId), name, uint16(in.Mode), 0, in.Flags)
 	if err != 0 {
@@ -227,7 +244,7 @@ func (fs *fileSystem) Create(cancel <-chan struct{}, in *fuse.CreateIn, name str
 }
 
 func (fs *fileSystem) Open(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entry, fh, err := fs.v.Open(ctx, Ino(in.NodeId), in.Flags)
 	if err != 0 {
@@ -243,8 +260,20 @@ func (fs *fileSystem) Open(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.Op
 }
 
 func (fs *fileSystem) Read(cancel <-chan struct{}, in *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
-	defer releaseContext(ctx)
+	ctx := fs.newContext(cancel, &in.InHeader)
+	defer releaseContext(ctx)
+	if fs.conf.Splice {
+		// the data in cache will be spliced into kernel, fallback to read if it's not cached
+		fd, off, n, err := fs.v.ReadFd(ctx, Ino(in.NodeId), len(buf), in.Offset, in.Fh)
+		if err == 0 {
+			if n == 0 {
+				return fuse.ReadResultData(nil), 0
+			}
+			return fuse.ReadResultFd(fd, off, n), 0
+		} else if err != syscall.ENOTSUP {
+			return nil, fuse.Status(err)
+		}
+	}
 	n, err := fs.v.Read(ctx, Ino(in.NodeId), buf, in.Offset, in.Fh)
 	if err != 0 {
 		return nil, fuse.Status(err)
@@ -253,13 +282,13 @@ func (fs *fileSystem) Read(cancel <-chan struct{}, in *fuse.ReadIn, buf []byte)
 }
 
 func (fs *fileSystem) Release(cancel <-chan struct{}, in *fuse.ReleaseIn) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	fs.v.Release(ctx, Ino(in.NodeId), in.Fh)
 }
 
 func (fs *fileSystem) Write(cancel <-chan struct{}, in *fuse.WriteIn, data []byte) (written uint32, code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Write(ctx, Ino(in.NodeId), data, in.Offset, in.Fh)
 	if err != 0 {
@@ -269,28 +298,36 @@ func (fs *fileSystem) Write(cancel <-chan struct{}, in *fuse.WriteIn, data []byt
 }
 
 func (fs *fileSystem) Flush(cancel <-chan struct{}, in *fuse.FlushIn) fuse.Status {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Flush(ctx, Ino(in.NodeId), in.Fh, in.LockOwner)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Fsync(cancel <-chan struct{}, in *fuse.FsyncIn) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Fsync(ctx, Ino(in.NodeId), int(in.FsyncFlags), in.Fh)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) Fallocate(cancel <-chan struct{}, in *fuse.FallocateIn) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Fallocate(ctx, Ino(in.NodeId), uint8(in.Mode), int64(in.Offset), int64(in.Length), in.Fh)
 	return fuse.Status(err)
 }
 
+func (fs *fileSystem) Lseek(cancel <-chan struct{}, in *fuse.LseekIn, out *fuse.LseekOut) fuse.Status {
+	ctx := fs.newContext(cancel, &in.InHeader)
+	defer releaseContext(ctx)
+	off, err := fs.v.Lseek(ctx, Ino(in.NodeId), int64(in.Offset), int(in.Whence), in.Fh)
+	out.Offset = uint64(off)
+	return fuse.Status(err)
+}
+
 func (fs *fileSystem) CopyFileRange(cancel <-chan struct{}, in *fuse.CopyFileRangeIn) (written uint32, code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	copied, err := fs.v.CopyFileRange(ctx, Ino(in.NodeId), in.FhIn, in.OffIn, Ino(in.NodeIdOut), in.FhOut, in.OffOut, in.Len, uint32(in.Flags))
 	if err != 0 {
@@ -300,7 +337,7 @@ func (fs *fileSystem) CopyFileRange(cancel <-chan struct{}, in *fuse.CopyFileRan
 }
 
 func (fs *fileSystem) GetLk(cancel <-chan struct{}, in *fuse.LkIn, out *fuse.LkOut) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	l := in.Lk
 	err := fs.v.Getlk(ctx, Ino(in.NodeId), in.Fh, in.Owner, &l.Start, &l.End, &l.Typ, &l.Pid)
@@ -322,7 +359,7 @@ func (fs *fileSystem) setLk(cancel <-chan struct{}, in *fuse.LkIn, block bool) (
 	if in.LkFlags&fuse.FUSE_LK_FLOCK != 0 {
 		return fs.Flock(cancel, in, block)
 	}
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	l := in.Lk
 	err := fs.v.Setlk(ctx, Ino(in.NodeId), in.Fh, in.Owner, l.Start, l.End, l.Typ, l.Pid, block)
@@ -330,14 +367,14 @@ func (fs *fileSystem) setLk(cancel <-chan struct{}, in *fuse.LkIn, block bool) (
 }
 
 func (fs *fileSystem) Flock(cancel <-chan struct{}, in *fuse.LkIn, block bool) (code fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Flock(ctx, Ino(in.NodeId), in.Fh, in.Owner, in.Lk.Typ, block)
 	return fuse.Status(err)
 }
 
 func (fs *fileSystem) OpenDir(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Status) {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	fh, err := fs.v.Opendir(ctx, Ino(in.NodeId))
 	out.Fh = fh
@@ -345,7 +382,7 @@ func (fs *fileSystem) OpenDir(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse
 }
 
 func (fs *fileSystem) ReadDir(cancel <-chan struct{}, in *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entries, err := fs.v.Readdir(ctx, Ino(in.NodeId), in.Size, int(in.Offset), in.Fh, false)
 	var de fuse.DirEntry
@@ -361,7 +398,7 @@ func (fs *fileSystem) ReadDir(cancel <-chan struct{}, in *fuse.ReadIn, out *fuse
 }
 
 func (fs *fileSystem) ReadDirPlus(cancel <-chan struct{}, in *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
-	ctx := newContext(cancel, &in.InHeader)
+	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	entries, err := fs.v.Readdir(ctx, Ino(in.NodeId), in.Size, int(in.Offset), in.Fh, true)
 	var de fuse.DirEntry
@@ -393,7 +430,7 @@ func (fs *fileSystem) ReleaseDir(in *fuse.ReleaseIn) {
 }
 
 func (fs *fileSystem) StatFs(cancel <-chan struct{}, in *fuse.InHeader, out *fuse.StatfsOut) (code fuse.Status) {
-	ctx := newContext(cancel, in)
+	ctx := fs.newContext(cancel, in)
 	defer releaseContext(ctx)
 	st, err := fs.v.StatFS(ctx, Ino(in.NodeId))
 	if err != 0 {
@@ -413,6 +450,8 @@ func (fs *fileSystem) StatFs(cancel <-chan struct{}, in *fuse.InHeader, out *fus
 	return 0
 }
 
+var procsLock sync.Mutex
+
 // Serve starts a server to serve requests from FUSE.
 func Serve(v *vfs.VFS, options string, xattrs bool) error {
 	if err := syscall.Setpriority(syscall.PRIO_PROCESS, os.Getpid(), -19); err != nil {
@@ -455,7 +494,15 @@ func Serve(v *vfs.VFS, options string, xattrs bool) error {
 		opt.Options = append(opt.Options, "volname="+conf.Format.Name)
 		opt.Options = append(opt.Options, "daemon_timeout=60", "iosize=65536", "novncache")
 	}
+	procsLock.Lock() // multiple volumes could be mounted concurrently
+	procs := runtime.GOMAXPROCS(0)
+	if conf.FuseReaders > 0 {
+		// go-fuse starts GOMAXPROCS (2~16) goroutines to read requests from the device
+		runtime.GOMAXPROCS(conf.FuseReaders)
+	}
 	fssrv, err := fuse.NewServer(imp, conf.Meta.MountPoint, &opt)
+	runtime.GOMAXPROCS(procs)
+	procsLock.Unlock()
 	if err != nil {
 		return fmt.Errorf("fuse: %s", err)
 	}
@@ -302,3 +302,34 @@ func TestFUSE(t *testing.T) {
 		})
 	}
 }
+
+func TestIdMapper(t *testing.T) {
+	if m := newIdMapper(&vfs.Config{Meta: &meta.Config{}}); m != nil {
+		t.Fatalf("mapper should be nil without mapping")
+	}
+	var m *idMapper
+	if m.uid(0) != 0 || m.gid(0, 1000) != 1000 {
+		t.Fatalf("nil mapper should not change ids")
+	}
+	conf := &vfs.Config{
+		Meta:       &meta.Config{UidMap: meta.IdMap{1000: 2000}, GidMap: meta.IdMap{100: 200}},
+		RootSquash: true,
+		AnonUid:    65534,
+		AnonGid:    65533,
+	}
+	m = newIdMapper(conf)
+	if m.uid(0) != 65534 || m.gid(0, 0) != 65533 || m.gid(0, 100) != 65533 {
+		t.Fatalf("root should be squashed: %d %d %d", m.uid(0), m.gid(0, 0), m.gid(0, 100))
+	}
+	if m.gid(1000, 0) != 0 {
+		t.Fatalf("group root of other users should not be squashed: %d", m.gid(1000, 0))
+	}
+	if m.uid(1000) != 2000 || m.uid(1001) != 1001 || m.gid(1000, 100) != 200 {
+		t.Fatalf("ids should be mapped: %d %d %d", m.uid(1000), m.uid(1001), m.gid(1000, 100))
+	}
+	conf.AllSquash = true
+	m = newIdMapper(conf)
+	if m.uid(1000) != 65534 || m.gid(1000, 100) != 65533 {
+		t.Fatalf("all users should be squashed: %d %d", m.uid(1000), m.gid(1000, 100))
+	}
+}
@@ -18,8 +18,10 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
@@ -31,7 +33,12 @@ import (
 	"time"
 
 	"github.com/minio/minio-go/pkg/s3utils"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	"github.com/minio/minio/pkg/bucket/versioning"
+	"github.com/minio/minio/pkg/event"
+	"github.com/minio/minio/pkg/mimedb"
 
 	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/fs"
@@ -49,9 +56,14 @@ var mctx meta.Context
 var logger = utils.GetLogger("juicefs")
 
 type Config struct {
-	MultiBucket bool
-	KeepEtag    bool
-	Mode        uint16
+	MultiBucket  bool
+	KeepEtag     bool
+	Versioning   bool
+	Mode         uint16
+	Users        []User
+	RootUser     string
+	Webhook      string
+	UploadExpiry time.Duration
 }
 
 func NewJFSGateway(conf *vfs.Config, m meta.Meta, store chunk.ChunkStore, gConf *Config) (minio.ObjectLayer, error) {
@@ -60,7 +72,20 @@ func NewJFSGateway(conf *vfs.Config, m meta.Meta, store chunk.ChunkStore, gConf
 		return nil, fmt.Errorf("Initialize failed: %s", err)
 	}
 	mctx = meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
-	return &jfsObjects{fs: jfs, conf: conf, listPool: minio.NewTreeWalkPool(time.Minute * 30), gConf: gConf}, nil
+	n := &jfsObjects{fs: jfs, conf: conf, listPool: minio.NewTreeWalkPool(time.Minute * 30), gConf: gConf, users: newUsers(gConf.Users), done: make(chan struct{})}
+	if len(gConf.Users) > 0 {
+		go func() {
+			if iam := n.waitIAMSys(); iam != nil {
+				registerUsers(iam, n, gConf.Users)
+			}
+		}()
+	}
+	if gConf.Webhook != "" {
+		n.events = newNotifier(n, gConf.Webhook)
+	}
+	go n.runLifecycle()
+	routedGateway.Store(n)
+	return n, nil
 }
 
 type jfsObjects struct {
@@ -69,6 +94,9 @@ type jfsObjects struct {
 	fs       *fs.FileSystem
 	listPool *minio.TreeWalkPool
 	gConf    *Config
+	users    map[string]*userInfo
+	events   *notifier
+	done     chan struct{}
 }
 
 func (n *jfsObjects) IsCompressionSupported() bool {
@@ -85,6 +113,11 @@ func (n *jfsObjects) IsReady(_ context.Context) bool {
 }
 
 func (n *jfsObjects) Shutdown(ctx context.Context) error {
+	routedGateway.Store((*jfsObjects)(nil))
+	close(n.done)
+	if n.events != nil {
+		n.events.close()
+	}
 	return n.fs.Close()
 }
 
@@ -136,6 +169,8 @@ func jfsToObjectErr(ctx context.Context, err error, params ...string) error {
 			return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
 		}
 		return minio.BucketAlreadyOwnedByYou{Bucket: bucket}
+	case err == syscall.EACCES || err == syscall.EPERM:
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
 	case fs.IsNotEmpty(err):
 		if object != "" {
 			return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
@@ -178,10 +213,13 @@ func (n *jfsObjects) DeleteBucket(ctx context.Context, bucket string, forceDelet
 	if !n.isValidBucketName(bucket) {
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
+	if err := n.checkUser(ctx, bucket, ""); err != nil {
+		return err
+	}
 	if !n.gConf.MultiBucket {
 		return minio.BucketNotEmpty{Bucket: bucket}
 	}
-	eno := n.fs.Delete(mctx, n.path(bucket))
+	eno := n.fs.Delete(n.uctx(ctx), n.path(bucket))
 	return jfsToObjectErr(ctx, eno, bucket)
 }
 
@@ -189,10 +227,13 @@ func (n *jfsObjects) MakeBucketWithLocation(ctx context.Context, bucket string,
 	if !n.isValidBucketName(bucket) {
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
+	if err := n.checkUser(ctx, bucket, ""); err != nil {
+		return err
+	}
 	if !n.gConf.MultiBucket {
 		return nil
 	}
-	eno := n.fs.Mkdir(mctx, n.path(bucket), 0755)
+	eno := n.fs.Mkdir(n.uctx(ctx), n.path(bucket), 0755)
 	return jfsToObjectErr(ctx, eno, bucket)
 }
 
@@ -200,7 +241,10 @@ func (n *jfsObjects) GetBucketInfo(ctx context.Context, bucket string) (bi minio
 	if !n.isValidBucketName(bucket) {
 		return bi, minio.BucketNameInvalid{Bucket: bucket}
 	}
-	fi, eno := n.fs.Stat(mctx, n.path(bucket))
+	if err = n.checkUser(ctx, bucket, ""); err != nil {
+		return
+	}
+	fi, eno := n.fs.Stat(n.uctx(ctx), n.path(bucket))
 	if eno == 0 {
 		bi = minio.BucketInfo{
 			Name:    bucket,
@@ -219,8 +263,11 @@ func isReservedOrInvalidBucket(bucketEntry string, strict bool) bool {
 }
 
 func (n *jfsObjects) ListBuckets(ctx context.Context) (buckets []minio.BucketInfo, err error) {
+	if err = n.checkUser(ctx, "", ""); err != nil {
+		return
+	}
 	if !n.gConf.MultiBucket {
-		fi, eno := n.fs.Stat(mctx, "/")
+		fi, eno := n.fs.Stat(n.uctx(ctx), "/")
 		if eno != 0 {
 			return nil, jfsToObjectErr(ctx, eno)
 		}
@@ -230,12 +277,12 @@ func (n *jfsObjects) ListBuckets(ctx context.Context) (buckets []minio.BucketInf
 		}}
 		return buckets, nil
 	}
-	f, eno := n.fs.Open(mctx, sep, 0)
+	f, eno := n.fs.Open(n.uctx(ctx), sep, 0)
 	if eno != 0 {
 		return nil, jfsToObjectErr(ctx, eno)
 	}
 	defer f.Close(mctx)
-	entries, eno := f.Readdir(mctx, 10000)
+	entries, eno := f.Readdir(n.uctx(ctx), 10000)
 	if eno != 0 {
 		return nil, jfsToObjectErr(ctx, eno)
 	}
@@ -261,35 +308,38 @@ func (n *jfsObjects) ListBuckets(ctx context.Context) (buckets []minio.BucketInf
 }
 
 func (n *jfsObjects) isObjectDir(ctx context.Context, bucket, object string) bool {
-	f, eno := n.fs.Open(mctx, n.path(bucket, object), 0)
+	uctx := n.uctx(ctx)
+	f, eno := n.fs.Open(uctx, n.path(bucket, object), 0)
 	if eno != 0 {
 		return false
 	}
-	defer f.Close(mctx)
+	defer f.Close(uctx)
 
-	fis, err := f.Readdir(mctx, 0)
+	fis, err := f.Readdir(uctx, 0)
 	if err != 0 {
 		return false
 	}
 	return len(fis) == 0
 }
 
-func (n *jfsObjects) isLeafDir(bucket, leafPath string) bool {
-	return n.isObjectDir(context.Background(), bucket, leafPath)
+func (n *jfsObjects) isLeafDirFactory(ctx context.Context) minio.IsLeafDirFunc {
+	return func(bucket, leafPath string) bool {
+		return n.isObjectDir(ctx, bucket, leafPath)
+	}
 }
 
 func (n *jfsObjects) isLeaf(bucket, leafPath string) bool {
 	return !strings.HasSuffix(leafPath, "/")
 }
 
-func (n *jfsObjects) listDirFactory() minio.ListDirFunc {
+func (n *jfsObjects) listDirFactory(uctx meta.Context) minio.ListDirFunc {
 	return func(bucket, prefixDir, prefixEntry string) (emptyDir bool, entries []string, delayIsLeaf bool) {
-		f, eno := n.fs.Open(mctx, n.path(bucket, prefixDir), 0)
+		f, eno := n.fs.Open(uctx, n.path(bucket, prefixDir), 0)
 		if eno != 0 {
 			return fs.IsNotExist(eno), nil, false
 		}
-		defer f.Close(mctx)
-		fis, eno := f.Readdir(mctx, 0)
+		defer f.Close(uctx)
+		fis, eno := f.Readdir(uctx, 0)
 		if eno != 0 {
 			return
 		}
@@ -316,7 +366,10 @@ func (n *jfsObjects) checkBucket(ctx context.Context, bucket string) error {
 	if !n.isValidBucketName(bucket) {
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
-	if _, eno := n.fs.Stat(mctx, n.path(bucket)); eno != 0 {
+	if err := n.checkUser(ctx, bucket, ""); err != nil {
+		return err
+	}
+	if _, eno := n.fs.Stat(n.uctx(ctx), n.path(bucket)); eno != 0 {
 		return jfsToObjectErr(ctx, eno, bucket)
 	}
 	return nil
@@ -328,16 +381,9 @@ func (n *jfsObjects) ListObjects(ctx context.Context, bucket, prefix, marker, de
 		return loi, err
 	}
 	getObjectInfo := func(ctx context.Context, bucket, object string) (obj minio.ObjectInfo, err error) {
-		fi, eno := n.fs.Stat(mctx, n.path(bucket, object))
+		fi, eno := n.fs.Stat(n.uctx(ctx), n.path(bucket, object))
 		if eno == 0 {
-			obj = minio.ObjectInfo{
-				Bucket:  bucket,
-				Name:    object,
-				ModTime: fi.ModTime(),
-				Size:    fi.Size(),
-				IsDir:   fi.IsDir(),
-				AccTime: fi.ModTime(),
-			}
+			obj = n.objectInfo(bucket, object, fi)
 		}
 		return obj, jfsToObjectErr(ctx, eno, bucket, object)
 	}
@@ -345,7 +391,7 @@ func (n *jfsObjects) ListObjects(ctx context.Context, bucket, prefix, marker, de
 	if maxKeys == 0 {
 		maxKeys = -1 // list as many objects as possible
 	}
-	return minio.ListObjects(ctx, n, bucket, prefix, marker, delimiter, maxKeys, n.listPool, n.listDirFactory(), n.isLeaf, n.isLeafDir, getObjectInfo, getObjectInfo)
+	return minio.ListObjects(ctx, n, bucket, prefix, marker, delimiter, maxKeys, n.listPoolOf(ctx), n.listDirFactory(n.uctx(ctx)), n.isLeaf, n.isLeafDirFactory(ctx), getObjectInfo, getObjectInfo)
 }
 
 // ListObjectsV2 lists all blobs in JFS bucket filtered by prefix
@@ -376,32 +422,42 @@ func (n *jfsObjects) DeleteObject(ctx context.Context, bucket, object string, op
 	if err = n.checkBucket(ctx, bucket); err != nil {
 		return
 	}
-	info.Bucket = bucket
-	info.Name = object
-	p := n.path(bucket, object)
-	root := n.path(bucket)
-	for p != root {
-		if eno := n.fs.Delete(mctx, p); eno != 0 {
-			if fs.IsNotEmpty(eno) {
-				err = nil
-			} else {
-				err = eno
+	defer func() {
+		if err == nil {
+			name := event.ObjectRemovedDelete
+			if info.DeleteMarker {
+				name = event.ObjectRemovedDeleteMarkerCreated
 			}
-			break
+			n.notify(ctx, name, bucket, minio.ObjectInfo{Name: object, VersionID: info.VersionID})
 		}
-		p = path.Dir(p)
+	}()
+	if options.VersionID != "" {
+		return n.deleteVersion(ctx, bucket, object, options.VersionID)
+	}
+	if state := n.versioningOf(bucket); state != "" && !strings.HasSuffix(object, sep) {
+		return n.deleteLatest(ctx, bucket, object, state == versioning.Suspended)
 	}
-	return info, jfsToObjectErr(ctx, err, bucket, object)
+	info.Bucket = bucket
+	info.Name = object
+	eno := n.deleteUp(n.uctx(ctx), n.path(bucket, object), n.path(bucket))
+	return info, jfsToObjectErr(ctx, eno, bucket, object)
 }
 
 func (n *jfsObjects) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, options minio.ObjectOptions) (objs []minio.DeletedObject, errs []error) {
 	objs = make([]minio.DeletedObject, len(objects))
 	errs = make([]error, len(objects))
 	for idx, object := range objects {
-		_, errs[idx] = n.DeleteObject(ctx, bucket, object.ObjectName, options)
+		options.VersionID = object.VersionID
+		var info minio.ObjectInfo
+		info, errs[idx] = n.DeleteObject(ctx, bucket, object.ObjectName, options)
 		if errs[idx] == nil {
 			objs[idx] = minio.DeletedObject{
 				ObjectName: object.ObjectName,
+				VersionID:  object.VersionID,
+			}
+			if info.DeleteMarker {
+				objs[idx].DeleteMarker = true
+				objs[idx].DeleteMarkerVersionID = info.VersionID
 			}
 		}
 	}
@@ -410,10 +466,15 @@ func (n *jfsObjects) DeleteObjects(ctx context.Context, bucket string, objects [
 
 type fReader struct {
 	*fs.File
+	ctx meta.Context
 }
 
 func (f *fReader) Read(b []byte) (int, error) {
-	return f.File.Read(mctx, b)
+	return f.File.Read(f.ctx, b)
+}
+
+func (f *fReader) ReadAt(b []byte, off int64) (int, error) {
+	return f.File.Pread(f.ctx, b, off)
 }
 
 func (n *jfsObjects) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (gr *minio.GetObjectReader, err error) {
@@ -427,13 +488,17 @@ func (n *jfsObjects) GetObjectNInfo(ctx context.Context, bucket, object string,
 	if err != nil {
 		return
 	}
-	f, eno := n.fs.Open(mctx, n.path(bucket, object), 0)
+	p, _, _, err := n.resolveVersion(ctx, bucket, object, opts.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	f, eno := n.fs.Open(n.uctx(ctx), p, vfs.MODE_MASK_R)
 	if eno != 0 {
 		return nil, jfsToObjectErr(ctx, eno, bucket, object)
 	}
-	_, _ = f.Seek(mctx, startOffset, 0)
-	r := &io.LimitedReader{R: &fReader{f}, N: length}
-	closer := func() { _ = f.Close(mctx) }
+	// the range is read by offset (Pread) rather than seeking the file
+	r := io.NewSectionReader(&fReader{f, n.uctx(ctx)}, startOffset, length)
+	closer := func() { _ = f.Close(n.uctx(ctx)) }
 	return minio.NewGetObjectReaderFromReader(r, objInfo, opts, closer)
 }
 
@@ -445,32 +510,46 @@ func (n *jfsObjects) CopyObject(ctx context.Context, srcBucket, srcObject, dstBu
 		return
 	}
 	dst := n.path(dstBucket, dstObject)
-	src := n.path(srcBucket, srcObject)
+	src, _, _, err := n.resolveVersion(ctx, srcBucket, srcObject, srcOpts.VersionID)
+	if err != nil {
+		return
+	}
 	if minio.IsStringEqual(src, dst) {
-		return n.GetObjectInfo(ctx, srcBucket, srcObject, minio.ObjectOptions{})
+		// only the metadata is replaced
+		n.setObjectMeta(n.uctx(ctx), dst, srcInfo.UserDefined)
+		if info, err = n.GetObjectInfo(ctx, srcBucket, srcObject, minio.ObjectOptions{}); err == nil {
+			n.notify(ctx, event.ObjectCreatedCopy, dstBucket, info)
+		}
+		return
 	}
 	tmp := n.tpath(dstBucket, "tmp", minio.MustGetUUID())
-	_ = n.mkdirAll(ctx, path.Dir(tmp), 0755)
-	_, eno := n.fs.Create(mctx, tmp, 0644)
+	n.sharedDir(path.Dir(tmp))
+	_, eno := n.fs.Create(n.uctx(ctx), tmp, 0644)
 	if eno != 0 {
 		logger.Errorf("create %s: %s", tmp, eno)
 		return
 	}
-	defer func() { _ = n.fs.Delete(mctx, tmp) }()
+	defer func() { _ = n.fs.Delete(n.uctx(ctx), tmp) }()
 
-	_, eno = n.fs.CopyFileRange(mctx, src, 0, tmp, 0, 1<<63)
+	_, eno = n.fs.CopyFileRange(n.uctx(ctx), src, 0, tmp, 0, 1<<63)
 	if eno != 0 {
 		err = jfsToObjectErr(ctx, eno, srcBucket, srcObject)
 		logger.Errorf("copy %s to %s: %s", src, tmp, err)
 		return
 	}
-	eno = n.fs.Rename(mctx, tmp, dst, 0)
+	n.setObjectMeta(n.uctx(ctx), tmp, srcInfo.UserDefined)
+	vid, err := n.newVersion(ctx, dstBucket, dstObject, tmp)
+	if err != nil {
+		err = jfsToObjectErr(ctx, err, dstBucket, dstObject)
+		return
+	}
+	eno = n.fs.Rename(n.uctx(ctx), tmp, dst, 0)
 	if eno != 0 {
 		err = jfsToObjectErr(ctx, eno, srcBucket, srcObject)
 		logger.Errorf("rename %s to %s: %s", tmp, dst, err)
 		return
 	}
-	fi, eno := n.fs.Stat(mctx, dst)
+	fi, eno := n.fs.Stat(n.uctx(ctx), dst)
 	if eno != 0 {
 		err = jfsToObjectErr(ctx, eno, dstBucket, dstObject)
 		return
@@ -487,15 +566,11 @@ func (n *jfsObjects) CopyObject(ctx context.Context, srcBucket, srcObject, dstBu
 		}
 	}
 
-	return minio.ObjectInfo{
-		Bucket:  dstBucket,
-		Name:    dstObject,
-		ETag:    string(etag),
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.ModTime(),
-	}, nil
+	info = n.objectInfo(dstBucket, dstObject, fi)
+	info.ETag = string(etag)
+	info.VersionID = vid
+	n.notify(ctx, event.ObjectCreatedCopy, dstBucket, info)
+	return info, nil
 }
 
 var buffPool = sync.Pool{
@@ -509,7 +584,11 @@ func (n *jfsObjects) GetObject(ctx context.Context, bucket, object string, start
 	if err = n.checkBucket(ctx, bucket); err != nil {
 		return
 	}
-	f, eno := n.fs.Open(mctx, n.path(bucket, object), vfs.MODE_MASK_R)
+	p, _, _, err := n.resolveVersion(ctx, bucket, object, opts.VersionID)
+	if err != nil {
+		return err
+	}
+	f, eno := n.fs.Open(n.uctx(ctx), p, vfs.MODE_MASK_R)
 	if eno != 0 {
 		return jfsToObjectErr(ctx, eno, bucket, object)
 	}
@@ -541,43 +620,110 @@ func (n *jfsObjects) GetObjectInfo(ctx context.Context, bucket, object string, o
 	if err = n.checkBucket(ctx, bucket); err != nil {
 		return
 	}
-	fi, eno := n.fs.Stat(mctx, n.path(bucket, object))
-	if eno != 0 {
-		err = jfsToObjectErr(ctx, eno, bucket, object)
+	p, fi, latest, err := n.resolveVersion(ctx, bucket, object, opts.VersionID)
+	if err != nil {
 		return
 	}
 	if strings.HasSuffix(object, sep) && !fi.IsDir() {
 		err = jfsToObjectErr(ctx, syscall.ENOENT, bucket, object)
 		return
 	}
-	var etag []byte
+	objInfo = n.fileInfo(p, bucket, object, fi)
+	objInfo.IsLatest = latest
+	if objInfo.DeleteMarker {
+		err = minio.MethodNotAllowed{Bucket: bucket, Object: object}
+	}
+	return
+}
+
+// objectInfo returns the info of the current version of an object.
+func (n *jfsObjects) objectInfo(bucket, object string, fi *fs.FileStat) minio.ObjectInfo {
+	return n.fileInfo(n.path(bucket, object), bucket, object, fi)
+}
+
+// fileInfo returns the info of an object stored in p, with the etag (if kept), version id,
+// metadata and tags in xattrs.
+func (n *jfsObjects) fileInfo(p, bucket, object string, fi *fs.FileStat) minio.ObjectInfo {
+	info := minio.ObjectInfo{
+		Bucket:       bucket,
+		Name:         object,
+		ModTime:      fi.ModTime(),
+		Size:         fi.Size(),
+		IsDir:        fi.IsDir(),
+		AccTime:      fi.ModTime(),
+		VersionID:    n.versionOf(p),
+		DeleteMarker: n.isDeleteMarker(p),
+	}
 	if n.gConf.KeepEtag {
-		etag, _ = n.fs.GetXattr(mctx, n.path(bucket, object), s3Etag)
+		etag, _ := n.fs.GetXattr(mctx, p, s3Etag)
+		info.ETag = string(etag)
+	}
+	if data, eno := n.fs.GetXattr(mctx, p, s3Meta); eno == 0 {
+		if err := json.Unmarshal(data, &info.UserDefined); err != nil {
+			logger.Warnf("invalid metadata of %s: %s", p, err)
+		}
+		info.ContentType = info.UserDefined["content-type"]
+		info.ContentEncoding = info.UserDefined["content-encoding"]
+	}
+	if info.ContentType == "" && !fi.IsDir() {
+		info.ContentType = mimedb.TypeByExtension(path.Ext(object))
+	}
+	if tagging, eno := n.fs.GetXattr(mctx, p, s3Tags); eno == 0 {
+		info.UserTags = string(tagging)
+	}
+	return info
+}
+
+// setObjectMeta saves the metadata (Content-Type, X-Amz-Meta-* ...) and tags of an object as
+// xattrs, the old ones are removed if they are not in userDefined.
+func (n *jfsObjects) setObjectMeta(jctx meta.Context, p string, userDefined map[string]string) {
+	metadata := make(map[string]string)
+	var tagging string
+	for k, v := range userDefined {
+		switch {
+		case k == xhttp.AmzObjectTagging:
+			tagging = v
+		case k == xhttp.AmzTagDirective || k == "etag" || k == "md5Sum" ||
+			strings.HasPrefix(strings.ToLower(k), minio.ReservedMetadataPrefixLower):
+		default:
+			metadata[k] = v
+		}
 	}
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.ModTime(),
-		ETag:    string(etag),
-	}, nil
+	n.setXattr(jctx, p, s3Meta, metadata, len(metadata) > 0)
+	n.setXattr(jctx, p, s3Tags, tagging, tagging != "")
 }
 
-func (n *jfsObjects) mkdirAll(ctx context.Context, p string, mode os.FileMode) error {
-	if fi, eno := n.fs.Stat(mctx, p); eno == 0 {
+// setXattr sets the xattr to value (in JSON if it's not a string) if ok, or removes it.
+func (n *jfsObjects) setXattr(jctx meta.Context, p, name string, value interface{}, ok bool) {
+	var eno syscall.Errno
+	if !ok {
+		if eno = n.fs.RemoveXattr(jctx, p, name); eno == meta.ENOATTR {
+			eno = 0
+		}
+	} else if v, isString := value.(string); isString {
+		eno = n.fs.SetXattr(jctx, p, name, []byte(v), 0)
+	} else {
+		data, _ := json.Marshal(value)
+		eno = n.fs.SetXattr(jctx, p, name, data, 0)
+	}
+	if eno != 0 {
+		logger.Warnf("set xattr %s of %s: %s", name, p, eno)
+	}
+}
+
+func (n *jfsObjects) mkdirAll(jctx meta.Context, p string, mode os.FileMode) error {
+	if fi, eno := n.fs.Stat(jctx, p); eno == 0 {
 		if !fi.IsDir() {
 			return fmt.Errorf("%s is not directory", p)
 		}
 		return nil
 	}
-	eno := n.fs.Mkdir(mctx, p, uint16(mode))
+	eno := n.fs.Mkdir(jctx, p, uint16(mode))
 	if eno != 0 && fs.IsNotExist(eno) {
-		if err := n.mkdirAll(ctx, path.Dir(p), 0755); err != nil {
+		if err := n.mkdirAll(jctx, path.Dir(p), 0755); err != nil {
 			return err
 		}
-		eno = n.fs.Mkdir(mctx, p, uint16(mode))
+		eno = n.fs.Mkdir(jctx, p, uint16(mode))
 	}
 	if eno != 0 && fs.IsExist(eno) {
 		eno = 0
@@ -588,16 +734,55 @@ func (n *jfsObjects) mkdirAll(ctx context.Context, p string, mode os.FileMode) e
 	return eno
 }
 
-func (n *jfsObjects) putObject(ctx context.Context, bucket, object string, r *minio.PutObjReader, opts minio.ObjectOptions) (err error) {
+// writeFile replaces the content of a file of the gateway atomically, by writing a temporary
+// file (name starts with ".") and renaming it.
+func (n *jfsObjects) writeFile(p string, data []byte) error {
+	dir := path.Dir(p)
+	if err := n.mkdirAll(mctx, dir, 0755); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, "."+path.Base(p)+"."+minio.MustGetUUID())
+	f, eno := n.fs.Create(mctx, tmp, 0600)
+	if eno != 0 {
+		return eno
+	}
+	if _, eno = f.Write(mctx, data); eno != 0 {
+		_ = f.Close(mctx)
+		_ = n.fs.Delete(mctx, tmp)
+		return eno
+	}
+	if eno = f.Close(mctx); eno != 0 {
+		_ = n.fs.Delete(mctx, tmp)
+		return eno
+	}
+	if eno = n.fs.Rename(mctx, tmp, p, 0); eno != 0 {
+		_ = n.fs.Delete(mctx, tmp)
+		return eno
+	}
+	return nil
+}
+
+func (n *jfsObjects) readFile(p string) ([]byte, error) {
+	f, eno := n.fs.Open(mctx, p, 0)
+	if eno != 0 {
+		return nil, eno
+	}
+	defer f.Close(mctx)
+	return ioutil.ReadAll(&fReader{f, mctx})
+}
+
+// putObject writes r into the file p, key is the name of the object (empty for parts) to keep
+// the previous version of.
+func (n *jfsObjects) putObject(ctx context.Context, bucket, key, p string, r *minio.PutObjReader, opts minio.ObjectOptions) (vid string, err error) {
 	tmpname := n.tpath(bucket, "tmp", minio.MustGetUUID())
-	_ = n.mkdirAll(ctx, path.Dir(tmpname), 0755)
-	f, eno := n.fs.Create(mctx, tmpname, n.gConf.Mode)
+	n.sharedDir(path.Dir(tmpname))
+	f, eno := n.fs.Create(n.uctx(ctx), tmpname, n.gConf.Mode)
 	if eno != 0 {
 		logger.Errorf("create %s: %s", tmpname, eno)
 		err = eno
 		return
 	}
-	defer func() { _ = n.fs.Delete(mctx, tmpname) }()
+	defer func() { _ = n.fs.Delete(n.uctx(ctx), tmpname) }()
 	var buf = buffPool.Get().(*[]byte)
 	defer buffPool.Put(buf)
 	for {
@@ -626,12 +811,18 @@ func (n *jfsObjects) putObject(ctx context.Context, bucket, object string, r *mi
 	if err != nil {
 		return
 	}
-	dir := path.Dir(object)
+	if key != "" {
+		if vid, err = n.newVersion(ctx, bucket, key, tmpname); err != nil {
+			err = jfsToObjectErr(ctx, err, bucket, key)
+			return
+		}
+	}
+	dir := path.Dir(p)
 	if dir != "" {
-		_ = n.mkdirAll(ctx, dir, os.FileMode(0755))
+		_ = n.mkdirAll(n.uctx(ctx), dir, os.FileMode(0755))
 	}
-	if eno := n.fs.Rename(mctx, tmpname, object, 0); eno != 0 {
-		err = jfsToObjectErr(ctx, eno, bucket, object)
+	if eno := n.fs.Rename(n.uctx(ctx), tmpname, p, 0); eno != 0 {
+		err = jfsToObjectErr(ctx, eno, bucket, key)
 		return
 	}
 	return
@@ -642,9 +833,10 @@ func (n *jfsObjects) PutObject(ctx context.Context, bucket string, object string
 		return
 	}
 
+	var vid string
 	p := n.path(bucket, object)
 	if strings.HasSuffix(object, sep) {
-		if err = n.mkdirAll(ctx, p, os.FileMode(0755)); err != nil {
+		if err = n.mkdirAll(n.uctx(ctx), p, os.FileMode(0755)); err != nil {
 			err = jfsToObjectErr(ctx, err, bucket, object)
 			return
 		}
@@ -656,10 +848,10 @@ func (n *jfsObjects) PutObject(ctx context.Context, bucket string, object string
 			}
 			return
 		}
-	} else if err = n.putObject(ctx, bucket, p, r, opts); err != nil {
+	} else if vid, err = n.putObject(ctx, bucket, object, p, r, opts); err != nil {
 		return
 	}
-	fi, eno := n.fs.Stat(mctx, p)
+	fi, eno := n.fs.Stat(n.uctx(ctx), p)
 	if eno != 0 {
 		return objInfo, jfsToObjectErr(ctx, eno, bucket, object)
 	}
@@ -670,15 +862,14 @@ func (n *jfsObjects) PutObject(ctx context.Context, bucket string, object string
 			logger.Errorf("set xattr error, path: %s,xattr: %s,value: %s,flags: %d", p, s3Etag, etag, 0)
 		}
 	}
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ETag:    etag,
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.ModTime(),
-	}, nil
+	if !fi.IsDir() {
+		n.setObjectMeta(n.uctx(ctx), p, opts.UserDefined)
+	}
+	objInfo = n.objectInfo(bucket, object, fi)
+	objInfo.ETag = etag
+	objInfo.VersionID = vid
+	n.notify(ctx, event.ObjectCreatedPut, bucket, objInfo)
+	return objInfo, nil
 }
 
 func (n *jfsObjects) NewMultipartUpload(ctx context.Context, bucket string, object string, opts minio.ObjectOptions) (uploadID string, err error) {
@@ -687,29 +878,35 @@ func (n *jfsObjects) NewMultipartUpload(ctx context.Context, bucket string, obje
 	}
 	uploadID = minio.MustGetUUID()
 	p := n.upath(bucket, uploadID)
-	err = n.mkdirAll(ctx, p, os.FileMode(0755))
+	// the uploads are owned by the users
+	n.sharedDir(path.Dir(p))
+	err = n.mkdirAll(n.uctx(ctx), p, os.FileMode(0755))
 	if err == nil {
-		eno := n.fs.SetXattr(mctx, p, uploadKeyName, []byte(object), 0)
+		eno := n.fs.SetXattr(n.uctx(ctx), p, uploadKeyName, []byte(object), 0)
 		if eno != 0 {
 			logger.Warnf("set object %s on upload %s: %s", object, uploadID, eno)
 		}
+		n.setObjectMeta(n.uctx(ctx), p, opts.UserDefined)
 	}
 	return
 }
 
 const uploadKeyName = "s3-object"
 const s3Etag = "s3-etag"
+const s3Meta = "s3-meta"
+const s3Tags = "s3-tags"
 
 func (n *jfsObjects) ListMultipartUploads(ctx context.Context, bucket string, prefix string, keyMarker string, uploadIDMarker string, delimiter string, maxUploads int) (lmi minio.ListMultipartsInfo, err error) {
 	if err = n.checkBucket(ctx, bucket); err != nil {
 		return
 	}
-	f, eno := n.fs.Open(mctx, n.tpath(bucket, "uploads"), 0)
+	uctx := n.uctx(ctx)
+	f, eno := n.fs.Open(uctx, n.tpath(bucket, "uploads"), 0)
 	if eno != 0 {
 		return // no found
 	}
-	defer f.Close(mctx)
-	entries, eno := f.ReaddirPlus(mctx, 0)
+	defer f.Close(uctx)
+	entries, eno := f.ReaddirPlus(uctx, 0)
 	if eno != 0 {
 		err = jfsToObjectErr(ctx, eno, bucket)
 		return
@@ -721,7 +918,7 @@ func (n *jfsObjects) ListMultipartUploads(ctx context.Context, bucket string, pr
 	for _, e := range entries {
 		uploadID := string(e.Name)
 		if uploadID > uploadIDMarker {
-			object_, _ := n.fs.GetXattr(mctx, n.upath(bucket, uploadID), uploadKeyName)
+			object_, _ := n.fs.GetXattr(uctx, n.upath(bucket, uploadID), uploadKeyName)
 			object := string(object_)
 			if strings.HasPrefix(object, prefix) && object > keyMarker {
 				lmi.Uploads = append(lmi.Uploads, minio.MultipartInfo{
@@ -745,7 +942,7 @@ func (n *jfsObjects) checkUploadIDExists(ctx context.Context, bucket, object, up
 	if err = n.checkBucket(ctx, bucket); err != nil {
 		return
 	}
-	_, eno := n.fs.Stat(mctx, n.upath(bucket, uploadID))
+	_, eno := n.fs.Stat(n.uctx(ctx), n.upath(bucket, uploadID))
 	return jfsToObjectErr(ctx, eno, bucket, object, uploadID)
 }
 
@@ -753,13 +950,14 @@ func (n *jfsObjects) ListObjectParts(ctx context.Context, bucket, object, upload
 	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
 		return result, err
 	}
-	f, e := n.fs.Open(mctx, n.upath(bucket, uploadID), 0)
+	uctx := n.uctx(ctx)
+	f, e := n.fs.Open(uctx, n.upath(bucket, uploadID), 0)
 	if e != 0 {
 		err = jfsToObjectErr(ctx, e, bucket, object, uploadID)
 		return
 	}
-	defer func() { _ = f.Close(mctx) }()
-	entries, e := f.ReaddirPlus(mctx, 0)
+	defer func() { _ = f.Close(uctx) }()
+	entries, e := f.ReaddirPlus(uctx, 0)
 	if e != 0 {
 		err = jfsToObjectErr(ctx, e, bucket, object, uploadID)
 		return
@@ -772,7 +970,7 @@ func (n *jfsObjects) ListObjectParts(ctx context.Context, bucket, object, upload
 	for _, entry := range entries {
 		num, er := strconv.Atoi(string(entry.Name))
 		if er == nil && num > partNumberMarker {
-			etag, _ := n.fs.GetXattr(mctx, n.ppath(bucket, uploadID, string(entry.Name)), s3Etag)
+			etag, _ := n.fs.GetXattr(uctx, n.ppath(bucket, uploadID, string(entry.Name)), s3Etag)
 			result.Parts = append(result.Parts, minio.PartInfo{
 				PartNumber:   num,
 				Size:         int64(entry.Attr.Length),
@@ -810,7 +1008,7 @@ func (n *jfsObjects) PutObjectPart(ctx context.Context, bucket, object, uploadID
 		return
 	}
 	p := n.ppath(bucket, uploadID, strconv.Itoa(partID))
-	if err = n.putObject(ctx, bucket, p, r, opts); err != nil {
+	if _, err = n.putObject(ctx, bucket, "", p, r, opts); err != nil {
 		err = jfsToObjectErr(ctx, err, bucket, object)
 		return
 	}
@@ -841,52 +1039,66 @@ func (n *jfsObjects) CompleteMultipartUpload(ctx context.Context, bucket, object
 	}
 
 	tmp := n.ppath(bucket, uploadID, "complete")
-	_ = n.fs.Delete(mctx, tmp)
-	_, eno := n.fs.Create(mctx, tmp, n.gConf.Mode)
+	_ = n.fs.Delete(n.uctx(ctx), tmp)
+	_, eno := n.fs.Create(n.uctx(ctx), tmp, n.gConf.Mode)
 	if eno != 0 {
 		err = jfsToObjectErr(ctx, eno, bucket, object, uploadID)
 		logger.Errorf("create complete: %s", err)
 		return
 	}
-	var total uint64
-	for _, part := range parts {
-		p := n.ppath(bucket, uploadID, strconv.Itoa(part.PartNumber))
-		copied, eno := n.fs.CopyFileRange(mctx, p, 0, tmp, total, 1<<30)
-		if eno != 0 {
-			err = jfsToObjectErr(ctx, eno, bucket, object, uploadID)
-			logger.Errorf("merge parts: %s", err)
-			return
+	// the parts of any size are merged into a temporary file by referencing their slices,
+	// so the cost depends on the number of parts and chunks, not the size of data
+	srcs := make([]string, len(parts))
+	for i, part := range parts {
+		srcs[i] = n.ppath(bucket, uploadID, strconv.Itoa(part.PartNumber))
+	}
+	if _, eno = n.fs.Concat(n.uctx(ctx), tmp, srcs...); eno != 0 {
+		err = jfsToObjectErr(ctx, eno, bucket, object, uploadID)
+		logger.Errorf("merge parts: %s", err)
+		return
+	}
+	// the metadata and tags given when the upload is created
+	for _, name := range []string{s3Meta, s3Tags} {
+		if v, eno := n.fs.GetXattr(mctx, n.upath(bucket, uploadID), name); eno == 0 {
+			if eno = n.fs.SetXattr(mctx, tmp, name, v, 0); eno != 0 {
+				logger.Warnf("set xattr %s of %s: %s", name, tmp, eno)
+			}
 		}
-		total += copied
 	}
 
 	name := n.path(bucket, object)
 	dir := path.Dir(name)
 	if dir != "" {
-		if err = n.mkdirAll(ctx, dir, os.FileMode(0755)); err != nil {
-			_ = n.fs.Delete(mctx, tmp)
+		if err = n.mkdirAll(n.uctx(ctx), dir, os.FileMode(0755)); err != nil {
+			_ = n.fs.Delete(n.uctx(ctx), tmp)
 			err = jfsToObjectErr(ctx, err, bucket, object, uploadID)
 			return
 		}
 	}
 
-	eno = n.fs.Rename(mctx, tmp, name, 0)
+	vid, err := n.newVersion(ctx, bucket, object, tmp)
+	if err != nil {
+		_ = n.fs.Delete(n.uctx(ctx), tmp)
+		err = jfsToObjectErr(ctx, err, bucket, object, uploadID)
+		return
+	}
+	eno = n.fs.Rename(n.uctx(ctx), tmp, name, 0)
 	if eno != 0 {
-		_ = n.fs.Delete(mctx, tmp)
+		_ = n.fs.Delete(n.uctx(ctx), tmp)
 		err = jfsToObjectErr(ctx, eno, bucket, object, uploadID)
 		logger.Errorf("Rename %s -> %s: %s", tmp, name, err)
 		return
 	}
 
-	fi, eno := n.fs.Stat(mctx, name)
+	fi, eno := n.fs.Stat(n.uctx(ctx), name)
 	if eno != 0 {
-		_ = n.fs.Delete(mctx, name)
+		_ = n.fs.Delete(n.uctx(ctx), name)
 		err = jfsToObjectErr(ctx, eno, bucket, object, uploadID)
 		return
 	}
 
 	// remove parts
-	_ = n.fs.Rmr(mctx, n.upath(bucket, uploadID))
+	_ = n.fs.Rmr(n.uctx(ctx), n.upath(bucket, uploadID))
 
 	// Calculate s3 compatible md5sum for complete multipart.
 	s3MD5 := minio.ComputeCompleteMultipartMD5(parts)
@@ -896,21 +1108,57 @@ func (n *jfsObjects) CompleteMultipartUpload(ctx context.Context, bucket, object
 			logger.Warnf("set xattr error, path: %s,xattr: %s,value: %s,flags: %d", name, s3Etag, s3MD5, 0)
 		}
 	}
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ETag:    s3MD5,
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.ModTime(),
-	}, nil
+	objInfo = n.objectInfo(bucket, object, fi)
+	objInfo.ETag = s3MD5
+	objInfo.VersionID = vid
+	n.notify(ctx, event.ObjectCreatedCompleteMultipartUpload, bucket, objInfo)
+	return objInfo, nil
 }
 
 func (n *jfsObjects) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, option minio.ObjectOptions) (err error) {
 	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
 		return
 	}
-	eno := n.fs.Rmr(mctx, n.upath(bucket, uploadID))
+	eno := n.fs.Rmr(n.uctx(ctx), n.upath(bucket, uploadID))
 	return jfsToObjectErr(ctx, eno, bucket, object, uploadID)
 }
+
+func (n *jfsObjects) PutObjectTags(ctx context.Context, bucket, object string, tagging string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := n.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return info, err
+	}
+	p, _, _, err := n.resolveVersion(ctx, bucket, object, opts.VersionID)
+	if err != nil {
+		return info, err
+	}
+	if eno := n.fs.SetXattr(n.uctx(ctx), p, s3Tags, []byte(tagging), 0); eno != 0 {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	info.UserTags = tagging
+	return info, nil
+}
+
+func (n *jfsObjects) GetObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (*tags.Tags, error) {
+	info, err := n.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tags.ParseObjectTags(info.UserTags)
+}
+
+func (n *jfsObjects) DeleteObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := n.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return info, err
+	}
+	p, _, _, err := n.resolveVersion(ctx, bucket, object, opts.VersionID)
+	if err != nil {
+		return info, err
+	}
+	if eno := n.fs.RemoveXattr(n.uctx(ctx), p, s3Tags); eno != 0 && eno != meta.ENOATTR {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	info.UserTags = ""
+	return info, nil
+}
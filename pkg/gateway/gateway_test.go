@@ -0,0 +1,943 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	miniogo "github.com/minio/minio-go/v7"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	xlogger "github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/bucket/lifecycle"
+	"github.com/minio/minio/pkg/bucket/policy"
+	"github.com/minio/minio/pkg/bucket/versioning"
+	"github.com/minio/minio/pkg/event"
+	"github.com/minio/minio/pkg/hash"
+	iampolicy "github.com/minio/minio/pkg/iam/policy"
+	"github.com/minio/minio/pkg/s3select"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+func newTestGateway(t *testing.T, gConf *Config) minio.ObjectLayer {
+	m := meta.NewClient("memkv://", &meta.Config{})
+	format := meta.Format{
+		Name:      "test",
+		BlockSize: 4096,
+		Capacity:  4 << 30,
+	}
+	_ = m.Init(format, true)
+	conf := vfs.Config{
+		Meta:   &meta.Config{},
+		Format: &format,
+		Chunk: &chunk.Config{
+			BlockSize:  format.BlockSize << 10,
+			MaxUpload:  1,
+			MaxDeletes: 1,
+			BufferSize: 100 << 20,
+		},
+		DirEntryTimeout: time.Millisecond * 100,
+		EntryTimeout:    time.Millisecond * 100,
+		AttrTimeout:     time.Millisecond * 100,
+	}
+	objStore, _ := object.CreateStorage("mem", "", "", "")
+	store := chunk.NewCachedStore(objStore, *conf.Chunk, nil)
+	gw, err := NewJFSGateway(&conf, m, store, gConf)
+	if err != nil {
+		t.Fatalf("new gateway: %s", err)
+	}
+	if err = gw.MakeBucketWithLocation(context.Background(), "bucket", minio.BucketOptions{}); err != nil {
+		t.Fatalf("make bucket: %s", err)
+	}
+	return gw
+}
+
+func putReader(t *testing.T, data []byte) *minio.PutObjReader {
+	r, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), false)
+	if err != nil {
+		t.Fatalf("hash reader: %s", err)
+	}
+	return minio.NewPutObjReader(r)
+}
+
+func TestObjectMeta(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, KeepEtag: true, Mode: 0644})
+	ctx := context.Background()
+	opts := minio.ObjectOptions{UserDefined: map[string]string{
+		"content-type":                     "text/plain",
+		"X-Amz-Meta-Owner":                 "alice",
+		xhttp.AmzObjectTagging:             "k1=v1",
+		minio.ReservedMetadataPrefix + "x": "internal",
+	}}
+	if _, err := gw.PutObject(ctx, "bucket", "dir/a.bin", putReader(t, []byte("hello")), opts); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	info, err := gw.GetObjectInfo(ctx, "bucket", "dir/a.bin", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatalf("get object info: %s", err)
+	}
+	if info.ContentType != "text/plain" || info.UserDefined["X-Amz-Meta-Owner"] != "alice" || info.UserTags != "k1=v1" || info.ETag == "" {
+		t.Fatalf("unexpected object info: %+v", info)
+	}
+	if _, ok := info.UserDefined[minio.ReservedMetadataPrefix+"x"]; ok {
+		t.Fatalf("internal metadata should not be kept: %+v", info.UserDefined)
+	}
+	loi, err := gw.ListObjects(ctx, "bucket", "dir/", "", "", 10)
+	if err != nil || len(loi.Objects) != 1 || loi.Objects[0].UserTags != "k1=v1" || loi.Objects[0].ContentType != "text/plain" {
+		t.Fatalf("list objects: %+v %v", loi.Objects, err)
+	}
+
+	// tagging
+	if _, err = gw.PutObjectTags(ctx, "bucket", "dir/a.bin", "k2=v2", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put tags: %s", err)
+	}
+	tags, err := gw.GetObjectTags(ctx, "bucket", "dir/a.bin", minio.ObjectOptions{})
+	if err != nil || tags.String() != "k2=v2" {
+		t.Fatalf("get tags: %v %v", tags, err)
+	}
+	if _, err = gw.DeleteObjectTags(ctx, "bucket", "dir/a.bin", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("delete tags: %s", err)
+	}
+	if info, _ = gw.GetObjectInfo(ctx, "bucket", "dir/a.bin", minio.ObjectOptions{}); info.UserTags != "" {
+		t.Fatalf("tags should be deleted: %s", info.UserTags)
+	}
+	if _, err = gw.PutObjectTags(ctx, "bucket", "missing", "k=v", minio.ObjectOptions{}); err == nil {
+		t.Fatalf("put tags of missing object should fail")
+	}
+
+	// copy keeps the metadata in srcInfo
+	src, _ := gw.GetObjectInfo(ctx, "bucket", "dir/a.bin", minio.ObjectOptions{})
+	src.UserDefined[xhttp.AmzObjectTagging] = "k3=v3"
+	if _, err = gw.CopyObject(ctx, "bucket", "dir/a.bin", "bucket", "b.bin", src, minio.ObjectOptions{}, minio.ObjectOptions{}); err != nil {
+		t.Fatalf("copy object: %s", err)
+	}
+	info, _ = gw.GetObjectInfo(ctx, "bucket", "b.bin", minio.ObjectOptions{})
+	if info.ContentType != "text/plain" || info.UserDefined["X-Amz-Meta-Owner"] != "alice" || info.UserTags != "k3=v3" {
+		t.Fatalf("unexpected info of copied object: %+v", info)
+	}
+	// replace the metadata
+	src.UserDefined = map[string]string{"content-type": "image/png"}
+	if _, err = gw.CopyObject(ctx, "bucket", "b.bin", "bucket", "b.bin", src, minio.ObjectOptions{}, minio.ObjectOptions{}); err != nil {
+		t.Fatalf("copy object to itself: %s", err)
+	}
+	info, _ = gw.GetObjectInfo(ctx, "bucket", "b.bin", minio.ObjectOptions{})
+	if info.ContentType != "image/png" || info.UserDefined["X-Amz-Meta-Owner"] != "" || info.UserTags != "" {
+		t.Fatalf("unexpected info of replaced object: %+v", info)
+	}
+
+	// the type is guessed by the extension without Content-Type
+	if _, err = gw.PutObject(ctx, "bucket", "c.json", putReader(t, []byte("{}")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	if info, _ = gw.GetObjectInfo(ctx, "bucket", "c.json", minio.ObjectOptions{}); info.ContentType != "application/json" {
+		t.Fatalf("unexpected content type: %s", info.ContentType)
+	}
+}
+
+func TestMultipartMeta(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, KeepEtag: true, Mode: 0644})
+	ctx := context.Background()
+	opts := minio.ObjectOptions{UserDefined: map[string]string{
+		"content-type":         "video/mp4",
+		"X-Amz-Meta-Owner":     "bob",
+		xhttp.AmzObjectTagging: "k=v",
+	}}
+	uploadID, err := gw.NewMultipartUpload(ctx, "bucket", "movie", opts)
+	if err != nil {
+		t.Fatalf("new multipart upload: %s", err)
+	}
+	var parts []minio.CompletePart
+	for i := 1; i <= 2; i++ {
+		pi, err := gw.PutObjectPart(ctx, "bucket", "movie", uploadID, i, putReader(t, []byte(fmt.Sprintf("part%d", i))), minio.ObjectOptions{})
+		if err != nil {
+			t.Fatalf("put part %d: %s", i, err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: i, ETag: pi.ETag})
+	}
+	info, err := gw.CompleteMultipartUpload(ctx, "bucket", "movie", uploadID, parts, minio.ObjectOptions{})
+	if err != nil {
+		t.Fatalf("complete multipart upload: %s", err)
+	}
+	if info.Size != 10 || info.ContentType != "video/mp4" || info.UserDefined["X-Amz-Meta-Owner"] != "bob" || info.UserTags != "k=v" {
+		t.Fatalf("unexpected object info: %+v", info)
+	}
+	if data := readObject(t, gw, "movie", ""); data != "part1part2" {
+		t.Fatalf("unexpected content: %s", data)
+	}
+}
+
+func TestMultipartLargePart(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644}).(*jfsObjects)
+	ctx := context.Background()
+	uploadID, err := gw.NewMultipartUpload(ctx, "bucket", "large", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatalf("new multipart upload: %s", err)
+	}
+	var parts []minio.CompletePart
+	for i, data := range []string{"head", "tail"} {
+		pi, err := gw.PutObjectPart(ctx, "bucket", "large", uploadID, i+1, putReader(t, []byte(data)), minio.ObjectOptions{})
+		if err != nil {
+			t.Fatalf("put part %d: %s", i+1, err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: i + 1, ETag: pi.ETag})
+	}
+	// make the first part larger than 1 GiB with a hole, which should not be truncated
+	if eno := gw.fs.Truncate(mctx, gw.ppath("bucket", uploadID, "1"), 1<<30+4); eno != 0 {
+		t.Fatalf("truncate part: %s", eno)
+	}
+	info, err := gw.CompleteMultipartUpload(ctx, "bucket", "large", uploadID, parts, minio.ObjectOptions{})
+	if err != nil || info.Size != 1<<30+8 {
+		t.Fatalf("complete multipart upload: %+v %v", info, err)
+	}
+	var buf bytes.Buffer
+	if err = gw.GetObject(ctx, "bucket", "large", 1<<30, 8, &buf, "", minio.ObjectOptions{}); err != nil || buf.String() != "\x00\x00\x00\x00tail" {
+		t.Fatalf("read the end of object: %q %v", buf.String(), err)
+	}
+}
+
+func readObject(t *testing.T, gw minio.ObjectLayer, object, versionID string) string {
+	r, err := gw.GetObjectNInfo(context.Background(), "bucket", object, nil, http.Header{}, 0, minio.ObjectOptions{VersionID: versionID})
+	if err != nil {
+		t.Fatalf("get object %s (%s): %s", object, versionID, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read object %s (%s): %s", object, versionID, err)
+	}
+	return string(data)
+}
+
+func TestVersioning(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Versioning: true, Mode: 0644})
+	ctx := context.Background()
+	var vids []string
+	for _, data := range []string{"v1", "v2", "v3"} {
+		info, err := gw.PutObject(ctx, "bucket", "dir/a", putReader(t, []byte(data)), minio.ObjectOptions{})
+		if err != nil || info.VersionID == "" {
+			t.Fatalf("put object: %+v %v", info, err)
+		}
+		vids = append(vids, info.VersionID)
+		time.Sleep(time.Millisecond)
+	}
+	if data := readObject(t, gw, "dir/a", ""); data != "v3" {
+		t.Fatalf("latest version: %s", data)
+	}
+	if data := readObject(t, gw, "dir/a", vids[0]); data != "v1" {
+		t.Fatalf("version %s: %s", vids[0], data)
+	}
+	info, err := gw.GetObjectInfo(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: vids[1]})
+	if err != nil || info.Size != 2 || info.VersionID != vids[1] || info.IsLatest {
+		t.Fatalf("get object info of %s: %+v %v", vids[1], info, err)
+	}
+	if _, err = gw.GetObjectInfo(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: minio.MustGetUUID()}); err == nil {
+		t.Fatalf("get unknown version should fail")
+	}
+
+	// delete marker
+	del, err := gw.DeleteObject(ctx, "bucket", "dir/a", minio.ObjectOptions{})
+	if err != nil || !del.DeleteMarker || del.VersionID == "" {
+		t.Fatalf("delete object: %+v %v", del, err)
+	}
+	if _, err = gw.GetObjectInfo(ctx, "bucket", "dir/a", minio.ObjectOptions{}); !errors.As(err, &minio.ObjectNotFound{}) {
+		t.Fatalf("deleted object should not be found: %v", err)
+	}
+	if _, err = gw.GetObjectInfo(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: del.VersionID}); err == nil {
+		t.Fatalf("get delete marker should fail")
+	}
+	if data := readObject(t, gw, "dir/a", vids[2]); data != "v3" {
+		t.Fatalf("version %s: %s", vids[2], data)
+	}
+	loi, err := gw.ListObjects(ctx, "bucket", "", "", "", 10)
+	if err != nil || len(loi.Objects) != 0 || len(loi.Prefixes) != 0 {
+		t.Fatalf("list objects: %+v %v", loi, err)
+	}
+
+	lv, err := gw.ListObjectVersions(ctx, "bucket", "", "", "", "", 10)
+	if err != nil || len(lv.Objects) != 4 || lv.IsTruncated {
+		t.Fatalf("list object versions: %+v %v", lv, err)
+	}
+	expected := []string{del.VersionID, vids[2], vids[1], vids[0]}
+	for i, o := range lv.Objects {
+		if o.Name != "dir/a" || o.VersionID != expected[i] || o.IsLatest != (i == 0) || o.DeleteMarker != (i == 0) {
+			t.Fatalf("version %d: %+v", i, o)
+		}
+	}
+	lv, err = gw.ListObjectVersions(ctx, "bucket", "", "", "", "/", 10)
+	if err != nil || len(lv.Objects) != 0 || len(lv.Prefixes) != 1 || lv.Prefixes[0] != "dir/" {
+		t.Fatalf("list object versions with delimiter: %+v %v", lv, err)
+	}
+	lv, err = gw.ListObjectVersions(ctx, "bucket", "dir/", "", "", "", 2)
+	if err != nil || len(lv.Objects) != 2 || !lv.IsTruncated || lv.NextMarker != "dir/a" || lv.NextVersionIDMarker != vids[2] {
+		t.Fatalf("list object versions with max keys: %+v %v", lv, err)
+	}
+	lv, err = gw.ListObjectVersions(ctx, "bucket", "dir/", lv.NextMarker, lv.NextVersionIDMarker, "", 10)
+	if err != nil || len(lv.Objects) != 2 || lv.Objects[0].VersionID != vids[1] || lv.IsTruncated {
+		t.Fatalf("list object versions from marker: %+v %v", lv, err)
+	}
+
+	// removing the delete marker brings back the latest version
+	if _, err = gw.DeleteObject(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: del.VersionID}); err != nil {
+		t.Fatalf("delete marker: %s", err)
+	}
+	if data := readObject(t, gw, "dir/a", ""); data != "v3" {
+		t.Fatalf("latest version after removing the marker: %s", data)
+	}
+	// removing the latest version makes the previous one current
+	if _, err = gw.DeleteObject(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: vids[2]}); err != nil {
+		t.Fatalf("delete version: %s", err)
+	}
+	if data := readObject(t, gw, "dir/a", ""); data != "v2" {
+		t.Fatalf("latest version after removing %s: %s", vids[2], data)
+	}
+	if _, err = gw.DeleteObject(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: vids[0]}); err != nil {
+		t.Fatalf("delete version: %s", err)
+	}
+	if lv, _ = gw.ListObjectVersions(ctx, "bucket", "", "", "", "", 10); len(lv.Objects) != 1 || lv.Objects[0].VersionID != vids[1] {
+		t.Fatalf("versions left: %+v", lv.Objects)
+	}
+
+	// copy from a version
+	if _, err = gw.PutObject(ctx, "bucket", "dir/a", putReader(t, []byte("v4")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	src, _ := gw.GetObjectInfo(ctx, "bucket", "dir/a", minio.ObjectOptions{VersionID: vids[1]})
+	info, err = gw.CopyObject(ctx, "bucket", "dir/a", "bucket", "b", src, minio.ObjectOptions{VersionID: vids[1]}, minio.ObjectOptions{})
+	if err != nil || info.VersionID == "" {
+		t.Fatalf("copy object: %+v %v", info, err)
+	}
+	if data := readObject(t, gw, "b", ""); data != "v2" {
+		t.Fatalf("copied object: %s", data)
+	}
+
+	// the keys are listed in order across the directories, page by page
+	for _, key := range []string{"x/z/w", "x/y", "x-1"} {
+		if _, err = gw.PutObject(ctx, "bucket", key, putReader(t, []byte(key)), minio.ObjectOptions{}); err != nil {
+			t.Fatalf("put object %s: %s", key, err)
+		}
+	}
+	var names []string
+	for marker, vmarker := "", ""; ; {
+		lv, err = gw.ListObjectVersions(ctx, "bucket", "x", marker, vmarker, "", 1)
+		if err != nil || len(lv.Objects) != 1 {
+			t.Fatalf("list object versions from %s: %+v %v", marker, lv, err)
+		}
+		names = append(names, lv.Objects[0].Name)
+		if !lv.IsTruncated {
+			break
+		}
+		marker, vmarker = lv.NextMarker, lv.NextVersionIDMarker
+	}
+	if strings.Join(names, ",") != "x-1,x/y,x/z/w" {
+		t.Fatalf("listed keys: %s", names)
+	}
+	lv, err = gw.ListObjectVersions(ctx, "bucket", "x", "", "", "/", 10)
+	if err != nil || len(lv.Objects) != 1 || lv.Objects[0].Name != "x-1" || len(lv.Prefixes) != 1 || lv.Prefixes[0] != "x/" {
+		t.Fatalf("list object versions with delimiter: %+v %v", lv, err)
+	}
+
+	// the null version of objects written before versioning is enabled
+	plain := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644}).(*jfsObjects)
+	if _, err = plain.PutObject(ctx, "bucket", "c", putReader(t, []byte("old")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	plain.gConf.Versioning = true
+	if _, err = plain.PutObject(ctx, "bucket", "c", putReader(t, []byte("new")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	if data := readObject(t, plain, "c", nullVersionID); data != "old" {
+		t.Fatalf("null version: %s", data)
+	}
+	objs, errs := plain.DeleteObjects(ctx, "bucket", []minio.ObjectToDelete{{ObjectName: "c", VersionID: nullVersionID}, {ObjectName: "c"}}, minio.ObjectOptions{})
+	if errs[0] != nil || errs[1] != nil || objs[0].VersionID != nullVersionID || !objs[1].DeleteMarker || objs[1].DeleteMarkerVersionID == "" {
+		t.Fatalf("delete objects: %+v %v", objs, errs)
+	}
+}
+
+func TestBucketVersioning(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644}).(*jfsObjects)
+	ctx := context.Background()
+	put := func(data string) string {
+		info, err := gw.PutObject(ctx, "bucket", "a", putReader(t, []byte(data)), minio.ObjectOptions{})
+		if err != nil {
+			t.Fatalf("put object: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+		return info.VersionID
+	}
+	setVersioning := func(state versioning.State) {
+		if err := gw.SetBucketVersioning(ctx, "bucket", &versioning.Versioning{Status: state}); err != nil {
+			t.Fatalf("set versioning %s: %s", state, err)
+		}
+		if v, err := gw.GetBucketVersioning(ctx, "bucket"); err != nil || v.Status != state {
+			t.Fatalf("get versioning: %+v %v", v, err)
+		}
+	}
+	if v, err := gw.GetBucketVersioning(ctx, "bucket"); err != nil || v.Status != "" {
+		t.Fatalf("versioning of new bucket: %+v %v", v, err)
+	}
+	if vid := put("null1"); vid != "" {
+		t.Fatalf("version of unversioned object: %s", vid)
+	}
+
+	setVersioning(versioning.Enabled)
+	v1 := put("v1")
+	if v1 == "" || readObject(t, gw, "a", nullVersionID) != "null1" {
+		t.Fatalf("the null version should be kept: %s", v1)
+	}
+
+	// the null version is replaced when versioning is suspended
+	setVersioning(versioning.Suspended)
+	if vid := put("null2"); vid != "" {
+		t.Fatalf("version of object when suspended: %s", vid)
+	}
+	if readObject(t, gw, "a", nullVersionID) != "null2" || readObject(t, gw, "a", v1) != "v1" {
+		t.Fatalf("versions after put when suspended")
+	}
+	info, err := gw.DeleteObject(ctx, "bucket", "a", minio.ObjectOptions{})
+	if err != nil || !info.DeleteMarker || info.VersionID != nullVersionID {
+		t.Fatalf("delete object when suspended: %+v %v", info, err)
+	}
+	if versions := gw.objectVersions(mctx, "bucket", "a"); len(versions) != 2 || !versions[0].DeleteMarker || versions[1].VersionID != v1 {
+		t.Fatalf("versions after delete when suspended: %+v", versions)
+	}
+	if _, err = gw.DeleteObject(ctx, "bucket", "a", minio.ObjectOptions{VersionID: nullVersionID}); err != nil {
+		t.Fatalf("delete the null delete marker: %s", err)
+	}
+	if data := readObject(t, gw, "a", ""); data != "v1" {
+		t.Fatalf("current version after deleting the delete marker: %s", data)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	users := []User{
+		{AccessKey: "alice", SecretKey: "alice-secret", Uid: 1001, Gids: []uint32{1001}, Policies: []Policy{{Bucket: "*"}}},
+		{AccessKey: "bob", SecretKey: "bob-secret", Uid: 1002, Policies: []Policy{{Bucket: "alice", Prefix: "shared/", ReadOnly: true}}},
+	}
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0640, Users: users, RootUser: "admin"}).(*jfsObjects)
+	asUser := func(ak string) context.Context {
+		return xlogger.SetReqInfo(context.Background(), &xlogger.ReqInfo{AccessKey: ak})
+	}
+	alice, bob := asUser("alice"), asUser("bob")
+
+	// the bucket created by root is not writable by others
+	if _, err := gw.PutObject(alice, "bucket", "a", putReader(t, []byte("a")), minio.ObjectOptions{}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+		t.Fatalf("put object into the bucket of root: %v", err)
+	}
+	if err := gw.MakeBucketWithLocation(alice, "alice", minio.BucketOptions{}); err != nil {
+		t.Fatalf("make bucket: %s", err)
+	}
+	if _, err := gw.PutObject(alice, "alice", "shared/a", putReader(t, []byte("a")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	fi, eno := gw.fs.Stat(mctx, gw.path("alice", "shared/a"))
+	if eno != 0 || fi.Uid() != 1001 || fi.Gid() != 1001 {
+		t.Fatalf("owner of the object: %+v %s", fi, eno)
+	}
+	uploadID, err := gw.NewMultipartUpload(alice, "alice", "big", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatalf("new multipart upload: %s", err)
+	}
+	part, err := gw.PutObjectPart(alice, "alice", "big", uploadID, 1, putReader(t, []byte("part")), minio.ObjectOptions{})
+	if err != nil {
+		t.Fatalf("put part: %s", err)
+	}
+	if _, err = gw.CompleteMultipartUpload(alice, "alice", "big", uploadID, []minio.CompletePart{{PartNumber: 1, ETag: part.ETag}}, minio.ObjectOptions{}); err != nil {
+		t.Fatalf("complete multipart upload: %s", err)
+	}
+
+	// bob can't read (mode 0640) or write the objects of alice
+	if _, err = gw.GetObjectInfo(bob, "alice", "shared/a", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("get object info: %s", err)
+	}
+	if _, err = gw.GetObjectNInfo(bob, "alice", "shared/a", nil, http.Header{}, 0, minio.ObjectOptions{}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+		t.Fatalf("read object of alice: %v", err)
+	}
+	if _, err = gw.DeleteObject(bob, "alice", "shared/a", minio.ObjectOptions{}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+		t.Fatalf("delete object of alice: %v", err)
+	}
+	if _, err = gw.PutObject(bob, "alice", "b", putReader(t, []byte("b")), minio.ObjectOptions{}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+		t.Fatalf("put object into the bucket of alice: %v", err)
+	}
+
+	// bob can't delete the noncurrent versions of alice
+	if err = gw.SetBucketVersioning(alice, "alice", &versioning.Versioning{Status: versioning.Enabled}); err != nil {
+		t.Fatalf("enable versioning: %s", err)
+	}
+	var vids []string
+	for _, data := range []string{"v1", "v2"} {
+		info, err := gw.PutObject(alice, "alice", "shared/v", putReader(t, []byte(data)), minio.ObjectOptions{})
+		if err != nil {
+			t.Fatalf("put object: %s", err)
+		}
+		vids = append(vids, info.VersionID)
+	}
+	if _, err = gw.DeleteObject(bob, "alice", "shared/v", minio.ObjectOptions{VersionID: vids[0]}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+		t.Fatalf("delete noncurrent version of alice: %v", err)
+	}
+	if _, err = gw.DeleteObject(alice, "alice", "shared/v", minio.ObjectOptions{VersionID: vids[0]}); err != nil {
+		t.Fatalf("delete noncurrent version: %s", err)
+	}
+
+	// the access keys which are not users are denied
+	for _, ak := range []string{"carol", ""} {
+		uctx := xlogger.SetReqInfo(context.Background(), &xlogger.ReqInfo{API: "GetObject", AccessKey: ak})
+		if _, err = gw.ListBuckets(uctx); !errors.As(err, &minio.PrefixAccessDenied{}) {
+			t.Fatalf("list buckets as %q: %v", ak, err)
+		}
+		if _, err = gw.GetObjectInfo(uctx, "alice", "shared/a", minio.ObjectOptions{}); !errors.As(err, &minio.PrefixAccessDenied{}) {
+			t.Fatalf("get object info as %q: %v", ak, err)
+		}
+	}
+	if _, err = gw.GetObjectInfo(asUser("admin"), "alice", "shared/a", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("get object info as root: %s", err)
+	}
+
+	// policies
+	args := func(ak string, action iampolicy.Action, bucket, object string) iampolicy.Args {
+		return iampolicy.Args{AccountName: ak, Action: action, BucketName: bucket, ObjectName: object, ConditionValues: map[string][]string{}}
+	}
+	for _, c := range []struct {
+		user    int
+		args    iampolicy.Args
+		allowed bool
+	}{
+		{0, args("alice", iampolicy.PutObjectAction, "any", "x"), true},
+		{1, args("bob", iampolicy.GetObjectAction, "alice", "shared/a"), true},
+		{1, args("bob", iampolicy.GetObjectAction, "alice", "private"), false},
+		{1, args("bob", iampolicy.PutObjectAction, "alice", "shared/b"), false},
+		{1, args("bob", iampolicy.GetObjectAction, "bucket", "shared/a"), false},
+	} {
+		policy, err := users[c.user].policy()
+		if err != nil {
+			t.Fatalf("policy of %s: %s", users[c.user].AccessKey, err)
+		}
+		if policy.IsAllowed(c.args) != c.allowed {
+			t.Fatalf("%s %s %s/%s should be allowed: %v", c.args.AccountName, c.args.Action, c.args.BucketName, c.args.ObjectName, c.allowed)
+		}
+	}
+
+	// register the users into MinIO
+	iam := minio.NewIAMSys()
+	registerUsers(iam, gw, users)
+	if cred, ok := iam.GetUser("bob"); !ok || cred.SecretKey != "bob-secret" {
+		t.Fatalf("get user bob: %+v %v", cred, ok)
+	}
+	if _, ok := iam.GetUser("carol"); ok {
+		t.Fatalf("carol should not exist")
+	}
+	if !iam.IsAllowed(args("bob", iampolicy.GetObjectAction, "alice", "shared/a")) || iam.IsAllowed(args("bob", iampolicy.PutObjectAction, "alice", "shared/a")) {
+		t.Fatalf("unexpected permissions of bob")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var lock sync.Mutex
+	var received []event.Log
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !failed { // the first delivery fails and should be retried
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var log event.Log
+		if err := json.NewDecoder(r.Body).Decode(&log); err != nil {
+			t.Errorf("decode event: %s", err)
+		}
+		received = append(received, log)
+	}))
+	defer srv.Close()
+	old := retryInterval
+	retryInterval = time.Millisecond * 10
+	defer func() { retryInterval = old }()
+
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644, Webhook: srv.URL})
+	defer gw.Shutdown(context.Background())
+	ctx := context.Background()
+	if _, err := gw.PutObject(ctx, "bucket", "dir/a b", putReader(t, []byte("hello")), minio.ObjectOptions{}); err != nil {
+		t.Fatalf("put object: %s", err)
+	}
+	src, _ := gw.GetObjectInfo(ctx, "bucket", "dir/a b", minio.ObjectOptions{})
+	if _, err := gw.CopyObject(ctx, "bucket", "dir/a b", "bucket", "c", src, minio.ObjectOptions{}, minio.ObjectOptions{}); err != nil {
+		t.Fatalf("copy object: %s", err)
+	}
+	if _, err := gw.DeleteObject(ctx, "bucket", "dir/a b", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("delete object: %s", err)
+	}
+	if _, err := gw.DeleteObject(ctx, "bucket", "missing", minio.ObjectOptions{}); err == nil {
+		t.Fatalf("delete missing object should fail")
+	}
+
+	expected := []struct {
+		name event.Name
+		key  string
+		size int64
+	}{
+		{event.ObjectCreatedPut, "dir%2Fa+b", 5},
+		{event.ObjectCreatedCopy, "c", 5},
+		{event.ObjectRemovedDelete, "dir%2Fa+b", 0},
+	}
+	for i := 0; i < 100; i++ {
+		lock.Lock()
+		n := len(received)
+		lock.Unlock()
+		if n >= len(expected) {
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != len(expected) {
+		t.Fatalf("expect %d events but got %d: %+v", len(expected), len(received), received)
+	}
+	for i, e := range expected {
+		r := received[i].Records[0]
+		if received[i].EventName != e.name || r.S3.Bucket.Name != "bucket" || r.S3.Object.Key != e.key || r.S3.Object.Size != e.size {
+			t.Fatalf("unexpected event %d: %+v", i, received[i])
+		}
+	}
+	// the delivered events are removed from the queue
+	jfs := gw.(*jfsObjects).fs
+	var queued int
+	for i := 0; i < 100; i++ {
+		f, eno := jfs.Open(mctx, "/.sys/.events", 0)
+		if eno != 0 {
+			t.Fatalf("open queue: %s", eno)
+		}
+		entries, _ := f.Readdir(mctx, 0)
+		_ = f.Close(mctx)
+		if queued = len(entries); queued == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if queued != 0 {
+		t.Fatalf("%d events are left in queue", queued)
+	}
+}
+
+func TestNotifyFailed(t *testing.T) {
+	var lock sync.Mutex
+	var received []event.Log
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		var log event.Log
+		if err := json.NewDecoder(r.Body).Decode(&log); err != nil {
+			t.Errorf("decode event: %s", err)
+		}
+		if log.Records[0].S3.Object.Key == "bad" { // rejected, should not be retried
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, log)
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644, Webhook: srv.URL})
+	defer gw.Shutdown(context.Background())
+	ctx := context.Background()
+	for _, key := range []string{"bad", "good"} {
+		if _, err := gw.PutObject(ctx, "bucket", key, putReader(t, []byte(key)), minio.ObjectOptions{}); err != nil {
+			t.Fatalf("put object: %s", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		lock.Lock()
+		n := len(received)
+		lock.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	lock.Lock()
+	if len(received) != 1 || received[0].Records[0].S3.Object.Key != "good" {
+		t.Fatalf("unexpected events: %+v", received)
+	}
+	lock.Unlock()
+	jfs := gw.(*jfsObjects).fs
+	f, eno := jfs.Open(mctx, "/.sys/.events/"+failedEvents, 0)
+	if eno != 0 {
+		t.Fatalf("open failed events: %s", eno)
+	}
+	defer f.Close(mctx)
+	if entries, _ := f.Readdir(mctx, 0); len(entries) != 1 {
+		t.Fatalf("expect 1 failed event but got %d", len(entries))
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644, UploadExpiry: time.Hour * 24})
+	n := gw.(*jfsObjects)
+	ctx := context.Background()
+	// make a file older
+	age := func(p string, d time.Duration) {
+		f, eno := n.fs.Open(mctx, p, 0)
+		if eno != 0 {
+			t.Fatalf("open %s: %s", p, eno)
+		}
+		defer f.Close(mctx)
+		ts := time.Now().Add(-d).UnixNano() / 1e6
+		if eno = f.Utime(mctx, ts, ts); eno != 0 {
+			t.Fatalf("utime %s: %s", p, eno)
+		}
+	}
+
+	if _, err := n.GetBucketLifecycle(ctx, "bucket"); !errors.As(err, &minio.BucketLifecycleNotFound{}) {
+		t.Fatalf("get lifecycle should fail: %v", err)
+	}
+	lc, err := lifecycle.ParseLifecycleConfig(strings.NewReader(`<LifecycleConfiguration>
+<Rule><ID>logs</ID><Status>Enabled</Status><Filter><Prefix>logs/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule>
+<Rule><ID>tmp</ID><Status>Enabled</Status><Filter><Tag><Key>tmp</Key><Value>true</Value></Tag></Filter><Expiration><Days>7</Days></Expiration></Rule>
+</LifecycleConfiguration>`))
+	if err != nil {
+		t.Fatalf("parse lifecycle: %s", err)
+	}
+	if err = n.SetBucketLifecycle(ctx, "bucket", lc); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if lc, err = n.GetBucketLifecycle(ctx, "bucket"); err != nil || len(lc.Rules) != 2 || lc.Rules[0].GetPrefix() != "logs/" {
+		t.Fatalf("get lifecycle: %+v %v", lc, err)
+	}
+
+	tagged := minio.ObjectOptions{UserDefined: map[string]string{xhttp.AmzObjectTagging: "tmp=true"}}
+	for key, d := range map[string]time.Duration{
+		"logs/old": 48 * time.Hour, "logs/new": 0, "data/old": 48 * time.Hour, "data/tmp-old": 10 * 24 * time.Hour, "data/tmp-new": 48 * time.Hour,
+	} {
+		opts := minio.ObjectOptions{}
+		if strings.Contains(key, "tmp") {
+			opts = tagged
+		}
+		if _, err = gw.PutObject(ctx, "bucket", key, putReader(t, []byte(key)), opts); err != nil {
+			t.Fatalf("put object %s: %s", key, err)
+		}
+		age(n.path("bucket", key), d)
+	}
+	oldUpload, _ := gw.NewMultipartUpload(ctx, "bucket", "big", minio.ObjectOptions{})
+	age(n.upath("bucket", oldUpload), 48*time.Hour)
+	newUpload, _ := gw.NewMultipartUpload(ctx, "bucket", "big", minio.ObjectOptions{})
+
+	// being applied by another gateway
+	fi, _ := n.fs.Stat(mctx, n.tpath())
+	if eno := n.fs.Meta().Flock(mctx, fi.Inode(), 1, meta.F_WRLCK, false); eno != 0 {
+		t.Fatalf("lock: %s", eno)
+	}
+	n.applyLifecycle(ctx)
+	if _, err = gw.GetObjectInfo(ctx, "bucket", "logs/old", minio.ObjectOptions{}); err != nil {
+		t.Fatalf("lifecycle should be skipped when locked: %s", err)
+	}
+	_ = n.fs.Meta().Flock(mctx, fi.Inode(), 1, meta.F_UNLCK, false)
+
+	n.applyLifecycle(ctx)
+	for key, exists := range map[string]bool{
+		"logs/old": false, "logs/new": true, "data/old": true, "data/tmp-old": false, "data/tmp-new": true,
+	} {
+		if _, err = gw.GetObjectInfo(ctx, "bucket", key, minio.ObjectOptions{}); (err == nil) != exists {
+			t.Fatalf("object %s should exist: %v, err: %v", key, exists, err)
+		}
+	}
+	lmi, err := gw.ListMultipartUploads(ctx, "bucket", "", "", "", "", 10)
+	if err != nil || len(lmi.Uploads) != 1 || lmi.Uploads[0].UploadID != newUpload {
+		t.Fatalf("list uploads: %+v %v", lmi.Uploads, err)
+	}
+
+	if err = n.DeleteBucketLifecycle(ctx, "bucket"); err != nil {
+		t.Fatalf("delete lifecycle: %s", err)
+	}
+	if _, err = n.GetBucketLifecycle(ctx, "bucket"); !errors.As(err, &minio.BucketLifecycleNotFound{}) {
+		t.Fatalf("lifecycle should be deleted: %v", err)
+	}
+}
+
+func TestLifecycleHandler(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644})
+	defer routedGateway.Store((*jfsObjects)(nil))
+	old := authorize
+	defer func() { authorize = old }()
+	var denied bool
+	authorize = func(ctx context.Context, r *http.Request, action policy.Action, bucket, object string) minio.APIErrorCode {
+		if denied {
+			return minio.ErrAccessDenied
+		}
+		return minio.ErrNone
+	}
+
+	var passed bool // to the handlers of MinIO
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { passed = true })
+	call := func(method, query, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/bucket?"+query, strings.NewReader(body))
+		r.Header.Set(xhttp.ContentMD5, "md5")
+		r = mux.SetURLVars(r, map[string]string{"bucket": "bucket"})
+		w := httptest.NewRecorder()
+		w.Header().Set(xhttp.AmzRequestID, "req")
+		lifecycleHandler(next).ServeHTTP(w, r)
+		return w
+	}
+	config := `<LifecycleConfiguration><Rule><ID>logs</ID><Status>Enabled</Status><Filter><Prefix>logs/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`
+
+	if call(http.MethodGet, "policy", ""); !passed {
+		t.Fatalf("other APIs should be served by MinIO")
+	}
+	if w := call(http.MethodGet, "lifecycle", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchLifecycleConfiguration") {
+		t.Fatalf("get lifecycle: %d %s", w.Code, w.Body)
+	}
+	denied = true
+	if w := call(http.MethodPut, "lifecycle", config); w.Code != http.StatusForbidden {
+		t.Fatalf("denied put lifecycle: %d %s", w.Code, w.Body)
+	}
+	denied = false
+	if w := call(http.MethodPut, "lifecycle", "<LifecycleConfiguration>"); w.Code != http.StatusBadRequest {
+		t.Fatalf("put invalid lifecycle: %d %s", w.Code, w.Body)
+	}
+	if w := call(http.MethodPut, "lifecycle", config); w.Code != http.StatusOK || w.Header().Get(xhttp.AmzRequestID) != "req" {
+		t.Fatalf("put lifecycle: %d %s", w.Code, w.Body)
+	}
+	w := call(http.MethodGet, "lifecycle", "")
+	if lc, err := lifecycle.ParseLifecycleConfig(w.Body); w.Code != http.StatusOK || err != nil || len(lc.Rules) != 1 || lc.Rules[0].ID != "logs" {
+		t.Fatalf("get lifecycle: %d %+v %v", w.Code, lc, err)
+	}
+	if w := call(http.MethodDelete, "lifecycle", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete lifecycle: %d %s", w.Code, w.Body)
+	}
+	if _, err := gw.(*jfsObjects).GetBucketLifecycle(context.Background(), "bucket"); err == nil {
+		t.Fatalf("lifecycle should be deleted")
+	}
+}
+
+func TestVersioningHandler(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644})
+	defer routedGateway.Store((*jfsObjects)(nil))
+	old := authorize
+	defer func() { authorize = old }()
+	authorize = func(ctx context.Context, r *http.Request, action policy.Action, bucket, object string) minio.APIErrorCode {
+		return minio.ErrNone
+	}
+	call := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/bucket?versioning", strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"bucket": "bucket"})
+		w := httptest.NewRecorder()
+		versioningHandler(nil).ServeHTTP(w, r)
+		return w
+	}
+	if w := call(http.MethodPut, "<VersioningConfiguration><Status>Disabled</Status></VersioningConfiguration>"); w.Code != http.StatusBadRequest {
+		t.Fatalf("put invalid versioning: %d %s", w.Code, w.Body)
+	}
+	if w := call(http.MethodPut, "<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>"); w.Code != http.StatusOK {
+		t.Fatalf("put versioning: %d %s", w.Code, w.Body)
+	}
+	w := call(http.MethodGet, "")
+	if v, err := versioning.ParseConfig(w.Body); w.Code != http.StatusOK || err != nil || !v.Enabled() {
+		t.Fatalf("get versioning: %d %+v %v", w.Code, v, err)
+	}
+	if gw.(*jfsObjects).versioningOf("bucket") != versioning.Enabled {
+		t.Fatalf("versioning should be enabled")
+	}
+}
+
+// selectObject runs S3 Select on an object in the way of the handler of MinIO, and returns
+// the records in CSV.
+func selectObject(t *testing.T, gw minio.ObjectLayer, object, input, expression string) string {
+	ctx := context.Background()
+	request := fmt.Sprintf(`<SelectObjectContentRequest><Expression>%s</Expression><ExpressionType>SQL</ExpressionType>
+<InputSerialization><CompressionType>NONE</CompressionType>%s</InputSerialization><OutputSerialization><CSV></CSV></OutputSerialization>
+</SelectObjectContentRequest>`, expression, input)
+	s3Select, err := s3select.NewS3Select(strings.NewReader(request))
+	if err != nil {
+		t.Fatalf("parse select request: %s", err)
+	}
+	defer s3Select.Close()
+	err = s3Select.Open(func(offset, length int64) (io.ReadCloser, error) {
+		if length > 0 {
+			length--
+		}
+		rs := &minio.HTTPRangeSpec{IsSuffixLength: offset < 0, Start: offset, End: offset + length}
+		return gw.GetObjectNInfo(ctx, "bucket", object, rs, http.Header{}, 0, minio.ObjectOptions{})
+	})
+	if err != nil {
+		t.Fatalf("open %s for select: %s", object, err)
+	}
+	w := httptest.NewRecorder()
+	s3Select.Evaluate(w)
+	res, err := miniogo.NewSelectResults(&http.Response{StatusCode: w.Code, Body: ioutil.NopCloser(w.Body)}, "bucket")
+	if err != nil {
+		t.Fatalf("select results: %s", err)
+	}
+	data, err := ioutil.ReadAll(res)
+	if err != nil {
+		t.Fatalf("read select results: %s", err)
+	}
+	return string(data)
+}
+
+func TestSelect(t *testing.T) {
+	gw := newTestGateway(t, &Config{MultiBucket: true, Mode: 0644})
+	ctx := context.Background()
+	parquet, err := ioutil.ReadFile("testdata/select.parquet")
+	if err != nil {
+		t.Fatalf("read parquet: %s", err)
+	}
+	for object, data := range map[string][]byte{
+		"people.csv":     []byte("name,age\nalice,30\nbob,17\ncarol,45\n"),
+		"people.json":    []byte(`{"name":"alice","age":30}` + "\n" + `{"name":"bob","age":17}` + "\n" + `{"name":"carol","age":45}` + "\n"),
+		"values.parquet": parquet,
+	} {
+		if _, err = gw.PutObject(ctx, "bucket", object, putReader(t, data), minio.ObjectOptions{}); err != nil {
+			t.Fatalf("put object %s: %s", object, err)
+		}
+	}
+
+	csvInput := `<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>`
+	if out := selectObject(t, gw, "people.csv", csvInput, "SELECT s.name FROM S3Object s WHERE CAST(s.age AS INT) &gt;= 18"); out != "alice\ncarol\n" {
+		t.Fatalf("select csv: %q", out)
+	}
+	if out := selectObject(t, gw, "people.csv", csvInput, "SELECT COUNT(*) FROM S3Object"); out != "3\n" {
+		t.Fatalf("count csv: %q", out)
+	}
+	jsonInput := `<JSON><Type>LINES</Type></JSON>`
+	if out := selectObject(t, gw, "people.json", jsonInput, "SELECT s.name, s.age FROM S3Object s WHERE s.age &lt; 18"); out != "bob,17\n" {
+		t.Fatalf("select json: %q", out)
+	}
+
+	os.Setenv("MINIO_API_SELECT_PARQUET", "on")
+	defer os.Unsetenv("MINIO_API_SELECT_PARQUET")
+	if out := selectObject(t, gw, "values.parquet", `<Parquet></Parquet>`, "SELECT two FROM S3Object WHERE three = true"); out != "foo\nbaz\n" {
+		t.Fatalf("select parquet: %q", out)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	xlogger "github.com/minio/minio/cmd/logger"
+	iampolicy "github.com/minio/minio/pkg/iam/policy"
+	"github.com/minio/minio/pkg/madmin"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+// Policy grants a user the access to the objects in a bucket ("*" for all buckets) with a prefix.
+type Policy struct {
+	Bucket   string `yaml:"bucket"`
+	Prefix   string `yaml:"prefix"`
+	ReadOnly bool   `yaml:"read-only"`
+}
+
+// User is an access key of the gateway, the requests signed by it are served as Uid and Gids.
+type User struct {
+	AccessKey string   `yaml:"access-key"`
+	SecretKey string   `yaml:"secret-key"`
+	Uid       uint32   `yaml:"uid"`
+	Gids      []uint32 `yaml:"gids"`
+	Policies  []Policy `yaml:"policies"`
+}
+
+type statement struct {
+	Effect    string
+	Action    []string
+	Resource  []string
+	Condition map[string]map[string][]string `json:",omitempty"`
+}
+
+// policy builds the IAM policy of the user.
+func (u *User) policy() (*iampolicy.Policy, error) {
+	stats := []statement{{
+		Effect:   "Allow",
+		Action:   []string{"s3:ListAllMyBuckets"},
+		Resource: []string{"arn:aws:s3:::*"},
+	}}
+	for _, p := range u.Policies {
+		if p.Bucket == "" {
+			return nil, fmt.Errorf("bucket of user %s is empty", u.AccessKey)
+		}
+		bucket := "arn:aws:s3:::" + p.Bucket
+		stats = append(stats, statement{
+			Effect:   "Allow",
+			Action:   []string{"s3:GetBucketLocation"},
+			Resource: []string{bucket},
+		})
+		list := statement{
+			Effect:   "Allow",
+			Action:   []string{"s3:ListBucket", "s3:ListBucketVersions"},
+			Resource: []string{bucket},
+		}
+		if p.Prefix != "" {
+			list.Condition = map[string]map[string][]string{"StringLike": {"s3:prefix": {p.Prefix + "*"}}}
+		}
+		objects := statement{
+			Effect:   "Allow",
+			Action:   []string{"s3:*"},
+			Resource: []string{bucket + "/" + p.Prefix + "*"},
+		}
+		if p.ReadOnly {
+			objects.Action = []string{"s3:GetObject", "s3:GetObjectVersion", "s3:GetObjectTagging", "s3:GetObjectVersionTagging"}
+		} else {
+			list.Action = append(list.Action, "s3:ListBucketMultipartUploads")
+		}
+		stats = append(stats, list, objects)
+	}
+	data, _ := json.Marshal(map[string]interface{}{"Version": "2012-10-17", "Statement": stats})
+	return iampolicy.ParseConfig(bytes.NewReader(data))
+}
+
+type userInfo struct {
+	ctx      meta.Context
+	listPool *minio.TreeWalkPool
+}
+
+// newUsers returns the contexts of the users, which have their own pools of listing.
+func newUsers(users []User) map[string]*userInfo {
+	m := make(map[string]*userInfo)
+	for _, u := range users {
+		gids := u.Gids
+		if len(gids) == 0 {
+			gids = []uint32{u.Uid}
+		}
+		m[u.AccessKey] = &userInfo{
+			ctx:      meta.NewContext(uint32(os.Getpid()), u.Uid, gids),
+			listPool: minio.NewTreeWalkPool(time.Minute * 30),
+		}
+	}
+	return m
+}
+
+// checkUser denies the requests which are not signed by the root user or one of the users once
+// the users are configured, like the anonymous ones or the temporary credentials of STS, which
+// have no uid in JuiceFS.
+func (n *jfsObjects) checkUser(ctx context.Context, bucket, object string) error {
+	if len(n.users) == 0 {
+		return nil
+	}
+	info := xlogger.GetReqInfo(ctx)
+	if info == nil || info.AccessKey == "" && info.API == "" || info.AccessKey == n.gConf.RootUser {
+		return nil // the internal ones have no API
+	}
+	if _, ok := n.users[info.AccessKey]; !ok {
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	return nil
+}
+
+// uctx returns the context to access JuiceFS for the user of a request, which is the one
+// of the gateway for the root user and the internal requests.
+func (n *jfsObjects) uctx(ctx context.Context) meta.Context {
+	if u := n.user(ctx); u != nil {
+		return u.ctx
+	}
+	return mctx
+}
+
+func (n *jfsObjects) user(ctx context.Context) *userInfo {
+	if len(n.users) == 0 {
+		return nil
+	}
+	if info := xlogger.GetReqInfo(ctx); info != nil {
+		return n.users[info.AccessKey]
+	}
+	return nil
+}
+
+func (n *jfsObjects) listPoolOf(ctx context.Context) *minio.TreeWalkPool {
+	if u := n.user(ctx); u != nil {
+		return u.listPool
+	}
+	return n.listPool
+}
+
+// sharedDir creates a directory of the gateway which is writable by all the users, the
+// sticky bit is added if it's created by an older version.
+func (n *jfsObjects) sharedDir(p string) {
+	if err := n.mkdirAll(mctx, p, 01777); err != nil {
+		logger.Warnf("mkdir %s: %s", p, err)
+		return
+	}
+	if len(n.users) == 0 {
+		return
+	}
+	fi, eno := n.fs.Stat(mctx, p)
+	if eno != 0 {
+		return
+	}
+	var attr meta.Attr
+	m := n.fs.Meta()
+	if eno = m.GetAttr(mctx, fi.Inode(), &attr); eno == 0 && attr.Mode&01777 != 01777 {
+		attr.Mode = 01777
+		eno = m.SetAttr(mctx, fi.Inode(), meta.SetAttrMode, 0, &attr)
+	}
+	if eno != 0 {
+		logger.Warnf("chmod %s: %s", p, eno)
+	}
+}
+
+// waitIAMSys returns the IAM system of MinIO, which is created before the object layer is
+// published, or nil if the gateway is shut down before that.
+func (n *jfsObjects) waitIAMSys() *minio.IAMSys {
+	for newObjectLayerFn() == nil {
+		select {
+		case <-n.done:
+			return nil
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+	return globalIAMSys
+}
+
+// registerUsers adds the users and their policies into the IAM system of MinIO, which has no
+// store for IAM in gateway mode (without etcd), so the configs are kept in memory and loaded
+// from the config file of users on every start.
+func registerUsers(iam *minio.IAMSys, n *jfsObjects, users []User) {
+	iam.InitStore(&iamObjects{jfsObjects: n, files: make(map[string][]byte)})
+	for _, u := range users {
+		policy, err := u.policy()
+		if err != nil {
+			logger.Errorf("policy of user %s: %s", u.AccessKey, err)
+			continue
+		}
+		name := "juicefs-" + u.AccessKey
+		if err = iam.SetPolicy(name, *policy); err != nil {
+			logger.Errorf("set policy of user %s: %s", u.AccessKey, err)
+			continue
+		}
+		err = iam.CreateUser(u.AccessKey, madmin.UserInfo{SecretKey: u.SecretKey, PolicyName: name, Status: madmin.AccountEnabled})
+		if err != nil {
+			logger.Errorf("create user %s: %s", u.AccessKey, err)
+		}
+	}
+}
+
+// iamObjects keeps the configs of IAM in memory.
+type iamObjects struct {
+	*jfsObjects
+	sync.Mutex
+	files map[string][]byte
+}
+
+func (o *iamObjects) PutObject(ctx context.Context, bucket string, object string, r *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	o.Lock()
+	o.files[object] = data
+	o.Unlock()
+	return minio.ObjectInfo{Bucket: bucket, Name: object, Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (o *iamObjects) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	o.Lock()
+	data, ok := o.files[object]
+	o.Unlock()
+	if !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return minio.ObjectInfo{Bucket: bucket, Name: object, Size: int64(len(data))}, nil
+}
+
+func (o *iamObjects) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (*minio.GetObjectReader, error) {
+	info, err := o.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, err
+	}
+	o.Lock()
+	data := o.files[object]
+	o.Unlock()
+	return minio.NewGetObjectReaderFromReader(bytes.NewReader(data), info, opts)
+}
+
+func (o *iamObjects) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	o.Lock()
+	delete(o.files, object)
+	o.Unlock()
+	return minio.ObjectInfo{Bucket: bucket, Name: object}, nil
+}
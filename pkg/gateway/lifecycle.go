@@ -0,0 +1,354 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	xlogger "github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/bucket/lifecycle"
+	"github.com/minio/minio/pkg/bucket/policy"
+
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+// the interval to apply the lifecycle rules of buckets
+var lifecycleInterval = time.Hour
+
+const lifecycleLockOwner = 0x4c494645 // "LIFE", the session of meta tells the gateways apart
+
+func (n *jfsObjects) lifecyclePath(bucket string) string {
+	return n.tpath(bucket, "lifecycle.xml")
+}
+
+// SetBucketLifecycle saves the lifecycle configuration of a bucket into .sys.
+func (n *jfsObjects) SetBucketLifecycle(ctx context.Context, bucket string, lc *lifecycle.Lifecycle) error {
+	if err := n.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	data, err := xml.Marshal(lc)
+	if err != nil {
+		return err
+	}
+	return jfsToObjectErr(ctx, n.writeFile(n.lifecyclePath(bucket), data), bucket)
+}
+
+// GetBucketLifecycle returns the lifecycle configuration of a bucket.
+func (n *jfsObjects) GetBucketLifecycle(ctx context.Context, bucket string) (*lifecycle.Lifecycle, error) {
+	if err := n.checkBucket(ctx, bucket); err != nil {
+		return nil, err
+	}
+	data, err := n.readFile(n.lifecyclePath(bucket))
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil, minio.BucketLifecycleNotFound{Bucket: bucket}
+		}
+		return nil, jfsToObjectErr(ctx, err, bucket)
+	}
+	return lifecycle.ParseLifecycleConfig(bytes.NewReader(data))
+}
+
+// DeleteBucketLifecycle removes the lifecycle configuration of a bucket.
+func (n *jfsObjects) DeleteBucketLifecycle(ctx context.Context, bucket string) error {
+	if err := n.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	if eno := n.fs.Delete(mctx, n.lifecyclePath(bucket)); eno != 0 && !fs.IsNotExist(eno) {
+		return jfsToObjectErr(ctx, eno, bucket)
+	}
+	return nil
+}
+
+// runLifecycle applies the lifecycle rules periodically until the gateway is shut down.
+func (n *jfsObjects) runLifecycle() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-time.After(lifecycleInterval):
+			n.applyLifecycle(context.Background())
+		}
+	}
+}
+
+// applyLifecycle expires the objects by the lifecycle rules of all the buckets, and aborts
+// the multipart uploads which are not completed in time. The system directory is locked so
+// only one of the gateways applies the rules at a time.
+func (n *jfsObjects) applyLifecycle(ctx context.Context) {
+	fi, eno := n.fs.Stat(mctx, n.tpath())
+	if eno != 0 {
+		logger.Warnf("stat %s: %s", n.tpath(), eno)
+		return
+	}
+	m := n.fs.Meta()
+	if eno = m.Flock(mctx, fi.Inode(), lifecycleLockOwner, meta.F_WRLCK, false); eno == syscall.EAGAIN {
+		logger.Debugf("lifecycle is being applied by another gateway")
+		return
+	} else if eno != 0 {
+		logger.Warnf("lock %s: %s", n.tpath(), eno)
+		return
+	}
+	defer m.Flock(mctx, fi.Inode(), lifecycleLockOwner, meta.F_UNLCK, false)
+
+	buckets, err := n.ListBuckets(ctx)
+	if err != nil {
+		logger.Warnf("list buckets: %s", err)
+		return
+	}
+	for _, b := range buckets {
+		if lc, err := n.GetBucketLifecycle(ctx, b.Name); err == nil {
+			n.expireObjects(ctx, b.Name, lc)
+		} else if !errors.As(err, &minio.BucketLifecycleNotFound{}) {
+			logger.Warnf("lifecycle of bucket %s: %s", b.Name, err)
+		}
+		if n.gConf.UploadExpiry > 0 {
+			n.abortUploads(ctx, b.Name, n.gConf.UploadExpiry)
+		}
+	}
+}
+
+// expireObjects deletes the expired objects (and noncurrent versions) in a bucket.
+func (n *jfsObjects) expireObjects(ctx context.Context, bucket string, lc *lifecycle.Lifecycle) {
+	// walk the keys once with the common prefix of the rules, which are matched by ComputeAction
+	var prefix string
+	var enabled bool
+	for _, rule := range lc.Rules {
+		if rule.Status == lifecycle.Disabled {
+			continue
+		}
+		if p := rule.GetPrefix(); !enabled {
+			prefix, enabled = p, true
+		} else {
+			for !strings.HasPrefix(p, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+	}
+	if !enabled {
+		return
+	}
+	n.objectKeys(mctx, bucket, prefix, "", "", func(key string, _ bool) bool {
+		versions := n.objectVersions(mctx, bucket, key)
+		for i, v := range versions {
+			obj := lifecycle.ObjectOpts{
+				Name:         key,
+				UserTags:     v.UserTags,
+				ModTime:      v.ModTime,
+				VersionID:    v.VersionID,
+				IsLatest:     v.IsLatest,
+				DeleteMarker: v.DeleteMarker,
+				NumVersions:  v.NumVersions,
+			}
+			if i > 0 {
+				obj.SuccessorModTime = versions[i-1].ModTime
+				if obj.VersionID == "" {
+					obj.VersionID = nullVersionID
+				}
+			}
+			var err error
+			switch lc.ComputeAction(obj) {
+			case lifecycle.DeleteAction:
+				_, err = n.DeleteObject(ctx, bucket, key, minio.ObjectOptions{})
+			case lifecycle.DeleteVersionAction:
+				_, err = n.DeleteObject(ctx, bucket, key, minio.ObjectOptions{VersionID: obj.VersionID})
+			default:
+				continue
+			}
+			if err != nil {
+				logger.Warnf("expire %s/%s (%s): %s", bucket, key, obj.VersionID, err)
+			} else {
+				logger.Debugf("expired %s/%s (%s)", bucket, key, obj.VersionID)
+			}
+			if i == 0 {
+				break // the versions are changed, check the others in next round
+			}
+		}
+		return true
+	})
+}
+
+// abortUploads removes the multipart uploads initiated before expiry.
+func (n *jfsObjects) abortUploads(ctx context.Context, bucket string, expiry time.Duration) {
+	f, eno := n.fs.Open(mctx, n.tpath(bucket, "uploads"), 0)
+	if eno != 0 {
+		return
+	}
+	entries, eno := f.ReaddirPlus(mctx, 0)
+	_ = f.Close(mctx)
+	if eno != 0 {
+		logger.Warnf("list uploads of bucket %s: %s", bucket, eno)
+		return
+	}
+	for _, e := range entries {
+		uploadID := string(e.Name)
+		initiated := time.Unix(e.Attr.Atime, int64(e.Attr.Atimensec))
+		if e.Attr.Typ != meta.TypeDirectory || time.Since(initiated) < expiry {
+			continue
+		}
+		if eno = n.fs.Rmr(mctx, n.upath(bucket, uploadID)); eno != 0 && !fs.IsNotExist(eno) {
+			logger.Warnf("abort upload %s of bucket %s: %s", uploadID, bucket, eno)
+		} else {
+			logger.Infof("aborted upload %s of bucket %s initiated at %s", uploadID, bucket, initiated)
+		}
+	}
+}
+
+// authorize verifies the signature of a request and checks the action against the policies
+// by MinIO, the access key is saved in the request info of ctx.
+var authorize = checkRequestAuthType
+
+// routedGateway is the gateway to serve the bucket APIs routed by the middlewares (lifecycle and
+// versioning), nil once it's shut down.
+var routedGateway atomic.Value // *jfsObjects
+
+func init() {
+	// MinIO has no way to add routes, so the lifecycle APIs are routed by a middleware
+	globalHandlers = append(globalHandlers, lifecycleHandler)
+}
+
+// lifecycleHandler routes the lifecycle APIs of buckets to the gateway, which are not
+// supported by MinIO in gateway mode.
+func lifecycleHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		bucket := vars["bucket"]
+		n, _ := routedGateway.Load().(*jfsObjects)
+		if _, ok := r.URL.Query()["lifecycle"]; !ok || bucket == "" || vars["object"] != "" || n == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			n.putLifecycleHandler(w, r, bucket)
+		case http.MethodGet:
+			n.getLifecycleHandler(w, r, bucket)
+		case http.MethodDelete:
+			n.deleteLifecycleHandler(w, r, bucket)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// bucketAPIContext authorizes a request of the bucket APIs, it writes the error and returns nil
+// if the request is denied.
+func bucketAPIContext(w http.ResponseWriter, r *http.Request, api string, action policy.Action, bucket string) context.Context {
+	ctx := xlogger.SetReqInfo(r.Context(), &xlogger.ReqInfo{
+		RequestID:  w.Header().Get(xhttp.AmzRequestID),
+		UserAgent:  r.UserAgent(),
+		API:        api,
+		BucketName: bucket,
+	})
+	if code := authorize(ctx, r, action, bucket, ""); code != minio.ErrNone {
+		writeError(w, r, getAPIError(code), bucket)
+		return nil
+	}
+	return ctx
+}
+
+func (n *jfsObjects) putLifecycleHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := bucketAPIContext(w, r, "PutBucketLifecycle", policy.PutBucketLifecycleAction, bucket)
+	if ctx == nil {
+		return
+	}
+	if _, ok := r.Header[xhttp.ContentMD5]; !ok {
+		writeError(w, r, getAPIError(minio.ErrMissingContentMD5), bucket)
+		return
+	}
+	lc, err := lifecycle.ParseLifecycleConfig(io.LimitReader(r.Body, 1<<20))
+	if err == nil {
+		err = lc.Validate()
+	}
+	if err == nil {
+		err = n.SetBucketLifecycle(ctx, bucket, lc)
+	}
+	if err != nil {
+		writeError(w, r, toAPIError(ctx, err), bucket)
+		return
+	}
+	writeResponse(w, http.StatusOK, nil)
+}
+
+func (n *jfsObjects) getLifecycleHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := bucketAPIContext(w, r, "GetBucketLifecycle", policy.GetBucketLifecycleAction, bucket)
+	if ctx == nil {
+		return
+	}
+	lc, err := n.GetBucketLifecycle(ctx, bucket)
+	var data []byte
+	if err == nil {
+		data, err = xml.Marshal(lc)
+	}
+	if err != nil {
+		writeError(w, r, toAPIError(ctx, err), bucket)
+		return
+	}
+	writeResponse(w, http.StatusOK, data)
+}
+
+func (n *jfsObjects) deleteLifecycleHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := bucketAPIContext(w, r, "DeleteBucketLifecycle", policy.PutBucketLifecycleAction, bucket)
+	if ctx == nil {
+		return
+	}
+	if err := n.DeleteBucketLifecycle(ctx, bucket); err != nil {
+		writeError(w, r, toAPIError(ctx, err), bucket)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil)
+}
+
+func writeResponse(w http.ResponseWriter, status int, data []byte) {
+	if data != nil {
+		w.Header().Set(xhttp.ContentType, "application/xml")
+	}
+	w.Header().Set(xhttp.ContentLength, strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err minio.APIError, bucket string) {
+	writeAPIError(w, r, err.HTTPStatusCode, err.Code, err.Description, bucket)
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, message, bucket string) {
+	data, _ := xml.Marshal(minio.APIErrorResponse{
+		Code:       code,
+		Message:    message,
+		BucketName: bucket,
+		Resource:   r.URL.Path,
+		RequestID:  w.Header().Get(xhttp.AmzRequestID),
+	})
+	w.Header().Set(xhttp.ContentType, "application/xml")
+	w.Header().Set(xhttp.ContentLength, strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,51 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"context"
+	"net/http"
+	_ "unsafe" // for go:linkname
+
+	"github.com/gorilla/mux"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/bucket/policy"
+)
+
+// The internals of MinIO used by the gateway, which are linked by name since the fork of MinIO
+// (github.com/juicedata/minio) doesn't export them. They must be checked whenever the fork is
+// upgraded, and should be replaced by the hooks of the fork: a way to register the handlers of
+// the bucket APIs which are not supported in gateway mode (lifecycle), and the ones to check the
+// signature and policies of a request, and the one to register the users.
+
+//go:linkname globalIAMSys github.com/minio/minio/cmd.globalIAMSys
+var globalIAMSys *minio.IAMSys
+
+//go:linkname newObjectLayerFn github.com/minio/minio/cmd.newObjectLayerFn
+func newObjectLayerFn() minio.ObjectLayer
+
+//go:linkname globalHandlers github.com/minio/minio/cmd.globalHandlers
+var globalHandlers []mux.MiddlewareFunc
+
+//go:linkname checkRequestAuthType github.com/minio/minio/cmd.checkRequestAuthType
+func checkRequestAuthType(ctx context.Context, r *http.Request, action policy.Action, bucketName, objectName string) minio.APIErrorCode
+
+//go:linkname getAPIError github.com/minio/minio/cmd.getAPIError
+func getAPIError(code minio.APIErrorCode) minio.APIError
+
+//go:linkname toAPIError github.com/minio/minio/cmd.toAPIError
+func toAPIError(ctx context.Context, err error) minio.APIError
@@ -0,0 +1,263 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	xlogger "github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/event"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+// the interval to retry the delivery after a failure, doubled up to maxRetryInterval
+var retryInterval = time.Second
+
+const (
+	maxRetryInterval = time.Minute
+	maxAttempts      = 20             // the failed events are moved into failedEvents after maxAttempts
+	failedEvents     = ".failed"      // in the queue, not delivered
+	eventsLockOwner  = 0x4556454e5453 // "EVENTS", the session of meta tells the gateways apart
+)
+
+// notifier delivers the events of objects to a webhook. The events are queued as files
+// in the volume before delivered, so they are not lost when the webhook is unavailable
+// or the gateway is restarted.
+type notifier struct {
+	*jfsObjects
+	url    string
+	dir    string
+	client *http.Client
+	seq    uint64
+	wake   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	failing  string // the event failed to deliver
+	attempts int
+}
+
+func newNotifier(n *jfsObjects, webhook string) *notifier {
+	q := &notifier{
+		jfsObjects: n,
+		url:        webhook,
+		dir:        sep + metaBucket + sep + ".events", // not a valid name of bucket
+		client:     &http.Client{Timeout: time.Second * 10},
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+// notify queues an event of an object, it's ignored if no webhook is configured.
+func (n *jfsObjects) notify(ctx context.Context, name event.Name, bucket string, info minio.ObjectInfo) {
+	if n.events == nil {
+		return
+	}
+	now := time.Now().UTC()
+	e := event.Event{
+		EventVersion: "2.0",
+		EventSource:  "juicefs:s3",
+		EventTime:    now.Format(event.AMZTimeFormat),
+		EventName:    name,
+		S3: event.Metadata{
+			SchemaVersion:   "1.0",
+			ConfigurationID: "Config",
+			Bucket:          event.Bucket{Name: bucket, ARN: "arn:aws:s3:::" + bucket},
+			Object: event.Object{
+				Key:         url.QueryEscape(info.Name),
+				Size:        info.Size,
+				ETag:        info.ETag,
+				ContentType: info.ContentType,
+				VersionID:   info.VersionID,
+				Sequencer:   fmt.Sprintf("%X", now.UnixNano()),
+			},
+		},
+	}
+	if req := xlogger.GetReqInfo(ctx); req != nil {
+		e.UserIdentity.PrincipalID = req.AccessKey
+		e.RequestParameters = map[string]string{"sourceIPAddress": req.RemoteHost}
+		e.ResponseElements = map[string]string{"x-amz-request-id": req.RequestID}
+		e.Source = event.Source{Host: req.RemoteHost, UserAgent: req.UserAgent}
+	}
+	if err := n.events.enqueue(event.Log{EventName: name, Key: bucket + sep + info.Name, Records: []event.Event{e}}); err != nil {
+		logger.Errorf("queue event %s of %s/%s: %s", name, bucket, info.Name, err)
+	}
+}
+
+// enqueue writes the event into the queue, the names of files are ordered by the time they
+// are queued.
+func (q *notifier) enqueue(log event.Log) error {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%08d", time.Now().UnixNano(), atomic.AddUint64(&q.seq, 1)%1e8)
+	if err = q.writeFile(path.Join(q.dir, name), data); err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers the queued events in order, and retries the failed one until it succeeds.
+func (q *notifier) run() {
+	defer q.wg.Done()
+	interval := retryInterval
+	for {
+		wait := time.Second * 10
+		if err := q.deliver(); err != nil {
+			logger.Warnf("deliver events to %s: %s, retry in %s", q.url, err, interval)
+			wait = interval
+			if interval *= 2; interval > maxRetryInterval {
+				interval = maxRetryInterval
+			}
+		} else {
+			interval = retryInterval
+		}
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// close stops the delivery, and waits for the one in progress.
+func (q *notifier) close() {
+	close(q.done)
+	q.wg.Wait()
+}
+
+// deliver sends all the queued events, and stops at the first failure. The queue is locked
+// so only one of the gateways delivers the events at a time, in order.
+func (q *notifier) deliver() error {
+	fi, eno := q.fs.Stat(mctx, q.dir)
+	if eno != 0 {
+		return nil // nothing queued
+	}
+	m := q.fs.Meta()
+	if eno = m.Flock(mctx, fi.Inode(), eventsLockOwner, meta.F_WRLCK, false); eno == syscall.EAGAIN {
+		return nil // delivered by another gateway
+	} else if eno != 0 {
+		return eno
+	}
+	defer m.Flock(mctx, fi.Inode(), eventsLockOwner, meta.F_UNLCK, false)
+
+	f, eno := q.fs.Open(mctx, q.dir, 0)
+	if eno != 0 {
+		return eno
+	}
+	entries, eno := f.Readdir(mctx, 0)
+	_ = f.Close(mctx)
+	if eno != 0 {
+		return eno
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case <-q.done:
+			return nil
+		default:
+		}
+		p := path.Join(q.dir, name)
+		data, err := q.readFile(p)
+		if err != nil {
+			return err
+		}
+		if err = q.post(data); err != nil {
+			if name != q.failing {
+				q.failing, q.attempts = name, 0
+			}
+			if q.attempts++; !errors.As(err, &permanentError{}) && q.attempts < maxAttempts {
+				return err
+			}
+			// give up the event, so the ones after it are not blocked
+			logger.Errorf("deliver event %s to %s: %s, move it to %s", name, q.url, err, failedEvents)
+			if err = q.mkdirAll(mctx, path.Join(q.dir, failedEvents), 0755); err != nil {
+				return err
+			}
+			if eno = q.fs.Rename(mctx, p, path.Join(q.dir, failedEvents, name), 0); eno != 0 {
+				return eno
+			}
+			continue
+		}
+		if eno = q.fs.Delete(mctx, p); eno != 0 {
+			return eno
+		}
+	}
+	return nil
+}
+
+// permanentError is the failure which will not succeed by retrying.
+type permanentError struct {
+	status string
+}
+
+func (e permanentError) Error() string {
+	return "status " + e.status
+}
+
+func (q *notifier) post(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, q.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return permanentError{resp.Status}
+	default:
+		return fmt.Errorf("status %s", resp.Status)
+	}
+}
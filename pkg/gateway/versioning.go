@@ -0,0 +1,559 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/gorilla/mux"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/bucket/policy"
+	"github.com/minio/minio/pkg/bucket/versioning"
+
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+// The current version of an object is the file in the bucket, the noncurrent versions
+// (and delete markers) are moved into .sys/BUCKET/versions/OBJECT/VERSION-ID by renaming,
+// so no data is copied. The version without an id (created when versioning is disabled
+// or suspended) is the "null" version. The versioning state of a bucket is kept as an
+// extended attribute of its directory.
+const (
+	s3Version      = "s3-version-id"
+	s3DeleteMarker = "s3-delete-marker"
+	s3Versioning   = "s3-versioning"
+	nullVersionID  = "null"
+)
+
+func init() {
+	globalHandlers = append(globalHandlers, versioningHandler)
+}
+
+// versioningHandler routes the versioning APIs of buckets to the gateway, which are not
+// supported by MinIO in gateway mode.
+func versioningHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		bucket := vars["bucket"]
+		n, _ := routedGateway.Load().(*jfsObjects)
+		if _, ok := r.URL.Query()["versioning"]; !ok || bucket == "" || vars["object"] != "" || n == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			n.putVersioningHandler(w, r, bucket)
+		case http.MethodGet:
+			n.getVersioningHandler(w, r, bucket)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (n *jfsObjects) putVersioningHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := bucketAPIContext(w, r, "PutBucketVersioning", policy.PutBucketVersioningAction, bucket)
+	if ctx == nil {
+		return
+	}
+	v, err := versioning.ParseConfig(io.LimitReader(r.Body, 1<<20))
+	if err == nil {
+		err = n.SetBucketVersioning(ctx, bucket, v)
+	}
+	if err != nil {
+		writeError(w, r, toAPIError(ctx, err), bucket)
+		return
+	}
+	writeResponse(w, http.StatusOK, nil)
+}
+
+func (n *jfsObjects) getVersioningHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := bucketAPIContext(w, r, "GetBucketVersioning", policy.GetBucketVersioningAction, bucket)
+	if ctx == nil {
+		return
+	}
+	v, err := n.GetBucketVersioning(ctx, bucket)
+	var data []byte
+	if err == nil {
+		data, err = xml.Marshal(v)
+	}
+	if err != nil {
+		writeError(w, r, toAPIError(ctx, err), bucket)
+		return
+	}
+	writeResponse(w, http.StatusOK, data)
+}
+
+// SetBucketVersioning enables or suspends the versioning of a bucket.
+func (n *jfsObjects) SetBucketVersioning(ctx context.Context, bucket string, v *versioning.Versioning) error {
+	if err := n.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	if eno := n.fs.SetXattr(mctx, n.path(bucket), s3Versioning, []byte(v.Status), 0); eno != 0 {
+		return jfsToObjectErr(ctx, eno, bucket)
+	}
+	return nil
+}
+
+// GetBucketVersioning returns the versioning configuration of a bucket, whose status is empty
+// if versioning is never enabled.
+func (n *jfsObjects) GetBucketVersioning(ctx context.Context, bucket string) (*versioning.Versioning, error) {
+	if err := n.checkBucket(ctx, bucket); err != nil {
+		return nil, err
+	}
+	return &versioning.Versioning{XMLNS: "http://s3.amazonaws.com/doc/2006-03-01/", Status: n.versioningOf(bucket)}, nil
+}
+
+// versioningOf returns the versioning state of a bucket, the ones never configured are enabled
+// by --versioning.
+func (n *jfsObjects) versioningOf(bucket string) versioning.State {
+	if v, eno := n.fs.GetXattr(mctx, n.path(bucket), s3Versioning); eno == 0 {
+		return versioning.State(v)
+	}
+	if n.gConf.Versioning {
+		return versioning.Enabled
+	}
+	return ""
+}
+
+// vpath returns the path of a noncurrent version of an object.
+func (n *jfsObjects) vpath(bucket, object, versionID string) string {
+	if versionID == "" {
+		versionID = nullVersionID
+	}
+	return n.tpath(bucket, "versions", object, versionID)
+}
+
+// versionOf returns the version id of a file, which is empty for the null version.
+func (n *jfsObjects) versionOf(p string) string {
+	if v, eno := n.fs.GetXattr(mctx, p, s3Version); eno == 0 {
+		return string(v)
+	}
+	return ""
+}
+
+func (n *jfsObjects) isDeleteMarker(p string) bool {
+	_, eno := n.fs.GetXattr(mctx, p, s3DeleteMarker)
+	return eno == 0
+}
+
+// resolveVersion finds the file of a version of an object (the current one if versionID is
+// empty), and tells whether it's the current version.
+func (n *jfsObjects) resolveVersion(ctx context.Context, bucket, object, versionID string) (string, *fs.FileStat, bool, error) {
+	p := n.path(bucket, object)
+	fi, eno := n.fs.Stat(n.uctx(ctx), p)
+	if versionID == "" {
+		return p, fi, true, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	vid := versionID
+	if vid == nullVersionID {
+		vid = ""
+	}
+	if eno == 0 && !fi.IsDir() && n.versionOf(p) == vid {
+		return p, fi, true, nil
+	}
+	p = n.vpath(bucket, object, vid)
+	if fi, eno = n.fs.Stat(mctx, p); eno != 0 {
+		if fs.IsNotExist(eno) {
+			return p, nil, false, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+		}
+		return p, nil, false, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	return p, fi, false, nil
+}
+
+// keepVersion moves the current version of an object into the versions area, it returns
+// whether there was a current version.
+func (n *jfsObjects) keepVersion(ctx context.Context, bucket, object string) (bool, error) {
+	p := n.path(bucket, object)
+	if fi, eno := n.fs.Stat(mctx, p); eno != 0 || fi.IsDir() {
+		return false, nil
+	}
+	// the versions are moved by the gateway, after checking the permission of the user
+	if eno := n.checkVersionAccess(ctx, bucket, object); eno != 0 {
+		return false, eno
+	}
+	vp := n.vpath(bucket, object, n.versionOf(p))
+	if err := n.mkdirAll(mctx, path.Dir(vp), 0755); err != nil {
+		return false, err
+	}
+	if eno := n.fs.Rename(mctx, p, vp, 0); eno != 0 {
+		logger.Errorf("rename %s to %s: %s", p, vp, eno)
+		return false, eno
+	}
+	return true, nil
+}
+
+// checkVersionAccess checks whether the user of a request can write the directory of an object,
+// or the nearest one above it if it's gone, before the versions of the object are changed by
+// the gateway.
+func (n *jfsObjects) checkVersionAccess(ctx context.Context, bucket, object string) syscall.Errno {
+	root := n.path(bucket)
+	dir := path.Dir(n.path(bucket, object))
+	for {
+		eno := n.fs.Access(n.uctx(ctx), dir, vfs.MODE_MASK_W)
+		if !fs.IsNotExist(eno) || dir == root || dir == "/" {
+			return eno
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// removeNullVersion removes the noncurrent null version of an object.
+func (n *jfsObjects) removeNullVersion(ctx context.Context, bucket, object string) syscall.Errno {
+	vp := n.vpath(bucket, object, "")
+	if _, eno := n.fs.Stat(mctx, vp); eno != 0 {
+		return 0
+	}
+	if eno := n.checkVersionAccess(ctx, bucket, object); eno != 0 {
+		return eno
+	}
+	return n.deleteUp(mctx, vp, n.tpath(bucket, "versions"))
+}
+
+// newVersion gives a new version id to tmp and keeps the current version of the object
+// before tmp is renamed to it. If versioning is suspended, tmp becomes the null version
+// and replaces the old one, it does nothing if versioning is disabled.
+func (n *jfsObjects) newVersion(ctx context.Context, bucket, object, tmp string) (string, error) {
+	switch n.versioningOf(bucket) {
+	case versioning.Enabled:
+	case versioning.Suspended:
+		if _, err := n.keepVersion(ctx, bucket, object); err != nil {
+			return "", err
+		}
+		if eno := n.removeNullVersion(ctx, bucket, object); eno != 0 {
+			return "", eno
+		}
+		return "", nil
+	default:
+		return "", nil
+	}
+	vid := minio.MustGetUUID()
+	if eno := n.fs.SetXattr(mctx, tmp, s3Version, []byte(vid), 0); eno != 0 {
+		return "", eno
+	}
+	_, err := n.keepVersion(ctx, bucket, object)
+	return vid, err
+}
+
+// deleteUp deletes p and the empty directories above it, up to root.
+func (n *jfsObjects) deleteUp(jctx meta.Context, p, root string) syscall.Errno {
+	for p != root && p != "/" {
+		if eno := n.fs.Delete(jctx, p); eno != 0 {
+			if fs.IsNotEmpty(eno) {
+				return 0
+			}
+			return eno
+		}
+		p = path.Dir(p)
+	}
+	return 0
+}
+
+// deleteLatest keeps the current version of an object and puts a delete marker on top of it,
+// the delete marker is the null version if versioning is suspended, which replaces the old one.
+func (n *jfsObjects) deleteLatest(ctx context.Context, bucket, object string, suspended bool) (info minio.ObjectInfo, err error) {
+	info.Bucket = bucket
+	info.Name = object
+	p := n.path(bucket, object)
+	kept, err := n.keepVersion(ctx, bucket, object)
+	if err != nil {
+		return info, jfsToObjectErr(ctx, err, bucket, object)
+	}
+	if suspended {
+		if eno := n.removeNullVersion(ctx, bucket, object); eno != 0 {
+			return info, jfsToObjectErr(ctx, eno, bucket, object)
+		}
+	}
+	if kept {
+		p = path.Dir(p)
+	}
+	if eno := n.deleteUp(n.uctx(ctx), p, n.path(bucket)); eno != 0 && !fs.IsNotExist(eno) {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+
+	vid := nullVersionID
+	if !suspended {
+		vid = minio.MustGetUUID()
+	}
+	vp := n.vpath(bucket, object, vid)
+	if err = n.mkdirAll(mctx, path.Dir(vp), 0755); err != nil {
+		return info, jfsToObjectErr(ctx, err, bucket, object)
+	}
+	f, eno := n.fs.Create(mctx, vp, n.gConf.Mode)
+	if eno != 0 {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	_ = f.Close(mctx)
+	n.setXattr(mctx, vp, s3Version, vid, !suspended)
+	n.setXattr(mctx, vp, s3DeleteMarker, "1", true)
+	info.DeleteMarker = true
+	info.VersionID = vid
+	return info, nil
+}
+
+// deleteVersion removes a version of an object permanently, the newest noncurrent version
+// becomes the current one if the current version is deleted.
+func (n *jfsObjects) deleteVersion(ctx context.Context, bucket, object, versionID string) (info minio.ObjectInfo, err error) {
+	info.Bucket = bucket
+	info.Name = object
+	info.VersionID = versionID
+	p, _, latest, err := n.resolveVersion(ctx, bucket, object, versionID)
+	if err != nil {
+		return info, err
+	}
+	info.DeleteMarker = n.isDeleteMarker(p)
+	jctx, root := mctx, n.tpath(bucket, "versions")
+	if latest {
+		jctx, root = n.uctx(ctx), n.path(bucket)
+	} else if eno := n.checkVersionAccess(ctx, bucket, object); eno != 0 {
+		// the noncurrent versions are removed by the gateway, as the user who can delete the object
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	if eno := n.deleteUp(jctx, p, root); eno != 0 {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+
+	p = n.path(bucket, object)
+	if _, eno := n.fs.Stat(mctx, p); eno == 0 {
+		return info, nil
+	}
+	versions := n.noncurrentVersions(mctx, bucket, object)
+	if len(versions) == 0 || versions[0].DeleteMarker {
+		return info, nil
+	}
+	vp := n.vpath(bucket, object, versions[0].VersionID)
+	if err = n.mkdirAll(n.uctx(ctx), path.Dir(p), 0755); err != nil {
+		return info, jfsToObjectErr(ctx, err, bucket, object)
+	}
+	if eno := n.fs.Rename(mctx, vp, p, 0); eno != 0 {
+		return info, jfsToObjectErr(ctx, eno, bucket, object)
+	}
+	_ = n.deleteUp(mctx, path.Dir(vp), n.tpath(bucket, "versions"))
+	return info, nil
+}
+
+// noncurrentVersions returns the noncurrent versions of an object, newest first.
+func (n *jfsObjects) noncurrentVersions(jctx meta.Context, bucket, object string) []minio.ObjectInfo {
+	dir := path.Dir(n.vpath(bucket, object, ""))
+	f, eno := n.fs.Open(jctx, dir, 0)
+	if eno != 0 {
+		return nil
+	}
+	defer f.Close(jctx)
+	entries, _ := f.Readdir(jctx, 0)
+	var versions []minio.ObjectInfo
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info := n.fileInfo(path.Join(dir, e.Name()), bucket, object, e.(*fs.FileStat))
+		versions = append(versions, info)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].ModTime.Equal(versions[j].ModTime) {
+			return versions[i].ModTime.After(versions[j].ModTime)
+		}
+		return versions[i].VersionID > versions[j].VersionID
+	})
+	return versions
+}
+
+// objectVersions returns all the versions of an object, newest first.
+func (n *jfsObjects) objectVersions(jctx meta.Context, bucket, object string) []minio.ObjectInfo {
+	var versions []minio.ObjectInfo
+	p := n.path(bucket, object)
+	if fi, eno := n.fs.Stat(jctx, p); eno == 0 && !fi.IsDir() {
+		versions = append(versions, n.fileInfo(p, bucket, object, fi))
+	}
+	versions = append(versions, n.noncurrentVersions(jctx, bucket, object)...)
+	if len(versions) > 0 {
+		versions[0].IsLatest = true
+	}
+	for i := range versions {
+		versions[i].NumVersions = len(versions)
+	}
+	return versions
+}
+
+func (n *jfsObjects) readDir(jctx meta.Context, p string) []os.FileInfo {
+	f, eno := n.fs.Open(jctx, p, 0)
+	if eno != 0 {
+		return nil
+	}
+	defer f.Close(jctx)
+	entries, _ := f.Readdir(jctx, 0)
+	return entries
+}
+
+// keyItem is a key, or a subtree of keys if its name ends with sep.
+type keyItem struct {
+	name    string
+	subtree bool
+}
+
+// keyItems returns the keys and subtrees in dir (relative to the bucket) in order, where the
+// objects are the files in the bucket, or the directories with version files in them.
+func (n *jfsObjects) keyItems(jctx meta.Context, bucket, dir string) []keyItem {
+	var items []keyItem
+	seen := make(map[string]bool)
+	add := func(name string, subtree bool) {
+		if !seen[name] {
+			seen[name] = true
+			items = append(items, keyItem{name, subtree})
+		}
+	}
+	p := n.path(bucket, dir)
+	for _, e := range n.readDir(jctx, p) {
+		if p == sep && e.Name() == metaBucket {
+			continue
+		}
+		if e.IsDir() {
+			add(dir+e.Name()+sep, true)
+		} else {
+			add(dir+e.Name(), false)
+		}
+	}
+	for _, e := range n.readDir(jctx, n.tpath(bucket, "versions", dir)) {
+		if e.IsDir() {
+			add(dir+e.Name(), false) // may have no version
+			add(dir+e.Name()+sep, true)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].name < items[j].name })
+	return items
+}
+
+// hasKeys returns whether there is any object (or noncurrent version) in the subtree dir.
+func (n *jfsObjects) hasKeys(jctx meta.Context, bucket, dir string) bool {
+	for _, it := range n.keyItems(jctx, bucket, dir) {
+		if it.subtree && n.hasKeys(jctx, bucket, it.name) || !it.subtree && len(n.objectVersions(jctx, bucket, it.name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// walkKeys visits the keys with prefix and after marker under dir in order, including the ones
+// which have only noncurrent versions, until fn returns false. The subtrees in which all the keys
+// have the same common prefix of delimiter are visited as the common prefix without walking them.
+// Some visited keys may have no version.
+func (n *jfsObjects) walkKeys(jctx meta.Context, bucket, dir, prefix, marker, delimiter string, fn func(key string, isPrefix bool) bool) bool {
+	for _, it := range n.keyItems(jctx, bucket, dir) {
+		if !it.subtree {
+			if strings.HasPrefix(it.name, prefix) && it.name >= marker && !fn(it.name, false) {
+				return false
+			}
+			continue
+		}
+		if !strings.HasPrefix(it.name, prefix) && !strings.HasPrefix(prefix, it.name) {
+			continue
+		}
+		if it.name < marker && !strings.HasPrefix(marker, it.name) {
+			continue
+		}
+		if delimiter != "" && len(it.name) > len(prefix) {
+			if i := strings.Index(it.name[len(prefix):], delimiter); i >= 0 {
+				cp := it.name[:len(prefix)+i+len(delimiter)]
+				if cp > marker && n.hasKeys(jctx, bucket, it.name) && !fn(cp, true) {
+					return false
+				}
+				continue
+			}
+		}
+		if !n.walkKeys(jctx, bucket, it.name, prefix, marker, delimiter, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// objectKeys visits the keys of the objects with prefix in order, see walkKeys.
+func (n *jfsObjects) objectKeys(jctx meta.Context, bucket, prefix, marker, delimiter string, fn func(key string, isPrefix bool) bool) {
+	n.walkKeys(jctx, bucket, prefix[:strings.LastIndex(prefix, sep)+1], prefix, marker, delimiter, fn)
+}
+
+func (n *jfsObjects) ListObjectVersions(ctx context.Context, bucket, prefix, marker, versionMarker, delimiter string, maxKeys int) (result minio.ListObjectVersionsInfo, err error) {
+	if err = n.checkBucket(ctx, bucket); err != nil {
+		return
+	}
+	if versionMarker == nullVersionID {
+		versionMarker = ""
+	}
+	jctx := n.uctx(ctx)
+	var count int
+	var lastKey, lastVersion string
+	full := func() bool {
+		if count < maxKeys {
+			count++
+			return false
+		}
+		result.IsTruncated = true
+		result.NextMarker = lastKey
+		result.NextVersionIDMarker = lastVersion
+		return true
+	}
+	n.objectKeys(jctx, bucket, prefix, marker, delimiter, func(key string, isPrefix bool) bool {
+		if key == marker && versionMarker == "" {
+			return true
+		}
+		if !isPrefix && delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				key, isPrefix = key[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if isPrefix {
+			if key <= marker || len(result.Prefixes) > 0 && result.Prefixes[len(result.Prefixes)-1] == key {
+				return true
+			}
+			if full() {
+				return false
+			}
+			result.Prefixes = append(result.Prefixes, key)
+			lastKey, lastVersion = key, ""
+			return true
+		}
+		versions := n.objectVersions(jctx, bucket, key)
+		if key == marker {
+			for i, v := range versions {
+				if v.VersionID == versionMarker {
+					versions = versions[i+1:]
+					break
+				}
+			}
+		}
+		for _, v := range versions {
+			if full() {
+				return false
+			}
+			result.Objects = append(result.Objects, v)
+			lastKey, lastVersion = key, v.VersionID
+		}
+		return true
+	})
+	return
+}
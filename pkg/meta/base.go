@@ -71,7 +71,17 @@ type engine interface {
 	doSetXattr(ctx Context, inode Ino, name string, value []byte, flags uint32) syscall.Errno
 	doRemoveXattr(ctx Context, inode Ino, name string) syscall.Errno
 
+	GetXattr(ctx Context, inode Ino, name string, vbuff *[]byte) syscall.Errno
 	GetSession(sid uint64, detail bool) (*Session, error)
+
+	doGetToken(name string) ([]byte, error) // nil if not found
+	doListTokens() (map[string][]byte, error)
+	doSetToken(name string, data []byte) error
+	doDeleteToken(name string) (bool, error)
+
+	doListIdNames() (map[string]uint32, error) // "u:" + user or "g:" + group -> id
+	doSetIdName(key string, id uint32) error
+	doDeleteIdName(key string) (bool, error)
 }
 
 type baseMeta struct {
@@ -84,6 +94,7 @@ type baseMeta struct {
 	sid          uint64
 	of           *openfiles
 	removedFiles map[Ino]bool
+	writtenFiles map[Ino]bool
 	compacting   map[uint64]bool
 	maxDeleting  chan struct{}
 	symlinks     *sync.Map
@@ -113,6 +124,7 @@ func newBaseMeta(conf *Config) baseMeta {
 		root:         1,
 		of:           newOpenFiles(conf.OpenCache),
 		removedFiles: make(map[Ino]bool),
+		writtenFiles: make(map[Ino]bool),
 		compacting:   make(map[uint64]bool),
 		maxDeleting:  make(chan struct{}, 100),
 		symlinks:     &sync.Map{},
@@ -217,6 +229,11 @@ func (m *baseMeta) refreshSession() {
 		if _, err := m.Load(false); err != nil {
 			logger.Warnf("reload setting: %s", err)
 		}
+		if m.conf.Token != "" {
+			if _, err := m.verifyToken(m.conf.Token); err != nil {
+				logger.Warnf("The token of this client is not valid any more (%s), it should be umounted", err)
+			}
+		}
 		if m.conf.NoBGJob {
 			continue
 		}
@@ -628,7 +645,11 @@ func (m *baseMeta) Mknod(ctx Context, parent Ino, name string, _type uint8, mode
 	if m.checkQuota(4<<10, 1) {
 		return syscall.ENOSPC
 	}
-	return m.en.doMknod(ctx, m.checkRoot(parent), name, _type, mode, cumask, rdev, path, inode, attr)
+	st := m.en.doMknod(ctx, m.checkRoot(parent), name, _type, mode, cumask, rdev, path, inode, attr)
+	if st == 0 && _type == TypeFile && inode != nil {
+		m.commitRetention(ctx, *inode)
+	}
+	return st
 }
 
 func (m *baseMeta) Create(ctx Context, parent Ino, name string, mode uint16, cumask uint16, flags uint32, inode *Ino, attr *Attr) syscall.Errno {
@@ -637,10 +658,15 @@ func (m *baseMeta) Create(ctx Context, parent Ino, name string, mode uint16, cum
 	}
 	eno := m.Mknod(ctx, parent, name, TypeFile, mode, cumask, 0, "", inode, attr)
 	if eno == syscall.EEXIST && (flags&syscall.O_EXCL) == 0 && attr.Typ == TypeFile {
-		eno = 0
+		if eno = checkOpen(attr, flags); eno == 0 && inode != nil {
+			eno = m.checkRetention(ctx, *inode)
+		}
 	}
 	if eno == 0 && inode != nil {
 		m.of.Open(*inode, attr)
+		m.Lock()
+		m.writtenFiles[*inode] = true
+		m.Unlock()
 	}
 	return eno
 }
@@ -743,14 +769,37 @@ func (m *baseMeta) Rename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 	}
 
 	defer timeit(time.Now())
-	return m.en.doRename(ctx, m.checkRoot(parentSrc), nameSrc, m.checkRoot(parentDst), nameDst, flags, inode, attr)
+	parentSrc, parentDst = m.checkRoot(parentSrc), m.checkRoot(parentDst)
+	st := m.en.doRename(ctx, parentSrc, nameSrc, parentDst, nameDst, flags, inode, attr)
+	if st == 0 && inode != nil {
+		m.commitRetention(ctx, *inode)
+	}
+	return st
 }
 
 func (m *baseMeta) Open(ctx Context, inode Ino, flags uint32, attr *Attr) syscall.Errno {
 	if m.conf.ReadOnly && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
 		return syscall.EROFS
 	}
+	if attr == nil && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		attr = &Attr{}
+	}
 	if m.conf.OpenCache > 0 && m.of.OpenCheck(inode, attr) {
+		if attr != nil {
+			if err := checkOpen(attr, flags); err != 0 {
+				m.of.Close(inode)
+				return err
+			}
+		}
+		if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+			if err := m.checkRetention(ctx, inode); err != 0 {
+				m.of.Close(inode)
+				return err
+			}
+			m.Lock()
+			m.writtenFiles[inode] = true
+			m.Unlock()
+		}
 		return 0
 	}
 	var err syscall.Errno
@@ -758,8 +807,19 @@ func (m *baseMeta) Open(ctx Context, inode Ino, flags uint32, attr *Attr) syscal
 	if attr != nil && !attr.Full {
 		err = m.GetAttr(ctx, inode, attr)
 	}
+	if err == 0 && attr != nil {
+		err = checkOpen(attr, flags)
+	}
+	if err == 0 && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		err = m.checkRetention(ctx, inode)
+	}
 	if err == 0 {
 		m.of.Open(inode, attr)
+		if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+			m.Lock()
+			m.writtenFiles[inode] = true
+			m.Unlock()
+		}
 	}
 	return err
 }
@@ -788,10 +848,16 @@ func (m *baseMeta) NewChunk(ctx Context, chunkid *uint64) syscall.Errno {
 func (m *baseMeta) Close(ctx Context, inode Ino) syscall.Errno {
 	if m.of.Close(inode) {
 		m.Lock()
-		defer m.Unlock()
+		written := m.writtenFiles[inode]
+		delete(m.writtenFiles, inode)
 		if m.removedFiles[inode] {
 			delete(m.removedFiles, inode)
 			_ = m.en.doDeleteSustainedInode(m.sid, inode)
+			written = false
+		}
+		m.Unlock()
+		if written {
+			m.commitRetention(ctx, inode)
 		}
 	}
 	return 0
@@ -831,7 +897,18 @@ func (m *baseMeta) SetXattr(ctx Context, inode Ino, name string, value []byte, f
 	}
 
 	defer timeit(time.Now())
-	return m.en.doSetXattr(ctx, m.checkRoot(inode), name, value, flags)
+	inode = m.checkRoot(inode)
+	var attr Attr
+	if err := m.GetAttr(ctx, inode, &attr); err != 0 {
+		return err
+	}
+	if attr.Flags&FlagImmutable != 0 {
+		return syscall.EPERM
+	}
+	if st := m.checkRetentionXattr(ctx, inode, &attr, name, value, false); st != 0 {
+		return st
+	}
+	return m.en.doSetXattr(ctx, inode, name, value, flags)
 }
 
 func (m *baseMeta) RemoveXattr(ctx Context, inode Ino, name string) syscall.Errno {
@@ -843,7 +920,18 @@ func (m *baseMeta) RemoveXattr(ctx Context, inode Ino, name string) syscall.Errn
 	}
 
 	defer timeit(time.Now())
-	return m.en.doRemoveXattr(ctx, m.checkRoot(inode), name)
+	inode = m.checkRoot(inode)
+	var attr Attr
+	if err := m.GetAttr(ctx, inode, &attr); err != 0 {
+		return err
+	}
+	if attr.Flags&FlagImmutable != 0 {
+		return syscall.EPERM
+	}
+	if st := m.checkRetentionXattr(ctx, inode, &attr, name, nil, true); st != 0 {
+		return st
+	}
+	return m.en.doRemoveXattr(ctx, inode, name)
 }
 
 func (m *baseMeta) fileDeleted(opened bool, inode Ino, length uint64) {
@@ -980,6 +1068,11 @@ func (m *baseMeta) doCleanupTrash(force bool) {
 				entries = entries[1:]
 			}
 			for _, se := range subEntries {
+				if m.fmt.EnableRetention && se.Attr.Typ == TypeFile && m.retainUntil(ctx, se.Inode) > now.Unix() {
+					logger.Debugf("skip retained file in trash: %s/%s", e.Name, se.Name)
+					rmdir = false
+					continue
+				}
 				if se.Attr.Typ == TypeDirectory {
 					st = m.en.doRmdir(ctx, e.Inode, string(se.Name))
 				} else {
@@ -40,6 +40,9 @@ type Config struct {
 	Heartbeat   time.Duration
 	MountPoint  string
 	Subdir      string
+	Token       string `json:"-"` // limits the client to the subtree of the token
+	UidMap      IdMap  // uids of the client -> uids in the volume
+	GidMap      IdMap  // gids of the client -> gids in the volume
 }
 
 type Format struct {
@@ -56,8 +59,11 @@ type Format struct {
 	Capacity         uint64
 	Inodes           uint64
 	EncryptKey       string `json:",omitempty"`
+	EncryptAlgo      string `json:",omitempty"`
 	KeyEncrypted     bool
 	TrashDays        int
+	EnableRetention  bool `json:",omitempty"` // WORM directories, which can't be disabled once enabled
+	RequireToken     bool `json:",omitempty"` // the clients serving the files must use a token
 	MetaVersion      int
 	MinClientVersion string
 	MaxClientVersion string
@@ -82,8 +88,12 @@ func (f *Format) update(old *Format, force bool) error {
 			args = []interface{}{"shards", old.Shards, f.Shards}
 		case f.HashPrefix != old.HashPrefix:
 			args = []interface{}{"hash prefix", old.HashPrefix, f.HashPrefix}
+		case f.EncryptAlgo != old.EncryptAlgo:
+			args = []interface{}{"encrypt algorithm", old.EncryptAlgo, f.EncryptAlgo}
 		case f.MetaVersion != old.MetaVersion:
 			args = []interface{}{"meta version", old.MetaVersion, f.MetaVersion}
+		case old.EnableRetention && !f.EnableRetention:
+			args = []interface{}{"retention", old.EnableRetention, f.EnableRetention}
 		}
 		if args == nil {
 			f.UUID = old.UUID
@@ -0,0 +1,151 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IdMap maps the ids of a client to the ones stored in the volume, other ids are not changed.
+type IdMap map[uint32]uint32
+
+// ParseIdMap parses a mapping like "1000:2000,1001:2001".
+func ParseIdMap(s string) (IdMap, error) {
+	m := make(IdMap)
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		ps := strings.Split(p, ":")
+		if len(ps) != 2 {
+			return nil, fmt.Errorf("invalid id mapping %q", p)
+		}
+		from, err := strconv.ParseUint(ps[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id mapping %q", p)
+		}
+		to, err := strconv.ParseUint(ps[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id mapping %q", p)
+		}
+		if _, ok := m[uint32(from)]; ok {
+			return nil, fmt.Errorf("duplicated id %d in mapping", from)
+		}
+		m[uint32(from)] = uint32(to)
+	}
+	return m, nil
+}
+
+func (m IdMap) String() string {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	ps := make([]string, len(ids))
+	for i, id := range ids {
+		ps[i] = fmt.Sprintf("%d:%d", id, m[uint32(id)])
+	}
+	return strings.Join(ps, ",")
+}
+
+// Map returns the id in the volume for the id of a client.
+func (m IdMap) Map(id uint32) uint32 {
+	if to, ok := m[id]; ok {
+		return to
+	}
+	return id
+}
+
+// Unmap returns the id of a client for the id in the volume, the smallest one is used
+// if multiple ids are mapped to it.
+func (m IdMap) Unmap(id uint32) uint32 {
+	var found bool
+	var from uint32
+	for k, v := range m {
+		if v == id && (!found || k < from) {
+			found = true
+			from = k
+		}
+	}
+	if found {
+		return from
+	}
+	return id
+}
+
+// Update adds the mappings in o, which override the existing ones.
+func (m IdMap) Update(o IdMap) {
+	for k, v := range o {
+		m[k] = v
+	}
+}
+
+func idNameKey(group bool, name string) string {
+	if group {
+		return "g:" + name
+	}
+	return "u:" + name
+}
+
+func checkIdName(name string) error {
+	if name == "" || strings.ContainsAny(name, ": \t\n") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// ListIdNames returns the shared mapping from the names of users and groups to the ids in the volume.
+func (m *baseMeta) ListIdNames() (users, groups map[string]uint32, err error) {
+	vals, err := m.en.doListIdNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	users = make(map[string]uint32)
+	groups = make(map[string]uint32)
+	for k, id := range vals {
+		switch {
+		case strings.HasPrefix(k, "u:"):
+			users[k[2:]] = id
+		case strings.HasPrefix(k, "g:"):
+			groups[k[2:]] = id
+		default:
+			logger.Warnf("invalid id name %q", k)
+		}
+	}
+	return users, groups, nil
+}
+
+// SetIdName maps the name of a user (or a group) to an id in the volume.
+func (m *baseMeta) SetIdName(group bool, name string, id uint32) error {
+	if err := checkIdName(name); err != nil {
+		return err
+	}
+	return m.en.doSetIdName(idNameKey(group, name), id)
+}
+
+// RemoveIdName removes the mapping of a user (or a group).
+func (m *baseMeta) RemoveIdName(group bool, name string) error {
+	found, err := m.en.doDeleteIdName(idNameKey(group, name))
+	if err == nil && !found {
+		err = fmt.Errorf("%s is not mapped", name)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import "testing"
+
+func TestIdMap(t *testing.T) {
+	m, err := ParseIdMap("1001:2001, 1000:2000,0:2000")
+	if err != nil {
+		t.Fatalf("parse id map: %s", err)
+	}
+	if m.String() != "0:2000,1000:2000,1001:2001" {
+		t.Fatalf("unexpected id map: %s", m)
+	}
+	if m.Map(1000) != 2000 || m.Map(5) != 5 {
+		t.Fatalf("map: %d %d", m.Map(1000), m.Map(5))
+	}
+	if m.Unmap(2000) != 0 || m.Unmap(2001) != 1001 || m.Unmap(5) != 5 {
+		t.Fatalf("unmap: %d %d %d", m.Unmap(2000), m.Unmap(2001), m.Unmap(5))
+	}
+	var empty IdMap
+	if empty.Map(1) != 1 || empty.Unmap(1) != 1 {
+		t.Fatalf("empty map should not change ids")
+	}
+	for _, s := range []string{"1000", "a:1", "1:b", "1:2:3", "1:2,1:3", "-1:2"} {
+		if _, err = ParseIdMap(s); err == nil {
+			t.Fatalf("parse invalid id map %q should fail", s)
+		}
+	}
+}
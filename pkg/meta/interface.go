@@ -42,6 +42,10 @@ const (
 	Info = 1003
 	// FillCache is a message to build cache for target directories/files
 	FillCache = 1004
+	// QoS is a message to get or update the QoS settings of a mount point
+	QoS = 1005
+	// Reload is a message to reload the config file of a mount point
+	Reload = 1006
 )
 
 const (
@@ -71,6 +75,14 @@ const (
 	SetAttrCtime
 	SetAttrAtimeNow
 	SetAttrMtimeNow
+	SetAttrFlag
+)
+
+const (
+	// FlagImmutable is an inode flag that the file can not be modified, removed or renamed (chattr +i)
+	FlagImmutable = 1 << iota
+	// FlagAppend is an inode flag that the file can only be appended (chattr +a)
+	FlagAppend
 )
 
 const MaxName = 255
@@ -91,7 +103,7 @@ type MsgCallback func(...interface{}) error
 
 // Attr represents attributes of a node.
 type Attr struct {
-	Flags     uint8  // reserved flags
+	Flags     uint8  // inode flags (FlagImmutable, FlagAppend)
 	Typ       uint8  // type of a node
 	Mode      uint16 // permission mode
 	Uid       uint32 // owner id
@@ -335,6 +347,20 @@ type Meta interface {
 	// OnMsg add a callback for the given message type.
 	OnMsg(mtype uint32, cb MsgCallback)
 
+	// CreateToken saves a new token and returns the string used by clients.
+	CreateToken(t *Token) (string, error)
+	// ListTokens returns all the tokens without secrets.
+	ListTokens() ([]*Token, error)
+	// RevokeToken removes a token.
+	RevokeToken(name string) error
+
+	// ListIdNames returns the shared mapping from names of users and groups to ids.
+	ListIdNames() (users, groups map[string]uint32, err error)
+	// SetIdName maps the name of a user (or a group) to an id.
+	SetIdName(group bool, name string, id uint32) error
+	// RemoveIdName removes the mapping of a user (or a group).
+	RemoveIdName(group bool, name string) error
+
 	// Dump the tree under root, which may be modified by checkRoot
 	DumpMeta(w io.Writer, root Ino) error
 	LoadMeta(r io.Reader) error
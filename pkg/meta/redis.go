@@ -202,7 +202,7 @@ func newRedisMeta(driver, addr string, conf *Config) (Meta, error) {
 	}
 	m.en = m
 	m.checkServerConfig()
-	m.root, err = lookupSubdir(m, conf.Subdir)
+	m.root, err = m.lookupRoot()
 	return m, err
 }
 
@@ -285,6 +285,61 @@ func (m *redisMeta) doLoad() ([]byte, error) {
 	return body, err
 }
 
+func (m *redisMeta) doGetToken(name string) ([]byte, error) {
+	data, err := m.rdb.HGet(Background, m.tokens(), name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (m *redisMeta) doListTokens() (map[string][]byte, error) {
+	vals, err := m.rdb.HGetAll(Background, m.tokens()).Result()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string][]byte, len(vals))
+	for name, v := range vals {
+		tokens[name] = []byte(v)
+	}
+	return tokens, nil
+}
+
+func (m *redisMeta) doSetToken(name string, data []byte) error {
+	return m.rdb.HSet(Background, m.tokens(), name, data).Err()
+}
+
+func (m *redisMeta) doDeleteToken(name string) (bool, error) {
+	n, err := m.rdb.HDel(Background, m.tokens(), name).Result()
+	return n > 0, err
+}
+
+func (m *redisMeta) doListIdNames() (map[string]uint32, error) {
+	vals, err := m.rdb.HGetAll(Background, m.idNames()).Result()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]uint32, len(vals))
+	for k, v := range vals {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			logger.Warnf("invalid id of %s: %s", k, v)
+			continue
+		}
+		names[k] = uint32(id)
+	}
+	return names, nil
+}
+
+func (m *redisMeta) doSetIdName(key string, id uint32) error {
+	return m.rdb.HSet(Background, m.idNames(), key, id).Err()
+}
+
+func (m *redisMeta) doDeleteIdName(key string) (bool, error) {
+	n, err := m.rdb.HDel(Background, m.idNames(), key).Result()
+	return n > 0, err
+}
+
 func (m *redisMeta) doNewSession(sinfo []byte) error {
 	err := m.rdb.ZAdd(Background, m.allSessions(), &redis.Z{
 		Score:  float64(m.expireTime()),
@@ -516,6 +571,14 @@ func (m *redisMeta) setting() string {
 	return m.prefix + "setting"
 }
 
+func (m *redisMeta) tokens() string {
+	return m.prefix + "tokens"
+}
+
+func (m *redisMeta) idNames() string {
+	return m.prefix + "idNames"
+}
+
 func (m *redisMeta) usedSpaceKey() string {
 	return m.prefix + usedSpace
 }
@@ -792,6 +855,9 @@ func (m *redisMeta) txn(ctx Context, txf func(tx *redis.Tx) error, keys ...strin
 
 func (m *redisMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	f := m.of.find(inode)
 	if f != nil {
 		f.Lock()
@@ -806,7 +872,7 @@ func (m *redisMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64,
 			return err
 		}
 		m.parseAttr(a, &t)
-		if t.Typ != TypeFile {
+		if t.Typ != TypeFile || protected(t.Flags) {
 			return syscall.EPERM
 		}
 		if length == t.Length {
@@ -905,6 +971,9 @@ func (m *redisMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, si
 	if size == 0 {
 		return syscall.EINVAL
 	}
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer timeit(time.Now())
 	f := m.of.find(inode)
 	if f != nil {
@@ -923,7 +992,7 @@ func (m *redisMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, si
 		if t.Typ == TypeFIFO {
 			return syscall.EPIPE
 		}
-		if t.Typ != TypeFile {
+		if t.Typ != TypeFile || protected(t.Flags) {
 			return syscall.EPERM
 		}
 		length := t.Length
@@ -947,7 +1016,9 @@ func (m *redisMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, si
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(&t), 0)
 			if mode&(fallocZeroRange|fallocPunchHole) != 0 {
-				if off+size > old {
+				if off >= old {
+					size = 0
+				} else if off+size > old {
 					size = old - off
 				}
 				for size > 0 {
@@ -976,6 +1047,9 @@ func (m *redisMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, si
 func (m *redisMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
 	inode = m.checkRoot(inode)
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer func() { m.of.InvalidateChunk(inode, 0xFFFFFFFE) }()
 	return errno(m.txn(ctx, func(tx *redis.Tx) error {
 		var cur Attr
@@ -984,6 +1058,9 @@ func (m *redisMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode u
 			return err
 		}
 		m.parseAttr(a, &cur)
+		if st := checkSetAttr(ctx, &cur, set, attr); st != 0 {
+			return st
+		}
 		if (set&(SetAttrUID|SetAttrGID)) != 0 && (set&SetAttrMode) != 0 {
 			attr.Mode |= (cur.Mode & 06000)
 		}
@@ -1011,6 +1088,10 @@ func (m *redisMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode u
 				changed = true
 			}
 		}
+		if set&SetAttrFlag != 0 && cur.Flags != attr.Flags {
+			cur.Flags = attr.Flags
+			changed = true
+		}
 		now := time.Now()
 		if set&SetAttrAtime != 0 && (cur.Atime != attr.Atime || cur.Atimensec != attr.Atimensec) {
 			cur.Atime = attr.Atime
@@ -1070,6 +1151,7 @@ func (m *redisMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, m
 		attr = &Attr{}
 	}
 	attr.Typ = _type
+	attr.Flags = 0
 	attr.Mode = mode & ^cumask
 	attr.Uid = ctx.Uid()
 	attr.Gid = ctx.Gid()
@@ -1101,6 +1183,9 @@ func (m *redisMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, m
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if pattr.Flags&FlagImmutable != 0 {
+			return syscall.EPERM
+		}
 
 		buf, err := tx.HGet(ctx, m.entryKey(parent), name).Bytes()
 		if err != nil && err != redis.Nil {
@@ -1181,6 +1266,18 @@ func (m *redisMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, m
 	return errno(err)
 }
 
+// checkRetainedTx checks the retention of a file in the transaction.
+func (m *redisMeta) checkRetainedTx(ctx Context, tx *redis.Tx, inode Ino) syscall.Errno {
+	if !m.fmt.EnableRetention {
+		return 0
+	}
+	v, err := tx.HGet(ctx, m.xattrKey(inode), RetainUntilXattr).Bytes()
+	if err != nil && err != redis.Nil {
+		return errno(err)
+	}
+	return m.checkRetained(inode, v)
+}
+
 func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 	var _type uint8
 	var trash, inode Ino
@@ -1210,7 +1307,11 @@ func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno
 		if _type == TypeDirectory {
 			return syscall.EPERM
 		}
-		if err := tx.Watch(ctx, m.inodeKey(inode)).Err(); err != nil {
+		watched := []string{m.inodeKey(inode)}
+		if m.fmt.EnableRetention {
+			watched = append(watched, m.xattrKey(inode))
+		}
+		if err := tx.Watch(ctx, watched...).Err(); err != nil {
 			return err
 		}
 		rs, _ := tx.MGet(ctx, m.inodeKey(parent), m.inodeKey(inode)).Result()
@@ -1222,6 +1323,9 @@ func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pattr.Flags) {
+			return syscall.EPERM
+		}
 		var updateParent bool
 		now := time.Now()
 		if !isTrash(parent) && now.Sub(time.Unix(pattr.Mtime, int64(pattr.Mtimensec))) >= minUpdateTime {
@@ -1238,6 +1342,14 @@ func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno
 			if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
 				return syscall.EACCES
 			}
+			if protected(attr.Flags) {
+				return syscall.EPERM
+			}
+			if _type == TypeFile {
+				if st := m.checkRetainedTx(ctx, tx, inode); st != 0 {
+					return st
+				}
+			}
 			attr.Ctime = now.Unix()
 			attr.Ctimensec = uint32(now.Nanosecond())
 			if trash == 0 {
@@ -1336,6 +1448,9 @@ func (m *redisMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pattr.Flags) {
+			return syscall.EPERM
+		}
 		now := time.Now()
 		pattr.Nlink--
 		pattr.Mtime = now.Unix()
@@ -1355,6 +1470,9 @@ func (m *redisMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno
 			if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
 				return syscall.EACCES
 			}
+			if protected(attr.Flags) {
+				return syscall.EPERM
+			}
 			if trash > 0 {
 				attr.Ctime = now.Unix()
 				attr.Ctimensec = uint32(now.Nanosecond())
@@ -1417,6 +1535,9 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 			return nil
 		}
 		keys = []string{m.inodeKey(ino)}
+		if m.fmt.EnableRetention && typ == TypeFile {
+			keys = append(keys, m.xattrKey(ino))
+		}
 
 		dbuf, err := tx.HGet(ctx, m.entryKey(parentDst), nameDst).Bytes()
 		if err == redis.Nil && m.conf.CaseInsensi {
@@ -1437,6 +1558,8 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 			keys = append(keys, m.inodeKey(dino))
 			if dtyp == TypeDirectory {
 				keys = append(keys, m.entryKey(dino))
+			} else if m.fmt.EnableRetention && dtyp == TypeFile {
+				keys = append(keys, m.xattrKey(dino))
 			}
 			if !exchange {
 				if st := m.checkTrash(parentDst, &trash); st != 0 {
@@ -1466,6 +1589,14 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 			return syscall.ENOTDIR
 		}
 		m.parseAttr([]byte(rs[2].(string)), &iattr)
+		if protected(sattr.Flags) || dattr.Flags&FlagImmutable != 0 || protected(iattr.Flags) {
+			return syscall.EPERM
+		}
+		if typ == TypeFile {
+			if st := m.checkRetainedTx(ctx, tx, ino); st != 0 {
+				return st
+			}
+		}
 
 		var supdate, dupdate bool
 		now := time.Now()
@@ -1477,6 +1608,14 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 				trash = 0
 			}
 			m.parseAttr([]byte(rs[3].(string)), &tattr)
+			if protected(dattr.Flags) || protected(tattr.Flags) {
+				return syscall.EPERM
+			}
+			if dtyp == TypeFile {
+				if st := m.checkRetainedTx(ctx, tx, dino); st != 0 {
+					return st
+				}
+			}
 			tattr.Ctime = now.Unix()
 			tattr.Ctimensec = uint32(now.Nanosecond())
 			if exchange {
@@ -1635,7 +1774,7 @@ func (m *redisMeta) doLink(ctx Context, inode, parent Ino, name string, attr *At
 			updateParent = true
 		}
 		m.parseAttr([]byte(rs[1].(string)), &iattr)
-		if iattr.Typ == TypeDirectory {
+		if iattr.Typ == TypeDirectory || pattr.Flags&FlagImmutable != 0 || protected(iattr.Flags) {
 			return syscall.EPERM
 		}
 		iattr.Ctime = now.Unix()
@@ -1930,6 +2069,9 @@ func (m *redisMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice
 		if attr.Typ != TypeFile {
 			return syscall.EPERM
 		}
+		if st := checkWrite(&attr, uint64(indx)*ChunkSize+uint64(off)); st != 0 {
+			return st
+		}
 		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
 		if newleng > attr.Length {
 			newSpace = align4K(newleng) - align4K(attr.Length)
@@ -2003,6 +2145,9 @@ func (m *redisMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino,
 		if attr.Typ != TypeFile {
 			return syscall.EINVAL
 		}
+		if st := checkWrite(&attr, offOut); st != 0 {
+			return st
+		}
 
 		newleng := offOut + size
 		if newleng > attr.Length {
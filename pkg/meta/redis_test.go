@@ -68,6 +68,10 @@ func testMeta(t *testing.T, m Meta) {
 	testTrash(t, m)
 	testRemove(t, m)
 	testStickyBit(t, m)
+	testFlags(t, m)
+	testRetention(t, m)
+	testTokens(t, m, base)
+	testIdNames(t, m)
 	testLocks(t, m)
 	testConcurrentWrite(t, m)
 	testCompaction(t, m, false)
@@ -431,6 +435,30 @@ func testMetaClient(t *testing.T, m Meta) {
 	if len(chunks) != 3 || chunks[1].Chunkid != 0 || chunks[1].Len != 50 || chunks[2].Chunkid != chunkid || chunks[2].Len != 50 {
 		t.Fatalf("chunks: %v", chunks)
 	}
+	if st := m.Fallocate(ctx, inode, fallocPunchHole|fallocKeepSize, 300, 50); st != 0 {
+		t.Fatalf("punch hole beyond EOF: %s", st)
+	}
+	if st := m.Read(ctx, inode, 0, &chunks); st != 0 || len(chunks) != 3 {
+		t.Fatalf("read chunk: %s %v", st, chunks)
+	}
+	for _, c := range []struct {
+		off    uint64
+		whence int
+		pos    uint64
+		st     syscall.Errno
+	}{
+		{0, SeekData, 150, 0},
+		{170, SeekData, 170, 0},
+		{200, SeekData, 0, syscall.ENXIO},
+		{0, SeekHole, 0, 0},
+		{120, SeekHole, 120, 0},
+		{160, SeekHole, 200, 0},
+		{0, 0, 0, syscall.EINVAL},
+	} {
+		if pos, st := SeekDataHole(m, ctx, inode, c.off, 200, c.whence); st != c.st || pos != c.pos {
+			t.Fatalf("seek %d from %d: expect %d %s, but got %d %s", c.whence, c.off, c.pos, c.st, pos, st)
+		}
+	}
 
 	// xattr
 	if st := m.SetXattr(ctx, inode, "a", []byte("v"), XattrCreateOrReplace); st != 0 {
@@ -602,6 +630,380 @@ func testStickyBit(t *testing.T, m Meta) {
 	}
 }
 
+func testFlags(t *testing.T, m Meta) {
+	_ = m.Init(Format{Name: "test"}, false)
+	ctx := Background
+	var dir, inode Ino
+	var attr = &Attr{}
+	m.Mkdir(ctx, 1, "flags", 0777, 0, 0, &dir, attr)
+	m.Create(ctx, dir, "f", 0644, 0, 0, &inode, attr)
+	m.Write(ctx, inode, 0, 0, Slice{Chunkid: 1, Size: 100, Len: 100})
+	ctxA := NewContext(1, 1, []uint32{1})
+	if e := m.SetAttr(ctxA, inode, SetAttrFlag, 0, &Attr{Flags: FlagImmutable}); e != syscall.EPERM {
+		t.Fatalf("set flags by non-root: %s", e)
+	}
+	if e := m.SetAttr(ctx, inode, SetAttrFlag, 0, &Attr{Flags: 0x80}); e != syscall.EINVAL {
+		t.Fatalf("set unknown flags: %s", e)
+	}
+	if e := m.SetAttr(ctx, inode, SetAttrFlag, 0, &Attr{Flags: FlagImmutable}); e != 0 {
+		t.Fatalf("set immutable: %s", e)
+	}
+	if e := m.GetAttr(ctx, inode, attr); e != 0 || attr.Flags != FlagImmutable {
+		t.Fatalf("getattr: %s %d", e, attr.Flags)
+	}
+	if e := m.Open(ctx, inode, syscall.O_WRONLY, &Attr{}); e != syscall.EPERM {
+		t.Fatalf("open immutable for write: %s", e)
+	}
+	if e := m.Write(ctx, inode, 0, 100, Slice{Chunkid: 2, Size: 100, Len: 100}); e != syscall.EPERM {
+		t.Fatalf("write immutable: %s", e)
+	}
+	if e := m.Truncate(ctx, inode, 0, 0, attr); e != syscall.EPERM {
+		t.Fatalf("truncate immutable: %s", e)
+	}
+	if e := m.SetAttr(ctx, inode, SetAttrMode, 0, &Attr{Mode: 0600}); e != syscall.EPERM {
+		t.Fatalf("chmod immutable: %s", e)
+	}
+	if e := m.SetXattr(ctx, inode, "user.a", []byte("v"), 0); e != syscall.EPERM {
+		t.Fatalf("setxattr immutable: %s", e)
+	}
+	if e := m.Link(ctx, inode, dir, "l", attr); e != syscall.EPERM {
+		t.Fatalf("link immutable: %s", e)
+	}
+	if e := m.Rename(ctx, dir, "f", dir, "f2", 0, &inode, attr); e != syscall.EPERM {
+		t.Fatalf("rename immutable: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f"); e != syscall.EPERM {
+		t.Fatalf("unlink immutable: %s", e)
+	}
+
+	if e := m.SetAttr(ctx, inode, SetAttrFlag, 0, &Attr{Flags: FlagAppend}); e != 0 {
+		t.Fatalf("set append-only: %s", e)
+	}
+	if e := m.Open(ctx, inode, syscall.O_WRONLY, &Attr{}); e != syscall.EPERM {
+		t.Fatalf("open append-only without O_APPEND: %s", e)
+	}
+	if e := m.Open(ctx, inode, syscall.O_WRONLY|syscall.O_APPEND, &Attr{}); e != 0 {
+		t.Fatalf("open append-only with O_APPEND: %s", e)
+	}
+	_ = m.Close(ctx, inode)
+	if e := m.Write(ctx, inode, 0, 0, Slice{Chunkid: 2, Size: 100, Len: 100}); e != syscall.EPERM {
+		t.Fatalf("overwrite append-only: %s", e)
+	}
+	if e := m.Write(ctx, inode, 0, 100, Slice{Chunkid: 2, Size: 100, Len: 100}); e != 0 {
+		t.Fatalf("append append-only: %s", e)
+	}
+	var chunkid uint64
+	_ = m.NewChunk(ctx, &chunkid)
+	if e := m.Write(ctx, inode, 1, 0, Slice{Chunkid: chunkid, Size: 100, Len: 100}); e != 0 {
+		t.Fatalf("append next chunk: %s", e)
+	}
+	_ = m.NewChunk(ctx, &chunkid)
+	if e := m.Write(ctx, inode, 0, 200, Slice{Chunkid: chunkid, Size: 100, Len: 100}); e != syscall.EPERM {
+		t.Fatalf("write into the hole of append-only: %s", e)
+	}
+	if e := m.Fallocate(ctx, inode, fallocPunchHole|fallocKeepSize, 0, 100); e != syscall.EPERM {
+		t.Fatalf("punch hole in append-only: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f"); e != syscall.EPERM {
+		t.Fatalf("unlink append-only: %s", e)
+	}
+	if e := m.SetAttr(ctx, inode, SetAttrFlag, 0, &Attr{}); e != 0 {
+		t.Fatalf("clear flags: %s", e)
+	}
+
+	// directory
+	if e := m.SetAttr(ctx, dir, SetAttrFlag, 0, &Attr{Flags: FlagAppend}); e != 0 {
+		t.Fatalf("set append-only on dir: %s", e)
+	}
+	if e := m.Create(ctx, dir, "f2", 0644, 0, 0, &inode, attr); e != 0 {
+		t.Fatalf("create in append-only dir: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f2"); e != syscall.EPERM {
+		t.Fatalf("unlink in append-only dir: %s", e)
+	}
+	if e := m.Rename(ctx, dir, "f2", 1, "f2", 0, &inode, attr); e != syscall.EPERM {
+		t.Fatalf("rename out of append-only dir: %s", e)
+	}
+	if e := m.SetAttr(ctx, dir, SetAttrFlag, 0, &Attr{Flags: FlagImmutable}); e != 0 {
+		t.Fatalf("set immutable on dir: %s", e)
+	}
+	if e := m.Mkdir(ctx, dir, "d", 0755, 0, 0, &inode, attr); e != syscall.EPERM {
+		t.Fatalf("mkdir in immutable dir: %s", e)
+	}
+	if e := m.SetAttr(ctx, dir, SetAttrFlag, 0, &Attr{}); e != 0 {
+		t.Fatalf("clear flags on dir: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f"); e != 0 {
+		t.Fatalf("unlink f: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f2"); e != 0 {
+		t.Fatalf("unlink f2: %s", e)
+	}
+	if e := m.Rmdir(ctx, 1, "flags"); e != 0 {
+		t.Fatalf("rmdir flags: %s", e)
+	}
+}
+
+func testRetention(t *testing.T, m Meta) {
+	_ = m.Init(Format{Name: "test"}, false)
+	ctx := Background
+	var dir, inode, other Ino
+	var attr = &Attr{}
+	m.Mkdir(ctx, 1, "worm", 0777, 0, 0, &dir, attr)
+	if e := m.SetXattr(ctx, dir, RetentionXattr, []byte("1d"), 0); e != syscall.ENOTSUP {
+		t.Fatalf("set retention when it's disabled: %s", e)
+	}
+	if err := m.Init(Format{Name: "test", EnableRetention: true}, false); err != nil {
+		t.Fatalf("enable retention: %s", err)
+	}
+	defer func() { _ = m.Init(Format{Name: "test"}, true) }()
+	if err := m.Init(Format{Name: "test"}, false); err == nil {
+		t.Fatalf("retention should not be disabled")
+	}
+	if e := m.SetXattr(ctx, dir, RetentionXattr, []byte("forever"), 0); e != syscall.EINVAL {
+		t.Fatalf("set invalid retention: %s", e)
+	}
+	if e := m.SetXattr(ctx, dir, RetentionXattr, []byte("1d"), 0); e != 0 {
+		t.Fatalf("set retention: %s", e)
+	}
+	if e := m.SetXattr(ctx, dir, RetentionXattr, []byte("1h"), 0); e != syscall.EPERM {
+		t.Fatalf("shorten retention of directory: %s", e)
+	}
+	if e := m.SetXattr(NewContext(0, 1, []uint32{1}), dir, RetentionXattr, []byte("2d"), 0); e != syscall.EPERM {
+		t.Fatalf("set retention of directory by others: %s", e)
+	}
+	if e := m.RemoveXattr(ctx, dir, RetentionXattr); e != syscall.EPERM {
+		t.Fatalf("remove retention of directory: %s", e)
+	}
+	if e := m.Create(ctx, dir, "f", 0644, 0, syscall.O_WRONLY, &inode, attr); e != 0 {
+		t.Fatalf("create f: %s", e)
+	}
+	// retained once created, in case the client crashes before closing it
+	var value []byte
+	if e := m.GetXattr(ctx, inode, RetainUntilXattr, &value); e != 0 {
+		t.Fatalf("get retain-until of new file: %s", e)
+	}
+	var chunkid uint64
+	_ = m.NewChunk(ctx, &chunkid)
+	if e := m.Write(ctx, inode, 0, 0, Slice{Chunkid: chunkid, Size: 100, Len: 100}); e != 0 {
+		t.Fatalf("write f: %s", e)
+	}
+	if e := m.Rename(ctx, dir, "f", dir, "f1", 0, &inode, attr); e != 0 {
+		t.Fatalf("rename uncommitted f: %s", e)
+	}
+	_ = m.Close(ctx, inode)
+	if e := m.GetXattr(ctx, inode, RetainUntilXattr, &value); e != 0 {
+		t.Fatalf("get retain-until: %s", e)
+	}
+	until, _ := strconv.ParseInt(string(value), 10, 64)
+	if until < time.Now().Add(time.Hour*23).Unix() {
+		t.Fatalf("retain until %d", until)
+	}
+	if e := m.Open(ctx, inode, syscall.O_RDONLY, &Attr{}); e != 0 {
+		t.Fatalf("open retained file for read: %s", e)
+	}
+	_ = m.Close(ctx, inode)
+	if e := m.Open(ctx, inode, syscall.O_RDWR, &Attr{}); e != syscall.EPERM {
+		t.Fatalf("open retained file for write: %s", e)
+	}
+	if e := m.Create(ctx, dir, "f1", 0644, 0, syscall.O_WRONLY, &other, &Attr{}); e != syscall.EPERM {
+		t.Fatalf("create existing retained file: %s", e)
+	}
+	if e := m.Truncate(ctx, inode, 0, 0, attr); e != syscall.EPERM {
+		t.Fatalf("truncate retained file: %s", e)
+	}
+	if e := m.SetAttr(ctx, inode, SetAttrMode, 0, &Attr{Mode: 0600}); e != syscall.EPERM {
+		t.Fatalf("chmod retained file: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f1"); e != syscall.EPERM {
+		t.Fatalf("unlink retained file: %s", e)
+	}
+	if e := m.Rename(ctx, dir, "f1", dir, "f2", 0, &other, attr); e != syscall.EPERM {
+		t.Fatalf("rename retained file: %s", e)
+	}
+	m.Create(ctx, dir, "g", 0644, 0, syscall.O_WRONLY, &other, attr)
+	if e := m.Rename(ctx, dir, "g", dir, "f1", 0, &other, attr); e != syscall.EPERM {
+		t.Fatalf("overwrite retained file: %s", e)
+	}
+	_ = m.Close(ctx, other)
+	if e := m.SetXattr(ctx, inode, RetainUntilXattr, []byte(strconv.FormatInt(until-1, 10)), 0); e != syscall.EPERM {
+		t.Fatalf("shorten retention: %s", e)
+	}
+	if e := m.SetXattr(NewContext(0, 1, []uint32{1}), inode, RetainUntilXattr, []byte(strconv.FormatInt(until+3600, 10)), 0); e != syscall.EPERM {
+		t.Fatalf("extend retention by others: %s", e)
+	}
+	if e := m.SetXattr(ctx, inode, RetainUntilXattr, []byte(strconv.FormatInt(until+3600, 10)), 0); e != 0 {
+		t.Fatalf("extend retention: %s", e)
+	}
+	if e := m.RemoveXattr(ctx, inode, RetainUntilXattr); e != syscall.EPERM {
+		t.Fatalf("remove retention: %s", e)
+	}
+	if e := m.SetXattr(ctx, dir, RetentionXattr, []byte("2d"), 0); e != 0 {
+		t.Fatalf("extend retention of directory: %s", e)
+	}
+	// the file keeps its own retention wherever it is
+	var sub Ino
+	if e := m.Mkdir(ctx, dir, "sub", 0777, 0, 0, &sub, attr); e != 0 {
+		t.Fatalf("mkdir sub: %s", e)
+	}
+	if e := m.Link(ctx, inode, sub, "f3", attr); e != 0 {
+		t.Fatalf("link retained file: %s", e)
+	}
+	if e := m.Unlink(ctx, sub, "f3"); e != syscall.EPERM {
+		t.Fatalf("unlink retained file out of retention directory: %s", e)
+	}
+
+	// expired
+	if e := m.(engine).doSetXattr(ctx, inode, RetainUntilXattr, []byte("1"), 0); e != 0 {
+		t.Fatalf("expire retention: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "f1"); e != 0 {
+		t.Fatalf("unlink expired file: %s", e)
+	}
+	if e := m.Unlink(ctx, sub, "f3"); e != 0 {
+		t.Fatalf("unlink expired file: %s", e)
+	}
+	if e := m.Rmdir(ctx, dir, "sub"); e != 0 {
+		t.Fatalf("rmdir sub: %s", e)
+	}
+	if e := m.(engine).doSetXattr(ctx, other, RetainUntilXattr, []byte("1"), 0); e != 0 {
+		t.Fatalf("expire retention: %s", e)
+	}
+	if e := m.Unlink(ctx, dir, "g"); e != 0 {
+		t.Fatalf("unlink expired file: %s", e)
+	}
+	if e := m.Rmdir(ctx, 1, "worm"); e != 0 {
+		t.Fatalf("rmdir worm: %s", e)
+	}
+}
+
+func testTokens(t *testing.T, m Meta, base *baseMeta) {
+	if _, err := m.CreateToken(&Token{Name: "a/b"}); err == nil {
+		t.Fatalf("create token with invalid name should fail")
+	}
+	tk, err := m.CreateToken(&Token{Name: "t1", Subdir: "tenant/../tenant/a", UidMap: IdMap{1000: 2000}})
+	if err != nil {
+		t.Fatalf("create token: %s", err)
+	}
+	if !strings.HasPrefix(tk, "t1:") {
+		t.Fatalf("invalid token: %s", tk)
+	}
+	if _, err = m.CreateToken(&Token{Name: "t1"}); err == nil {
+		t.Fatalf("create existing token should fail")
+	}
+	tk2, err := m.CreateToken(&Token{Name: "t2", Subdir: "/tenant/b", ReadOnly: true})
+	if err != nil {
+		t.Fatalf("create token: %s", err)
+	}
+	tokens, err := m.ListTokens()
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("list tokens: %v %+v", err, tokens)
+	}
+	if tokens[0].Name != "t1" || tokens[0].Subdir != "/tenant/a" || tokens[0].Secret != "" || tokens[0].Created == 0 {
+		t.Fatalf("unexpected token: %+v", tokens[0])
+	}
+	ctx := Background
+	var parent, inode Ino
+	var attr Attr
+	if st := m.Lookup(ctx, 1, "tenant", &parent, &attr); st != 0 {
+		t.Fatalf("lookup tenant: %s", st)
+	}
+	if st := m.Lookup(ctx, parent, "a", &inode, &attr); st != 0 {
+		t.Fatalf("lookup tenant/a: %s", st)
+	}
+
+	conf := *base.conf
+	defer func() { *base.conf = conf }()
+	base.conf.Token = tk
+	base.conf.Subdir = "/../.."
+	if root, err := base.lookupRoot(); err != nil || root != inode {
+		t.Fatalf("root of token: %d (expect %d) %v", root, inode, err)
+	}
+	if base.conf.Subdir != "/tenant/a" || base.conf.ReadOnly || base.conf.UidMap.Map(1000) != 2000 {
+		t.Fatalf("unexpected config: %+v", base.conf)
+	}
+	base.conf.Token = strings.Replace(tk, "t1:", "t2:", 1)
+	if _, err = base.lookupRoot(); err == nil {
+		t.Fatalf("token with wrong secret should fail")
+	}
+	*base.conf = conf
+	base.conf.Token = tk[:len(tk)-1]
+	if _, err = base.lookupRoot(); err == nil {
+		t.Fatalf("token with wrong secret should fail")
+	}
+	if err = m.RevokeToken("t1"); err != nil {
+		t.Fatalf("revoke token: %s", err)
+	}
+	if err = m.RevokeToken("t1"); err == nil {
+		t.Fatalf("revoke token twice should fail")
+	}
+	*base.conf = conf
+	base.conf.Token = tk
+	if _, err = base.lookupRoot(); err == nil {
+		t.Fatalf("revoked token should fail")
+	}
+	*base.conf = conf
+	base.conf.Token = tk2
+	if _, err = base.lookupRoot(); err != nil || !base.conf.ReadOnly || base.conf.Subdir != "/tenant/b" {
+		t.Fatalf("read-only token: %v %+v", err, base.conf)
+	}
+	*base.conf = conf
+
+	format, err := m.Load(false)
+	if err != nil {
+		t.Fatalf("load format: %s", err)
+	}
+	format.RequireToken = true
+	if err = m.Init(*format, false); err != nil {
+		t.Fatalf("require token: %s", err)
+	}
+	if err = format.CheckToken(&Config{}); err == nil {
+		t.Fatalf("client without token should fail")
+	}
+	if err = format.CheckToken(&Config{Token: tk2}); err != nil {
+		t.Fatalf("client with token: %s", err)
+	}
+	*base.conf = conf
+	format.RequireToken = false
+	if err = m.Init(*format, false); err != nil {
+		t.Fatalf("not require token: %s", err)
+	}
+	if err = m.RevokeToken("t2"); err != nil {
+		t.Fatalf("revoke token: %s", err)
+	}
+}
+
+func testIdNames(t *testing.T, m Meta) {
+	if err := m.SetIdName(false, "a:b", 1); err == nil {
+		t.Fatalf("set invalid name should fail")
+	}
+	if err := m.SetIdName(false, "alice", 2000); err != nil {
+		t.Fatalf("set user: %s", err)
+	}
+	if err := m.SetIdName(false, "alice", 2001); err != nil {
+		t.Fatalf("update user: %s", err)
+	}
+	if err := m.SetIdName(true, "alice", 3000); err != nil {
+		t.Fatalf("set group: %s", err)
+	}
+	users, groups, err := m.ListIdNames()
+	if err != nil || len(users) != 1 || users["alice"] != 2001 || len(groups) != 1 || groups["alice"] != 3000 {
+		t.Fatalf("list names: %v %v %v", err, users, groups)
+	}
+	if err = m.RemoveIdName(false, "alice"); err != nil {
+		t.Fatalf("remove user: %s", err)
+	}
+	if err = m.RemoveIdName(false, "alice"); err == nil {
+		t.Fatalf("remove user twice should fail")
+	}
+	if err = m.RemoveIdName(true, "alice"); err != nil {
+		t.Fatalf("remove group: %s", err)
+	}
+	if users, groups, err = m.ListIdNames(); err != nil || len(users) != 0 || len(groups) != 0 {
+		t.Fatalf("list names: %v %v %v", err, users, groups)
+	}
+}
+
 func testLocks(t *testing.T, m Meta) {
 	_ = m.Init(Format{Name: "test"}, false)
 	ctx := Background
@@ -0,0 +1,179 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// RetentionXattr is set on a directory to make the files in it write-once-read-many,
+	// the value is the retention period, like "86400" (seconds), "720h" or "30d".
+	RetentionXattr = "user.juicefs.retention"
+	// RetainUntilXattr is the unix time until which a file is retained, it's set when a
+	// file in a retention directory is closed after written, and can only be extended.
+	RetainUntilXattr = "user.juicefs.retain-until"
+)
+
+// ParseRetention parses a retention period.
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var d time.Duration
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		d = time.Duration(n) * time.Second
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	return d, nil
+}
+
+// retainUntil returns the unix time until which the file is retained, 0 if it's not committed.
+func (m *baseMeta) retainUntil(ctx Context, inode Ino) int64 {
+	var v []byte
+	if m.en.GetXattr(ctx, inode, RetainUntilXattr, &v) != 0 {
+		return 0
+	}
+	until, _ := strconv.ParseInt(string(v), 10, 64)
+	return until
+}
+
+// checkRetention returns EPERM if the file is still retained, the files being written by
+// this client are not checked until closed.
+func (m *baseMeta) checkRetention(ctx Context, inode Ino) syscall.Errno {
+	if !m.fmt.EnableRetention {
+		return 0
+	}
+	m.Lock()
+	writing := m.writtenFiles[inode]
+	m.Unlock()
+	if !writing && m.retainUntil(ctx, inode) > time.Now().Unix() {
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// checkRetained is checkRetention with the value of RetainUntilXattr (nil if not set), which
+// is read by the engines in the transactions of unlink and rename, so the files keep their
+// own retention time wherever they are and can't be committed in between.
+func (m *baseMeta) checkRetained(inode Ino, value []byte) syscall.Errno {
+	if !m.fmt.EnableRetention || value == nil {
+		return 0
+	}
+	m.Lock()
+	writing := m.writtenFiles[inode]
+	m.Unlock()
+	if until, _ := strconv.ParseInt(string(value), 10, 64); !writing && until > time.Now().Unix() {
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// dirRetention returns the retention period of a directory, 0 if it's not a retention directory.
+func (m *baseMeta) dirRetention(ctx Context, inode Ino) time.Duration {
+	var v []byte
+	if m.en.GetXattr(ctx, inode, RetentionXattr, &v) != 0 {
+		return 0
+	}
+	d, err := ParseRetention(string(v))
+	if err != nil {
+		logger.Warnf("retention of directory %d: %s", inode, err)
+	}
+	return d
+}
+
+// commitRetention makes a file read-only until the retention time of its directory. It's
+// called when a file is created in or moved into a retention directory (so the ones left by
+// a crashed client are retained), and again when it's closed after written.
+func (m *baseMeta) commitRetention(ctx Context, inode Ino) {
+	if !m.fmt.EnableRetention {
+		return
+	}
+	var attr Attr
+	if m.en.doGetAttr(ctx, inode, &attr) != 0 || attr.Typ != TypeFile || attr.Parent == 0 || isTrash(attr.Parent) {
+		return
+	}
+	d := m.dirRetention(ctx, attr.Parent)
+	if d <= 0 {
+		return
+	}
+	until := time.Now().Add(d).Unix()
+	if until <= m.retainUntil(ctx, inode) {
+		return
+	}
+	if st := m.en.doSetXattr(ctx, inode, RetainUntilXattr, []byte(strconv.FormatInt(until, 10)), XattrCreateOrReplace); st != 0 {
+		logger.Warnf("commit retention of inode %d: %s", inode, st)
+	}
+}
+
+// checkRetentionXattr checks the changes of xattrs on a file or directory, the retention of
+// directories and files can only be changed by the owner or root, and only be extended.
+func (m *baseMeta) checkRetentionXattr(ctx Context, inode Ino, attr *Attr, name string, value []byte, remove bool) syscall.Errno {
+	if !m.fmt.EnableRetention {
+		if !remove && (name == RetentionXattr || name == RetainUntilXattr) {
+			return syscall.ENOTSUP
+		}
+		return 0
+	}
+	if (name == RetentionXattr || name == RetainUntilXattr) && ctx.Uid() != 0 && ctx.Uid() != attr.Uid {
+		return syscall.EPERM
+	}
+	switch name {
+	case RetentionXattr:
+		if remove {
+			return syscall.EPERM
+		}
+		d, err := ParseRetention(string(value))
+		if err != nil {
+			return syscall.EINVAL
+		}
+		if d < m.dirRetention(ctx, inode) {
+			return syscall.EPERM
+		}
+		return 0
+	case RetainUntilXattr:
+		cur := m.retainUntil(ctx, inode)
+		if remove {
+			if cur > time.Now().Unix() {
+				return syscall.EPERM
+			}
+			return 0
+		}
+		until, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil || until <= 0 {
+			return syscall.EINVAL
+		}
+		if until < cur {
+			return syscall.EPERM
+		}
+		return 0
+	default:
+		return m.checkRetention(ctx, inode)
+	}
+}
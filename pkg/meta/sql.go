@@ -48,6 +48,16 @@ type setting struct {
 	Value string `xorm:"varchar(4096) notnull"`
 }
 
+type token struct {
+	Name  string `xorm:"pk"`
+	Value []byte `xorm:"blob notnull"`
+}
+
+type idName struct {
+	Name string `xorm:"pk"` // "u:" + user or "g:" + group
+	Id   uint32 `xorm:"notnull"`
+}
+
 type counter struct {
 	Name  string `xorm:"pk"`
 	Value int64  `xorm:"notnull"`
@@ -204,7 +214,7 @@ func newSQLMeta(driver, addr string, conf *Config) (Meta, error) {
 		db:       engine,
 	}
 	m.en = m
-	m.root, err = lookupSubdir(m, conf.Subdir)
+	m.root, err = m.lookupRoot()
 
 	return m, err
 }
@@ -225,8 +235,8 @@ func (m *dbMeta) doDeleteSlice(chunkid uint64, size uint32) error {
 }
 
 func (m *dbMeta) Init(format Format, force bool) error {
-	if err := m.db.Sync2(new(setting), new(counter)); err != nil {
-		return fmt.Errorf("create table setting, counter: %s", err)
+	if err := m.db.Sync2(new(setting), new(counter), new(token), new(idName)); err != nil {
+		return fmt.Errorf("create table setting, counter, token, id_name: %s", err)
 	}
 	if err := m.db.Sync2(new(edge)); err != nil && !strings.Contains(err.Error(), "Duplicate entry") {
 		return fmt.Errorf("create table edge: %s", err)
@@ -315,7 +325,7 @@ func (m *dbMeta) Init(format Format, force bool) error {
 }
 
 func (m *dbMeta) Reset() error {
-	return m.db.DropTables(&setting{}, &counter{},
+	return m.db.DropTables(&setting{}, &counter{}, &token{}, &idName{},
 		&node{}, &edge{}, &symlink{}, &xattr{},
 		&chunk{}, &chunkRef{}, &delslices{},
 		&session{}, &session2{}, &sustained{}, &delfile{},
@@ -323,20 +333,113 @@ func (m *dbMeta) Reset() error {
 }
 
 func (m *dbMeta) doLoad() (data []byte, err error) {
-	err = m.txn(func(ses *xorm.Session) error {
-		if ok, err := ses.IsTableExist(&setting{}); err != nil {
+	// not in a transaction, which is not allowed for read-only clients
+	if ok, err := m.db.IsTableExist(&setting{}); err != nil || !ok {
+		return nil, err
+	}
+	s := setting{Name: "format"}
+	ok, err := m.db.Get(&s)
+	if err == nil && ok {
+		data = []byte(s.Value)
+	}
+	return
+}
+
+func (m *dbMeta) doGetToken(name string) ([]byte, error) {
+	if ok, err := m.db.IsTableExist(&token{}); err != nil || !ok {
+		return nil, err
+	}
+	t := token{Name: name}
+	ok, err := m.db.Get(&t)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return t.Value, nil
+}
+
+func (m *dbMeta) doListTokens() (map[string][]byte, error) {
+	if ok, err := m.db.IsTableExist(&token{}); err != nil || !ok {
+		return nil, err
+	}
+	var rows []token
+	if err := m.db.Find(&rows); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string][]byte, len(rows))
+	for _, t := range rows {
+		tokens[t.Name] = t.Value
+	}
+	return tokens, nil
+}
+
+func (m *dbMeta) doSetToken(name string, data []byte) error {
+	if err := m.db.Sync2(new(token)); err != nil {
+		return fmt.Errorf("create table token: %s", err)
+	}
+	return m.txn(func(s *xorm.Session) error {
+		return mustInsert(s, &token{name, data})
+	})
+}
+
+func (m *dbMeta) doDeleteToken(name string) (bool, error) {
+	if ok, err := m.db.IsTableExist(&token{}); err != nil || !ok {
+		return false, err
+	}
+	var found bool
+	err := m.txn(func(s *xorm.Session) error {
+		n, err := s.Delete(&token{Name: name})
+		found = n > 0
+		return err
+	})
+	return found, err
+}
+
+func (m *dbMeta) doListIdNames() (map[string]uint32, error) {
+	if ok, err := m.db.IsTableExist(&idName{}); err != nil || !ok {
+		return nil, err
+	}
+	var rows []idName
+	if err := m.db.Find(&rows); err != nil {
+		return nil, err
+	}
+	names := make(map[string]uint32, len(rows))
+	for _, r := range rows {
+		names[r.Name] = r.Id
+	}
+	return names, nil
+}
+
+func (m *dbMeta) doSetIdName(key string, id uint32) error {
+	if err := m.db.Sync2(new(idName)); err != nil {
+		return fmt.Errorf("create table id_name: %s", err)
+	}
+	return m.txn(func(s *xorm.Session) error {
+		n := idName{Name: key}
+		ok, err := s.Get(&n)
+		if err != nil {
 			return err
-		} else if !ok {
-			return nil
 		}
-		s := setting{Name: "format"}
-		ok, err := ses.Get(&s)
-		if err == nil && ok {
-			data = []byte(s.Value)
+		n.Id = id
+		if ok {
+			_, err = s.Cols("id").Update(&n, &idName{Name: key})
+		} else {
+			err = mustInsert(s, &n)
 		}
 		return err
 	})
-	return
+}
+
+func (m *dbMeta) doDeleteIdName(key string) (bool, error) {
+	if ok, err := m.db.IsTableExist(&idName{}); err != nil || !ok {
+		return false, err
+	}
+	var found bool
+	err := m.txn(func(s *xorm.Session) error {
+		n, err := s.Delete(&idName{Name: key})
+		found = n > 0
+		return err
+	})
+	return found, err
 }
 
 func (m *dbMeta) doNewSession(sinfo []byte) error {
@@ -501,7 +604,7 @@ func (m *dbMeta) ListSessions() ([]*Session, error) {
 }
 
 func (m *dbMeta) getCounter(name string) (v int64, err error) {
-	err = m.txn(func(s *xorm.Session) error {
+	err = m.roTxn(func(s *xorm.Session) error {
 		c := counter{Name: name}
 		_, err := s.Get(&c)
 		if err == nil {
@@ -609,6 +712,11 @@ func (m *dbMeta) txn(f func(s *xorm.Session) error) error {
 	if m.conf.ReadOnly {
 		return syscall.EROFS
 	}
+	return m.roTxn(f)
+}
+
+// roTxn runs a transaction which only reads, it's allowed for read-only clients.
+func (m *dbMeta) roTxn(f func(s *xorm.Session) error) error {
 	start := time.Now()
 	defer func() { txDist.Observe(time.Since(start).Seconds()) }()
 	var err error
@@ -676,7 +784,7 @@ func (m *dbMeta) flushStats() {
 }
 
 func (m *dbMeta) doLookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.roTxn(func(s *xorm.Session) error {
 		s = s.Table(&edge{})
 		if attr != nil {
 			s = s.Join("INNER", &node{}, "jfs_edge.inode=jfs_node.inode")
@@ -696,7 +804,7 @@ func (m *dbMeta) doLookup(ctx Context, parent Ino, name string, inode *Ino, attr
 }
 
 func (m *dbMeta) doGetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.roTxn(func(s *xorm.Session) error {
 		var n = node{Inode: inode}
 		ok, err := s.Get(&n)
 		if ok {
@@ -735,6 +843,9 @@ func clearSUGIDSQL(ctx Context, cur *node, set *Attr) {
 func (m *dbMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
 	inode = m.checkRoot(inode)
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer func() { m.of.InvalidateChunk(inode, 0xFFFFFFFE) }()
 	return errno(m.txn(func(s *xorm.Session) error {
 		var cur = node{Inode: inode}
@@ -745,6 +856,9 @@ func (m *dbMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 		if !ok {
 			return syscall.ENOENT
 		}
+		if st := checkSetAttr(ctx, &Attr{Flags: cur.Flags, Length: cur.Length}, set, attr); st != 0 {
+			return st
+		}
 		if (set&(SetAttrUID|SetAttrGID)) != 0 && (set&SetAttrMode) != 0 {
 			attr.Mode |= (cur.Mode & 06000)
 		}
@@ -772,6 +886,10 @@ func (m *dbMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 				changed = true
 			}
 		}
+		if set&SetAttrFlag != 0 && cur.Flags != attr.Flags {
+			cur.Flags = attr.Flags
+			changed = true
+		}
 		now := time.Now().UnixNano() / 1e3
 		if set&SetAttrAtime != 0 {
 			cur.Atime = attr.Atime*1e6 + int64(attr.Atimensec)/1e3
@@ -794,7 +912,7 @@ func (m *dbMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 			return nil
 		}
 		cur.Ctime = now
-		_, err = s.Cols("mode", "uid", "gid", "atime", "mtime", "ctime").Update(&cur, &node{Inode: inode})
+		_, err = s.Cols("flags", "mode", "uid", "gid", "atime", "mtime", "ctime").Update(&cur, &node{Inode: inode})
 		if err == nil {
 			m.parseAttr(&cur, attr)
 		}
@@ -821,6 +939,9 @@ func (m *dbMeta) appendSlice(s *xorm.Session, inode Ino, indx uint32, buf []byte
 
 func (m *dbMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	f := m.of.find(inode)
 	if f != nil {
 		f.Lock()
@@ -837,7 +958,7 @@ func (m *dbMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, at
 		if !ok {
 			return syscall.ENOENT
 		}
-		if n.Type != TypeFile {
+		if n.Type != TypeFile || protected(n.Flags) {
 			return syscall.EPERM
 		}
 		if length == n.Length {
@@ -910,6 +1031,9 @@ func (m *dbMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 	if size == 0 {
 		return syscall.EINVAL
 	}
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer timeit(time.Now())
 	f := m.of.find(inode)
 	if f != nil {
@@ -930,7 +1054,7 @@ func (m *dbMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 		if n.Type == TypeFIFO {
 			return syscall.EPIPE
 		}
-		if n.Type != TypeFile {
+		if n.Type != TypeFile || protected(n.Flags) {
 			return syscall.EPERM
 		}
 		length := n.Length
@@ -953,7 +1077,9 @@ func (m *dbMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 			return err
 		}
 		if mode&(fallocZeroRange|fallocPunchHole) != 0 {
-			if off+size > old {
+			if off >= old {
+				size = 0
+			} else if off+size > old {
 				size = old - off
 			}
 			for size > 0 {
@@ -980,7 +1106,7 @@ func (m *dbMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 }
 
 func (m *dbMeta) doReadlink(ctx Context, inode Ino) (target []byte, err error) {
-	err = m.txn(func(s *xorm.Session) error {
+	err = m.roTxn(func(s *xorm.Session) error {
 		var l = symlink{Inode: inode}
 		ok, err := s.Get(&l)
 		if err == nil && ok {
@@ -1039,6 +1165,9 @@ func (m *dbMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode
 		if pn.Type != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if pn.Flags&FlagImmutable != 0 {
+			return syscall.EPERM
+		}
 		var e = edge{Parent: parent, Name: []byte(name)}
 		ok, err = s.ForUpdate().Get(&e)
 		if err != nil {
@@ -1116,6 +1245,22 @@ func (m *dbMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode
 	return errno(err)
 }
 
+// checkRetainedTx checks the retention of a file in the transaction.
+func (m *dbMeta) checkRetainedTx(s *xorm.Session, inode Ino) syscall.Errno {
+	if !m.fmt.EnableRetention {
+		return 0
+	}
+	var x = xattr{Inode: inode, Name: RetainUntilXattr}
+	ok, err := s.ForUpdate().Get(&x)
+	if err != nil {
+		return errno(err)
+	}
+	if !ok {
+		return 0
+	}
+	return m.checkRetained(inode, x.Value)
+}
+
 func (m *dbMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 	var trash Ino
 	if st := m.checkTrash(parent, &trash); st != 0 {
@@ -1136,6 +1281,9 @@ func (m *dbMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 		if pn.Type != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pn.Flags) {
+			return syscall.EPERM
+		}
 		var e = edge{Parent: parent, Name: []byte(name)}
 		ok, err = s.ForUpdate().Get(&e)
 		if err != nil {
@@ -1167,6 +1315,14 @@ func (m *dbMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 			if ctx.Uid() != 0 && pn.Mode&01000 != 0 && ctx.Uid() != pn.Uid && ctx.Uid() != n.Uid {
 				return syscall.EACCES
 			}
+			if protected(n.Flags) {
+				return syscall.EPERM
+			}
+			if n.Type == TypeFile {
+				if st := m.checkRetainedTx(s, e.Inode); st != 0 {
+					return st
+				}
+			}
 			n.Ctime = now
 			if trash == 0 {
 				n.Nlink--
@@ -1268,6 +1424,9 @@ func (m *dbMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno {
 		if pn.Type != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pn.Flags) {
+			return syscall.EPERM
+		}
 		var e = edge{Parent: parent, Name: []byte(name)}
 		ok, err = s.ForUpdate().Get(&e)
 		if err != nil {
@@ -1304,6 +1463,9 @@ func (m *dbMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno {
 			if ctx.Uid() != 0 && pn.Mode&01000 != 0 && ctx.Uid() != pn.Uid && ctx.Uid() != n.Uid {
 				return syscall.EACCES
 			}
+			if protected(n.Flags) {
+				return syscall.EPERM
+			}
 			if trash > 0 {
 				n.Ctime = now
 				n.Parent = trash
@@ -1410,6 +1572,14 @@ func (m *dbMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 		if !ok {
 			return syscall.ENOENT
 		}
+		if protected(spn.Flags) || dpn.Flags&FlagImmutable != 0 || protected(sn.Flags) {
+			return syscall.EPERM
+		}
+		if sn.Type == TypeFile {
+			if st := m.checkRetainedTx(s, sn.Inode); st != 0 {
+				return st
+			}
+		}
 
 		var de = edge{Parent: parentDst, Name: []byte(nameDst)}
 		ok, err = s.ForUpdate().Get(&de)
@@ -1441,6 +1611,14 @@ func (m *dbMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 				logger.Warnf("no attribute for inode %d (%d, %s)", dino, parentDst, de.Name)
 				trash = 0
 			}
+			if protected(dpn.Flags) || protected(dn.Flags) {
+				return syscall.EPERM
+			}
+			if de.Type == TypeFile {
+				if st := m.checkRetainedTx(s, dino); st != 0 {
+					return st
+				}
+			}
 			dn.Ctime = now
 			if exchange {
 				dn.Parent = parentSrc
@@ -1634,7 +1812,7 @@ func (m *dbMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 		if !ok {
 			return syscall.ENOENT
 		}
-		if n.Type == TypeDirectory {
+		if n.Type == TypeDirectory || pn.Flags&FlagImmutable != 0 || protected(n.Flags) {
 			return syscall.EPERM
 		}
 
@@ -1667,7 +1845,7 @@ func (m *dbMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 }
 
 func (m *dbMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*Entry, limit int) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.roTxn(func(s *xorm.Session) error {
 		s = s.Table(&edge{})
 		if plus != 0 {
 			s = s.Join("INNER", &node{}, "jfs_edge.inode=jfs_node.inode")
@@ -1847,7 +2025,7 @@ func (m *dbMeta) Read(ctx Context, inode Ino, indx uint32, chunks *[]Slice) sysc
 	}
 	defer timeit(time.Now())
 	var c chunk
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.roTxn(func(s *xorm.Session) error {
 		_, err := s.Where("inode=? and indx=?", inode, indx).Get(&c)
 		return err
 	})
@@ -1888,6 +2066,9 @@ func (m *dbMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice Sl
 		if n.Type != TypeFile {
 			return syscall.EPERM
 		}
+		if st := checkWrite(&Attr{Flags: n.Flags, Length: n.Length}, uint64(indx)*ChunkSize+uint64(off)); st != 0 {
+			return st
+		}
 		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
 		if newleng > n.Length {
 			newSpace = align4K(newleng) - align4K(n.Length)
@@ -1974,6 +2155,9 @@ func (m *dbMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 		if nout.Type != TypeFile {
 			return syscall.EINVAL
 		}
+		if st := checkWrite(&Attr{Flags: nout.Flags, Length: nout.Length}, offOut); st != 0 {
+			return st
+		}
 
 		newleng := offOut + size
 		if newleng > nout.Length {
@@ -2435,7 +2619,7 @@ func (m *dbMeta) ListSlices(ctx Context, slices map[Ino][]Slice, delete bool, sh
 func (m *dbMeta) GetXattr(ctx Context, inode Ino, name string, vbuff *[]byte) syscall.Errno {
 	defer timeit(time.Now())
 	inode = m.checkRoot(inode)
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.roTxn(func(s *xorm.Session) error {
 		var x = xattr{Inode: inode, Name: name}
 		ok, err := s.Get(&x)
 		if err != nil {
@@ -2452,7 +2636,7 @@ func (m *dbMeta) GetXattr(ctx Context, inode Ino, name string, vbuff *[]byte) sy
 func (m *dbMeta) ListXattr(ctx Context, inode Ino, names *[]byte) syscall.Errno {
 	defer timeit(time.Now())
 	inode = m.checkRoot(inode)
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.roTxn(func(s *xorm.Session) error {
 		var xs []xattr
 		err := s.Where("inode = ?", inode).Find(&xs, &xattr{Inode: inode})
 		if err != nil {
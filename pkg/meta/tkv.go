@@ -89,7 +89,7 @@ func newKVMeta(driver, addr string, conf *Config) (Meta, error) {
 		client:   client,
 	}
 	m.en = m
-	m.root, err = lookupSubdir(m, conf.Subdir)
+	m.root, err = m.lookupRoot()
 	return m, err
 }
 
@@ -172,6 +172,8 @@ All keys:
   SHssssssss         session heartbeat // for legacy client
   SIssssssss         session info
   SSssssssssiiiiiiii sustained inode
+  T...               token
+  N...               id of user ("u:" + name) or group ("g:" + name)
 */
 
 func (m *kvMeta) inodeKey(inode Ino) []byte {
@@ -234,6 +236,14 @@ func (m *kvMeta) sustainedKey(sid uint64, inode Ino) []byte {
 	return m.fmtKey("SS", sid, inode)
 }
 
+func (m *kvMeta) tokenKey(name string) []byte {
+	return m.fmtKey("T", name)
+}
+
+func (m *kvMeta) idNameKey(key string) []byte {
+	return m.fmtKey("N", key)
+}
+
 func (m *kvMeta) encodeInode(ino Ino, buf []byte) {
 	binary.LittleEndian.PutUint64(buf, uint64(ino))
 }
@@ -383,6 +393,66 @@ func (m *kvMeta) doLoad() ([]byte, error) {
 	return m.get(m.fmtKey("setting"))
 }
 
+func (m *kvMeta) doGetToken(name string) ([]byte, error) {
+	return m.get(m.tokenKey(name))
+}
+
+func (m *kvMeta) doListTokens() (map[string][]byte, error) {
+	vals, err := m.scanValues(m.fmtKey("T"), -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string][]byte, len(vals))
+	for k, v := range vals {
+		tokens[k[1:]] = v // "T" + name
+	}
+	return tokens, nil
+}
+
+func (m *kvMeta) doSetToken(name string, data []byte) error {
+	return m.setValue(m.tokenKey(name), data)
+}
+
+func (m *kvMeta) doDeleteToken(name string) (bool, error) {
+	var found bool
+	err := m.txn(func(tx kvTxn) error {
+		key := m.tokenKey(name)
+		if found = tx.get(key) != nil; found {
+			tx.dels(key)
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (m *kvMeta) doListIdNames() (map[string]uint32, error) {
+	vals, err := m.scanValues(m.fmtKey("N"), -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]uint32, len(vals))
+	for k, v := range vals {
+		names[k[1:]] = uint32(m.parseInt64(v)) // "N" + key
+	}
+	return names, nil
+}
+
+func (m *kvMeta) doSetIdName(key string, id uint32) error {
+	return m.setValue(m.idNameKey(key), m.packInt64(int64(id)))
+}
+
+func (m *kvMeta) doDeleteIdName(key string) (bool, error) {
+	var found bool
+	err := m.txn(func(tx kvTxn) error {
+		k := m.idNameKey(key)
+		if found = tx.get(k) != nil; found {
+			tx.dels(k)
+		}
+		return nil
+	})
+	return found, err
+}
+
 func (m *kvMeta) doNewSession(sinfo []byte) error {
 	if err := m.setValue(m.sessionKey(m.sid), m.packInt64(m.expireTime())); err != nil {
 		return fmt.Errorf("set session ID %d: %s", m.sid, err)
@@ -732,6 +802,9 @@ func (m *kvMeta) doGetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 func (m *kvMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
 	inode = m.checkRoot(inode)
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer func() { m.of.InvalidateChunk(inode, 0xFFFFFFFE) }()
 	return errno(m.txn(func(tx kvTxn) error {
 		var cur Attr
@@ -740,6 +813,9 @@ func (m *kvMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 			return syscall.ENOENT
 		}
 		m.parseAttr(a, &cur)
+		if st := checkSetAttr(ctx, &cur, set, attr); st != 0 {
+			return st
+		}
 		if (set&(SetAttrUID|SetAttrGID)) != 0 && (set&SetAttrMode) != 0 {
 			attr.Mode |= (cur.Mode & 06000)
 		}
@@ -767,6 +843,10 @@ func (m *kvMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 				changed = true
 			}
 		}
+		if set&SetAttrFlag != 0 && cur.Flags != attr.Flags {
+			cur.Flags = attr.Flags
+			changed = true
+		}
 		now := time.Now()
 		if set&SetAttrAtime != 0 && (cur.Atime != attr.Atime || cur.Atimensec != attr.Atimensec) {
 			cur.Atime = attr.Atime
@@ -802,6 +882,9 @@ func (m *kvMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint
 
 func (m *kvMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, attr *Attr) syscall.Errno {
 	defer timeit(time.Now())
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	f := m.of.find(inode)
 	if f != nil {
 		f.Lock()
@@ -816,7 +899,7 @@ func (m *kvMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, at
 			return syscall.ENOENT
 		}
 		m.parseAttr(a, &t)
-		if t.Typ != TypeFile {
+		if t.Typ != TypeFile || protected(t.Flags) {
 			return syscall.EPERM
 		}
 		if length == t.Length {
@@ -882,6 +965,9 @@ func (m *kvMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 	if size == 0 {
 		return syscall.EINVAL
 	}
+	if st := m.checkRetention(ctx, inode); st != 0 {
+		return st
+	}
 	defer timeit(time.Now())
 	f := m.of.find(inode)
 	if f != nil {
@@ -900,7 +986,7 @@ func (m *kvMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 		if t.Typ == TypeFIFO {
 			return syscall.EPIPE
 		}
-		if t.Typ != TypeFile {
+		if t.Typ != TypeFile || protected(t.Flags) {
 			return syscall.EPERM
 		}
 		length := t.Length
@@ -923,7 +1009,9 @@ func (m *kvMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size
 		t.Ctimensec = uint32(now.Nanosecond())
 		tx.set(m.inodeKey(inode), m.marshal(&t))
 		if mode&(fallocZeroRange|fallocPunchHole) != 0 {
-			if off+size > old {
+			if off >= old {
+				size = 0
+			} else if off+size > old {
 				size = old - off
 			}
 			for size > 0 {
@@ -967,6 +1055,7 @@ func (m *kvMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode
 		attr = &Attr{}
 	}
 	attr.Typ = _type
+	attr.Flags = 0
 	attr.Mode = mode & ^cumask
 	attr.Uid = ctx.Uid()
 	attr.Gid = ctx.Gid()
@@ -998,6 +1087,9 @@ func (m *kvMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if pattr.Flags&FlagImmutable != 0 {
+			return syscall.EPERM
+		}
 
 		buf := tx.get(m.entryKey(parent, name))
 		var foundIno Ino
@@ -1068,6 +1160,14 @@ func (m *kvMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode
 	return errno(err)
 }
 
+// checkRetainedTx checks the retention of a file in the transaction.
+func (m *kvMeta) checkRetainedTx(tx kvTxn, inode Ino) syscall.Errno {
+	if !m.fmt.EnableRetention {
+		return 0
+	}
+	return m.checkRetained(inode, tx.get(m.xattrKey(inode, RetainUntilXattr)))
+}
+
 func (m *kvMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 	var trash Ino
 	if st := m.checkTrash(parent, &trash); st != 0 {
@@ -1102,6 +1202,9 @@ func (m *kvMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pattr.Flags) {
+			return syscall.EPERM
+		}
 		attr = Attr{}
 		opened = false
 		now := time.Now()
@@ -1110,6 +1213,14 @@ func (m *kvMeta) doUnlink(ctx Context, parent Ino, name string) syscall.Errno {
 			if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
 				return syscall.EACCES
 			}
+			if protected(attr.Flags) {
+				return syscall.EPERM
+			}
+			if _type == TypeFile {
+				if st := m.checkRetainedTx(tx, inode); st != 0 {
+					return st
+				}
+			}
 			attr.Ctime = now.Unix()
 			attr.Ctimensec = uint32(now.Nanosecond())
 			if trash == 0 {
@@ -1203,6 +1314,9 @@ func (m *kvMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno {
 		if pattr.Typ != TypeDirectory {
 			return syscall.ENOTDIR
 		}
+		if protected(pattr.Flags) {
+			return syscall.EPERM
+		}
 		if tx.exist(m.entryKey(inode, "")) {
 			return syscall.ENOTEMPTY
 		}
@@ -1213,6 +1327,9 @@ func (m *kvMeta) doRmdir(ctx Context, parent Ino, name string) syscall.Errno {
 			if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
 				return syscall.EACCES
 			}
+			if protected(attr.Flags) {
+				return syscall.EPERM
+			}
 			if trash > 0 {
 				attr.Ctime = now.Unix()
 				attr.Ctimensec = uint32(now.Nanosecond())
@@ -1290,6 +1407,14 @@ func (m *kvMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 			return syscall.ENOTDIR
 		}
 		m.parseAttr(rs[2], &iattr)
+		if protected(sattr.Flags) || dattr.Flags&FlagImmutable != 0 || protected(iattr.Flags) {
+			return syscall.EPERM
+		}
+		if typ == TypeFile {
+			if st := m.checkRetainedTx(tx, ino); st != 0 {
+				return st
+			}
+		}
 
 		dbuf := tx.get(m.entryKey(parentDst, nameDst))
 		if dbuf == nil && m.conf.CaseInsensi {
@@ -1313,6 +1438,14 @@ func (m *kvMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 				trash = 0
 			}
 			m.parseAttr(a, &tattr)
+			if protected(dattr.Flags) || protected(tattr.Flags) {
+				return syscall.EPERM
+			}
+			if dtyp == TypeFile {
+				if st := m.checkRetainedTx(tx, dino); st != 0 {
+					return st
+				}
+			}
 			tattr.Ctime = now.Unix()
 			tattr.Ctimensec = uint32(now.Nanosecond())
 			if exchange {
@@ -1449,7 +1582,7 @@ func (m *kvMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 			return syscall.ENOTDIR
 		}
 		m.parseAttr(rs[1], &iattr)
-		if iattr.Typ == TypeDirectory {
+		if iattr.Typ == TypeDirectory || pattr.Flags&FlagImmutable != 0 || protected(iattr.Flags) {
 			return syscall.EPERM
 		}
 		buf := tx.get(m.entryKey(parent, name))
@@ -1627,6 +1760,9 @@ func (m *kvMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice Sl
 		if attr.Typ != TypeFile {
 			return syscall.EPERM
 		}
+		if st := checkWrite(&attr, uint64(indx)*ChunkSize+uint64(off)); st != 0 {
+			return st
+		}
 		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
 		if newleng > attr.Length {
 			newSpace = align4K(newleng) - align4K(attr.Length)
@@ -1685,6 +1821,9 @@ func (m *kvMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 		if attr.Typ != TypeFile {
 			return syscall.EINVAL
 		}
+		if st := checkWrite(&attr, offOut); st != 0 {
+			return st
+		}
 
 		newleng := offOut + size
 		if newleng > attr.Length {
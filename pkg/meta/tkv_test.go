@@ -41,7 +41,7 @@ func TestTiKVClient(t *testing.T) {
 }
 
 func TestBadgerClient(t *testing.T) {
-	m, err := newKVMeta("badger", "badger", &Config{})
+	m, err := newKVMeta("badger", t.TempDir(), &Config{})
 	if err != nil || m.Name() != "badger" {
 		t.Fatalf("create meta: %s", err)
 	}
@@ -0,0 +1,164 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Token limits the clients using it to a subtree of the volume. It's enforced by the
+// clients, which have access to the whole meta engine, so it's not a boundary between
+// tenants: they should use different credentials of the meta engine (e.g. ACL of Redis,
+// users of databases) to be isolated from each other.
+type Token struct {
+	Name     string
+	Secret   string `json:",omitempty"` // SHA-256 of the secret, never returned by ListTokens
+	Subdir   string
+	ReadOnly bool
+	UidMap   IdMap `json:",omitempty"`
+	Created  int64
+}
+
+var tokenName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func cleanSubdir(subdir string) string {
+	return path.Clean("/" + subdir)
+}
+
+// CreateToken saves a new token and returns the string used by clients, which is
+// "NAME:SECRET". The subdir of the token is created if it does not exist.
+func (m *baseMeta) CreateToken(t *Token) (string, error) {
+	if !tokenName.MatchString(t.Name) {
+		return "", fmt.Errorf("invalid token name %q", t.Name)
+	}
+	if old, err := m.en.doGetToken(t.Name); err != nil {
+		return "", err
+	} else if old != nil {
+		return "", fmt.Errorf("token %s already exists", t.Name)
+	}
+	t.Subdir = cleanSubdir(t.Subdir)
+	if _, err := lookupSubdir(m, t.Subdir); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+	t.Secret = hashSecret(secret)
+	t.Created = time.Now().Unix()
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("json: %s", err)
+	}
+	if err = m.en.doSetToken(t.Name, data); err != nil {
+		return "", err
+	}
+	t.Secret = ""
+	return t.Name + ":" + secret, nil
+}
+
+// ListTokens returns all the tokens sorted by name, without secrets.
+func (m *baseMeta) ListTokens() ([]*Token, error) {
+	vals, err := m.en.doListTokens()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*Token, 0, len(vals))
+	for name, v := range vals {
+		var t Token
+		if err = json.Unmarshal(v, &t); err != nil {
+			logger.Warnf("corrupt token %s: %s", name, err)
+			continue
+		}
+		t.Secret = ""
+		tokens = append(tokens, &t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+// RevokeToken removes a token, clients using it can't mount any more.
+func (m *baseMeta) RevokeToken(name string) error {
+	found, err := m.en.doDeleteToken(name)
+	if err == nil && !found {
+		err = fmt.Errorf("token %s not found", name)
+	}
+	return err
+}
+
+func (m *baseMeta) verifyToken(token string) (*Token, error) {
+	ps := strings.SplitN(token, ":", 2)
+	if len(ps) != 2 || !tokenName.MatchString(ps[0]) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	data, err := m.en.doGetToken(ps[0])
+	if err != nil {
+		return nil, fmt.Errorf("get token %s: %s", ps[0], err)
+	}
+	var t Token
+	if data == nil {
+		return nil, fmt.Errorf("token %s not found", ps[0])
+	} else if err = json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("corrupt token %s: %s", ps[0], err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(ps[1])), []byte(t.Secret)) != 1 {
+		return nil, fmt.Errorf("invalid secret of token %s", ps[0])
+	}
+	return &t, nil
+}
+
+// CheckToken returns an error if the volume requires a token but the client has none. It's
+// checked by the clients serving the files (mount, gateway, WebDAV and the Java SDK) when
+// they start, to prevent them from exposing the whole volume by mistake.
+func (f *Format) CheckToken(conf *Config) error {
+	if f.RequireToken && conf.Token == "" {
+		return fmt.Errorf("volume %s requires a token", f.Name)
+	}
+	return nil
+}
+
+// lookupRoot returns the inode of the root for the client, which is always inside the
+// subtree of its token if given.
+func (m *baseMeta) lookupRoot() (Ino, error) {
+	if m.conf.Token != "" {
+		t, err := m.verifyToken(m.conf.Token)
+		if err != nil {
+			return 0, err
+		}
+		m.conf.Subdir = path.Join(t.Subdir, cleanSubdir(m.conf.Subdir))
+		m.conf.ReadOnly = m.conf.ReadOnly || t.ReadOnly
+		m.conf.UidMap = t.UidMap
+		logger.Infof("Use token %s: subdir %s, read-only %t, uid map %q", t.Name, m.conf.Subdir, m.conf.ReadOnly, t.UidMap)
+	}
+	return lookupSubdir(m, m.conf.Subdir)
+}
@@ -47,6 +47,12 @@ const (
 	fallocInsertRange   = 0x20
 )
 
+const (
+	// whence of lseek, same as Linux
+	SeekData = 3
+	SeekHole = 4
+)
+
 type msgCallbacks struct {
 	sync.Mutex
 	callbacks map[uint32]MsgCallback
@@ -109,6 +115,48 @@ func accessMode(attr *Attr, uid uint32, gids []uint32) uint8 {
 	return uint8(mode & 7)
 }
 
+// protected returns true if the node can not be removed, renamed, linked or truncated.
+func protected(flags uint8) bool {
+	return flags&(FlagImmutable|FlagAppend) != 0
+}
+
+// checkWrite checks whether the data of a file can be written at off.
+func checkWrite(attr *Attr, off uint64) syscall.Errno {
+	if attr.Flags&FlagImmutable != 0 || attr.Flags&FlagAppend != 0 && off < attr.Length {
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// checkOpen checks whether a file can be opened with the flags.
+func checkOpen(attr *Attr, flags uint32) syscall.Errno {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) == 0 {
+		return 0
+	}
+	if attr.Flags&FlagImmutable != 0 || attr.Flags&FlagAppend != 0 && (flags&syscall.O_APPEND == 0 || flags&syscall.O_TRUNC != 0) {
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// checkSetAttr checks whether the attributes of a node can be changed, only root
+// can change the flags, and nothing else can be changed on a protected node.
+func checkSetAttr(ctx Context, cur *Attr, set uint16, attr *Attr) syscall.Errno {
+	if set&SetAttrFlag != 0 {
+		if ctx.Uid() != 0 {
+			return syscall.EPERM
+		}
+		if attr.Flags&^(FlagImmutable|FlagAppend) != 0 {
+			return syscall.EINVAL
+		}
+		set &^= SetAttrFlag
+	}
+	if set != 0 && protected(cur.Flags) {
+		return syscall.EPERM
+	}
+	return 0
+}
+
 func align4K(length uint64) int64 {
 	if length == 0 {
 		return 1 << 12
@@ -116,7 +164,13 @@ func align4K(length uint64) int64 {
 	return int64((((length - 1) >> 12) + 1) << 12)
 }
 
-func lookupSubdir(m Meta, subdir string) (Ino, error) {
+// dirLooker is the part of Meta used to find the root.
+type dirLooker interface {
+	Lookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr) syscall.Errno
+	Mkdir(ctx Context, parent Ino, name string, mode uint16, cumask uint16, copysgid uint8, inode *Ino, attr *Attr) syscall.Errno
+}
+
+func lookupSubdir(m dirLooker, subdir string) (Ino, error) {
 	var root Ino = 1
 	for subdir != "" {
 		ps := strings.SplitN(subdir, "/", 2)
@@ -289,6 +343,50 @@ func Remove(r Meta, ctx Context, parent Ino, name string) syscall.Errno {
 	return emptyEntry(r, ctx, parent, name, inode, concurrent)
 }
 
+// SeekDataHole returns the offset of the next data (SeekData) or hole (SeekHole) at or after off,
+// based on the slices of chunks. The end of file is treated as a hole.
+func SeekDataHole(r Meta, ctx Context, inode Ino, off, length uint64, whence int) (uint64, syscall.Errno) {
+	if whence != SeekData && whence != SeekHole {
+		return 0, syscall.EINVAL
+	}
+	if off >= length {
+		return 0, syscall.ENXIO
+	}
+	found := func(pos uint64) uint64 {
+		if pos < off {
+			pos = off
+		}
+		if pos > length {
+			pos = length
+		}
+		return pos
+	}
+	for indx := off / ChunkSize; indx*ChunkSize < length; indx++ {
+		var slices []Slice
+		if st := r.Read(ctx, inode, uint32(indx), &slices); st != 0 {
+			return 0, st
+		}
+		pos := indx * ChunkSize
+		for _, s := range slices {
+			end := pos + uint64(s.Len)
+			if end > off && (s.Chunkid != 0) == (whence == SeekData) {
+				if whence == SeekData && pos >= length {
+					return 0, syscall.ENXIO
+				}
+				return found(pos), 0
+			}
+			pos = end
+		}
+		if whence == SeekHole && pos < (indx+1)*ChunkSize {
+			return found(pos), 0
+		}
+	}
+	if whence == SeekHole {
+		return length, 0
+	}
+	return 0, syscall.ENXIO
+}
+
 func GetSummary(r Meta, ctx Context, inode Ino, summary *Summary, recursive bool) syscall.Errno {
 	var attr Attr
 	if st := r.GetAttr(ctx, inode, &attr); st != 0 {
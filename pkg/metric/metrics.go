@@ -55,23 +55,32 @@ var (
 	}, func() float64 {
 		return time.Since(start).Seconds()
 	})
-	usedSpace = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "used_space",
-		Help: "Total used space in bytes.",
-	})
-	usedInodes = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "used_inodes",
-		Help: "Total number of inodes.",
-	})
 )
 
-func UpdateMetrics(m meta.Meta, registerer prometheus.Registerer) {
+// InitMetrics registers the metrics of the process, which should be called once.
+func InitMetrics(registerer prometheus.Registerer) {
 	if registerer == nil {
 		return
 	}
 	registerer.MustRegister(cpu)
 	registerer.MustRegister(memory)
 	registerer.MustRegister(uptime)
+}
+
+// UpdateMetrics updates the usage of a volume periodically, it could be called for
+// multiple volumes with different registerers (labels).
+func UpdateMetrics(m meta.Meta, registerer prometheus.Registerer) {
+	if registerer == nil {
+		return
+	}
+	usedSpace := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "used_space",
+		Help: "Total used space in bytes.",
+	})
+	usedInodes := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "used_inodes",
+		Help: "Total number of inodes.",
+	})
 	registerer.MustRegister(usedSpace)
 	registerer.MustRegister(usedInodes)
 
@@ -20,18 +20,41 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strconv"
 	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported algorithms to encrypt the data blocks.
+const (
+	AES256GCM_RSA  = "aes256gcm-rsa"
+	CHACHA20_RSA   = "chacha20-rsa"
+	AES256GCM_HKDF = "aes256gcm-hkdf"
+	CHACHA20_HKDF  = "chacha20-hkdf"
 )
 
+// EncryptAlgos lists all the supported encryption algorithms.
+var EncryptAlgos = []string{AES256GCM_RSA, CHACHA20_RSA, AES256GCM_HKDF, CHACHA20_HKDF}
+
+// IsSymmetricAlgo returns true if the algorithm uses a symmetric master key instead of an RSA key.
+func IsSymmetricAlgo(algo string) bool {
+	return algo == AES256GCM_HKDF || algo == CHACHA20_HKDF
+}
+
 type Encryptor interface {
 	Encrypt(plaintext []byte) ([]byte, error)
 	Decrypt(ciphertext []byte) ([]byte, error)
@@ -114,16 +137,46 @@ func (e *rsaEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	return rsa.DecryptOAEP(sha256.New(), rand.Reader, e.privKey, ciphertext, e.label)
 }
 
-type aesEncryptor struct {
+func newAEAD(algo string, key []byte) (cipher.AEAD, error) {
+	switch algo {
+	case "", AES256GCM_RSA, AES256GCM_HKDF:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CHACHA20_RSA, CHACHA20_HKDF:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("unsupported encrypt algorithm: %s", algo)
+	}
+}
+
+type dataEncryptor struct {
 	keyEncryptor Encryptor
 	keyLen       int
+	algo         string
 }
 
+// NewAESEncryptor returns an Encryptor using AES-256-GCM, with a random key for each block
+// which is encrypted by keyEncryptor and stored along with the data.
 func NewAESEncryptor(keyEncryptor Encryptor) Encryptor {
-	return &aesEncryptor{keyEncryptor, 32} //  AES-256-GCM
+	return &dataEncryptor{keyEncryptor, 32, AES256GCM_RSA}
+}
+
+// NewDataEncryptor is like NewAESEncryptor, but uses the given algorithm (aes256gcm-rsa or chacha20-rsa).
+func NewDataEncryptor(keyEncryptor Encryptor, algo string) (Encryptor, error) {
+	switch algo {
+	case "", AES256GCM_RSA:
+		algo = AES256GCM_RSA
+	case CHACHA20_RSA:
+	default:
+		return nil, fmt.Errorf("unsupported encrypt algorithm: %s", algo)
+	}
+	return &dataEncryptor{keyEncryptor, 32, algo}, nil
 }
 
-func (e *aesEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+func (e *dataEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	key := make([]byte, e.keyLen)
 	if _, err := io.ReadFull(rand.Reader, key); err != nil {
 		return nil, err
@@ -132,21 +185,17 @@ func (e *aesEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	block, err := aes.NewCipher(key)
+	aead, err := newAEAD(e.algo, key)
 	if err != nil {
 		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	nonce := make([]byte, aesgcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
 	headerSize := 3 + len(cipherkey) + len(nonce)
-	buf := make([]byte, headerSize+len(plaintext)+aesgcm.Overhead())
+	buf := make([]byte, headerSize+len(plaintext)+aead.Overhead())
 	buf[0] = byte(len(cipherkey) >> 8)
 	buf[1] = byte(len(cipherkey) & 0xFF)
 	buf[2] = byte(len(nonce))
@@ -155,11 +204,14 @@ func (e *aesEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	p = p[len(cipherkey):]
 	copy(p, nonce)
 	p = p[len(nonce):]
-	ciphertext := aesgcm.Seal(p[:0], nonce, plaintext, nil)
+	ciphertext := aead.Seal(p[:0], nonce, plaintext, nil)
 	return buf[:headerSize+len(ciphertext)], nil
 }
 
-func (e *aesEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+func (e *dataEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 3 {
+		return nil, fmt.Errorf("misformed ciphertext: %d bytes", len(ciphertext))
+	}
 	keyLen := int(ciphertext[0])<<8 + int(ciphertext[1])
 	nonceLen := int(ciphertext[2])
 	if 3+keyLen+nonceLen >= len(ciphertext) {
@@ -174,15 +226,152 @@ func (e *aesEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, errors.New("decryt key: " + err.Error())
 	}
-	block, err := aes.NewCipher(key)
+	aead, err := newAEAD(e.algo, key)
 	if err != nil {
 		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	return aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+}
+
+const hkdfSaltSize = 16
+
+// symmetricEncryptor derives a key for each block from the volume master key using HKDF,
+// so no asymmetric operation is needed for reading or writing a block.
+type symmetricEncryptor struct {
+	master []byte
+	algo   string
+}
+
+// the cost parameters of scrypt to derive the master key from the passphrase, they are stored
+// with the salt so they can be raised for new volumes without breaking the old ones.
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	scryptMaxN = 1 << 22
+)
+
+// DeriveMasterKey derives a 256-bit master key of the volume from the passphrase and salt
+// using scrypt with the cost parameters N, r and p, so it's expensive to guess the passphrase.
+func DeriveMasterKey(passphrase, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, n, r, p, 32)
+}
+
+func keyVerifier(master []byte) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("juicefs key verifier"))
+	return mac.Sum(nil)
+}
+
+// NewSymmetricKey derives a master key from the passphrase with a random salt, and returns it
+// with the parameters, salt and verifier ("scrypt:N:r:p:SALT:VERIFIER", salt and verifier in
+// base64) to be stored in the volume, the master key itself is never stored.
+func NewSymmetricKey(passphrase []byte) (master []byte, stored string, err error) {
+	if len(passphrase) == 0 {
+		return nil, "", errors.New("passphrase is empty")
+	}
+	salt := make([]byte, hkdfSaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, "", err
+	}
+	if master, err = DeriveMasterKey(passphrase, salt, scryptN, scryptR, scryptP); err != nil {
+		return nil, "", err
+	}
+	stored = fmt.Sprintf("scrypt:%d:%d:%d:%s:%s", scryptN, scryptR, scryptP,
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(keyVerifier(master)))
+	return master, stored, nil
+}
+
+// LoadSymmetricKey derives the master key from the passphrase and the parameters, salt and
+// verifier returned by NewSymmetricKey, the passphrase is checked by the verifier.
+func LoadSymmetricKey(passphrase []byte, stored string) ([]byte, error) {
+	ps := strings.Split(stored, ":")
+	if len(ps) != 6 || ps[0] != "scrypt" {
+		return nil, errors.New("invalid parameters, salt and verifier of the master key")
+	}
+	var cost [3]int
+	for i := range cost {
+		v, err := strconv.Atoi(ps[i+1])
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid parameter of scrypt: %q", ps[i+1])
+		}
+		cost[i] = v
+	}
+	if cost[0] > scryptMaxN {
+		return nil, fmt.Errorf("parameter N of scrypt is too large: %d", cost[0])
+	}
+	salt, err := base64.StdEncoding.DecodeString(ps[4])
+	if err != nil {
+		return nil, fmt.Errorf("decode salt: %s", err)
+	}
+	verifier, err := base64.StdEncoding.DecodeString(ps[5])
+	if err != nil {
+		return nil, fmt.Errorf("decode verifier: %s", err)
+	}
+	master, err := DeriveMasterKey(passphrase, salt, cost[0], cost[1], cost[2])
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keyVerifier(master), verifier) {
+		return nil, errors.New("incorrect passphrase")
+	}
+	return master, nil
+}
+
+// NewSymmetricEncryptor returns an Encryptor using aes256gcm-hkdf or chacha20-hkdf with the master key.
+func NewSymmetricEncryptor(master []byte, algo string) (Encryptor, error) {
+	if !IsSymmetricAlgo(algo) {
+		return nil, fmt.Errorf("unsupported symmetric encrypt algorithm: %s", algo)
+	}
+	if len(master) < 32 {
+		return nil, fmt.Errorf("master key is too short: %d bytes", len(master))
+	}
+	return &symmetricEncryptor{master, algo}, nil
+}
+
+func (e *symmetricEncryptor) blockAEAD(salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, e.master, salt, []byte("juicefs block key")), key); err != nil {
+		return nil, err
+	}
+	return newAEAD(e.algo, key)
+}
+
+func (e *symmetricEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, hkdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := e.blockAEAD(salt)
 	if err != nil {
 		return nil, err
 	}
-	return aesgcm.Open(ciphertext[:0], nonce, ciphertext, nil)
+	headerSize := hkdfSaltSize + aead.NonceSize()
+	buf := make([]byte, headerSize+len(plaintext)+aead.Overhead())
+	copy(buf, salt)
+	nonce := buf[hkdfSaltSize:headerSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(buf[headerSize:headerSize], nonce, plaintext, nil)
+	return buf[:headerSize+len(ciphertext)], nil
+}
+
+func (e *symmetricEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) <= hkdfSaltSize {
+		return nil, fmt.Errorf("misformed ciphertext: %d bytes", len(ciphertext))
+	}
+	aead, err := e.blockAEAD(ciphertext[:hkdfSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[hkdfSaltSize:]
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("misformed ciphertext: %d bytes", len(ciphertext)+hkdfSaltSize)
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	ciphertext = ciphertext[aead.NonceSize():]
+	return aead.Open(ciphertext[:0], nonce, ciphertext, nil)
 }
 
 type encrypted struct {
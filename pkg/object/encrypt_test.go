@@ -21,9 +21,12 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"io/ioutil"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -158,3 +161,103 @@ func TestEncryptedStore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestChaCha20(t *testing.T) {
+	kc := NewRSAEncryptor(testkey)
+	dc, err := NewDataEncryptor(kc, CHACHA20_RSA)
+	if err != nil {
+		t.Fatalf("create encryptor: %s", err)
+	}
+	data := []byte("hello")
+	ciphertext, _ := dc.Encrypt(data)
+	plaintext, _ := dc.Decrypt(ciphertext)
+	if !bytes.Equal(data, plaintext) {
+		t.Fatalf("decrypt fail")
+	}
+	if _, err := NewAESEncryptor(kc).Decrypt(ciphertext); err == nil {
+		t.Fatalf("decrypt chacha20 with aes should fail")
+	}
+	if _, err := NewDataEncryptor(kc, "des"); err == nil {
+		t.Fatalf("unsupported algorithm should fail")
+	}
+}
+
+func TestSymmetric(t *testing.T) {
+	master, stored, err := NewSymmetricKey([]byte("secret"))
+	if err != nil {
+		t.Fatalf("new master key: %s", err)
+	}
+	if strings.Contains(stored, base64.StdEncoding.EncodeToString(master)) {
+		t.Fatalf("master key should not be stored: %s", stored)
+	}
+	if loaded, err := LoadSymmetricKey([]byte("secret"), stored); err != nil || !bytes.Equal(loaded, master) {
+		t.Fatalf("load master key: %s", err)
+	}
+	if _, err = LoadSymmetricKey([]byte("wrong"), stored); err == nil {
+		t.Fatalf("load master key with wrong passphrase should fail")
+	}
+	ps := strings.Split(stored, ":")
+	if len(ps) != 6 || ps[0] != "scrypt" || ps[1] != strconv.Itoa(scryptN) {
+		t.Fatalf("parameters of scrypt should be stored: %s", stored)
+	}
+	ps[1] = strconv.Itoa(scryptN / 2)
+	if _, err = LoadSymmetricKey([]byte("secret"), strings.Join(ps, ":")); err == nil {
+		t.Fatalf("load master key with other parameters should fail")
+	}
+	ps[1] = strconv.Itoa(scryptMaxN * 2)
+	if _, err = LoadSymmetricKey([]byte("secret"), strings.Join(ps, ":")); err == nil {
+		t.Fatalf("load master key with too large N should fail")
+	}
+	other, _, _ := NewSymmetricKey([]byte("secret"))
+	if bytes.Equal(master, other) {
+		t.Fatalf("master key should depend on salt")
+	}
+	for _, algo := range []string{AES256GCM_HKDF, CHACHA20_HKDF} {
+		dc, err := NewSymmetricEncryptor(master, algo)
+		if err != nil {
+			t.Fatalf("create encryptor %s: %s", algo, err)
+		}
+		data := []byte("hello")
+		ciphertext, _ := dc.Encrypt(data)
+		plaintext, err := dc.Decrypt(ciphertext)
+		if err != nil || !bytes.Equal(data, plaintext) {
+			t.Fatalf("decrypt %s: %s", algo, err)
+		}
+		dc2, _ := NewSymmetricEncryptor(other, algo)
+		if _, err = dc2.Decrypt(ciphertext); err == nil {
+			t.Fatalf("decrypt with another key should fail")
+		}
+		ciphertext[len(ciphertext)-1] ^= 1
+		if _, err = dc.Decrypt(ciphertext); err == nil {
+			t.Fatalf("decrypt corrupted data should fail")
+		}
+		if _, err = dc.Decrypt(ciphertext[:10]); err == nil {
+			t.Fatalf("decrypt truncated data should fail")
+		}
+	}
+	if _, err := NewSymmetricEncryptor(master, AES256GCM_RSA); err == nil {
+		t.Fatalf("rsa algorithm should fail")
+	}
+}
+
+func benchmarkEncryptor(b *testing.B, dc Encryptor) {
+	data := make([]byte, 4<<20)
+	ciphertext, _ := dc.Encrypt(data)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = dc.Decrypt(append([]byte{}, ciphertext...))
+	}
+}
+
+func BenchmarkAESGCMDecrypt(b *testing.B) {
+	benchmarkEncryptor(b, NewAESEncryptor(NewRSAEncryptor(testkey)))
+}
+
+func BenchmarkHKDFDecrypt(b *testing.B) {
+	master, _ := DeriveMasterKey([]byte("secret"), []byte("salt"), scryptN, scryptR, scryptP)
+	for _, algo := range []string{AES256GCM_HKDF, CHACHA20_HKDF} {
+		dc, _ := NewSymmetricEncryptor(master, algo)
+		b.Run(algo, func(b *testing.B) { benchmarkEncryptor(b, dc) })
+	}
+}
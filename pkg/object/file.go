@@ -18,11 +18,14 @@ package object
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"math/rand"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -34,14 +37,22 @@ import (
 )
 
 const (
-	dirSuffix = "/"
+	dirSuffix      = "/"
+	checksumSuffix = ".crc32c"
 )
 
 var TryCFR bool // try copy_file_range
 
+// ErrNoChecksum is returned by Scrub if the object has no checksum stored.
+var ErrNoChecksum = errors.New("no checksum")
+
 type filestore struct {
 	DefaultObjectStorage
-	root string
+	root     string
+	fsync    bool // fsync the file before renaming it
+	dirSync  bool // fsync the parent directory after renaming
+	directIO bool // read with O_DIRECT to bypass the page cache
+	checksum bool // store the crc32c of each object in a sidecar file
 }
 
 func (d *filestore) Symlink(oldName, newName string) error {
@@ -74,6 +85,52 @@ func (d *filestore) path(key string) string {
 	return d.root + key
 }
 
+func checksumPath(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+checksumSuffix)
+}
+
+func isChecksumFile(name string) bool {
+	name = filepath.Base(strings.TrimSuffix(name, dirSuffix))
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, checksumSuffix)
+}
+
+func (d *filestore) readChecksum(p string) (string, error) {
+	cs, err := ioutil.ReadFile(checksumPath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoChecksum
+		}
+		return "", err
+	}
+	// a broken sidecar means the object can not be verified, which is treated as corruption
+	v := strings.TrimSpace(string(cs))
+	if _, err = strconv.ParseUint(v, 10, 32); err != nil {
+		return "", fmt.Errorf("invalid checksum %q in %s", v, checksumPath(p))
+	}
+	return v, nil
+}
+
+func (d *filestore) syncDir(dir string) error {
+	if !d.dirSync {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	_ = f.Close()
+	return err
+}
+
+func (d *filestore) readDirect(p string, off, limit int64) (io.ReadCloser, error) {
+	data, err := readDirect(p, off, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
 func (d *filestore) Head(key string) (Object, error) {
 	p := d.path(key)
 
@@ -93,9 +150,31 @@ func (d *filestore) Head(key string) (Object, error) {
 	}, nil
 }
 
+// Get verifies the object against its checksum only when it's read as a whole, because the
+// checksum covers the whole object, the range reads are verified by Scrub.
 func (d *filestore) Get(key string, off, limit int64) (io.ReadCloser, error) {
 	p := d.path(key)
+	r, err := d.get(p, off, limit)
+	if err != nil || !d.checksum || off != 0 || limit != -1 {
+		return r, err
+	}
+	cs, err := d.readChecksum(p)
+	if err == ErrNoChecksum {
+		return r, nil
+	} else if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return verifyChecksum(r, cs), nil
+}
 
+func (d *filestore) get(p string, off, limit int64) (io.ReadCloser, error) {
+	if d.directIO {
+		r, err := d.readDirect(p, off, limit)
+		if err != errDirectIONotSupported {
+			return r, err
+		}
+	}
 	f, err := os.Open(p)
 	if err != nil {
 		return nil, err
@@ -153,12 +232,20 @@ func (d *filestore) Put(key string, in io.Reader) error {
 		}
 	}()
 
-	if TryCFR {
+	var w io.Writer = f
+	var hash uint32
+	if d.checksum {
+		w = io.MultiWriter(f, crc32Writer{&hash})
+	}
+	if TryCFR && !d.checksum {
 		_, err = io.Copy(f, in)
 	} else {
 		buf := bufPool.Get().(*[]byte)
 		defer bufPool.Put(buf)
-		_, err = io.CopyBuffer(onlyWriter{f}, in, *buf)
+		_, err = io.CopyBuffer(onlyWriter{w}, in, *buf)
+	}
+	if err == nil && d.fsync {
+		err = f.Sync()
 	}
 	if err != nil {
 		_ = f.Close()
@@ -168,7 +255,83 @@ func (d *filestore) Put(key string, in io.Reader) error {
 	if err != nil {
 		return err
 	}
-	err = os.Rename(tmp, p)
+	if !d.checksum {
+		err = os.Rename(tmp, p)
+		if err == nil {
+			err = d.syncDir(filepath.Dir(p))
+		}
+		return err
+	}
+
+	// the old checksum is removed before the data is replaced and the new one is written after it,
+	// so an object is never verified against the checksum of another one, at worst it's unchecked.
+	cp := checksumPath(p)
+	old, err := ioutil.ReadFile(cp)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(cp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Rename(tmp, p); err != nil {
+		if old != nil {
+			_ = d.writeFile(cp, old)
+		}
+		return err
+	}
+	if err = d.writeFile(cp, []byte(strconv.Itoa(int(hash)))); err != nil {
+		return err
+	}
+	return d.syncDir(filepath.Dir(p))
+}
+
+type crc32Writer struct {
+	hash *uint32
+}
+
+func (w crc32Writer) Write(p []byte) (int, error) {
+	*w.hash = crc32.Update(*w.hash, crc32c, p)
+	return len(p), nil
+}
+
+// writeFile writes data into a temporary file and renames it to p atomically.
+func (d *filestore) writeFile(p string, data []byte) error {
+	tmp := p + ".tmp" + strconv.Itoa(rand.Int())
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && d.fsync {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// Scrub reads the whole object and verifies it against the stored checksum.
+func (d *filestore) Scrub(key string) error {
+	p := d.path(key)
+	expected, err := d.readChecksum(p)
+	if err != nil {
+		return err
+	}
+	r, err := d.get(p, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+	_, err = io.CopyBuffer(ioutil.Discard, verifyChecksum(r, expected), *buf)
 	return err
 }
 
@@ -182,10 +345,16 @@ func (d *filestore) Copy(dst, src string) error {
 }
 
 func (d *filestore) Delete(key string) error {
-	err := os.Remove(d.path(key))
+	p := d.path(key)
+	err := os.Remove(p)
 	if err != nil && os.IsNotExist(err) {
 		err = nil
 	}
+	if err == nil && d.checksum {
+		if err = os.Remove(checksumPath(p)); err != nil && os.IsNotExist(err) {
+			err = nil
+		}
+	}
 	return err
 }
 
@@ -345,6 +514,9 @@ func (d *filestore) ListAll(prefix, marker string) (<-chan Object, error) {
 			}
 
 			key := path[len(d.root):]
+			if !info.IsDir() && isChecksumFile(key) {
+				return nil
+			}
 			if !strings.HasPrefix(key, prefix) || (marker != "" && key <= marker) {
 				if info.IsDir() && !strings.HasPrefix(prefix, key) && !strings.HasPrefix(marker, key) {
 					return filepath.SkipDir
@@ -397,6 +569,33 @@ func newDisk(root, accesskey, secretkey string) (ObjectStorage, error) {
 	if runtime.GOOS == "windows" && strings.HasPrefix(root, "/") {
 		root = root[1:]
 	}
+	store := &filestore{}
+	if p := strings.Index(root, "?"); p > 0 {
+		values, err := url.ParseQuery(root[p+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid options %s: %s", root[p+1:], err)
+		}
+		root = root[:p]
+		for k := range values {
+			var opt *bool
+			switch k {
+			case "fsync":
+				opt = &store.fsync
+			case "dirsync":
+				opt = &store.dirSync
+			case "direct":
+				opt = &store.directIO
+			case "checksum":
+				opt = &store.checksum
+			default:
+				return nil, fmt.Errorf("unknown option for file storage: %s", k)
+			}
+			if *opt, err = strconv.ParseBool(values.Get(k)); err != nil {
+				return nil, fmt.Errorf("invalid value for option %s: %s", k, values.Get(k))
+			}
+		}
+	}
+	store.root = root
 	if strings.HasSuffix(root, dirSuffix) {
 		logger.Debugf("Ensure directory %s", root)
 		if err := os.MkdirAll(root, 0755); err != nil {
@@ -409,7 +608,7 @@ func newDisk(root, accesskey, secretkey string) (ObjectStorage, error) {
 			return nil, fmt.Errorf("Creating directory %s failed: %q", dir, err)
 		}
 	}
-	return &filestore{root: root}, nil
+	return store, nil
 }
 
 func init() {
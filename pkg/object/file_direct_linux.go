@@ -0,0 +1,83 @@
+/*
+ * JuiceFS, Copyright 2022 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const directIOAlign = 4096
+
+var errDirectIONotSupported = errors.New("direct IO is not supported")
+
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlign)
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlign - 1))
+	if shift != 0 {
+		shift = directIOAlign - shift
+	}
+	return buf[shift : shift+size]
+}
+
+// readDirect reads a range of the file with O_DIRECT, bypassing the page cache.
+func readDirect(p string, off, limit int64) ([]byte, error) {
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EINVAL) {
+			return nil, errDirectIONotSupported
+		}
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil
+	}
+	size := fi.Size()
+	if off > size {
+		off = size
+	}
+	end := size
+	if limit >= 0 && off+limit < size {
+		end = off + limit
+	}
+	start := off &^ (directIOAlign - 1)
+	aligned := (end + directIOAlign - 1) &^ (directIOAlign - 1)
+	buf := alignedBuffer(int(aligned - start))
+	var n int
+	for n < len(buf) {
+		m, err := f.ReadAt(buf[n:], start+int64(n))
+		n += m
+		if err != nil || m == 0 {
+			if errors.Is(err, syscall.EINVAL) {
+				return nil, errDirectIONotSupported
+			}
+			break
+		}
+	}
+	if int64(n) < end-start {
+		return nil, fmt.Errorf("short read of %s: %d < %d", p, n, end-start)
+	}
+	return buf[off-start : end-start], nil
+}
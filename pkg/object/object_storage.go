@@ -43,6 +43,11 @@ type SupportSymlink interface {
 	Readlink(name string) (string, error)
 }
 
+type SupportScrub interface {
+	// Scrub reads the whole object and verifies it against the stored checksum
+	Scrub(key string) error
+}
+
 type File interface {
 	Object
 	Owner() string
@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -255,6 +256,108 @@ func TestDisk(t *testing.T) {
 	testStorage(t, s)
 }
 
+func TestDiskHardened(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newDisk(dir+"/?fsync=true&dirsync=true&direct=true&checksum=true", "", "")
+	if err != nil {
+		t.Fatalf("create disk: %s", err)
+	}
+	testStorage(t, s)
+
+	if _, err = newDisk(dir+"/?unknown=true", "", ""); err == nil {
+		t.Fatalf("unknown option should fail")
+	}
+	if err = s.Put("a/b", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if d, err := get(s, "a/b", 1, 3); err != nil || d != "ell" {
+		t.Fatalf("get range: %q %s", d, err)
+	}
+	objs, err := listAll(s, "a/", "", 10)
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	for _, o := range objs {
+		if strings.HasSuffix(o.Key(), checksumSuffix) {
+			t.Fatalf("checksum file %s should not be listed", o.Key())
+		}
+	}
+	scrub := s.(SupportScrub)
+	if err = scrub.Scrub("a/b"); err != nil {
+		t.Fatalf("scrub: %s", err)
+	}
+	if err = s.Put("a/b", bytes.NewReader([]byte("world"))); err != nil {
+		t.Fatalf("overwrite: %s", err)
+	}
+	if err = scrub.Scrub("a/b"); err != nil {
+		t.Fatalf("scrub after overwrite: %s", err)
+	}
+	// the old checksum is kept if the data can not be replaced
+	_ = os.MkdirAll(filepath.Join(dir, "a", "d", "e"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "a", ".d"+checksumSuffix), []byte("123"), 0644)
+	if err = s.Put("a/d", bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatalf("put onto a directory should fail")
+	}
+	if cs, _ := os.ReadFile(filepath.Join(dir, "a", ".d"+checksumSuffix)); string(cs) != "123" {
+		t.Fatalf("checksum should be restored: %q", cs)
+	}
+	if fs, _ := filepath.Glob(filepath.Join(dir, "a", "*.tmp*")); len(fs) > 0 {
+		t.Fatalf("temporary files should be removed: %v", fs)
+	}
+	_ = os.RemoveAll(filepath.Join(dir, "a", "d"))
+	_ = os.Remove(filepath.Join(dir, "a", ".d"+checksumSuffix))
+	// bitrot
+	if err = os.WriteFile(filepath.Join(dir, "a", "b"), []byte("hellO"), 0644); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if err = scrub.Scrub("a/b"); err == nil {
+		t.Fatalf("scrub should find the corruption")
+	}
+	if _, err = get(s, "a/b", 0, -1); err == nil {
+		t.Fatalf("get corrupted object should fail")
+	}
+	if err = s.Delete("a/b"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "a", ".b"+checksumSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("checksum file should be deleted: %s", err)
+	}
+	_ = os.WriteFile(filepath.Join(dir, "c"), []byte("hello"), 0644)
+	if err = scrub.Scrub("c"); err != ErrNoChecksum {
+		t.Fatalf("expect no checksum, but got %s", err)
+	}
+	// broken sidecar
+	if err = s.Put("d", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	for _, cs := range []string{"", "garbage", "-1"} {
+		_ = os.WriteFile(filepath.Join(dir, ".d"+checksumSuffix), []byte(cs), 0644)
+		if err = scrub.Scrub("d"); err == nil || err == ErrNoChecksum {
+			t.Fatalf("scrub with checksum %q should fail: %v", cs, err)
+		}
+		if _, err = get(s, "d", 0, -1); err == nil {
+			t.Fatalf("get with checksum %q should fail", cs)
+		}
+	}
+
+	// sharded
+	sh, err := NewSharded("file", dir+"/shard%d/?checksum=true", "", "", 3)
+	if err != nil {
+		t.Fatalf("create sharded: %s", err)
+	}
+	ps := WithPrefix(sh, "vol/")
+	for i := 0; i < 10; i++ {
+		if err = ps.Put(fmt.Sprintf("k%d", i), bytes.NewReader([]byte("hello"))); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err = ps.(SupportScrub).Scrub(fmt.Sprintf("k%d", i)); err != nil {
+			t.Fatalf("scrub sharded: %s", err)
+		}
+	}
+}
+
 func TestQingStor(t *testing.T) {
 	if os.Getenv("QY_ACCESS_KEY") == "" {
 		t.SkipNow()
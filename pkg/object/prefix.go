@@ -47,6 +47,13 @@ func (s *withPrefix) Readlink(name string) (string, error) {
 	return "", notSupported
 }
 
+func (s *withPrefix) Scrub(key string) error {
+	if w, ok := s.os.(SupportScrub); ok {
+		return w.Scrub(s.prefix + key)
+	}
+	return notSupported
+}
+
 func (p *withPrefix) String() string {
 	return fmt.Sprintf("%s%s", p.os, p.prefix)
 }
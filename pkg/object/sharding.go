@@ -67,6 +67,13 @@ func (s *sharded) Delete(key string) error {
 	return s.pick(key).Delete(key)
 }
 
+func (s *sharded) Scrub(key string) error {
+	if w, ok := s.pick(key).(SupportScrub); ok {
+		return w.Scrub(key)
+	}
+	return notSupported
+}
+
 const maxResults = 10000
 
 // ListAll on all the keys that starts at marker from object storage.
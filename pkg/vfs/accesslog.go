@@ -17,20 +17,19 @@
 package vfs
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
-	"github.com/prometheus/client_golang/prometheus"
-)
-
-var (
-	opsDurationsHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "fuse_ops_durations_histogram_seconds",
-		Help:    "Operations latency distributions.",
-		Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 30),
-	})
 )
 
 type logReader struct {
@@ -39,34 +38,175 @@ type logReader struct {
 	last   []byte
 }
 
+// accessLog is the access log of a volume, which is read by the handles of .accesslog and
+// written into the file of --access-log (the reader with fh 0, which is not used by any handle).
+type accessLog struct {
+	sync.Mutex
+	readers map[uint64]*logReader
+
+	fileLock sync.Mutex
+	filePath string
+	fileStop chan struct{}
+}
+
 var (
-	readerLock sync.Mutex
-	readers    map[uint64]*logReader
+	confLock   sync.Mutex
+	accessConf AccessLogConfig
+	accessOps  map[string]bool
+	logPaths   int32 // whether the paths of operations are needed
 )
 
-func init() {
-	readers = make(map[uint64]*logReader)
+// AccessLogConfig is the format and filters of the access log, which are applied to all the
+// readers of it (.accesslog and --access-log) of all the volumes in the process.
+type AccessLogConfig struct {
+	JSON       bool          // JSON lines instead of text
+	Ops        []string      // only the operations in the list if not empty
+	PathPrefix string        // only the operations under the directory
+	MinLatency time.Duration // only the operations slower than it
+	MaxSize    int64         // rotate the log file when it's larger than it, 0 means never
+	MaxBackups int           // number of rotated log files to keep
+}
+
+// SetAccessLogConfig changes the format and filters of the access log.
+func SetAccessLogConfig(conf AccessLogConfig) {
+	confLock.Lock()
+	defer confLock.Unlock()
+	if conf.PathPrefix != "" {
+		conf.PathPrefix = path.Clean("/" + conf.PathPrefix)
+	}
+	accessConf = conf
+	accessOps = nil
+	for _, op := range conf.Ops {
+		if op = strings.TrimSpace(op); op != "" {
+			if accessOps == nil {
+				accessOps = make(map[string]bool)
+			}
+			accessOps[op] = true
+		}
+	}
+	if conf.JSON || conf.PathPrefix != "" && conf.PathPrefix != "/" {
+		atomic.StoreInt32(&logPaths, 1)
+	} else {
+		atomic.StoreInt32(&logPaths, 0)
+	}
 }
 
-func logit(ctx Context, format string, args ...interface{}) {
+// opInfo is the structured part of an operation in the access log.
+type opInfo struct {
+	ino   Ino
+	name  string // name in the directory ino, for operations on entries
+	size  int64  // bytes read or written
+	err   syscall.Errno
+	entry *meta.Entry // the entry of name
+	path  string
+}
+
+// accessEntry is a line of the access log in JSON.
+type accessEntry struct {
+	Time    string  `json:"time"`
+	Op      string  `json:"op"`
+	Inode   Ino     `json:"inode"`
+	Path    string  `json:"path,omitempty"`
+	Uid     uint32  `json:"uid"`
+	Gid     uint32  `json:"gid"`
+	Pid     uint32  `json:"pid"`
+	Size    int64   `json:"size,omitempty"`
+	Latency float64 `json:"latency"` // in seconds
+	Errno   int     `json:"errno"`
+	Args    string  `json:"args"`
+}
+
+// logit logs an operation into the access log, with the path of it if needed.
+func (v *VFS) logit(ctx Context, op string, info opInfo, format string, args ...interface{}) {
+	if atomic.LoadInt32(&logPaths) != 0 {
+		if info.err == 0 && info.entry != nil && info.name != "" {
+			v.paths.add(info.ino, info.name, info.entry.Inode)
+		}
+		info.path = v.paths.path(info.ino, info.name)
+		if info.path == "" && v.Meta != nil && v.accessLog.wanted(ctx, op) {
+			v.resolvePath(info.ino)
+			info.path = v.paths.path(info.ino, info.name)
+		}
+	}
+	v.metrics.opsDurations.Observe(ctx.Duration().Seconds())
+	v.accessLog.logit(ctx, op, info, format, args...)
+}
+
+// wanted returns whether the operation could be logged, so its path is worth resolving.
+func (l *accessLog) wanted(ctx Context, op string) bool {
+	l.Lock()
+	n := len(l.readers)
+	l.Unlock()
+	confLock.Lock()
+	defer confLock.Unlock()
+	return n > 0 && ctx.Duration() >= accessConf.MinLatency && (accessOps == nil || accessOps[op])
+}
+
+// resolvePath looks up the ancestors of ino missed in the path cache from meta, and remembers them
+// together with their siblings.
+func (v *VFS) resolvePath(ino Ino) {
+	var attr Attr
+	for i := 0; ino != rootID && i < 1000; i++ {
+		if v.paths.has(ino) {
+			return
+		}
+		if st := v.Meta.GetAttr(meta.Background, ino, &attr); st != 0 || attr.Parent == 0 {
+			return // the inode with hard links has no parent
+		}
+		var entries []*meta.Entry
+		if st := v.Meta.Readdir(meta.Background, attr.Parent, 0, &entries); st != 0 {
+			return
+		}
+		v.paths.addEntries(attr.Parent, entries)
+		ino = attr.Parent
+	}
+}
+
+func matchPrefix(p, prefix string) bool {
+	return prefix == "" || prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+func (l *accessLog) logit(ctx Context, op string, info opInfo, format string, args ...interface{}) {
 	used := ctx.Duration()
-	opsDurationsHistogram.Observe(used.Seconds())
-	readerLock.Lock()
-	defer readerLock.Unlock()
-	if len(readers) == 0 && used < time.Second*10 {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.readers) == 0 && used < time.Second*10 {
 		return
 	}
 
-	cmd := fmt.Sprintf(format, args...)
+	cmd := op + " " + fmt.Sprintf(format, args...)
 	t := utils.Now()
-	ts := t.Format("2006.01.02 15:04:05.000000")
-	cmd += fmt.Sprintf(" <%.6f>", used.Seconds())
 	if ctx.Pid() != 0 && used >= time.Second*10 {
-		logger.Infof("slow operation: %s", cmd)
+		logger.Infof("slow operation: %s <%.6f>", cmd, used.Seconds())
+	}
+	confLock.Lock()
+	conf, ops := accessConf, accessOps
+	confLock.Unlock()
+	if len(l.readers) == 0 || used < conf.MinLatency || ops != nil && !ops[op] || !matchPrefix(info.path, conf.PathPrefix) {
+		return
+	}
+	var line []byte
+	if conf.JSON {
+		line, _ = json.Marshal(&accessEntry{
+			Time:    t.Format("2006-01-02T15:04:05.000000Z07:00"),
+			Op:      op,
+			Inode:   info.ino,
+			Path:    info.path,
+			Uid:     ctx.Uid(),
+			Gid:     ctx.Gid(),
+			Pid:     ctx.Pid(),
+			Size:    info.size,
+			Latency: used.Seconds(),
+			Errno:   int(info.err),
+			Args:    cmd[len(op)+1:],
+		})
+		line = append(line, '\n')
+	} else {
+		ts := t.Format("2006.01.02 15:04:05.000000")
+		line = []byte(fmt.Sprintf("%s [uid:%d,gid:%d,pid:%d] %s <%.6f>\n", ts, ctx.Uid(), ctx.Gid(), ctx.Pid(), cmd, used.Seconds()))
 	}
-	line := []byte(fmt.Sprintf("%s [uid:%d,gid:%d,pid:%d] %s\n", ts, ctx.Uid(), ctx.Gid(), ctx.Pid(), cmd))
 
-	for _, r := range readers {
+	for _, r := range l.readers {
 		select {
 		case r.buffer <- line:
 		default:
@@ -74,23 +214,252 @@ func logit(ctx Context, format string, args ...interface{}) {
 	}
 }
 
-func openAccessLog(fh uint64) uint64 {
-	readerLock.Lock()
-	defer readerLock.Unlock()
-	readers[fh] = &logReader{buffer: make(chan []byte, 10240)}
+// pathCache remembers the parents and names of the inodes looked up when the paths are
+// needed by the access log, so the paths of operations can be built without meta.
+type pathCache struct {
+	sync.Mutex
+	entries map[Ino]pathEntry
+}
+
+type pathEntry struct {
+	parent Ino
+	name   string
+}
+
+const maxPathEntries = 1 << 20
+
+func (c *pathCache) add(parent Ino, name string, ino Ino) {
+	if atomic.LoadInt32(&logPaths) == 0 || name == "." || name == ".." {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.entries == nil || len(c.entries) >= maxPathEntries {
+		c.entries = make(map[Ino]pathEntry)
+	}
+	c.entries[ino] = pathEntry{parent, name}
+}
+
+func (c *pathCache) addEntries(parent Ino, entries []*meta.Entry) {
+	for _, e := range entries {
+		c.add(parent, string(e.Name), e.Inode)
+	}
+}
+
+func (c *pathCache) has(ino Ino) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.entries[ino]
+	return ok
+}
+
+// path returns the path of name in directory ino (or ino itself if name is empty),
+// or an empty string if it's unknown.
+func (c *pathCache) path(ino Ino, name string) string {
+	c.Lock()
+	defer c.Unlock()
+	var names []string
+	if name != "" {
+		names = append(names, name)
+	}
+	for ino != rootID {
+		e, ok := c.entries[ino]
+		if !ok || len(names) > 1000 { // a loop
+			return ""
+		}
+		names = append(names, e.name)
+		ino = e.parent
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+// rotateFile is a log file rotated by size, the old ones are renamed to path.1, path.2 ...
+type rotateFile struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+func openRotateFile(path string) (*rotateFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	if st, err := f.Stat(); err == nil {
+		size = st.Size()
+	}
+	return &rotateFile{path, f, size}, nil
+}
+
+func (r *rotateFile) rotate(backups int) error {
+	_ = r.f.Close()
+	for i := backups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	var err error
+	if backups > 0 {
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err != nil {
+		logger.Warnf("rotate access log %s: %s", r.path, err)
+	}
+	r.f, r.size = nil, 0
+	return r.reopen()
+}
+
+func (r *rotateFile) reopen() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		r.f = f
+	}
+	return err
+}
+
+func (r *rotateFile) Write(line []byte) (int, error) {
+	confLock.Lock()
+	maxSize, backups := accessConf.MaxSize, accessConf.MaxBackups
+	confLock.Unlock()
+	if maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > maxSize {
+		if err := r.rotate(backups); err != nil {
+			return 0, err
+		}
+	}
+	if r.f == nil {
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(line)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotateFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// socketSink sends the access log to a local socket, the lines are dropped if it's not connected.
+type socketSink struct {
+	addr     string
+	conn     net.Conn
+	lastDial time.Time
+}
+
+func (s *socketSink) Write(line []byte) (int, error) {
+	if s.conn == nil {
+		if time.Since(s.lastDial) < time.Second {
+			return 0, nil
+		}
+		s.lastDial = time.Now()
+		conn, err := net.DialTimeout("unix", s.addr, time.Second)
+		if err != nil {
+			logger.Debugf("connect to access log socket %s: %s", s.addr, err)
+			return 0, nil
+		}
+		s.conn = conn
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	n, err := s.conn.Write(line)
+	if err != nil {
+		logger.Warnf("write access log to %s: %s", s.addr, err)
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return n, nil
+}
+
+func (s *socketSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SetAccessLog writes the access log of all operations in the volume into a file or a local
+// socket (unix://PATH), or stops it if path is empty.
+func (v *VFS) SetAccessLog(path string) error {
+	return v.accessLog.setFile(path)
+}
+
+func (l *accessLog) setFile(path string) error {
+	l.fileLock.Lock()
+	defer l.fileLock.Unlock()
+	if path == l.filePath {
+		return nil
+	}
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	if strings.HasPrefix(path, "unix://") {
+		w = &socketSink{addr: path[len("unix://"):]}
+	} else if path != "" {
+		f, err := openRotateFile(path)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	if l.fileStop != nil {
+		l.close(0)
+		close(l.fileStop)
+		l.fileStop = nil
+	}
+	l.filePath = path
+	if w == nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	l.fileStop = stop
+	l.open(0)
+	l.Lock()
+	r := l.readers[0]
+	l.Unlock()
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case line := <-r.buffer:
+				if _, err := w.Write(line); err != nil {
+					logger.Errorf("write access log %s: %s", path, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *accessLog) open(fh uint64) uint64 {
+	l.Lock()
+	defer l.Unlock()
+	if l.readers == nil {
+		l.readers = make(map[uint64]*logReader)
+	}
+	l.readers[fh] = &logReader{buffer: make(chan []byte, 10240)}
 	return fh
 }
 
-func closeAccessLog(fh uint64) {
-	readerLock.Lock()
-	defer readerLock.Unlock()
-	delete(readers, fh)
+func (l *accessLog) close(fh uint64) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.readers, fh)
 }
 
-func readAccessLog(fh uint64, buf []byte) int {
-	readerLock.Lock()
-	r, ok := readers[fh]
-	readerLock.Unlock()
+func (l *accessLog) read(fh uint64, buf []byte) int {
+	l.Lock()
+	r, ok := l.readers[fh]
+	l.Unlock()
 	if !ok {
 		return 0
 	}
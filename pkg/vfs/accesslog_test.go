@@ -17,6 +17,13 @@
 package vfs
 
 import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -24,13 +31,16 @@ import (
 )
 
 func TestAccessLog(t *testing.T) {
-	openAccessLog(1)
-	defer closeAccessLog(1)
+	var l, other accessLog
+	l.open(1)
+	defer l.close(1)
+	other.open(1)
+	defer other.close(1)
 
 	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
-	logit(ctx, "test")
+	l.logit(ctx, "test", opInfo{}, "(%d)", 1)
 
-	n := readAccessLog(2, nil)
+	n := l.read(2, nil)
 	if n != 0 {
 		t.Fatalf("invalid fd")
 	}
@@ -38,7 +48,7 @@ func TestAccessLog(t *testing.T) {
 	now := time.Now()
 	// partial read
 	buf := make([]byte, 1024)
-	n = readAccessLog(1, buf[:10])
+	n = l.read(1, buf[:10])
 	if n != 10 {
 		t.Fatalf("partial read: %d", n)
 	}
@@ -47,8 +57,8 @@ func TestAccessLog(t *testing.T) {
 	}
 
 	// read whole line, block for 1 second
-	n = readAccessLog(1, buf[10:])
-	if n != 54 {
+	n = l.read(1, buf[10:])
+	if n != 58 {
 		t.Fatalf("partial read: %d", n)
 	}
 	logs := string(buf[:10+n])
@@ -61,13 +71,152 @@ func TestAccessLog(t *testing.T) {
 	if now.Sub(ts.Local()) > time.Millisecond*10 {
 		t.Fatalf("stale time: %s now: %s", ts, time.Now())
 	}
-	if logs[26:len(logs)-4] != " [uid:1,gid:2,pid:10] test <0.0000" {
+	if logs[26:len(logs)-4] != " [uid:1,gid:2,pid:10] test (1) <0.0000" {
 		t.Fatalf("unexpected log: %q", logs[26:])
 	}
 
 	// block read
-	n = readAccessLog(1, buf)
+	n = l.read(1, buf)
 	if n != 2 || string(buf[:2]) != "#\n" {
 		t.Fatalf("expected line: %q", string(buf[:n]))
 	}
+	// the access log of another volume
+	if n = other.read(1, buf); string(buf[:n]) != "#\n" {
+		t.Fatalf("operations of other volumes should not be logged: %q", string(buf[:n]))
+	}
+}
+
+func TestAccessLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	var l accessLog
+	if err := l.setFile(path); err != nil {
+		t.Fatalf("set access log: %s", err)
+	}
+	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
+	l.logit(ctx, "to", opInfo{}, "file")
+	time.Sleep(time.Millisecond * 100)
+	if err := l.setFile(""); err != nil {
+		t.Fatalf("stop access log: %s", err)
+	}
+	l.logit(ctx, "not", opInfo{}, "logged")
+	time.Sleep(time.Millisecond * 100)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read access log: %s", err)
+	}
+	if !strings.Contains(string(data), "to file") || strings.Contains(string(data), "not logged") {
+		t.Fatalf("unexpected access log: %q", string(data))
+	}
+	if err = l.setFile(filepath.Join(path, "invalid")); err == nil {
+		t.Fatalf("set invalid access log should fail")
+	}
+}
+
+func TestStructuredAccessLog(t *testing.T) {
+	SetAccessLogConfig(AccessLogConfig{JSON: true, Ops: []string{"read", "lookup"}, PathPrefix: "/d/"})
+	defer SetAccessLogConfig(AccessLogConfig{})
+
+	v := &VFS{metrics: newVFSMetrics()}
+	v.accessLog.open(1)
+	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
+	v.logit(ctx, "lookup", opInfo{ino: 1, name: "d", entry: &meta.Entry{Inode: 2}}, "(1,d)")
+	v.logit(ctx, "lookup", opInfo{ino: 2, name: "f", entry: &meta.Entry{Inode: 3}}, "(2,f)")
+	v.logit(ctx, "write", opInfo{ino: 3, size: 10}, "(3,10,0)")                  // filtered by op
+	v.logit(ctx, "read", opInfo{ino: 4, size: 10}, "(4,10,0)")                   // unknown path
+	v.logit(ctx, "read", opInfo{ino: 3, size: 10, err: syscall.EIO}, "(3,10,0)") // logged
+
+	buf := make([]byte, 4096)
+	n := v.accessLog.read(1, buf)
+	lines := strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 lines, got %q", lines)
+	}
+	var e accessEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("unmarshal %s: %s", lines[0], err)
+	}
+	if e.Op != "lookup" || e.Path != "/d" || e.Inode != 1 || e.Uid != 1 || e.Gid != 2 || e.Pid != 10 || e.Args != "(1,d)" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &e); err != nil {
+		t.Fatalf("unmarshal %s: %s", lines[2], err)
+	}
+	if e.Op != "read" || e.Path != "/d/f" || e.Size != 10 || e.Errno != int(syscall.EIO) {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+
+	// the paths missed in the cache are resolved by meta
+	v, _ = createTestVFS()
+	v.accessLog.open(1)
+	defer v.accessLog.close(1)
+	var d, f Ino
+	var attr Attr
+	if st := v.Meta.Mkdir(meta.Background, 1, "d", 0755, 0, 0, &d, &attr); st != 0 {
+		t.Fatalf("mkdir: %s", st)
+	}
+	if st := v.Meta.Create(meta.Background, d, "f", 0644, 0, 0, &f, &attr); st != 0 {
+		t.Fatalf("create: %s", st)
+	}
+	v.logit(ctx, "read", opInfo{ino: f, size: 10}, "(%d,10,0)", f)
+	n = v.accessLog.read(1, buf)
+	if err := json.Unmarshal(buf[:n], &e); err != nil || e.Path != "/d/f" || e.Inode != f {
+		t.Fatalf("unexpected entry %q: %s", buf[:n], err)
+	}
+
+	SetAccessLogConfig(AccessLogConfig{MinLatency: time.Hour})
+	v.accessLog.logit(ctx, "read", opInfo{}, "(3,10,0)")
+	if n = v.accessLog.read(1, buf); string(buf[:n]) != "#\n" {
+		t.Fatalf("fast operations should be filtered: %q", string(buf[:n]))
+	}
+}
+
+func TestAccessLogRotate(t *testing.T) {
+	SetAccessLogConfig(AccessLogConfig{MaxSize: 200, MaxBackups: 2})
+	defer SetAccessLogConfig(AccessLogConfig{})
+	path := filepath.Join(t.TempDir(), "access.log")
+	r, err := openRotateFile(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	for i := 0; i < 7; i++ {
+		if _, err = r.Write(line); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+	_ = r.Close()
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if st, err := os.Stat(name); err != nil || st.Size() == 0 || st.Size() > 200 {
+			t.Fatalf("stat %s: %v %v", name, st, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 should not exist: %v", path, err)
+	}
+}
+
+func TestAccessLogSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+	var al accessLog
+	if err = al.setFile("unix://" + addr); err != nil {
+		t.Fatalf("set access log: %s", err)
+	}
+	defer func() { _ = al.setFile("") }()
+	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
+	al.logit(ctx, "to", opInfo{}, "socket")
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(line, "to socket") {
+		t.Fatalf("read from socket: %q %v", line, err)
+	}
 }
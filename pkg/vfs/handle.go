@@ -17,6 +17,7 @@
 package vfs
 
 import (
+	"io"
 	"sync"
 	"syscall"
 	"time"
@@ -39,6 +40,8 @@ type handle struct {
 	reader     FileReader
 	writer     FileWriter
 	ops        []Context
+	appendOnly bool               // can only be written at the end
+	pins       map[io.Closer]bool // the cached blocks used by ReadFd, released with the handle
 
 	// rwlock
 	writing uint32
@@ -132,7 +135,26 @@ func (h *handle) Wunlock() {
 	h.Unlock()
 }
 
+// pin keeps a cached block opened until the handle is released, because the fd returned by ReadFd
+// is used after the reply, and the kernel will not release the handle before the replies of reads.
+func (h *handle) pin(p io.Closer) {
+	h.Lock()
+	defer h.Unlock()
+	if h.pins[p] {
+		_ = p.Close() // pinned already
+		return
+	}
+	if h.pins == nil {
+		h.pins = make(map[io.Closer]bool)
+	}
+	h.pins[p] = true
+}
+
 func (h *handle) Close() {
+	for p := range h.pins {
+		_ = p.Close()
+	}
+	h.pins = nil
 	if h.reader != nil {
 		h.reader.Close(meta.Background)
 		h.reader = nil
@@ -190,18 +212,19 @@ func (v *VFS) releaseHandle(inode Ino, fh uint64) {
 	}
 }
 
-func (v *VFS) newFileHandle(inode Ino, length uint64, flags uint32) uint64 {
+func (v *VFS) newFileHandle(inode Ino, attr *Attr, flags uint32) uint64 {
 	h := v.newHandle(inode)
 	h.Lock()
 	defer h.Unlock()
 	switch flags & O_ACCMODE {
 	case syscall.O_RDONLY:
-		h.reader = v.reader.Open(inode, length)
+		h.reader = v.reader.Open(inode, attr.Length)
 	case syscall.O_WRONLY: // FUSE writeback_cache mode need reader even for WRONLY
 		fallthrough
 	case syscall.O_RDWR:
-		h.reader = v.reader.Open(inode, length)
-		h.writer = v.writer.Open(inode, length)
+		h.reader = v.reader.Open(inode, attr.Length)
+		h.writer = v.writer.Open(inode, attr)
+		h.appendOnly = attr.Flags&meta.FlagAppend != 0
 	}
 	return h.fh
 }
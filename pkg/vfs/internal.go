@@ -18,6 +18,7 @@ package vfs
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
@@ -25,6 +26,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -156,6 +158,38 @@ func collectMetrics(registry *prometheus.Registry) []byte {
 	return w.Bytes()
 }
 
+// reply encodes the result of a message as errno and message.
+func reply(errno syscall.Errno, msg []byte) []byte {
+	w := utils.NewBuffer(5 + uint32(len(msg)))
+	w.Put8(uint8(errno))
+	w.Put32(uint32(len(msg)))
+	w.Put(msg)
+	return w.Bytes()
+}
+
+// handleQoS updates the QoS settings if a new one is given, and returns the current one.
+// Only root can use it, since the rules limit all the users.
+func (v *VFS) handleQoS(ctx Context, r *utils.Buffer) []byte {
+	if ctx.Uid() != 0 {
+		return reply(syscall.EPERM, []byte("only root can manage QoS"))
+	}
+	if v.Conf.Chunk == nil || v.Conf.Chunk.QoS == nil {
+		return reply(syscall.ENOTSUP, []byte("QoS is not enabled"))
+	}
+	qos := v.Conf.Chunk.QoS
+	if r.HasMore() {
+		var conf chunk.QoSConfig
+		if err := json.Unmarshal(r.Get(int(r.Get32())), &conf); err != nil {
+			return reply(syscall.EINVAL, []byte(err.Error()))
+		}
+		if err := qos.Update(conf); err != nil {
+			return reply(syscall.EINVAL, []byte(err.Error()))
+		}
+	}
+	data, _ := json.MarshalIndent(qos.Config(), "", "  ")
+	return reply(0, data)
+}
+
 func (v *VFS) handleInternalMsg(ctx Context, cmd uint32, r *utils.Buffer) []byte {
 	switch cmd {
 	case meta.Rmr:
@@ -207,6 +241,17 @@ func (v *VFS) handleInternalMsg(ctx Context, cmd uint32, r *utils.Buffer) []byte
 			go v.fillCache(paths, int(concurrent))
 		}
 		return []byte{uint8(0)}
+	case meta.QoS:
+		return v.handleQoS(ctx, r)
+	case meta.Reload:
+		if v.reload == nil {
+			return reply(syscall.ENOTSUP, []byte("not mounted with a config file"))
+		}
+		msg, err := v.reload()
+		if err != nil {
+			return reply(syscall.EINVAL, []byte(err.Error()))
+		}
+		return reply(0, []byte(msg))
 	default:
 		logger.Warnf("unknown message type: %d", cmd)
 		return []byte{uint8(syscall.EINVAL & 0xff)}
@@ -19,6 +19,7 @@ package vfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"runtime"
 	"sort"
 	"sync"
@@ -68,6 +69,9 @@ func (m sstate) String() string {
 
 type FileReader interface {
 	Read(ctx meta.Context, off uint64, buf []byte) (int, syscall.Errno)
+	// ReadFd returns the fd and the offset in it of the cached data, ENOTSUP if it's not available.
+	// The fd is valid until the returned pin is closed.
+	ReadFd(ctx meta.Context, off uint64, size int) (uintptr, int64, int, io.Closer, syscall.Errno)
 	Close(ctx meta.Context)
 }
 
@@ -101,6 +105,7 @@ type sliceReader struct {
 	next       *sliceReader
 	prev       **sliceReader
 	refs       uint16
+	id         chunk.Identity
 }
 
 func (s *sliceReader) delay(delay time.Duration) {
@@ -203,7 +208,7 @@ func (s *sliceReader) run() {
 	p := s.page.Slice(0, int(need))
 	defer p.Release()
 	var n int
-	ctx := context.TODO()
+	ctx := chunk.WithIdentity(context.TODO(), s.id)
 	n = f.r.Read(ctx, p, chunks, (uint32(s.block.off))%meta.ChunkSize)
 
 	f.Lock()
@@ -288,6 +293,9 @@ type fileReader struct {
 	sessions [readSessions]session
 	slices   *sliceReader
 	last     **sliceReader
+	owner    chunk.Identity          // the last one who read it
+	chunks   map[uint32][]meta.Slice // the slices used by ReadFd, dropped on changes
+	changes  uint64
 
 	sync.Mutex
 	closing bool
@@ -299,9 +307,11 @@ type fileReader struct {
 }
 
 // protected by f
-func (f *fileReader) newSlice(block *frange) *sliceReader {
+func (f *fileReader) newSlice(block *frange, prio chunk.Priority) *sliceReader {
 	s := &sliceReader{}
 	s.file = f
+	s.id = f.owner
+	s.id.Priority = prio
 	s.lastAccess = time.Now()
 	s.indx = uint32(block.off / meta.ChunkSize)
 	s.block = &frange{block.off, block.len} // random read
@@ -532,7 +542,7 @@ func (f *fileReader) readAhead(block *frange) {
 		if block.len < f.r.blockSize {
 			block.len += f.r.blockSize - block.end()%f.r.blockSize // align to end of a block
 		}
-		f.newSlice(block)
+		f.newSlice(block, chunk.PriorityPrefetch)
 		if block.len > 0 {
 			f.readAhead(block)
 		}
@@ -560,7 +570,7 @@ func (f *fileReader) prepareRequests(ranges []uint64) []*req {
 		})
 		if !added {
 			for b.len > 0 {
-				s := f.newSlice(&b)
+				s := f.newSlice(&b, chunk.PriorityInteractive)
 				s.refs++
 				reqs = append(reqs, &req{frange{0, s.block.len}, s})
 			}
@@ -621,6 +631,7 @@ func (f *fileReader) Read(ctx meta.Context, offset uint64, buf []byte) (int, sys
 	if offset >= f.length || size == 0 {
 		return 0, 0
 	}
+	f.owner = chunk.Identity{Uid: ctx.Uid(), Gid: ctx.Gid(), Pid: ctx.Pid()}
 	block := &frange{offset, size}
 	if block.end() > f.length {
 		block.len = f.length - block.off
@@ -650,6 +661,63 @@ func (f *fileReader) Read(ctx meta.Context, offset uint64, buf []byte) (int, sys
 	return f.waitForIO(ctx, reqs, buf)
 }
 
+func (f *fileReader) ReadFd(ctx meta.Context, offset uint64, size int) (uintptr, int64, int, io.Closer, syscall.Errno) {
+	f.Lock()
+	if f.err != 0 || f.closing {
+		f.Unlock()
+		return 0, 0, 0, nil, f.err
+	}
+	length := f.length
+	f.Unlock()
+	if offset >= length || size == 0 {
+		return 0, 0, 0, nil, 0
+	}
+	if offset+uint64(size) > length {
+		size = int(length - offset)
+	}
+	indx := uint32(offset / meta.ChunkSize)
+	coff := uint32(offset % meta.ChunkSize)
+	if uint64(coff)+uint64(size) > meta.ChunkSize {
+		return 0, 0, 0, nil, syscall.ENOTSUP
+	}
+	f.Lock()
+	slices, ok := f.chunks[indx]
+	changes := f.changes
+	f.Unlock()
+	if !ok {
+		if st := f.r.m.Read(ctx, f.inode, indx, &slices); st != 0 {
+			return 0, 0, 0, nil, st
+		}
+		f.Lock()
+		if f.changes == changes { // not changed during the read
+			if f.chunks == nil {
+				f.chunks = make(map[uint32][]meta.Slice)
+			}
+			f.chunks[indx] = slices
+		}
+		f.Unlock()
+	}
+	var pos uint32
+	for _, s := range slices {
+		if coff < pos+s.Len {
+			if s.Chunkid == 0 || coff+uint32(size) > pos+s.Len {
+				break // hole or multiple slices
+			}
+			r, ok := f.r.store.NewReader(s.Chunkid, int(s.Size)).(chunk.FileReader)
+			if !ok {
+				break
+			}
+			fd, foff, pin, err := r.ReadFile(int(s.Off+coff-pos), size)
+			if err != nil {
+				break
+			}
+			return fd, foff, size, pin, 0
+		}
+		pos += s.Len
+	}
+	return 0, 0, 0, nil, syscall.ENOTSUP
+}
+
 func (f *fileReader) visit(fn func(s *sliceReader)) {
 	var next *sliceReader
 	for s := f.slices; s != nil; s = next {
@@ -762,6 +830,8 @@ func (r *dataReader) Truncate(inode Ino, length uint64) {
 			})
 		}
 		f.length = length
+		f.chunks = nil
+		f.changes++
 	})
 }
 
@@ -776,6 +846,8 @@ func (r *dataReader) Invalidate(inode Ino, off, length uint64) {
 				s.invalidate()
 			}
 		})
+		f.chunks = nil
+		f.changes++
 	})
 }
 
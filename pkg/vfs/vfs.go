@@ -18,8 +18,10 @@ package vfs
 
 import (
 	"encoding/json"
+	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -52,20 +54,46 @@ type Config struct {
 	FastResolve     bool   `json:",omitempty"`
 	AccessLog       string `json:",omitempty"`
 	HideInternal    bool
+	Splice          bool   `json:",omitempty"`
+	FuseReaders     int    `json:",omitempty"`
+	RootSquash      bool   `json:",omitempty"` // map root to the anonymous user
+	AllSquash       bool   `json:",omitempty"` // map all users to the anonymous user
+	AnonUid         uint32 `json:",omitempty"`
+	AnonGid         uint32 `json:",omitempty"`
 }
 
-var (
-	readSizeHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "fuse_read_size_bytes",
-		Help:    "size of read distributions.",
-		Buckets: prometheus.LinearBuckets(4096, 4096, 32),
-	})
-	writtenSizeHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "fuse_written_size_bytes",
-		Help:    "size of write distributions.",
-		Buckets: prometheus.LinearBuckets(4096, 4096, 32),
-	})
-)
+// vfsMetrics is the metrics of the operations in a volume, which are registered with the labels
+// of the volume.
+type vfsMetrics struct {
+	readSize     prometheus.Histogram
+	writtenSize  prometheus.Histogram
+	spliced      prometheus.Counter
+	opsDurations prometheus.Histogram
+}
+
+func newVFSMetrics() *vfsMetrics {
+	return &vfsMetrics{
+		readSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fuse_read_size_bytes",
+			Help:    "size of read distributions.",
+			Buckets: prometheus.LinearBuckets(4096, 4096, 32),
+		}),
+		writtenSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fuse_written_size_bytes",
+			Help:    "size of write distributions.",
+			Buckets: prometheus.LinearBuckets(4096, 4096, 32),
+		}),
+		spliced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fuse_spliced_bytes",
+			Help: "size of data spliced into kernel from cache.",
+		}),
+		opsDurations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fuse_ops_durations_histogram_seconds",
+			Help:    "Operations latency distributions.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 30),
+		}),
+	}
+}
 
 func (v *VFS) Lookup(ctx Context, parent Ino, name string) (entry *meta.Entry, err syscall.Errno) {
 	var inode Ino
@@ -78,7 +106,7 @@ func (v *VFS) Lookup(ctx Context, parent Ino, name string) (entry *meta.Entry, e
 		}
 	}
 	defer func() {
-		logit(ctx, "lookup (%d,%s): %s%s", parent, name, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "lookup", opInfo{ino: parent, name: name, err: err, entry: entry}, "(%d,%s): %s%s", parent, name, strerr(err), (*Entry)(entry))
 	}()
 	if len(name) > maxName {
 		err = syscall.ENAMETOOLONG
@@ -98,7 +126,9 @@ func (v *VFS) GetAttr(ctx Context, ino Ino, opened uint8) (entry *meta.Entry, er
 		entry = &meta.Entry{Inode: n.inode, Attr: n.attr}
 		return
 	}
-	defer func() { logit(ctx, "getattr (%d): %s%s", ino, strerr(err), (*Entry)(entry)) }()
+	defer func() {
+		v.logit(ctx, "getattr", opInfo{ino: ino, err: err}, "(%d): %s%s", ino, strerr(err), (*Entry)(entry))
+	}()
 	var attr = &Attr{}
 	err = v.Meta.GetAttr(ctx, ino, attr)
 	if err == 0 {
@@ -130,7 +160,7 @@ func get_filetype(mode uint16) uint8 {
 
 func (v *VFS) Mknod(ctx Context, parent Ino, name string, mode uint16, cumask uint16, rdev uint32) (entry *meta.Entry, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "mknod (%d,%s,%s:0%04o,0x%08X): %s%s", parent, name, smode(mode), mode, rdev, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "mknod", opInfo{ino: parent, name: name, err: err, entry: entry}, "(%d,%s,%s:0%04o,0x%08X): %s%s", parent, name, smode(mode), mode, rdev, strerr(err), (*Entry)(entry))
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -156,7 +186,9 @@ func (v *VFS) Mknod(ctx Context, parent Ino, name string, mode uint16, cumask ui
 }
 
 func (v *VFS) Unlink(ctx Context, parent Ino, name string) (err syscall.Errno) {
-	defer func() { logit(ctx, "unlink (%d,%s): %s", parent, name, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "unlink", opInfo{ino: parent, name: name, err: err}, "(%d,%s): %s", parent, name, strerr(err))
+	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EPERM
 		return
@@ -171,7 +203,7 @@ func (v *VFS) Unlink(ctx Context, parent Ino, name string) (err syscall.Errno) {
 
 func (v *VFS) Mkdir(ctx Context, parent Ino, name string, mode uint16, cumask uint16) (entry *meta.Entry, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "mkdir (%d,%s,%s:0%04o): %s%s", parent, name, smode(mode), mode, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "mkdir", opInfo{ino: parent, name: name, err: err, entry: entry}, "(%d,%s,%s:0%04o): %s%s", parent, name, smode(mode), mode, strerr(err), (*Entry)(entry))
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -192,7 +224,9 @@ func (v *VFS) Mkdir(ctx Context, parent Ino, name string, mode uint16, cumask ui
 }
 
 func (v *VFS) Rmdir(ctx Context, parent Ino, name string) (err syscall.Errno) {
-	defer func() { logit(ctx, "rmdir (%d,%s): %s", parent, name, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "rmdir", opInfo{ino: parent, name: name, err: err}, "(%d,%s): %s", parent, name, strerr(err))
+	}()
 	if len(name) > maxName {
 		err = syscall.ENAMETOOLONG
 		return
@@ -203,7 +237,7 @@ func (v *VFS) Rmdir(ctx Context, parent Ino, name string) (err syscall.Errno) {
 
 func (v *VFS) Symlink(ctx Context, path string, parent Ino, name string) (entry *meta.Entry, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "symlink (%d,%s,%s): %s%s", parent, name, path, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "symlink", opInfo{ino: parent, name: name, err: err, entry: entry}, "(%d,%s,%s): %s%s", parent, name, path, strerr(err), (*Entry)(entry))
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -224,14 +258,16 @@ func (v *VFS) Symlink(ctx Context, path string, parent Ino, name string) (entry
 }
 
 func (v *VFS) Readlink(ctx Context, ino Ino) (path []byte, err syscall.Errno) {
-	defer func() { logit(ctx, "readlink (%d): %s (%s)", ino, strerr(err), string(path)) }()
+	defer func() {
+		v.logit(ctx, "readlink", opInfo{ino: ino, err: err}, "(%d): %s (%s)", ino, strerr(err), string(path))
+	}()
 	err = v.Meta.ReadLink(ctx, ino, &path)
 	return
 }
 
 func (v *VFS) Rename(ctx Context, parent Ino, name string, newparent Ino, newname string, flags uint32) (err syscall.Errno) {
 	defer func() {
-		logit(ctx, "rename (%d,%s,%d,%s,%d): %s", parent, name, newparent, newname, flags, strerr(err))
+		v.logit(ctx, "rename", opInfo{ino: parent, name: name, err: err}, "(%d,%s,%d,%s,%d): %s", parent, name, newparent, newname, flags, strerr(err))
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EPERM
@@ -252,7 +288,7 @@ func (v *VFS) Rename(ctx Context, parent Ino, name string, newparent Ino, newnam
 
 func (v *VFS) Link(ctx Context, ino Ino, newparent Ino, newname string) (entry *meta.Entry, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "link (%d,%d,%s): %s%s", ino, newparent, newname, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "link", opInfo{ino: newparent, name: newname, err: err, entry: entry}, "(%d,%d,%s): %s%s", ino, newparent, newname, strerr(err), (*Entry)(entry))
 	}()
 	if IsSpecialNode(ino) {
 		err = syscall.EPERM
@@ -277,7 +313,7 @@ func (v *VFS) Link(ctx Context, ino Ino, newparent Ino, newname string) (entry *
 }
 
 func (v *VFS) Opendir(ctx Context, ino Ino) (fh uint64, err syscall.Errno) {
-	defer func() { logit(ctx, "opendir (%d): %s [fh:%d]", ino, strerr(err), fh) }()
+	defer func() { v.logit(ctx, "opendir", opInfo{ino: ino, err: err}, "(%d): %s [fh:%d]", ino, strerr(err), fh) }()
 	fh = v.newHandle(ino).fh
 	return
 }
@@ -293,7 +329,9 @@ func (v *VFS) UpdateLength(inode Ino, attr *meta.Attr) {
 }
 
 func (v *VFS) Readdir(ctx Context, ino Ino, size uint32, off int, fh uint64, plus bool) (entries []*meta.Entry, err syscall.Errno) {
-	defer func() { logit(ctx, "readdir (%d,%d,%d): %s (%d)", ino, size, off, strerr(err), len(entries)) }()
+	defer func() {
+		v.logit(ctx, "readdir", opInfo{ino: ino, err: err}, "(%d,%d,%d): %s (%d)", ino, size, off, strerr(err), len(entries))
+	}()
 	h := v.findHandle(ino, fh)
 	if h == nil {
 		err = syscall.EBADF
@@ -312,6 +350,9 @@ func (v *VFS) Readdir(ctx Context, ino Ino, size uint32, off int, fh uint64, plu
 			return
 		}
 		h.children = inodes
+		if plus {
+			v.paths.addEntries(ino, inodes)
+		}
 		if ino == rootID && !v.Conf.HideInternal {
 			// add internal nodes
 			for _, node := range internalNodes[1:] {
@@ -335,13 +376,13 @@ func (v *VFS) Releasedir(ctx Context, ino Ino, fh uint64) int {
 		return 0
 	}
 	v.ReleaseHandler(ino, fh)
-	logit(ctx, "releasedir (%d): OK", ino)
+	v.logit(ctx, "releasedir", opInfo{ino: ino}, "(%d): OK", ino)
 	return 0
 }
 
 func (v *VFS) Create(ctx Context, parent Ino, name string, mode uint16, cumask uint16, flags uint32) (entry *meta.Entry, fh uint64, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "create (%d,%s,%s:0%04o): %s%s [fh:%d]", parent, name, smode(mode), mode, strerr(err), (*Entry)(entry), fh)
+		v.logit(ctx, "create", opInfo{ino: parent, name: name, err: err, entry: entry}, "(%d,%s,%s:0%04o): %s%s [fh:%d]", parent, name, smode(mode), mode, strerr(err), (*Entry)(entry), fh)
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -360,7 +401,7 @@ func (v *VFS) Create(ctx Context, parent Ino, name string, mode uint16, cumask u
 	}
 	if err == 0 {
 		v.UpdateLength(inode, attr)
-		fh = v.newFileHandle(inode, attr.Length, flags)
+		fh = v.newFileHandle(inode, attr, flags)
 		entry = &meta.Entry{Inode: inode, Attr: attr}
 	}
 	return
@@ -382,34 +423,33 @@ func (v *VFS) Open(ctx Context, ino Ino, flags uint32) (entry *meta.Entry, fh ui
 		entry = &meta.Entry{Inode: ino, Attr: n.attr}
 		switch ino {
 		case logInode:
-			openAccessLog(fh)
+			v.accessLog.open(fh)
 		case statsInode:
 			h.data = collectMetrics(v.registry)
 		case configInode:
-			v.Conf.Format.RemoveSecret()
-			h.data, _ = json.MarshalIndent(v.Conf, "", " ")
+			h.data = v.dumpConfig()
 			entry.Attr.Length = uint64(len(h.data))
 		}
 		return
 	}
 	defer func() {
 		if entry != nil {
-			logit(ctx, "open (%d): %s [fh:%d]", ino, strerr(err), fh)
+			v.logit(ctx, "open", opInfo{ino: ino, err: err}, "(%d): %s [fh:%d]", ino, strerr(err), fh)
 		} else {
-			logit(ctx, "open (%d): %s", ino, strerr(err))
+			v.logit(ctx, "open", opInfo{ino: ino, err: err}, "(%d): %s", ino, strerr(err))
 		}
 	}()
 	err = v.Meta.Open(ctx, ino, flags, attr)
 	if err == 0 {
 		v.UpdateLength(ino, attr)
-		fh = v.newFileHandle(ino, attr.Length, flags)
+		fh = v.newFileHandle(ino, attr, flags)
 		entry = &meta.Entry{Inode: ino, Attr: attr}
 	}
 	return
 }
 
 func (v *VFS) Truncate(ctx Context, ino Ino, size int64, opened uint8, attr *Attr) (err syscall.Errno) {
-	// defer func() { logit(ctx, "truncate (%d,%d): %s", ino, size, strerr(err)) }()
+	// defer func() { v.logit(ctx, "truncate", opInfo{ino: ino, err: err}, "(%d,%d): %s", ino, size, strerr(err)) }()
 	if IsSpecialNode(ino) {
 		err = syscall.EPERM
 		return
@@ -446,13 +486,13 @@ func (v *VFS) ReleaseHandler(ino Ino, fh uint64) {
 func (v *VFS) Release(ctx Context, ino Ino, fh uint64) {
 	if IsSpecialNode(ino) {
 		if ino == logInode {
-			closeAccessLog(fh)
+			v.accessLog.close(fh)
 		}
 		v.releaseHandle(ino, fh)
 		return
 	}
 	var err syscall.Errno
-	defer func() { logit(ctx, "release (%d): %s", ino, strerr(err)) }()
+	defer func() { v.logit(ctx, "release", opInfo{ino: ino, err: err}, "(%d): %s", ino, strerr(err)) }()
 	if fh > 0 {
 		f := v.findHandle(ino, fh)
 		if f != nil {
@@ -484,7 +524,7 @@ func (v *VFS) Read(ctx Context, ino Ino, buf []byte, off uint64, fh uint64) (n i
 	size := uint32(len(buf))
 	if IsSpecialNode(ino) {
 		if ino == logInode {
-			n = readAccessLog(fh, buf)
+			n = v.accessLog.read(fh, buf)
 		} else {
 			h := v.findHandle(ino, fh)
 			if h == nil {
@@ -514,8 +554,8 @@ func (v *VFS) Read(ctx Context, ino Ino, buf []byte, off uint64, fh uint64) (n i
 	}
 
 	defer func() {
-		readSizeHistogram.Observe(float64(n))
-		logit(ctx, "read (%d,%d,%d): %s (%d)", ino, size, off, strerr(err), n)
+		v.metrics.readSize.Observe(float64(n))
+		v.logit(ctx, "read", opInfo{ino: ino, size: int64(n), err: err}, "(%d,%d,%d): %s (%d)", ino, size, off, strerr(err), n)
 	}()
 	h := v.findHandle(ino, fh)
 	if h == nil {
@@ -548,9 +588,58 @@ func (v *VFS) Read(ctx Context, ino Ino, buf []byte, off uint64, fh uint64) (n i
 	return
 }
 
+// ReadFd returns a file descriptor and the offset of the data in it, so it could be
+// spliced into the kernel without copying. ENOTSUP is returned if the data is not in a
+// single cached block, then Read should be used instead.
+func (v *VFS) ReadFd(ctx Context, ino Ino, size int, off uint64, fh uint64) (fd uintptr, foff int64, n int, err syscall.Errno) {
+	if IsSpecialNode(ino) {
+		err = syscall.ENOTSUP
+		return
+	}
+	defer func() {
+		if err == 0 {
+			v.metrics.readSize.Observe(float64(n))
+			v.metrics.spliced.Add(float64(n))
+		}
+		v.logit(ctx, "readfd", opInfo{ino: ino, size: int64(n), err: err}, "(%d,%d,%d): %s (%d)", ino, size, off, strerr(err), n)
+	}()
+	h := v.findHandle(ino, fh)
+	if h == nil {
+		err = syscall.EBADF
+		return
+	}
+	if off >= maxFileSize || off+uint64(size) >= maxFileSize {
+		err = syscall.EFBIG
+		return
+	}
+	if h.reader == nil {
+		err = syscall.EACCES
+		return
+	}
+	if !h.Rlock(ctx) {
+		err = syscall.EINTR
+		return
+	}
+	defer h.Runlock()
+
+	_ = v.writer.Flush(ctx, ino)
+	var pin io.Closer
+	fd, foff, n, pin, err = h.reader.ReadFd(ctx, off, size)
+	if pin != nil {
+		h.pin(pin)
+	}
+	if err == syscall.ENOENT {
+		err = syscall.EBADF
+	}
+	h.removeOp(ctx)
+	return
+}
+
 func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err syscall.Errno) {
 	size := uint64(len(buf))
-	defer func() { logit(ctx, "write (%d,%d,%d): %s", ino, size, off, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "write", opInfo{ino: ino, size: int64(size), err: err}, "(%d,%d,%d): %s", ino, size, off, strerr(err))
+	}()
 	h := v.findHandle(ino, fh)
 	if h == nil {
 		err = syscall.EBADF
@@ -594,6 +683,11 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 	}
 	defer h.Wunlock()
 
+	// the data of append-only files can only be written at the end
+	if h.appendOnly && off != h.writer.GetLength() {
+		err = syscall.EPERM
+		return
+	}
 	err = h.writer.Write(ctx, off, buf)
 	if err == syscall.ENOENT || err == syscall.EPERM || err == syscall.EINVAL {
 		err = syscall.EBADF
@@ -601,14 +695,16 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 	h.removeOp(ctx)
 
 	if err == 0 {
-		writtenSizeHistogram.Observe(float64(len(buf)))
+		v.metrics.writtenSize.Observe(float64(len(buf)))
 		v.reader.Truncate(ino, v.writer.GetLength(ino))
 	}
 	return
 }
 
 func (v *VFS) Fallocate(ctx Context, ino Ino, mode uint8, off, length int64, fh uint64) (err syscall.Errno) {
-	defer func() { logit(ctx, "fallocate (%d,%d,%d,%d): %s", ino, mode, off, length, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "fallocate", opInfo{ino: ino, err: err}, "(%d,%d,%d,%d): %s", ino, mode, off, length, strerr(err))
+	}()
 	if off < 0 || length <= 0 {
 		err = syscall.EINVAL
 		return
@@ -637,13 +733,58 @@ func (v *VFS) Fallocate(ctx Context, ino Ino, mode uint8, off, length int64, fh
 	defer h.Wunlock()
 	defer h.removeOp(ctx)
 
+	err = v.writer.Flush(ctx, ino)
+	if err != 0 {
+		return
+	}
 	err = v.Meta.Fallocate(ctx, ino, mode, uint64(off), uint64(length))
+	if err == 0 {
+		v.reader.Invalidate(ino, uint64(off), uint64(length))
+		var attr Attr
+		if v.Meta.GetAttr(ctx, ino, &attr) == 0 {
+			v.writer.Truncate(ino, attr.Length)
+			v.reader.Truncate(ino, attr.Length)
+		}
+	}
+	return
+}
+
+// Lseek finds the next data or hole in a file, other whences are handled by the kernel.
+func (v *VFS) Lseek(ctx Context, ino Ino, off int64, whence int, fh uint64) (pos int64, err syscall.Errno) {
+	defer func() {
+		v.logit(ctx, "lseek", opInfo{ino: ino, err: err}, "(%d,%d,%d): %s (%d)", ino, off, whence, strerr(err), pos)
+	}()
+	if IsSpecialNode(ino) {
+		err = syscall.ENOTSUP
+		return
+	}
+	if off < 0 {
+		err = syscall.ENXIO
+		return
+	}
+	h := v.findHandle(ino, fh)
+	if h == nil {
+		err = syscall.EBADF
+		return
+	}
+	if h.writer != nil {
+		if err = v.writer.Flush(ctx, ino); err != 0 {
+			return
+		}
+	}
+	var attr Attr
+	if err = v.Meta.GetAttr(ctx, ino, &attr); err != 0 {
+		return
+	}
+	var p uint64
+	p, err = meta.SeekDataHole(v.Meta, ctx, ino, uint64(off), attr.Length, whence)
+	pos = int64(p)
 	return
 }
 
 func (v *VFS) CopyFileRange(ctx Context, nodeIn Ino, fhIn, offIn uint64, nodeOut Ino, fhOut, offOut, size uint64, flags uint32) (copied uint64, err syscall.Errno) {
 	defer func() {
-		logit(ctx, "copy_file_range (%d,%d,%d,%d,%d,%d): %s", nodeIn, offIn, nodeOut, offOut, size, flags, strerr(err))
+		v.logit(ctx, "copy_file_range", opInfo{ino: nodeOut, size: int64(copied), err: err}, "(%d,%d,%d,%d,%d,%d): %s", nodeIn, offIn, nodeOut, offOut, size, flags, strerr(err))
 	}()
 	if IsSpecialNode(nodeIn) {
 		err = syscall.ENOTSUP
@@ -714,7 +855,7 @@ func (v *VFS) Flush(ctx Context, ino Ino, fh uint64, lockOwner uint64) (err sysc
 	if IsSpecialNode(ino) {
 		return
 	}
-	defer func() { logit(ctx, "flush (%d): %s", ino, strerr(err)) }()
+	defer func() { v.logit(ctx, "flush", opInfo{ino: ino, err: err}, "(%d): %s", ino, strerr(err)) }()
 	h := v.findHandle(ino, fh)
 	if h == nil {
 		err = syscall.EBADF
@@ -748,7 +889,7 @@ func (v *VFS) Flush(ctx Context, ino Ino, fh uint64, lockOwner uint64) (err sysc
 }
 
 func (v *VFS) Fsync(ctx Context, ino Ino, datasync int, fh uint64) (err syscall.Errno) {
-	defer func() { logit(ctx, "fsync (%d,%d): %s", ino, datasync, strerr(err)) }()
+	defer func() { v.logit(ctx, "fsync", opInfo{ino: ino, err: err}, "(%d,%d): %s", ino, datasync, strerr(err)) }()
 	if IsSpecialNode(ino) {
 		return
 	}
@@ -778,7 +919,9 @@ const (
 )
 
 func (v *VFS) SetXattr(ctx Context, ino Ino, name string, value []byte, flags uint32) (err syscall.Errno) {
-	defer func() { logit(ctx, "setxattr (%d,%s,%d,%d): %s", ino, name, len(value), flags, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "setxattr", opInfo{ino: ino, err: err}, "(%d,%s,%d,%d): %s", ino, name, len(value), flags, strerr(err))
+	}()
 	if IsSpecialNode(ino) {
 		err = syscall.EPERM
 		return
@@ -807,12 +950,21 @@ func (v *VFS) SetXattr(ctx Context, ino Ino, name string, value []byte, flags ui
 		err = syscall.ENOTSUP
 		return
 	}
+	if name == flagsXattr {
+		var attr Attr
+		if attr.Flags, err = parseFlags(value); err == 0 {
+			err = v.Meta.SetAttr(ctx, ino, meta.SetAttrFlag, 0, &attr)
+		}
+		return
+	}
 	err = v.Meta.SetXattr(ctx, ino, name, value, flags)
 	return
 }
 
 func (v *VFS) GetXattr(ctx Context, ino Ino, name string, size uint32) (value []byte, err syscall.Errno) {
-	defer func() { logit(ctx, "getxattr (%d,%s,%d): %s (%d)", ino, name, size, strerr(err), len(value)) }()
+	defer func() {
+		v.logit(ctx, "getxattr", opInfo{ino: ino, err: err}, "(%d,%s,%d): %s (%d)", ino, name, size, strerr(err), len(value))
+	}()
 
 	if IsSpecialNode(ino) {
 		err = meta.ENOATTR
@@ -834,7 +986,16 @@ func (v *VFS) GetXattr(ctx Context, ino Ino, name string, size uint32) (value []
 		err = syscall.ENOTSUP
 		return
 	}
-	err = v.Meta.GetXattr(ctx, ino, name, &value)
+	if name == flagsXattr {
+		var attr Attr
+		if err = v.Meta.GetAttr(ctx, ino, &attr); err == 0 {
+			if value = formatFlags(attr.Flags); value == nil {
+				err = meta.ENOATTR
+			}
+		}
+	} else {
+		err = v.Meta.GetXattr(ctx, ino, name, &value)
+	}
 	if size > 0 && len(value) > int(size) {
 		err = syscall.ERANGE
 	}
@@ -842,7 +1003,9 @@ func (v *VFS) GetXattr(ctx Context, ino Ino, name string, size uint32) (value []
 }
 
 func (v *VFS) ListXattr(ctx Context, ino Ino, size int) (data []byte, err syscall.Errno) {
-	defer func() { logit(ctx, "listxattr (%d,%d): %s (%d)", ino, size, strerr(err), len(data)) }()
+	defer func() {
+		v.logit(ctx, "listxattr", opInfo{ino: ino, err: err}, "(%d,%d): %s (%d)", ino, size, strerr(err), len(data))
+	}()
 	if IsSpecialNode(ino) {
 		err = meta.ENOATTR
 		return
@@ -855,7 +1018,7 @@ func (v *VFS) ListXattr(ctx Context, ino Ino, size int) (data []byte, err syscal
 }
 
 func (v *VFS) RemoveXattr(ctx Context, ino Ino, name string) (err syscall.Errno) {
-	defer func() { logit(ctx, "removexattr (%d,%s): %s", ino, name, strerr(err)) }()
+	defer func() { v.logit(ctx, "removexattr", opInfo{ino: ino, err: err}, "(%d,%s): %s", ino, name, strerr(err)) }()
 	if IsSpecialNode(ino) {
 		err = syscall.EPERM
 		return
@@ -875,10 +1038,45 @@ func (v *VFS) RemoveXattr(ctx Context, ino Ino, name string) (err syscall.Errno)
 		err = syscall.EINVAL
 		return
 	}
+	if name == flagsXattr {
+		err = v.Meta.SetAttr(ctx, ino, meta.SetAttrFlag, 0, &Attr{})
+		return
+	}
 	err = v.Meta.RemoveXattr(ctx, ino, name)
 	return
 }
 
+// flagsXattr is a virtual xattr to get or set the inode flags, "i" for immutable
+// and "a" for append-only, as an alternative to the FS_IOC_SETFLAGS ioctl.
+const flagsXattr = "user.juicefs.flags"
+
+func parseFlags(value []byte) (uint8, syscall.Errno) {
+	var flags uint8
+	for _, c := range value {
+		switch c {
+		case 'i':
+			flags |= meta.FlagImmutable
+		case 'a':
+			flags |= meta.FlagAppend
+		case '\n':
+		default:
+			return 0, syscall.EINVAL
+		}
+	}
+	return flags, 0
+}
+
+func formatFlags(flags uint8) []byte {
+	var value []byte
+	if flags&meta.FlagImmutable != 0 {
+		value = append(value, 'i')
+	}
+	if flags&meta.FlagAppend != 0 {
+		value = append(value, 'a')
+	}
+	return value
+}
+
 var logger = utils.GetLogger("juicefs")
 
 type VFS struct {
@@ -896,6 +1094,56 @@ type VFS struct {
 	usedBufferSize prometheus.GaugeFunc
 	storeCacheSize prometheus.GaugeFunc
 	registry       *prometheus.Registry
+	metrics        *vfsMetrics
+	reload         func() (string, error)
+	paths          pathCache
+	accessLog      accessLog
+
+	// the timeouts of the kernel caches in nanoseconds, which could be changed by reloading
+	attrTimeout     int64
+	entryTimeout    int64
+	dirEntryTimeout int64
+}
+
+// OnReload sets the function to reload the config, which returns the changes.
+func (v *VFS) OnReload(fn func() (string, error)) {
+	v.reload = fn
+}
+
+// AttrTimeout returns the timeout of attributes cached by the kernel.
+func (v *VFS) AttrTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&v.attrTimeout))
+}
+
+// EntryTimeout returns the timeout of entries cached by the kernel.
+func (v *VFS) EntryTimeout(isDir bool) time.Duration {
+	if isDir {
+		return time.Duration(atomic.LoadInt64(&v.dirEntryTimeout))
+	}
+	return time.Duration(atomic.LoadInt64(&v.entryTimeout))
+}
+
+func (v *VFS) SetAttrTimeout(d time.Duration) {
+	atomic.StoreInt64(&v.attrTimeout, int64(d))
+}
+
+func (v *VFS) SetEntryTimeout(d time.Duration) {
+	atomic.StoreInt64(&v.entryTimeout, int64(d))
+}
+
+func (v *VFS) SetDirEntryTimeout(d time.Duration) {
+	atomic.StoreInt64(&v.dirEntryTimeout, int64(d))
+}
+
+// dumpConfig returns the config in JSON with the current timeouts of caches.
+func (v *VFS) dumpConfig() []byte {
+	v.Conf.Format.RemoveSecret()
+	conf := *v.Conf
+	conf.AttrTimeout = v.AttrTimeout()
+	conf.EntryTimeout = v.EntryTimeout(false)
+	conf.DirEntryTimeout = v.EntryTimeout(true)
+	data, _ := json.MarshalIndent(&conf, "", " ")
+	return data
 }
 
 func NewVFS(conf *Config, m meta.Meta, store chunk.ChunkStore, registerer prometheus.Registerer, registry *prometheus.Registry) *VFS {
@@ -911,12 +1159,15 @@ func NewVFS(conf *Config, m meta.Meta, store chunk.ChunkStore, registerer promet
 		handles:  make(map[Ino][]*handle),
 		nextfh:   1,
 		registry: registry,
+		metrics:  newVFSMetrics(),
+
+		attrTimeout:     int64(conf.AttrTimeout),
+		entryTimeout:    int64(conf.EntryTimeout),
+		dirEntryTimeout: int64(conf.DirEntryTimeout),
 	}
 
 	n := getInternalNode(configInode)
-	v.Conf.Format.RemoveSecret()
-	data, _ := json.MarshalIndent(v.Conf, "", " ")
-	n.attr.Length = uint64(len(data))
+	n.attr.Length = uint64(len(v.dumpConfig()))
 	if conf.Meta.Subdir != "" { // don't show trash directory
 		internalNodes = internalNodes[:len(internalNodes)-1]
 	}
@@ -958,14 +1209,17 @@ func initVFSMetrics(v *VFS, writer DataWriter, registerer prometheus.Registerer)
 	_ = registerer.Register(v.handlersGause)
 	_ = registerer.Register(v.usedBufferSize)
 	_ = registerer.Register(v.storeCacheSize)
+	_ = registerer.Register(v.metrics.readSize)
+	_ = registerer.Register(v.metrics.writtenSize)
+	_ = registerer.Register(v.metrics.spliced)
+	_ = registerer.Register(v.metrics.opsDurations)
 }
 
+// InitMetrics registers the metrics shared by all the volumes in the process, the ones of
+// operations are registered by NewVFS for each volume.
 func InitMetrics(registerer prometheus.Registerer) {
 	if registerer == nil {
 		return
 	}
-	registerer.MustRegister(readSizeHistogram)
-	registerer.MustRegister(writtenSizeHistogram)
-	registerer.MustRegister(opsDurationsHistogram)
 	registerer.MustRegister(compactSizeHistogram)
 }
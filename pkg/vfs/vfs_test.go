@@ -17,6 +17,9 @@
 package vfs
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
@@ -431,6 +434,51 @@ func TestVFSXattrs(t *testing.T) {
 	}
 }
 
+func TestVFSFlags(t *testing.T) {
+	v, _ := createTestVFS()
+	ctx := NewLogContext(meta.Background)
+	fe, fh, e := v.Create(ctx, 1, "flags", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create flags: %s", e)
+	}
+	v.Release(ctx, fe.Inode, fh)
+	if _, e := v.GetXattr(ctx, fe.Inode, flagsXattr, 0); e != meta.ENOATTR {
+		t.Fatalf("get flags: %s", e)
+	}
+	if e := v.SetXattr(ctx, fe.Inode, flagsXattr, []byte("x"), 0); e != syscall.EINVAL {
+		t.Fatalf("set invalid flags: %s", e)
+	}
+	if e := v.SetXattr(NewLogContext(meta.NewContext(10, 1, []uint32{1})), fe.Inode, flagsXattr, []byte("i"), 0); e != syscall.EPERM {
+		t.Fatalf("set flags by non-root: %s", e)
+	}
+	if e := v.SetXattr(ctx, fe.Inode, flagsXattr, []byte("i"), 0); e != 0 {
+		t.Fatalf("set immutable: %s", e)
+	}
+	if value, e := v.GetXattr(ctx, fe.Inode, flagsXattr, 0); e != 0 || string(value) != "i" {
+		t.Fatalf("get flags: %s %q", e, value)
+	}
+	if _, _, e := v.Open(ctx, fe.Inode, syscall.O_WRONLY); e != syscall.EPERM {
+		t.Fatalf("open immutable for write: %s", e)
+	}
+	if e := v.Unlink(ctx, 1, "flags"); e != syscall.EPERM {
+		t.Fatalf("unlink immutable: %s", e)
+	}
+	if e := v.SetXattr(ctx, fe.Inode, flagsXattr, []byte("a"), 0); e != 0 {
+		t.Fatalf("set append-only: %s", e)
+	}
+	_, fh, e = v.Open(ctx, fe.Inode, syscall.O_WRONLY|syscall.O_APPEND)
+	if e != 0 {
+		t.Fatalf("open append-only with O_APPEND: %s", e)
+	}
+	v.Release(ctx, fe.Inode, fh)
+	if e := v.RemoveXattr(ctx, fe.Inode, flagsXattr); e != 0 {
+		t.Fatalf("clear flags: %s", e)
+	}
+	if e := v.Unlink(ctx, 1, "flags"); e != 0 {
+		t.Fatalf("unlink flags: %s", e)
+	}
+}
+
 type accessCase struct {
 	uid  uint32
 	gid  uint32
@@ -773,6 +821,38 @@ func TestInternalFile(t *testing.T) {
 	}
 	off += uint64(n)
 
+	// reload
+	reload := func() (syscall.Errno, string) {
+		buf := make([]byte, 4+4)
+		w := utils.FromBuffer(buf)
+		w.Put32(meta.Reload)
+		w.Put32(0)
+		if e := v.Write(ctx, fe.Inode, buf, off, fh); e != 0 {
+			t.Fatalf("write reload: %s", e)
+		}
+		off += uint64(len(buf))
+		resp := make([]byte, 1024)
+		n, e := readControl(resp, off)
+		if e != 0 || n < 5 {
+			t.Fatalf("read result: %s %d", e, n)
+		}
+		off += uint64(n)
+		r := utils.ReadBuffer(resp[:n])
+		errno := syscall.Errno(r.Get8())
+		return errno, string(r.Get(int(r.Get32())))
+	}
+	if errno, _ := reload(); errno != syscall.ENOTSUP {
+		t.Fatalf("reload without config: %s", errno)
+	}
+	v.OnReload(func() (string, error) { return "upload-limit: 0 -> 100", nil })
+	if errno, msg := reload(); errno != 0 || msg != "upload-limit: 0 -> 100" {
+		t.Fatalf("reload: %s %q", errno, msg)
+	}
+	v.OnReload(func() (string, error) { return "", errors.New("bad config") })
+	if errno, msg := reload(); errno != syscall.EINVAL || msg != "bad config" {
+		t.Fatalf("reload with bad config: %s %q", errno, msg)
+	}
+
 	// invalid msg
 	buf = make([]byte, 4+4+2)
 	w = utils.FromBuffer(buf)
@@ -789,3 +869,202 @@ func TestInternalFile(t *testing.T) {
 		t.Fatalf("result: %s", string(resp[:n]))
 	}
 }
+
+func TestVFSSparse(t *testing.T) {
+	v, _ := createTestVFS()
+	ctx := NewLogContext(meta.Background)
+	fe, fh, e := v.Create(ctx, 1, "sparse", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create file: %s", e)
+	}
+	if e = v.Write(ctx, fe.Inode, []byte("hello"), 0, fh); e != 0 {
+		t.Fatalf("write file: %s", e)
+	}
+	if e = v.Write(ctx, fe.Inode, []byte("world"), 128<<10, fh); e != 0 {
+		t.Fatalf("write file: %s", e)
+	}
+	// buffered data should be visible
+	if off, e := v.Lseek(ctx, fe.Inode, 10, meta.SeekData, fh); e != 0 || off != 128<<10 {
+		t.Fatalf("seek data: %s %d", e, off)
+	}
+	if off, e := v.Lseek(ctx, fe.Inode, 0, meta.SeekHole, fh); e != 0 || off != 5 {
+		t.Fatalf("seek hole: %s %d", e, off)
+	}
+	if _, e := v.Lseek(ctx, fe.Inode, (128<<10)+5, meta.SeekData, fh); e != syscall.ENXIO {
+		t.Fatalf("seek data at EOF: %s", e)
+	}
+
+	buf := make([]byte, 5)
+	if n, e := v.Read(ctx, fe.Inode, buf, 0, fh); e != 0 || string(buf[:n]) != "hello" {
+		t.Fatalf("read: %s %q", e, buf[:n])
+	}
+	if e = v.Fallocate(ctx, fe.Inode, 3, 0, 5, fh); e != 0 { // punch hole and keep size
+		t.Fatalf("punch hole: %s", e)
+	}
+	if n, e := v.Read(ctx, fe.Inode, buf, 0, fh); e != 0 || n != 5 || string(buf) != "\x00\x00\x00\x00\x00" {
+		t.Fatalf("read hole: %s %q", e, buf[:n])
+	}
+	if off, e := v.Lseek(ctx, fe.Inode, 0, meta.SeekData, fh); e != 0 || off != 128<<10 {
+		t.Fatalf("seek data after punching hole: %s %d", e, off)
+	}
+
+	if e = v.Fallocate(ctx, fe.Inode, 0, 1<<20, 10, fh); e != 0 {
+		t.Fatalf("fallocate: %s", e)
+	}
+	if n, e := v.Read(ctx, fe.Inode, buf, (1<<20)+5, fh); e != 0 || n != 5 {
+		t.Fatalf("read after extending: %s %d", e, n)
+	}
+	if off, e := v.Lseek(ctx, fe.Inode, (128<<10)+5, meta.SeekHole, fh); e != 0 || off != (128<<10)+5 {
+		t.Fatalf("seek hole: %s %d", e, off)
+	}
+}
+
+func TestVFSCacheTimeouts(t *testing.T) {
+	v, _ := createTestVFS()
+	v.SetAttrTimeout(time.Second * 3)
+	v.SetDirEntryTimeout(time.Second * 2)
+	if v.AttrTimeout() != time.Second*3 || v.EntryTimeout(true) != time.Second*2 || v.EntryTimeout(false) != v.Conf.EntryTimeout {
+		t.Fatalf("timeouts: %s %s %s", v.AttrTimeout(), v.EntryTimeout(true), v.EntryTimeout(false))
+	}
+	var conf Config
+	if err := json.Unmarshal(v.dumpConfig(), &conf); err != nil || conf.AttrTimeout != time.Second*3 || conf.DirEntryTimeout != time.Second*2 {
+		t.Fatalf("dumped config: %+v %s", conf, err)
+	}
+}
+
+func TestVFSReadFd(t *testing.T) {
+	v, blob := createTestVFS()
+	ctx := NewLogContext(meta.Background)
+	fe, fh, e := v.Create(ctx, 1, "splice", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create file: %s", e)
+	}
+	if e = v.Write(ctx, fe.Inode, []byte("hello world"), 0, fh); e != 0 {
+		t.Fatalf("write file: %s", e)
+	}
+	// memory cache can't be spliced
+	if _, _, _, e = v.ReadFd(ctx, fe.Inode, 5, 0, fh); e != syscall.ENOTSUP {
+		t.Fatalf("readfd from memory cache: %s", e)
+	}
+	if _, _, _, e = v.ReadFd(ctx, fe.Inode, 5, 0, fh+100); e != syscall.EBADF {
+		t.Fatalf("readfd with bad handle: %s", e)
+	}
+
+	conf := *v.Conf
+	chunkConf := *conf.Chunk
+	chunkConf.CacheDir = t.TempDir()
+	conf.Chunk = &chunkConf
+	v2 := NewVFS(&conf, v.Meta, chunk.NewCachedStore(blob, chunkConf, nil), nil, nil)
+	fe, fh, e = v2.Create(ctx, 1, "splice2", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create file: %s", e)
+	}
+	if e = v2.Write(ctx, fe.Inode, []byte("hello world"), 0, fh); e != 0 {
+		t.Fatalf("write file: %s", e)
+	}
+	if e = v2.Flush(ctx, fe.Inode, fh, 0); e != 0 {
+		t.Fatalf("flush file: %s", e)
+	}
+	time.Sleep(time.Millisecond * 100) // wait for cache written
+	fd, off, n, e := v2.ReadFd(ctx, fe.Inode, 20, 6, fh)
+	if e != 0 || n != 5 || off != 6 {
+		t.Fatalf("readfd: %s %d %d", e, off, n)
+	}
+	buf := make([]byte, n)
+	if _, err := syscall.Pread(int(fd), buf, off); err != nil || string(buf) != "world" {
+		t.Fatalf("read from fd: %s %q", err, buf)
+	}
+	if _, _, n, e = v2.ReadFd(ctx, fe.Inode, 20, 11, fh); e != 0 || n != 0 {
+		t.Fatalf("readfd at EOF: %s %d", e, n)
+	}
+	// the cached slices are dropped by the writes
+	if e = v2.Write(ctx, fe.Inode, []byte("WORLD"), 6, fh); e != 0 {
+		t.Fatalf("write file: %s", e)
+	}
+	if e = v2.Flush(ctx, fe.Inode, fh, 0); e != 0 {
+		t.Fatalf("flush file: %s", e)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if fd, off, n, e = v2.ReadFd(ctx, fe.Inode, 5, 6, fh); e != 0 || n != 5 {
+		t.Fatalf("readfd after write: %s %d", e, n)
+	}
+	if _, err := syscall.Pread(int(fd), buf, off); err != nil || string(buf) != "WORLD" {
+		t.Fatalf("read from fd after write: %s %q", err, buf)
+	}
+	if _, _, _, e = v2.ReadFd(ctx, fe.Inode, 5, 0, fh); e != 0 {
+		t.Fatalf("readfd again: %s", e)
+	}
+	// the blocks are pinned by the handle until it's released
+	h := v2.findHandle(fe.Inode, fh)
+	if h == nil || len(h.pins) != 2 {
+		t.Fatalf("pinned blocks: %+v", h)
+	}
+	v2.releaseFileHandle(fe.Inode, fh)
+	if h.pins != nil {
+		t.Fatalf("pinned blocks should be released: %d", len(h.pins))
+	}
+}
+
+func TestVFSAppendOnly(t *testing.T) {
+	v, _ := createTestVFS()
+	ctx := NewLogContext(meta.Background)
+	fe, fh, e := v.Create(ctx, 1, "append", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create file: %s", e)
+	}
+	start := uint64(meta.ChunkSize - 4096) // so the appends are written into multiple chunks
+	if e = v.Truncate(ctx, fe.Inode, int64(start), 1, nil); e != 0 {
+		t.Fatalf("truncate: %s", e)
+	}
+	v.Release(ctx, fe.Inode, fh)
+	if e = v.Meta.SetAttr(meta.Background, fe.Inode, meta.SetAttrFlag, 0, &Attr{Flags: meta.FlagAppend}); e != 0 {
+		t.Fatalf("set append-only: %s", e)
+	}
+	if _, _, e = v.Open(ctx, fe.Inode, syscall.O_WRONLY); e != syscall.EPERM {
+		t.Fatalf("open append-only without O_APPEND: %s", e)
+	}
+	if _, _, e = v.Open(ctx, fe.Inode, syscall.O_WRONLY|syscall.O_APPEND|syscall.O_TRUNC); e != syscall.EPERM {
+		t.Fatalf("open append-only with O_TRUNC: %s", e)
+	}
+	_, fh, e = v.Open(ctx, fe.Inode, syscall.O_WRONLY|syscall.O_APPEND)
+	if e != 0 {
+		t.Fatalf("open append-only: %s", e)
+	}
+	if e = v.Write(ctx, fe.Inode, []byte("overwrite"), 0, fh); e != syscall.EPERM {
+		t.Fatalf("overwrite append-only: %s", e)
+	}
+	data := make([]byte, 3000)
+	var expected []byte
+	off := start
+	for i := 0; i < 5; i++ {
+		for j := range data {
+			data[j] = byte(i + 1)
+		}
+		if e = v.Write(ctx, fe.Inode, data, off, fh); e != 0 {
+			t.Fatalf("append at %d: %s", off, e)
+		}
+		expected = append(expected, data...)
+		off += uint64(len(data))
+	}
+	if e = v.Write(ctx, fe.Inode, data, off-1, fh); e != syscall.EPERM {
+		t.Fatalf("write before the end: %s", e)
+	}
+	if e = v.Flush(ctx, fe.Inode, fh, 0); e != 0 {
+		t.Fatalf("flush: %s", e)
+	}
+	v.Release(ctx, fe.Inode, fh)
+
+	var attr Attr
+	if e = v.Meta.GetAttr(ctx, fe.Inode, &attr); e != 0 || attr.Length != off {
+		t.Fatalf("length of file: %s %d != %d", e, attr.Length, off)
+	}
+	_, fh, e = v.Open(ctx, fe.Inode, syscall.O_RDONLY)
+	if e != 0 {
+		t.Fatalf("open: %s", e)
+	}
+	buf := make([]byte, len(expected))
+	if n, e := v.Read(ctx, fe.Inode, buf, start, fh); e != 0 || n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatalf("read appended data: %s %d", e, n)
+	}
+	v.Release(ctx, fe.Inode, fh)
+}
@@ -48,7 +48,7 @@ func (v *VFS) StatFS(ctx Context, ino Ino) (st *Statfs, err syscall.Errno) {
 	st.Avail = availspace
 	st.Files = iused + iavail
 	st.Favail = iavail
-	logit(ctx, "statfs (%d): OK (%d,%d,%d,%d)", ino, totalspace-availspace, availspace, iused, iavail)
+	v.logit(ctx, "statfs", opInfo{ino: ino}, "(%d): OK (%d,%d,%d,%d)", ino, totalspace-availspace, availspace, iused, iavail)
 	return
 }
 
@@ -73,7 +73,7 @@ func accessTest(attr *Attr, mmode uint16, uid uint32, gid uint32) syscall.Errno
 }
 
 func (v *VFS) Access(ctx Context, ino Ino, mask int) (err syscall.Errno) {
-	defer func() { logit(ctx, "access (%d,0x%X): %s", ino, mask, strerr(err)) }()
+	defer func() { v.logit(ctx, "access", opInfo{ino: ino, err: err}, "(%d,0x%X): %s", ino, mask, strerr(err)) }()
 	var mmask uint16
 	if mask&unix.R_OK != 0 {
 		mmask |= MODE_MASK_R
@@ -142,7 +142,7 @@ func setattrStr(set int, mode, uid, gid uint32, atime, mtime int64, size uint64)
 func (v *VFS) SetAttr(ctx Context, ino Ino, set int, opened uint8, mode, uid, gid uint32, atime, mtime int64, atimensec, mtimensec uint32, size uint64) (entry *meta.Entry, err syscall.Errno) {
 	str := setattrStr(set, mode, uid, gid, atime, mtime, size)
 	defer func() {
-		logit(ctx, "setattr (%d,0x%X,[%s]): %s%s", ino, set, str, strerr(err), (*Entry)(entry))
+		v.logit(ctx, "setattr", opInfo{ino: ino, err: err}, "(%d,0x%X,[%s]): %s%s", ino, set, str, strerr(err), (*Entry)(entry))
 	}()
 	if IsSpecialNode(ino) {
 		n := getInternalNode(ino)
@@ -203,7 +203,7 @@ func (l lockType) String() string {
 
 func (v *VFS) Getlk(ctx Context, ino Ino, fh uint64, owner uint64, start, len *uint64, typ *uint32, pid *uint32) (err syscall.Errno) {
 	defer func() {
-		logit(ctx, "getlk (%d,%016X): %s (%d,%d,%s,%d)", ino, owner, strerr(err), *start, *len, lockType(*typ), *pid)
+		v.logit(ctx, "getlk", opInfo{ino: ino, err: err}, "(%d,%016X): %s (%d,%d,%s,%d)", ino, owner, strerr(err), *start, *len, lockType(*typ), *pid)
 	}()
 	if lockType(*typ).String() == "X" {
 		return syscall.EINVAL
@@ -222,7 +222,7 @@ func (v *VFS) Getlk(ctx Context, ino Ino, fh uint64, owner uint64, start, len *u
 
 func (v *VFS) Setlk(ctx Context, ino Ino, fh uint64, owner uint64, start, end uint64, typ uint32, pid uint32, block bool) (err syscall.Errno) {
 	defer func() {
-		logit(ctx, "setlk (%d,%016X,%d,%d,%s,%t,%d): %s", ino, owner, start, end, lockType(typ), block, pid, strerr(err))
+		v.logit(ctx, "setlk", opInfo{ino: ino, err: err}, "(%d,%016X,%d,%d,%s,%t,%d): %s", ino, owner, start, end, lockType(typ), block, pid, strerr(err))
 	}()
 	if lockType(typ).String() == "X" {
 		return syscall.EINVAL
@@ -253,7 +253,9 @@ func (v *VFS) Setlk(ctx Context, ino Ino, fh uint64, owner uint64, start, end ui
 func (v *VFS) Flock(ctx Context, ino Ino, fh uint64, owner uint64, typ uint32, block bool) (err syscall.Errno) {
 	var name string
 	var reqid uint32
-	defer func() { logit(ctx, "flock (%d,%d,%016X,%s,%t): %s", reqid, ino, owner, name, block, strerr(err)) }()
+	defer func() {
+		v.logit(ctx, "flock", opInfo{ino: ino, err: err}, "(%d,%d,%016X,%s,%t): %s", reqid, ino, owner, name, block, strerr(err))
+	}()
 	switch typ {
 	case syscall.F_RDLCK:
 		name = "LOCKSH"
@@ -41,7 +41,7 @@ type FileWriter interface {
 }
 
 type DataWriter interface {
-	Open(inode Ino, fleng uint64) FileWriter
+	Open(inode Ino, attr *Attr) FileWriter
 	Flush(ctx meta.Context, inode Ino) syscall.Errno
 	GetLength(inode Ino) uint64
 	Truncate(inode Ino, length uint64)
@@ -193,6 +193,10 @@ func (c *chunkWriter) commitThread() {
 				go s.flushData()
 			}
 		}
+		// the length of an append-only file can only grow, so the chunks before are committed first
+		for f.appendOnly && f.pendingBefore(c.indx) {
+			f.commitcond.WaitWithTimeout(time.Millisecond * 100)
+		}
 		err := s.err
 		f.Unlock()
 
@@ -222,14 +226,16 @@ type fileWriter struct {
 
 	inode        Ino
 	length       uint64
+	appendOnly   bool
 	err          syscall.Errno
 	flushwaiting uint16
 	writewaiting uint16
 	refs         uint16
 	chunks       map[uint32]*chunkWriter
 
-	flushcond *utils.Cond // wait for chunks==nil (flush)
-	writecond *utils.Cond // wait for flushwaiting==0 (write)
+	flushcond  *utils.Cond // wait for chunks==nil (flush)
+	writecond  *utils.Cond // wait for flushwaiting==0 (write)
+	commitcond *utils.Cond // wait for the chunks before to be committed (append-only)
 }
 
 // protected by file
@@ -245,6 +251,9 @@ func (f *fileWriter) findChunk(i uint32) *chunkWriter {
 // protected by file
 func (f *fileWriter) freeChunk(c *chunkWriter) {
 	delete(f.chunks, c.indx)
+	if f.appendOnly {
+		f.commitcond.Broadcast()
+	}
 	if len(f.chunks) == 0 && f.flushwaiting > 0 {
 		f.flushcond.Broadcast()
 	}
@@ -262,6 +271,7 @@ func (f *fileWriter) writeChunk(ctx meta.Context, indx uint32, off uint32, data
 			notify:  utils.NewCond(&f.Mutex),
 			started: time.Now(),
 		}
+		s.writer.SetOwner(chunk.Identity{Uid: ctx.Uid(), Gid: ctx.Gid(), Pid: ctx.Pid()})
 		c.slices = append(c.slices, s)
 		if len(c.slices) == 1 {
 			f.w.Lock()
@@ -273,6 +283,16 @@ func (f *fileWriter) writeChunk(ctx meta.Context, indx uint32, off uint32, data
 	return s.write(ctx, off-s.off, data)
 }
 
+// protected by file
+func (f *fileWriter) pendingBefore(indx uint32) bool {
+	for i := range f.chunks {
+		if i < indx {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *fileWriter) totalSlices() int {
 	var cnt int
 	f.Lock()
@@ -463,22 +483,26 @@ func (w *dataWriter) flushAll() {
 	}
 }
 
-func (w *dataWriter) Open(inode Ino, len uint64) FileWriter {
+func (w *dataWriter) Open(inode Ino, attr *Attr) FileWriter {
 	w.Lock()
-	defer w.Unlock()
 	f, ok := w.files[inode]
 	if !ok {
 		f = &fileWriter{
 			w:      w,
 			inode:  inode,
-			length: len,
+			length: attr.Length,
 			chunks: make(map[uint32]*chunkWriter),
 		}
 		f.flushcond = utils.NewCond(f)
 		f.writecond = utils.NewCond(f)
+		f.commitcond = utils.NewCond(f)
 		w.files[inode] = f
 	}
 	f.refs++
+	w.Unlock()
+	f.Lock()
+	f.appendOnly = attr.Flags&meta.FlagAppend != 0
+	f.Unlock()
 	return f
 }
 
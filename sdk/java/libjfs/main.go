@@ -78,6 +78,7 @@ const (
 	ENOENT    = -0x02
 	EINTR     = -0x04
 	EIO       = -0x05
+	ENXIO     = -0x06
 	EACCES    = -0x0d
 	EEXIST    = -0x11
 	ENOTDIR   = -0x14
@@ -110,6 +111,8 @@ func errno(err error) int {
 		return EINTR
 	case syscall.EIO:
 		return EIO
+	case syscall.ENXIO:
+		return ENXIO
 	case syscall.EACCES:
 		return EACCES
 	case syscall.EEXIST:
@@ -395,6 +398,10 @@ func jfs_init(cname, jsonConf, user, group, superuser, supergroup *C.char) uintp
 			logger.Errorf("load setting: %s", err)
 			return nil
 		}
+		if err = format.CheckToken(metaConf); err != nil {
+			logger.Errorf("%s", err)
+			return nil
+		}
 		var registerer prometheus.Registerer
 		if jConf.PushGateway != "" || jConf.PushGraphite != "" {
 			commonLabels := prometheus.Labels{"vol_name": name, "mp": "sdk-" + strconv.Itoa(os.Getpid())}
@@ -420,6 +427,7 @@ func jfs_init(cname, jsonConf, user, group, superuser, supergroup *C.char) uintp
 			}
 			meta.InitMetrics(registerer)
 			vfs.InitMetrics(registerer)
+			metric.InitMetrics(registerer)
 			go metric.UpdateMetrics(m, registerer)
 		}
 
@@ -1031,13 +1039,8 @@ func jfs_concat(pid int, h uintptr, _dst *C.char, buf uintptr, bufsize int) int
 		}
 		defer func() { _ = w.Delete(ctx, tmp) }()
 		defer fi.Close(ctx)
-		var off uint64
-		for _, src := range srcs {
-			copied, err := w.CopyFileRange(ctx, src, 0, tmp, off, 1<<63)
-			if err != 0 {
-				return errno(err)
-			}
-			off += copied
+		if _, err := w.Concat(ctx, tmp, srcs...); err != 0 {
+			return errno(err)
 		}
 	} else {
 		tmp = srcs[0]
@@ -1071,7 +1074,10 @@ func jfs_lseek(pid, fd int, offset int64, whence int) int64 {
 	f, ok := openFiles[fd]
 	if ok {
 		filesLock.Unlock()
-		off, _ := f.Seek(f.w.withPid(pid), offset, whence)
+		off, err := f.Seek(f.w.withPid(pid), offset, whence)
+		if err != nil {
+			return int64(errno(err))
+		}
 		return off
 	}
 	filesLock.Unlock()
@@ -169,6 +169,7 @@ public class JuiceFileSystemImpl extends FileSystem {
   static int ENOENT = -0x02;
   static int EINTR = -0x04;
   static int EIO = -0x05;
+  static int ENXIO = -0x06;
   static int EACCESS = -0xd;
   static int EEXIST = -0x11;
   static int ENOTDIR = -0x14;
@@ -180,6 +181,9 @@ public class JuiceFileSystemImpl extends FileSystem {
   static int ENOATTR = -0x5d;
   static int ENOTSUP = -0x5f;
 
+  static int SEEK_DATA = 3;
+  static int SEEK_HOLE = 4;
+
   static int MODE_MASK_R = 4;
   static int MODE_MASK_W = 2;
   static int MODE_MASK_X = 1;
@@ -903,6 +907,31 @@ public class JuiceFileSystemImpl extends FileSystem {
       }
     }
 
+    /**
+     * Returns the offset of the next data at or after pos, or -1 if there is no more data.
+     */
+    public synchronized long seekData(long pos) throws IOException {
+      return seekDataHole(pos, SEEK_DATA);
+    }
+
+    /**
+     * Returns the offset of the next hole at or after pos, the end of file is treated as a hole.
+     */
+    public synchronized long seekHole(long pos) throws IOException {
+      return seekDataHole(pos, SEEK_HOLE);
+    }
+
+    private long seekDataHole(long pos, int whence) throws IOException {
+      if (buf == null)
+        throw new IOException("stream was closed");
+      long r = lib.jfs_lseek(Thread.currentThread().getId(), fd, pos, whence);
+      if (r == ENXIO)
+        return -1;
+      if (r < 0)
+        throw error((int) r, path);
+      return r;
+    }
+
     @Override
     public synchronized long skip(long n) throws IOException {
       if (n < 0)